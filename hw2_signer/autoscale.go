@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type ScaleConfig struct {
+	MinWorkers int
+	MaxWorkers int
+	// QueueSize is capacity of the stage input queue which depth is observed
+	QueueSize int
+	// worker is added when queue depth is above HighWater
+	// and removed when it is below LowWater
+	HighWater int
+	LowWater  int
+	Interval  time.Duration
+	// OnScale is called with the new worker count, may be nil
+	OnScale func(workers int)
+}
+
+func (cfg ScaleConfig) validate() {
+	if cfg.MinWorkers < 1 || cfg.MaxWorkers < cfg.MinWorkers {
+		panic("expected 0 < MinWorkers <= MaxWorkers")
+	}
+	if cfg.LowWater > cfg.HighWater {
+		panic("expected LowWater <= HighWater")
+	}
+	if cfg.Interval <= 0 {
+		panic("interval must be > 0")
+	}
+}
+
+// AutoscaledWorkers is like Workers but adjusts the number of goroutines at
+// runtime depending on how many items are waiting in the stage queue.
+func AutoscaledWorkers(cfg ScaleConfig, fn func(interface{}) interface{}) job {
+	cfg.validate()
+	return func(in, out chan interface{}) {
+		queue := make(chan interface{}, cfg.QueueSize)
+		fed := make(chan struct{})
+		go func() {
+			defer close(fed)
+			defer close(queue)
+			for val := range in {
+				queue <- val
+			}
+		}()
+
+		// quit has room for every worker so scaling down never blocks
+		quit := make(chan struct{}, cfg.MaxWorkers)
+		wg := sync.WaitGroup{}
+		workers := 0
+		startWorker := func() {
+			wg.Add(1)
+			workers++
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-quit:
+						return
+					case val, ok := <-queue:
+						if !ok {
+							return
+						}
+						out <- fn(val)
+					}
+				}
+			}()
+		}
+		for workers < cfg.MinWorkers {
+			startWorker()
+		}
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		// keep scaling until everything fed to the queue is taken by workers
+		feeding := fed
+		for feeding != nil || len(queue) > 0 {
+			select {
+			case <-feeding:
+				feeding = nil
+			case <-ticker.C:
+				depth := len(queue)
+				switch {
+				case depth > cfg.HighWater && workers < cfg.MaxWorkers:
+					startWorker()
+				case depth < cfg.LowWater && workers > cfg.MinWorkers:
+					quit <- struct{}{}
+					workers--
+				default:
+					continue
+				}
+				if cfg.OnScale != nil {
+					cfg.OnScale(workers)
+				}
+			}
+		}
+		wg.Wait()
+	}
+}
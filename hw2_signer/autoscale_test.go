@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutoscaledWorkers(t *testing.T) {
+	mu := sync.Mutex{}
+	maxSeen := 0
+	cfg := ScaleConfig{
+		MinWorkers: 1,
+		MaxWorkers: 4,
+		QueueSize:  50,
+		HighWater:  5,
+		LowWater:   1,
+		Interval:   5 * time.Millisecond,
+		OnScale: func(workers int) {
+			mu.Lock()
+			defer mu.Unlock()
+			if workers > maxSeen {
+				maxSeen = workers
+			}
+		},
+	}
+	received := 0
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			for i := 0; i < 50; i++ {
+				out <- i
+			}
+		}),
+		AutoscaledWorkers(cfg, func(val interface{}) interface{} {
+			time.Sleep(10 * time.Millisecond)
+			return val
+		}),
+		job(func(in, out chan interface{}) {
+			for range in {
+				received++
+			}
+		}),
+	)
+	if received != 50 {
+		t.Errorf("expected 50 items, got %d", received)
+	}
+	if maxSeen <= cfg.MinWorkers {
+		t.Errorf("expected stage to scale up, max workers %d", maxSeen)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+type TuningConfig struct {
+	Workers int
+	Buffer  int
+}
+
+type TuningResult struct {
+	TuningConfig
+	Items      int
+	Elapsed    time.Duration
+	Throughput float64 // items per second
+	AvgLatency time.Duration
+	MaxLatency time.Duration
+}
+
+type benchItem struct {
+	data string
+	born time.Time
+}
+
+// RunTuning pushes items synthetic values through a single work stage for
+// every combination of workers and buffers and measures wall-clock results.
+func RunTuning(items int, work func(data string) string, workers, buffers []int) []TuningResult {
+	var results []TuningResult
+	for _, w := range workers {
+		for _, b := range buffers {
+			results = append(results, runTuningCase(items, work, TuningConfig{w, b}))
+		}
+	}
+	return results
+}
+
+func runTuningCase(items int, work func(data string) string, cfg TuningConfig) TuningResult {
+	result := TuningResult{TuningConfig: cfg}
+	var totalLatency time.Duration
+	start := time.Now()
+	NewPipeline(
+		job(func(in, out chan interface{}) {
+			for i := 0; i < items; i++ {
+				out <- benchItem{strconv.Itoa(i), time.Now()}
+			}
+		}),
+		Workers(cfg.Workers, func(val interface{}) interface{} {
+			item := val.(benchItem)
+			item.data = work(item.data)
+			return item
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				latency := time.Since(val.(benchItem).born)
+				totalLatency += latency
+				if latency > result.MaxLatency {
+					result.MaxLatency = latency
+				}
+				result.Items++
+			}
+		}),
+	).Buffer(cfg.Buffer).Run()
+	result.Elapsed = time.Since(start)
+	if result.Items > 0 {
+		result.AvgLatency = totalLatency / time.Duration(result.Items)
+	}
+	if result.Elapsed > 0 {
+		result.Throughput = float64(result.Items) / result.Elapsed.Seconds()
+	}
+	return result
+}
+
+// WriteTuningReport prints results ordered by throughput, the best one first.
+func WriteTuningReport(w io.Writer, results []TuningResult) error {
+	sorted := make([]TuningResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Throughput > sorted[j].Throughput
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "workers\tbuffer\titems\telapsed\titems/s\tavg latency\tmax latency\t")
+	for _, r := range sorted {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%.1f\t%s\t%s\t\n",
+			r.Workers, r.Buffer, r.Items, r.Elapsed, r.Throughput, r.AvgLatency, r.MaxLatency)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTuning(t *testing.T) {
+	work := func(data string) string {
+		time.Sleep(5 * time.Millisecond)
+		return data
+	}
+	results := RunTuning(20, work, []int{1, 4}, []int{0, 8})
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Items != 20 {
+			t.Errorf("%+v: expected 20 items, got %d", r.TuningConfig, r.Items)
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteTuningReport(buf, results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected header and 4 rows, got:\n%s", buf.String())
+	}
+	// the order depends on timing, so only the rows are checked
+	rows := map[string]bool{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[2] != "20" {
+			t.Errorf("expected 20 items in row %q", line)
+			continue
+		}
+		rows[fields[0]+" "+fields[1]] = true
+	}
+	for _, config := range []string{"1 0", "1 8", "4 0", "4 8"} {
+		if !rows[config] {
+			t.Errorf("expected row of workers and buffer %s, got:\n%s", config, buf.String())
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// maxMergeRuns is the number of runs merged at once, more runs are merged
+// into new ones first, so the number of open files is bounded too.
+const maxMergeRuns = 64
+
+// NewExternalCombineResults returns a streaming variant of CombineResults.
+// Items are collected into sorted runs of at most runSize elements, every full
+// run is spilled to a temporary file in dir (os.TempDir() if empty) and at the
+// end all runs are merged, so memory usage is bounded by runSize.
+//
+// Unlike CombineResults it sends the sorted items to out one by one instead of
+// a single string of them joined with "_", so it's not a drop-in replacement:
+// a stage after it has to join them if it needs that string. I/O errors of
+// the runs are logged and stop the job, the rest of input is dropped then.
+func NewExternalCombineResults(runSize int, dir string) job {
+	if runSize < 1 {
+		panic("run size must be > 0")
+	}
+	return func(in, out chan interface{}) {
+		runs := &runFiles{dir: dir}
+		defer runs.remove()
+		if err := runs.combine(in, out, runSize); err != nil {
+			log.Printf("external combine: %v", err)
+			// previous stages must not block on the stopped job
+			for range in {
+			}
+		}
+	}
+}
+
+// runFiles are the temporary files of sorted runs
+type runFiles struct {
+	dir  string
+	runs []*os.File
+}
+
+func (f *runFiles) combine(in, out chan interface{}, runSize int) error {
+	emit := func(data string) error {
+		out <- data
+		return nil
+	}
+	buf := make([]string, 0, runSize)
+	for unit := range in {
+		data, ok := unit.(string)
+		if !ok {
+			panic("type assertion failed")
+		}
+		buf = append(buf, data)
+		if len(buf) == runSize {
+			if err := f.spill(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	sort.Strings(buf)
+	if len(f.runs) == 0 {
+		for _, data := range buf {
+			emit(data)
+		}
+		return nil
+	}
+	if len(buf) > 0 {
+		if err := f.spill(buf); err != nil {
+			return err
+		}
+	}
+	return f.merge(emit)
+}
+
+func (f *runFiles) spill(run []string) error {
+	sort.Strings(run)
+	return f.write(func(emit func(string) error) error {
+		for _, data := range run {
+			if err := emit(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// write makes a new run of the items passed to emit by fill
+func (f *runFiles) write(fill func(emit func(string) error) error) error {
+	file, err := ioutil.TempFile(f.dir, "combine-run-")
+	if err != nil {
+		return err
+	}
+	// the run is removed with the others even if it's incomplete
+	f.runs = append(f.runs, file)
+	w := bufio.NewWriter(file)
+	err = fill(func(data string) error {
+		// quoting keeps one item per line even if it contains line breaks
+		_, err := w.WriteString(strconv.Quote(data) + "\n")
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	return err
+}
+
+// merge passes items of all runs to emit in order, at most maxMergeRuns
+// runs are merged into a new one at a time until the rest fit in one pass
+func (f *runFiles) merge(emit func(string) error) error {
+	for len(f.runs) > maxMergeRuns {
+		batch := f.runs[:maxMergeRuns]
+		f.runs = f.runs[maxMergeRuns:]
+		err := f.write(func(emit func(string) error) error {
+			return mergeRuns(batch, emit)
+		})
+		removeRuns(batch)
+		if err != nil {
+			return err
+		}
+	}
+	return mergeRuns(f.runs, emit)
+}
+
+func (f *runFiles) remove() {
+	removeRuns(f.runs)
+	f.runs = nil
+}
+
+func removeRuns(runs []*os.File) {
+	for _, file := range runs {
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+type runReader struct {
+	reader *bufio.Reader
+	head   string
+	err    error
+}
+
+// next reads the next item of the run to head, false is the end of the run
+// or an error, which is kept in err
+func (r *runReader) next() bool {
+	line, err := r.reader.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return false
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		r.head, err = strconv.Unquote(line[:len(line)-1])
+	}
+	r.err = err
+	return err == nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].head < h[j].head }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+func mergeRuns(runs []*os.File, emit func(string) error) error {
+	h := make(runHeap, 0, len(runs))
+	for _, f := range runs {
+		r := &runReader{reader: bufio.NewReader(f)}
+		if r.next() {
+			h = append(h, r)
+		} else if r.err != nil {
+			return r.err
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		r := h[0]
+		if err := emit(r.head); err != nil {
+			return err
+		}
+		if r.next() {
+			heap.Fix(&h, 0)
+		} else if r.err != nil {
+			return r.err
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExternalCombineResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := []string{"e", "b", "a\nb", "d", "c", "a", "f"}
+	for _, runSize := range []int{1, 3, 100} {
+		result := collect(
+			job(func(in, out chan interface{}) {
+				for _, v := range input {
+					out <- v
+				}
+			}),
+			NewExternalCombineResults(runSize, dir),
+		)
+		expected := []interface{}{"a", "a\nb", "b", "c", "d", "e", "f"}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("run size %d: expected %q, got %q", runSize, expected, result)
+		}
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 0 {
+			t.Errorf("run size %d: temporary runs were not removed", runSize)
+		}
+	}
+}
+
+func TestExternalCombineResultsManyRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "combine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// more runs than maxMergeRuns are merged in several passes, long items
+	// are read back whole
+	long := strings.Repeat("z", 2<<20)
+	var input, expected []interface{}
+	for i := 0; i < 3*maxMergeRuns; i++ {
+		input = append(input, fmt.Sprintf("%04d", 3*maxMergeRuns-i))
+		expected = append(expected, fmt.Sprintf("%04d", i+1))
+	}
+	input = append(input, long)
+	expected = append(expected, long)
+	result := collect(
+		job(func(in, out chan interface{}) {
+			for _, v := range input {
+				out <- v
+			}
+		}),
+		NewExternalCombineResults(1, dir),
+	)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %d sorted items, got %d", len(expected), len(result))
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("temporary runs were not removed: %d", len(files))
+	}
+}
+
+func TestExternalCombineResultsError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	// the job stops without blocking the source
+	result := collect(
+		job(func(in, out chan interface{}) {
+			for _, v := range []string{"b", "a", "c"} {
+				out <- v
+			}
+		}),
+		NewExternalCombineResults(1, filepath.Join(os.TempDir(), "combine-missing", "dir")),
+	)
+	if len(result) != 0 || !strings.Contains(buf.String(), "external combine:") {
+		t.Errorf("expected logged error and no items, got %q and log %q", result, buf)
+	}
+}
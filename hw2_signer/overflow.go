@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+type OverflowPolicy int
+
+const (
+	// Block waits until the stage is ready to accept an item
+	Block OverflowPolicy = iota
+	// DropNewest discards incoming item if the stage queue is full
+	DropNewest
+	// DropOldest evicts the oldest queued item to make room for the new one
+	DropOldest
+	// Sample passes only every SampleRate-th item
+	Sample
+)
+
+type OverflowStats struct {
+	dropped uint64
+}
+
+func (s *OverflowStats) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *OverflowStats) drop() {
+	if s != nil {
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+type OverflowConfig struct {
+	Policy OverflowPolicy
+	// QueueSize is the number of items buffered in front of the stage
+	QueueSize  int
+	SampleRate int
+	Stats      *OverflowStats
+}
+
+// WithOverflow puts a bounded queue in front of a stage and applies the
+// configured policy when the stage can't keep up. Wrap a single job with it
+// to configure stages individually.
+func WithOverflow(cfg OverflowConfig) Middleware {
+	if cfg.QueueSize < 0 {
+		panic("queue size must be >= 0")
+	}
+	if cfg.Policy == Sample && cfg.SampleRate < 1 {
+		panic("sample rate must be > 0")
+	}
+	return func(next job) job {
+		return func(in, out chan interface{}) {
+			if in == nil {
+				// the first stage has no input to regulate
+				next(in, out)
+				return
+			}
+			queue := make(chan interface{}, cfg.QueueSize)
+			go func() {
+				defer close(queue)
+				seen := 0
+				for val := range in {
+					switch cfg.Policy {
+					case Block:
+						queue <- val
+					case DropNewest:
+						select {
+						case queue <- val:
+						default:
+							cfg.Stats.drop()
+						}
+					case DropOldest:
+						offerDropOldest(queue, val, cfg.Stats)
+					case Sample:
+						if seen%cfg.SampleRate == 0 {
+							queue <- val
+						} else {
+							cfg.Stats.drop()
+						}
+						seen++
+					default:
+						panic("unknown overflow policy")
+					}
+				}
+			}()
+			next(queue, out)
+			// drain the rest if the stage returned early
+			for range queue {
+			}
+		}
+	}
+}
+
+func offerDropOldest(queue chan interface{}, val interface{}, stats *OverflowStats) {
+	if cap(queue) == 0 {
+		select {
+		case queue <- val:
+		default:
+			stats.drop()
+		}
+		return
+	}
+	for {
+		select {
+		case queue <- val:
+			return
+		default:
+		}
+		select {
+		case <-queue:
+			stats.drop()
+		default:
+		}
+	}
+}
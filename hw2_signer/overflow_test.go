@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOverflowPolicies(t *testing.T) {
+	source := job(func(in, out chan interface{}) {
+		for i := 0; i < 10; i++ {
+			out <- i
+		}
+	})
+	cases := []struct {
+		cfg      OverflowConfig
+		expected []interface{}
+		dropped  uint64
+	}{
+		{OverflowConfig{Policy: Block}, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, 0},
+		{OverflowConfig{Policy: DropNewest, QueueSize: 3}, []interface{}{0, 1, 2}, 7},
+		{OverflowConfig{Policy: DropOldest, QueueSize: 3}, []interface{}{7, 8, 9}, 7},
+		{OverflowConfig{Policy: Sample, SampleRate: 4}, []interface{}{0, 4, 8}, 7},
+	}
+	for _, c := range cases {
+		c.cfg.Stats = &OverflowStats{}
+		var result []interface{}
+		NewPipeline(
+			source,
+			WithOverflow(c.cfg)(func(in, out chan interface{}) {
+				// the slow stage doesn't read anything until overflow happened
+				deadline := time.Now().Add(time.Second)
+				for c.cfg.Stats.Dropped() < c.dropped && time.Now().Before(deadline) {
+					time.Sleep(time.Millisecond)
+				}
+				for val := range in {
+					result = append(result, val)
+				}
+			}),
+		).Run()
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("policy %d: expected %v, got %v", c.cfg.Policy, c.expected, result)
+		}
+		if c.cfg.Stats.Dropped() != c.dropped {
+			t.Errorf("policy %d: expected %d dropped, got %d", c.cfg.Policy, c.dropped, c.cfg.Stats.Dropped())
+		}
+	}
+}
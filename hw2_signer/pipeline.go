@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a stage of the pipeline, e.g. to add logging or metrics.
+type Middleware func(job) job
+
+// StageMiddleware makes Middleware of the stage by its number, stages are
+// numbered from 1 in the order of the pipeline on every run
+type StageMiddleware func(stage int) Middleware
+
+type Pipeline struct {
+	jobs        []job
+	middlewares []StageMiddleware
+	buffer      int
+}
+
+func NewPipeline(jobs ...job) *Pipeline {
+	return &Pipeline{jobs: jobs}
+}
+
+// Use appends middlewares applied to every stage. The first registered
+// middleware is the outermost one.
+func (p *Pipeline) Use(mws ...Middleware) *Pipeline {
+	for _, mw := range mws {
+		mw := mw
+		p.middlewares = append(p.middlewares, func(int) Middleware { return mw })
+	}
+	return p
+}
+
+// UseStages is Use of middlewares which depend on the stage they wrap
+func (p *Pipeline) UseStages(mws ...StageMiddleware) *Pipeline {
+	p.middlewares = append(p.middlewares, mws...)
+	return p
+}
+
+// Buffer sets capacity of channels between stages, unbuffered by default.
+func (p *Pipeline) Buffer(size int) *Pipeline {
+	if size < 0 {
+		panic("buffer size must be >= 0")
+	}
+	p.buffer = size
+	return p
+}
+
+func (p *Pipeline) wrap(stage int, j job) job {
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		j = p.middlewares[i](stage)(j)
+	}
+	return j
+}
+
+func (p *Pipeline) Run() {
+	if len(p.jobs) == 0 {
+		return
+	}
+	wg := sync.WaitGroup{}
+	var inChan chan interface{}
+	for i, j := range p.jobs {
+		outChan := make(chan interface{}, p.buffer)
+		wg.Add(1)
+		go func(worker job, chIn, chOut chan interface{}) {
+			defer wg.Done()
+			defer close(chOut)
+			worker(chIn, chOut)
+		}(p.wrap(i+1, j), inChan, outChan)
+		inChan = outChan
+	}
+	wg.Wait()
+}
+
+// Workers returns a stage which applies fn to every input item on n
+// goroutines. Output order is not preserved.
+func Workers(n int, fn func(interface{}) interface{}) job {
+	if n < 1 {
+		panic("workers count must be > 0")
+	}
+	return func(in, out chan interface{}) {
+		wg := sync.WaitGroup{}
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+				for val := range in {
+					out <- fn(val)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// Logging reports when a stage starts and finishes and how many items it
+// emitted, stages are named by their numbers.
+func Logging(logger *log.Logger) StageMiddleware {
+	return func(stage int) Middleware {
+		name := "stage " + strconv.Itoa(stage)
+		return func(next job) job {
+			return func(in, out chan interface{}) {
+				start := time.Now()
+				logger.Printf("%s: started", name)
+				proxy := make(chan interface{})
+				done := make(chan int)
+				go func() {
+					count := 0
+					for val := range proxy {
+						count++
+						out <- val
+					}
+					done <- count
+				}()
+				next(in, proxy)
+				close(proxy)
+				logger.Printf("%s: finished, emitted %d items in %s", name, <-done, time.Since(start))
+			}
+		}
+	}
+}
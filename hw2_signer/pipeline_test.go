@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPipelineUse(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next job) job {
+			return func(in, out chan interface{}) {
+				calls = append(calls, name)
+				next(in, out)
+			}
+		}
+	}
+	var result []interface{}
+	NewPipeline(
+		job(func(in, out chan interface{}) {}),
+	).Use(trace("outer"), trace("inner")).Run()
+	expected := []string{"outer", "inner"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+
+	buf := &bytes.Buffer{}
+	p := NewPipeline(
+		job(func(in, out chan interface{}) {
+			out <- 1
+			out <- 2
+		}),
+		job(func(in, out chan interface{}) {
+			for val := range in {
+				result = append(result, val)
+			}
+		}),
+	).UseStages(Logging(log.New(buf, "", 0)))
+	p.Run()
+	p.Run()
+	if !reflect.DeepEqual(result, []interface{}{1, 2, 1, 2}) {
+		t.Errorf("values should pass through the middleware, got %v", result)
+	}
+	if !strings.Contains(buf.String(), "stage 1: finished, emitted 2 items") ||
+		!strings.Contains(buf.String(), "stage 2: finished, emitted 0 items") {
+		t.Errorf("unexpected log output:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "stage 3") {
+		t.Errorf("stages should be numbered from 1 on every run:\n%s", buf.String())
+	}
+}
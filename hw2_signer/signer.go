@@ -35,31 +35,45 @@ func SingleHash(in, out chan interface{}) {
 	wg.Wait()
 }
 
-func MultiHash(in, out chan interface{}) {
-	wg := sync.WaitGroup{}
-	for unit := range in {
-		data, ok := unit.(string)
-		if !ok {
-			panic("type assertion failed")
-		}
-		wg.Add(1)
-		go func(data string) {
-			defer wg.Done()
-			const numHashes int = 6
-			var multiRes [numHashes]string
-			wgIn := sync.WaitGroup{}
-			wgIn.Add(numHashes)
-			for i := 0; i < numHashes; i++ {
-				go func(i int) {
-					defer wgIn.Done()
-					multiRes[i] = DataSignerCrc32(strconv.Itoa(i) + data)
-				}(i)
+const multiHashRounds = 6
+
+func indexSalt(round int) string {
+	return strconv.Itoa(round)
+}
+
+func NewMultiHash(rounds int, salt func(round int) string) job {
+	if rounds < 1 {
+		panic("rounds must be > 0")
+	}
+	return func(in, out chan interface{}) {
+		wg := sync.WaitGroup{}
+		for unit := range in {
+			data, ok := unit.(string)
+			if !ok {
+				panic("type assertion failed")
 			}
-			wgIn.Wait()
-			out <- strings.Join(multiRes[:], "")
-		}(data)
+			wg.Add(1)
+			go func(data string) {
+				defer wg.Done()
+				multiRes := make([]string, rounds)
+				wgIn := sync.WaitGroup{}
+				wgIn.Add(rounds)
+				for i := 0; i < rounds; i++ {
+					go func(i int) {
+						defer wgIn.Done()
+						multiRes[i] = DataSignerCrc32(salt(i) + data)
+					}(i)
+				}
+				wgIn.Wait()
+				out <- strings.Join(multiRes, "")
+			}(data)
+		}
+		wg.Wait()
 	}
-	wg.Wait()
+}
+
+func MultiHash(in, out chan interface{}) {
+	NewMultiHash(multiHashRounds, indexSalt)(in, out)
 }
 
 func CombineResults(in, out chan interface{}) {
@@ -76,26 +90,5 @@ func CombineResults(in, out chan interface{}) {
 }
 
 func ExecutePipeline(jobs ...job) {
-	out := make(chan interface{})
-	firstJob := jobs[0]
-	jobs = jobs[1:]
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		defer close(out)
-		firstJob(nil, out)
-	}()
-	inChan := out
-	for _, j := range jobs {
-		outChan := make(chan interface{})
-		wg.Add(1)
-		go func(worker job, chIn, chOut chan interface{}) {
-			defer wg.Done()
-			defer close(chOut)
-			worker(chIn, chOut)
-		}(j, inChan, outChan)
-		inChan = outChan
-	}
-	wg.Wait()
+	NewPipeline(jobs...).Run()
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+// stubCrc32 replaces slow DataSignerCrc32 with an instant one, returns restore func
+func stubCrc32() func() {
+	orig := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		return "<" + data + ">"
+	}
+	return func() { DataSignerCrc32 = orig }
+}
+
+func collect(jobs ...job) []interface{} {
+	var result []interface{}
+	jobs = append(jobs, job(func(in, out chan interface{}) {
+		for val := range in {
+			result = append(result, val)
+		}
+	}))
+	ExecutePipeline(jobs...)
+	return result
+}
+
+func TestMultiHashConfigurable(t *testing.T) {
+	defer stubCrc32()()
+
+	salt := func(round int) string { return string(rune('a' + round)) }
+	result := collect(
+		job(func(in, out chan interface{}) {
+			out <- "x"
+		}),
+		NewMultiHash(3, salt),
+	)
+	expected := "<ax><bx><cx>"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestMultiHashDefault(t *testing.T) {
+	defer stubCrc32()()
+
+	result := collect(
+		job(func(in, out chan interface{}) {
+			out <- "x"
+		}),
+		job(MultiHash),
+	)
+	expected := "<0x><1x><2x><3x><4x><5x>"
+	if len(result) != 1 || result[0] != expected {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
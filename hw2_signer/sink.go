@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// KeyStore keeps idempotency keys of already delivered items.
+type KeyStore interface {
+	Has(key string) (bool, error)
+	Add(key string) error
+}
+
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]struct{})}
+}
+
+func (s *MemoryKeyStore) Has(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.keys[key]
+	return ok, nil
+}
+
+func (s *MemoryKeyStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = struct{}{}
+	return nil
+}
+
+// FileKeyStore is a MemoryKeyStore persisted to an append-only file,
+// one Go-quoted key per line, so delivered keys survive restarts and may
+// contain line breaks.
+type FileKeyStore struct {
+	*MemoryKeyStore
+	file *os.File
+}
+
+func OpenFileKeyStore(path string) (*FileKeyStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	store := &FileKeyStore{NewMemoryKeyStore(), file}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		key, err := strconv.Unquote(scanner.Text())
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s:%d: bad key: %v", path, line, err)
+		}
+		store.keys[key] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileKeyStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.WriteString(strconv.Quote(key) + "\n"); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.keys[key] = struct{}{}
+	return nil
+}
+
+func (s *FileKeyStore) Close() error {
+	return s.file.Close()
+}
+
+// NewIdempotentSink returns a final stage which passes every item to emit
+// exactly once per key. The key is recorded only after emit succeeded, so an
+// item interrupted in the middle of delivery is delivered again after restart.
+func NewIdempotentSink(store KeyStore, key func(interface{}) string, emit func(interface{}) error) job {
+	return func(in, out chan interface{}) {
+		for val := range in {
+			k := key(val)
+			seen, err := store.Has(k)
+			if err != nil {
+				panic(err)
+			}
+			if seen {
+				continue
+			}
+			if err := emit(val); err != nil {
+				panic(err)
+			}
+			if err := store.Add(k); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIdempotentSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+
+	var emitted []interface{}
+	run := func(values ...interface{}) {
+		store, err := OpenFileKeyStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		ExecutePipeline(
+			job(func(in, out chan interface{}) {
+				for _, v := range values {
+					out <- v
+				}
+			}),
+			NewIdempotentSink(store, func(v interface{}) string {
+				return fmt.Sprint(v)
+			}, func(v interface{}) error {
+				emitted = append(emitted, v)
+				return nil
+			}),
+		)
+	}
+	run(1, 2, 2, 3)
+	// restart with partially repeated input
+	run(3, 4, 1)
+
+	expected := []interface{}{1, 2, 3, 4}
+	if !reflect.DeepEqual(emitted, expected) {
+		t.Errorf("expected %v, got %v", expected, emitted)
+	}
+}
+
+func TestFileKeyStoreQuoting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+
+	keys := []string{"a\nb", "a", "b", `"c"`, ""}
+	store, err := OpenFileKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := store.Add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	store, err = OpenFileKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if len(store.keys) != len(keys) {
+		t.Errorf("expected %d keys after reopen, got %q", len(keys), store.keys)
+	}
+	for _, key := range keys {
+		if seen, _ := store.Has(key); !seen {
+			t.Errorf("key %q is lost after reopen", key)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("unquoted\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileKeyStore(path); err == nil {
+		t.Error("expected error of the bad key")
+	}
+}
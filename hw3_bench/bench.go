@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"text/tabwriter"
+)
+
+type benchCase struct {
+	name   string
+	search func(out io.Writer) error
+}
+
+func benchCases(path string) []benchCase {
+	files := func(opts Options) func(out io.Writer) error {
+		return func(out io.Writer) error {
+			return FastSearchFiles(context.Background(), []string{path}, out, opts)
+		}
+	}
+	return []benchCase{
+		{"slow", func(out io.Writer) error {
+			SlowSearch(out)
+			return nil
+		}},
+		{"fast", func(out io.Writer) error {
+			FastSearch(out)
+			return nil
+		}},
+		{"files", files(Options{Workers: runtime.GOMAXPROCS(0)})},
+		{"mmap", files(Options{Mmap: true})},
+	}
+}
+
+// checkBenchCases verifies that every case prints the same as the first one
+func checkBenchCases(cases []benchCase) error {
+	expected := new(bytes.Buffer)
+	if err := cases[0].search(expected); err != nil {
+		return err
+	}
+	for _, c := range cases[1:] {
+		got := new(bytes.Buffer)
+		if err := c.search(got); err != nil {
+			return fmt.Errorf("%s: %s", c.name, err)
+		}
+		if got.String() != expected.String() {
+			return fmt.Errorf("%s: result doesn't match %s", c.name, cases[0].name)
+		}
+	}
+	return nil
+}
+
+// runBench checks that every search variant prints the same as SlowSearch
+// and reports benchmark results for each of them.
+func runBench(out io.Writer) error {
+	cases := benchCases(filePath)
+	if err := checkBenchCases(cases); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "name\truns\tns/op\tB/op\tallocs/op\t")
+	for _, c := range cases {
+		search := c.search
+		var err error
+		res := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err = search(ioutil.Discard); err != nil {
+					b.FailNow()
+				}
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %s", c.name, err)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t\n",
+			c.name, res.N, res.NsPerOp(), res.AllocedBytesPerOp(), res.AllocsPerOp())
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestBenchCases(t *testing.T) {
+	if err := checkBenchCases(benchCases(filePath)); err != nil {
+		t.Error(err)
+	}
+	broken := append(benchCases(filePath)[:1], benchCase{"broken", func(out io.Writer) error {
+		_, err := fmt.Fprintln(out, "nothing")
+		return err
+	}})
+	if err := checkBenchCases(broken); err == nil {
+		t.Error("expected mismatch error")
+	}
+}
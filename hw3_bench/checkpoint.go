@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const defaultCheckpointLines = 100000
+
+// checkpoint is the state of a file search persisted to resume it later
+type checkpoint struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Lines  int    `json:"lines"`
+	// Index is the index of the last processed record
+	Index    int            `json:"index"`
+	Browsers map[string]int `json:"browsers"`
+	// Sketch keeps HyperLogLog registers over the memory budget
+	Sketch  []byte       `json:"sketch,omitempty"`
+	Records int          `json:"records"`
+	Sampled int          `json:"sampled"`
+	Found   int          `json:"found"`
+	Skipped int          `json:"skipped"`
+	Samples []savedError `json:"samples,omitempty"`
+}
+
+type savedError struct {
+	Line int    `json:"line"`
+	Err  string `json:"err"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %s", path, err)
+	}
+	return cp, nil
+}
+
+// save replaces the checkpoint file atomically, so an interrupted save
+// leaves the previous checkpoint
+func (cp *checkpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *searcher) saveState(cp *checkpoint) {
+	cp.Browsers = make(map[string]int, len(s.seenBrowsers))
+	for browser, users := range s.seenBrowsers {
+		cp.Browsers[browser] = *users
+	}
+	cp.Sketch = nil
+	if s.approxBrowsers != nil {
+		cp.Sketch = s.approxBrowsers.registers
+	}
+	cp.Records, cp.Sampled, cp.Found = s.records, s.sampled, s.found
+	cp.Skipped = s.errors.Skipped
+	cp.Samples = cp.Samples[:0]
+	for _, err := range s.errors.Samples {
+		cp.Samples = append(cp.Samples, savedError{err.Line, err.Err.Error()})
+	}
+}
+
+func (s *searcher) restoreState(cp *checkpoint) {
+	if len(cp.Sketch) == hllRegisters {
+		s.approximateBrowsers()
+		copy(s.approxBrowsers.registers, cp.Sketch)
+	}
+	for browser, users := range cp.Browsers {
+		s.countBrowser(browser, users)
+	}
+	s.records, s.sampled, s.found = cp.Records, cp.Sampled, cp.Found
+	s.errors.Skipped = cp.Skipped
+	for _, err := range cp.Samples {
+		s.errors.Samples = append(s.errors.Samples, LineError{err.Line, errors.New(err.Err)})
+	}
+}
+
+// openLinesAt opens lines starting at offset of the decompressed data
+func openLinesAt(path string, useMmap bool, offset int64) (lineSource, func() error, error) {
+	if !useMmap {
+		// plain files are seeked, compressed ones are read up to offset
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		magic := make([]byte, len(zstdMagic))
+		n, err := file.ReadAt(magic, 0)
+		if err != nil && err != io.EOF {
+			file.Close()
+			return nil, nil, err
+		}
+		if !isCompressed(magic[:n]) {
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				file.Close()
+				return nil, nil, err
+			}
+			return bufio.NewReader(file), file.Close, nil
+		}
+		file.Close()
+	}
+	lines, closeLines, err := openLines(path, useMmap)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch l := lines.(type) {
+	case *bytesLines:
+		if offset > int64(len(l.data)) {
+			offset = int64(len(l.data))
+		}
+		l.pos = int(offset)
+	case *bufio.Reader:
+		for offset > 0 {
+			chunk := offset
+			if chunk > 1<<30 {
+				chunk = 1 << 30
+			}
+			n, err := l.Discard(int(chunk))
+			offset -= int64(n)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				closeLines()
+				return nil, nil, err
+			}
+		}
+	}
+	return lines, closeLines, nil
+}
+
+// firstLine reads the first line of the file to restore the decoder
+func firstLine(path string) ([]byte, error) {
+	lines, closeLines, err := openLines(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer closeLines()
+	line, err := lines.ReadSlice('\n')
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	return append([]byte(nil), line...), nil
+}
+
+// searchFileResumable searches the file continuing from the checkpoint if
+// it's there and saves the checkpoint every opts.CheckpointLines lines.
+// Output is flushed before every save, so users printed before the
+// checkpoint are not printed again, cancelled search saves the checkpoint
+// too. After a crash users found since the last checkpoint are printed
+// again. The checkpoint is removed when the file is done.
+func searchFileResumable(ctx context.Context, s *searcher, path, source string, w *userWriter) error {
+	cpPath := s.opts.Checkpoint
+	cp, err := loadCheckpoint(cpPath)
+	if err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if cp != nil && cp.Path != absPath {
+		return fmt.Errorf("checkpoint %s is for %s", cpPath, cp.Path)
+	}
+	if cp == nil {
+		cp = &checkpoint{Path: absPath, Index: -1}
+	}
+	lines, closeLines, err := openLinesAt(path, s.opts.Mmap, cp.Offset)
+	if err != nil {
+		return err
+	}
+	defer closeLines()
+
+	s.restoreState(cp)
+	sc := s.newScanner(ctx, lines)
+	if cp.Offset > 0 {
+		header, err := firstLine(path)
+		if err != nil {
+			return err
+		}
+		if sc.decode, _, err = newDecoder(s.opts.Format, s.opts.BrowsersSeparator, header); err != nil {
+			return err
+		}
+		sc.index = cp.Index
+		sc.progress = Progress{cp.Offset, cp.Lines}
+	}
+	every := s.opts.CheckpointLines
+	if every <= 0 {
+		every = defaultCheckpointLines
+	}
+	sc.checkpointLines = every
+	sc.checkpoint = func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		cp.Offset, cp.Lines, cp.Index = sc.progress.Bytes, sc.progress.Lines, sc.index
+		s.saveState(cp)
+		return cp.save(cpPath)
+	}
+	for sc.next() {
+		if err := w.writeUser(source, sc.index, &s.user); err != nil {
+			return err
+		}
+	}
+	if sc.err != nil && sc.err == ctx.Err() {
+		// cancellation stops between lines, so the state is consistent
+		if err := sc.checkpoint(); err != nil {
+			return err
+		}
+	}
+	if sc.err != nil {
+		return fmt.Errorf("%s: %s", path, sc.err)
+	}
+	return os.Remove(cpPath)
+}
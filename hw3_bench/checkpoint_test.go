@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFastSearchFilesCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	full := new(bytes.Buffer)
+	opts := Options{TopBrowsers: 3}
+	if err := FastSearchFiles(context.Background(), []string{filePath}, full, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mmap := range []bool{false, true} {
+		opts.Mmap = mmap
+		opts.Checkpoint = filepath.Join(dir, "search.checkpoint")
+		opts.CheckpointLines = 100
+		ctx, cancel := context.WithCancel(context.Background())
+		opts.ProgressLines = 450
+		opts.Progress = func(p Progress) { cancel() }
+		first := new(bytes.Buffer)
+		if err := FastSearchFiles(ctx, []string{filePath}, first, opts); err == nil {
+			t.Fatalf("mmap %v: expected cancelled search", mmap)
+		}
+		if _, err := os.Stat(opts.Checkpoint); err != nil {
+			t.Fatalf("checkpoint is not saved: %v", err)
+		}
+
+		opts.Progress = nil
+		second := new(bytes.Buffer)
+		if err := FastSearchFiles(context.Background(), []string{filePath}, second, opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(opts.Checkpoint); !os.IsNotExist(err) {
+			t.Errorf("checkpoint is not removed after the search")
+		}
+		// users of both runs are the users of the full run
+		resumed := first.String() + strings.TrimPrefix(second.String(), "found users:\n")
+		if resumed != full.String() {
+			t.Errorf("mmap %v: Got:\n%v\nExpected:\n%v", mmap, resumed, full.String())
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxErrorSamples = 10
+
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// ParseErrors summarizes lines skipped in lenient mode
+type ParseErrors struct {
+	Skipped int
+	// Samples keeps first skipped lines
+	Samples []LineError
+}
+
+func (p *ParseErrors) add(err LineError) {
+	p.Skipped++
+	if len(p.Samples) < maxErrorSamples {
+		p.Samples = append(p.Samples, err)
+	}
+}
+
+func (p *ParseErrors) merge(other *ParseErrors) {
+	p.Skipped += other.Skipped
+	for _, err := range other.Samples {
+		if len(p.Samples) == maxErrorSamples {
+			break
+		}
+		p.Samples = append(p.Samples, err)
+	}
+}
+
+func validateEmail(email string) error {
+	atIdx := strings.Index(email, "@")
+	if atIdx == -1 || atIdx == len(email)-1 {
+		return fmt.Errorf("malformed email %q", email)
+	}
+	return nil
+}
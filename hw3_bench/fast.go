@@ -2,12 +2,10 @@ package main
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"strings"
 )
 
 const (
@@ -26,53 +24,446 @@ func FastSearch(out io.Writer) {
 	if err != nil {
 		panic(err)
 	}
-	seenBrowsers := make(map[string]struct{}, 150)
-	bufReader := bufio.NewReader(file)
+	defer file.Close()
+	if err := FastSearchReader(file, out); err != nil {
+		panic(err)
+	}
+}
+
+type Options struct {
+	// TopBrowsers adds report of N most popular browsers by user count,
+	// negative value reports every browser, 0 disables the report
+	TopBrowsers int
+	// Workers is the number of input files processed concurrently
+	Workers int
+	// Lenient skips malformed lines instead of failing, every skipped line
+	// is reported to ErrorLog if it's set
+	Lenient  bool
+	ErrorLog io.Writer
+	// Format is one of FormatJSON, FormatCSV or FormatTSV, sniffed from the
+	// first line if empty. BrowsersSeparator splits browsers column of
+	// CSV and TSV input, "|" by default
+	Format            string
+	BrowsersSeparator string
+	// BrowserFamilies counts browser families parsed from user agents
+	// instead of raw user agent strings
+	BrowserFamilies bool
+	// BrowsersMemory is the memory budget in bytes for counting browsers,
+	// over it unique browsers are estimated with HyperLogLog and top
+	// browsers are not reported, 0 means no limit
+	BrowsersMemory int64
+	// FlushSize is the size of output batch, 32KB by default
+	FlushSize int
+	// SummaryOnly suppresses found users and prints only the summary
+	SummaryOnly bool
+	// Sink receives found users instead of the output writer
+	Sink Sink
+	// Filter selects users to output, Android and MSIE users by default
+	Filter *Filter
+	// SampleEvery processes only every Nth record and SampleReservoir only
+	// a uniform random sample of N records selected with SampleSeed.
+	// Users counts of the summary are extrapolated to the whole input.
+	SampleEvery     int
+	SampleReservoir int
+	SampleSeed      int64
+	// Checkpoint is the file where search of a single input file saves its
+	// state every CheckpointLines lines, 100000 by default, and resumes from
+	// if it's there. Not supported with SampleReservoir.
+	Checkpoint      string
+	CheckpointLines int
+	// Mmap maps uncompressed input files into memory instead of reading them,
+	// ignored on platforms without mmap
+	Mmap bool
+	// Progress is called for every input each ProgressLines lines and when
+	// the input is over
+	Progress      func(Progress)
+	ProgressLines int
+}
+
+type Progress struct {
+	Bytes int64
+	Lines int
+}
+
+const defaultProgressLines = 10000
+
+func FastSearchReader(r io.Reader, out io.Writer) error {
+	return FastSearchOptions(r, out, Options{})
+}
+
+func FastSearchOptions(r io.Reader, out io.Writer, opts Options) error {
+	return FastSearchContext(context.Background(), r, out, opts)
+}
+
+// FastSearchContext stops with ctx.Err() as soon as ctx is done.
+func FastSearchContext(ctx context.Context, r io.Reader, out io.Writer, opts Options) error {
+	s := newSearcher(opts)
+	w := newUserWriter(out, opts)
+	if err := w.writeHeader(); err != nil {
+		w.Close()
+		return err
+	}
+	err := s.scan(ctx, bufio.NewReader(r), func(index int, user *User) error {
+		return w.writeUser("", index, user)
+	})
+	if err != nil {
+		w.Close()
+		return err
+	}
+	s.writeSummary(w)
+	return w.Close()
+}
+
+type searcher struct {
+	opts Options
+	// counters are pointers, so increment doesn't replace the copied key
+	// with the one pointing into the read buffer
+	seenBrowsers map[string]*int
+	// browsersSize is memory taken by seenBrowsers, when it's over the
+	// budget browsers are counted approximately by approxBrowsers
+	browsersSize   int64
+	approxBrowsers *hyperLogLog
+	user           User
+	filter         *Filter
+	errors         ParseErrors
+	families       []string
+	// records is the number of input records, sampled of them were
+	// processed and found users matched
+	records int
+	sampled int
+	found   int
+}
+
+var defaultCompiledFilter = mustCompileFilter(defaultFilter)
+
+func mustCompileFilter(expr string) *Filter {
+	f, err := CompileFilter(expr)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func newSearcher(opts Options) *searcher {
+	filter := opts.Filter
+	if filter == nil {
+		filter = defaultCompiledFilter
+	}
+	return &searcher{
+		opts:         opts,
+		seenBrowsers: make(map[string]*int, 150),
+		filter:       filter,
+	}
+}
+
+// lineSource is implemented by bufio.Reader and by bytesLines for
+// files mapped into memory.
+type lineSource interface {
+	ReadSlice(delim byte) ([]byte, error)
+}
+
+// scan reads users line by line and calls emit for every matched one.
+// User passed to emit is valid only until emit returns.
+func (s *searcher) scan(ctx context.Context, lines lineSource, emit func(index int, user *User) error) error {
+	sc := s.newScanner(ctx, lines)
+	for sc.next() {
+		if err := emit(sc.index, &s.user); err != nil {
+			return err
+		}
+	}
+	return sc.err
+}
+
+// lineScanner iterates over matched users of the input
+type lineScanner struct {
+	s             *searcher
+	ctx           context.Context
+	done          <-chan struct{}
+	lines         lineSource
+	index         int
+	progress      Progress
+	progressLines int
+	decode        decodeFunc
+	reservoir     *reservoir
+	// checkpoint is called before reading a line every checkpointLines lines
+	checkpoint      func() error
+	checkpointLines int
+	finished        bool
+	err             error
+}
+
+func (s *searcher) newScanner(ctx context.Context, lines lineSource) *lineScanner {
+	progressLines := s.opts.ProgressLines
+	if progressLines <= 0 {
+		progressLines = defaultProgressLines
+	}
+	sc := &lineScanner{
+		s:             s,
+		ctx:           ctx,
+		done:          ctx.Done(),
+		lines:         lines,
+		index:         -1,
+		progressLines: progressLines,
+	}
+	if s.opts.SampleReservoir > 0 {
+		sc.reservoir = newReservoir(s.opts.SampleReservoir, s.opts.SampleSeed)
+	}
+	return sc
+}
 
-	androidB := []byte(android)
-	msieB := []byte(msie)
-	user := User{}
-	index := -1
-	fmt.Fprintln(out, "found users:")
+func (sc *lineScanner) reportProgress() {
+	if sc.s.opts.Progress != nil {
+		sc.s.opts.Progress(sc.progress)
+	}
+}
+
+func (sc *lineScanner) stop(err error) bool {
+	sc.finished = true
+	sc.err = err
+	if err == nil {
+		sc.reportProgress()
+	}
+	return false
+}
+
+// next advances to the next matched user which is stored in searcher,
+// it returns false when input is over or an error occurred.
+func (sc *lineScanner) next() bool {
+	user := &sc.s.user
 	for {
-		index++
-		segment, err := bufReader.ReadSlice('\n')
-		if err != nil {
-			if err == io.EOF {
+		segment, ok := sc.nextRecord()
+		if !ok {
+			return false
+		}
+		sc.s.sampled++
+		if !sc.s.filter.mayMatch(segment) {
+			continue
+		}
+		err := sc.decode(segment, user)
+		if err == nil {
+			if !sc.s.match(user) {
+				continue
+			}
+			if err = validateEmail(user.Email); err == nil {
+				sc.s.found++
+				return true
+			}
+		}
+		if !sc.s.opts.Lenient {
+			return sc.stop(LineError{sc.index, err})
+		}
+		sc.s.skip(LineError{sc.index, err})
+	}
+}
+
+// nextRecord returns the next record to be processed, all of them or
+// only the sampled ones.
+func (sc *lineScanner) nextRecord() ([]byte, bool) {
+	if sc.reservoir == nil {
+		return sc.readRecord()
+	}
+	if !sc.reservoir.filled {
+		for {
+			segment, ok := sc.readRecord()
+			if !ok {
 				break
 			}
-			panic(err)
+			sc.reservoir.add(sc.index, segment)
+		}
+		if sc.err != nil {
+			return nil, false
 		}
+		sc.reservoir.fill()
+	}
+	rec, ok := sc.reservoir.pop()
+	if !ok {
+		return nil, false
+	}
+	sc.index = rec.index
+	return rec.data, true
+}
 
-		if !(bytes.Contains(segment, androidB) || bytes.Contains(segment, msieB)) {
-			continue
+// readRecord reads the next record skipping the header and the lines
+// which are not in every Nth sample.
+func (sc *lineScanner) readRecord() ([]byte, bool) {
+	for !sc.finished {
+		select {
+		case <-sc.done:
+			return nil, sc.stop(sc.ctx.Err())
+		default:
+		}
+		if sc.checkpoint != nil && sc.progress.Lines > 0 && sc.progress.Lines%sc.checkpointLines == 0 {
+			if err := sc.checkpoint(); err != nil {
+				return nil, sc.stop(err)
+			}
+		}
+		sc.index++
+		segment, err := sc.lines.ReadSlice('\n')
+		if err != nil && err != io.EOF {
+			return nil, sc.stop(err)
 		}
-		if err := json.Unmarshal(segment, &user); err != nil {
-			panic(err)
-		}
-		isAndroid := false
-		isMSIE := false
-		for _, browser := range user.Browsers {
-			isAndroidFinded := strings.Contains(browser, android)
-			isMSIEFinded := strings.Contains(browser, msie)
-			if isAndroidFinded || isMSIEFinded {
-				isAndroid = isAndroid || isAndroidFinded
-				isMSIE = isMSIE || isMSIEFinded
-				_, ok := seenBrowsers[browser]
-				if !ok {
-					seenBrowsers[browser] = struct{}{}
-				}
+		// the last line may be not terminated by line break
+		if len(segment) == 0 && err == io.EOF {
+			return nil, sc.stop(nil)
+		}
+		sc.progress.Bytes += int64(len(segment))
+		sc.progress.Lines++
+		if err == io.EOF {
+			sc.finished = true
+			sc.reportProgress()
+		} else if sc.progress.Lines%sc.progressLines == 0 {
+			sc.reportProgress()
+		}
+		if sc.decode == nil {
+			decode, isHeader, err := newDecoder(sc.s.opts.Format, sc.s.opts.BrowsersSeparator, segment)
+			if err != nil {
+				return nil, sc.stop(err)
+			}
+			sc.decode = decode
+			if isHeader {
+				// header is not counted as a record
+				sc.index--
+				continue
 			}
 		}
-		if !(isAndroid && isMSIE) {
+		sc.s.records++
+		if sc.s.opts.SampleEvery > 1 && sc.index%sc.s.opts.SampleEvery != 0 {
 			continue
 		}
-		atIdx := strings.Index(user.Email, "@")
-		if atIdx == -1 || atIdx == len(user.Email)-1 {
-			panic("malformed email")
+		return segment, true
+	}
+	return nil, false
+}
+
+// match counts browsers of the user and reports whether the user
+// satisfies the filter.
+func (s *searcher) match(user *User) bool {
+	if s.opts.BrowserFamilies {
+		s.countFamilies(user)
+		return s.filter.Match(user)
+	}
+	for i, browser := range user.Browsers {
+		if !s.filter.countable(browser) || isDuplicate(user.Browsers[:i], browser) {
+			continue
+		}
+		s.countBrowser(browser, 1)
+	}
+	return s.filter.Match(user)
+}
+
+// countFamilies counts user once for every browser family
+func (s *searcher) countFamilies(user *User) {
+	s.families = s.families[:0]
+	for _, browser := range user.Browsers {
+		if !s.filter.countable(browser) {
+			continue
+		}
+		family, _ := ParseUserAgent(browser)
+		if isDuplicate(s.families, family) {
+			continue
+		}
+		s.families = append(s.families, family)
+		s.countBrowser(family, 1)
+	}
+}
+
+func (s *searcher) countBrowser(browser string, users int) {
+	if s.approxBrowsers != nil {
+		s.approxBrowsers.add(browser)
+		return
+	}
+	if counter, ok := s.seenBrowsers[browser]; ok {
+		*counter += users
+		return
+	}
+	counter := new(int)
+	*counter = users
+	// browser may refer to the read buffer, so copy it
+	s.seenBrowsers[string([]byte(browser))] = counter
+	s.browsersSize += int64(len(browser)) + browserEntrySize
+	if s.opts.BrowsersMemory > 0 && s.browsersSize > s.opts.BrowsersMemory {
+		s.approximateBrowsers()
+	}
+}
+
+// browserEntrySize is an estimate of memory taken by a map entry
+// besides the key bytes: string header, counter and map overhead
+const browserEntrySize = 48
+
+// approximateBrowsers moves browsers into HyperLogLog, users per browser
+// are lost then
+func (s *searcher) approximateBrowsers() {
+	if s.approxBrowsers != nil {
+		return
+	}
+	s.approxBrowsers = newHyperLogLog()
+	for browser := range s.seenBrowsers {
+		s.approxBrowsers.add(browser)
+	}
+	s.seenBrowsers = nil
+	s.browsersSize = 0
+}
+
+func (s *searcher) uniqueBrowsers() int {
+	if s.approxBrowsers != nil {
+		return s.approxBrowsers.estimate()
+	}
+	return len(s.seenBrowsers)
+}
+
+func (s *searcher) skip(err LineError) {
+	s.errors.add(err)
+	if s.opts.ErrorLog != nil {
+		fmt.Fprintln(s.opts.ErrorLog, "skipped", err)
+	}
+}
+
+func (s *searcher) merge(other *searcher) {
+	if other.approxBrowsers != nil {
+		s.approximateBrowsers()
+		s.approxBrowsers.merge(other.approxBrowsers)
+	}
+	for browser, users := range other.seenBrowsers {
+		s.countBrowser(browser, *users)
+	}
+	s.errors.merge(&other.errors)
+	s.records += other.records
+	s.sampled += other.sampled
+	s.found += other.found
+}
+
+func (s *searcher) browsersLabel() string {
+	if s.opts.BrowserFamilies {
+		return "browser families"
+	}
+	return "browsers"
+}
+
+func (s *searcher) writeSummary(out io.Writer) {
+	fmt.Fprint(out, "\nTotal unique ", s.browsersLabel(), " ", s.uniqueBrowsers())
+	if s.approxBrowsers != nil {
+		fmt.Fprint(out, " approximately")
+	}
+	scale := 1.0
+	if s.opts.sampling() {
+		// unique browsers can't be extrapolated, only users counts are
+		fmt.Fprintln(out, " in sample")
+		if s.sampled > 0 {
+			scale = float64(s.records) / float64(s.sampled)
 		}
-		fmt.Fprintf(out, "[%d] %s <%s [at] %s>\n",
-			index, user.Name, user.Email[:atIdx], user.Email[atIdx+1:])
+		fmt.Fprintf(out, "Sampled %d of %d records, estimated found users %d\n",
+			s.sampled, s.records, extrapolate(s.found, scale))
+	} else {
+		fmt.Fprintln(out)
+	}
+	if s.errors.Skipped > 0 {
+		fmt.Fprintln(out, "Skipped malformed lines", s.errors.Skipped)
+	}
+	switch {
+	case s.opts.TopBrowsers == 0:
+	case s.approxBrowsers != nil:
+		fmt.Fprintln(out, "\nTop browsers are not available over memory budget")
+	default:
+		writeTopBrowsers(out, s.seenBrowsers, s.opts.TopBrowsers, scale)
 	}
-	fmt.Fprintln(out, "\nTotal unique browsers", len(seenBrowsers))
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+const testUsers = `{"browsers":["Opera/9.80 (X11; Linux x86_64)"],"email":"skip@example.com","name":"Skip"}
+{"browsers":["Mozilla/5.0 (Linux; Android 4.4.2)","Mozilla/4.0 (compatible; MSIE 8.0)"],"email":"first@example.com","name":"First"}
+{"browsers":["Mozilla/5.0 (Linux; Android 5.0)","Mozilla/4.0 (compatible; MSIE 8.0)"],"email":"last@example.org","name":"Last"}`
+
+func TestFastSearchReader(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := FastSearchReader(strings.NewReader(testUsers), out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "found users:\n" +
+		"[1] First <first [at] example.com>\n" +
+		"[2] Last <last [at] example.org>\n" +
+		"\nTotal unique browsers 3\n"
+	if out.String() != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestFastSearchReaderBadEmail(t *testing.T) {
+	in := `{"browsers":["Android","MSIE"],"email":"nobody","name":"Bad"}`
+	err := FastSearchReader(strings.NewReader(in), new(bytes.Buffer))
+	if err == nil || !strings.Contains(err.Error(), "malformed email") {
+		t.Errorf("expected malformed email error, got %v", err)
+	}
+}
+
+func TestUserUnmarshal(t *testing.T) {
+	user := User{Browsers: []string{"stale"}}
+	in := `{"company":{"nested":[1,{"name":"no"}]},"name":"Jo \"Jo\"","email":"jo@example.com","browsers":["A","B!"],"phone":null}`
+	if err := user.unmarshal([]byte(in)); err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != `Jo "Jo"` || user.Email != "jo@example.com" ||
+		len(user.Browsers) != 2 || user.Browsers[0] != "A" || user.Browsers[1] != "B!" {
+		t.Errorf("unexpected result: %+v", user)
+	}
+	if err := user.unmarshal([]byte(`{"name":`)); err == nil {
+		t.Error("expected error on truncated json")
+	}
+}
+
+func TestFastSearchTopBrowsers(t *testing.T) {
+	out := new(bytes.Buffer)
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, Options{TopBrowsers: 2}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\nTotal unique browsers 3\n" +
+		"\nTop 2 browsers by users:\n" +
+		"2\tMozilla/4.0 (compatible; MSIE 8.0)\n" +
+		"1\tMozilla/5.0 (Linux; Android 4.4.2)\n"
+	if !strings.HasSuffix(out.String(), expected) {
+		t.Errorf("Got:\n%v\nExpected suffix:\n%v", out.String(), expected)
+	}
+}
+
+func TestFastSearchContext(t *testing.T) {
+	var reports []Progress
+	opts := Options{
+		ProgressLines: 2,
+		Progress: func(p Progress) {
+			reports = append(reports, p)
+		},
+	}
+	err := FastSearchContext(context.Background(), strings.NewReader(testUsers), new(bytes.Buffer), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := Progress{int64(len(testUsers)), 3}
+	if len(reports) != 2 || reports[0].Lines != 2 || reports[1] != total {
+		t.Errorf("unexpected progress reports: %+v", reports)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = FastSearchContext(ctx, strings.NewReader(testUsers), new(bytes.Buffer), Options{})
+	if err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestFastSearchLenient(t *testing.T) {
+	in := testUsers + "\n" +
+		`{"browsers":["Android","MSIE"],"email":"nobody","name":"Bad"}` + "\n" +
+		`{"browsers":["Android", broken` + "\n" +
+		`{"browsers":["Android","MSIE"],"email":"ok@example.com","name":"Ok"}`
+	out := new(bytes.Buffer)
+	errLog := new(bytes.Buffer)
+	err := FastSearchOptions(strings.NewReader(in), out, Options{Lenient: true, ErrorLog: errLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[5] Ok <ok [at] example.com>\n") ||
+		!strings.HasSuffix(out.String(), "Skipped malformed lines 2\n") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if !strings.Contains(errLog.String(), "line 3: malformed email") ||
+		!strings.Contains(errLog.String(), "line 4: ") {
+		t.Errorf("unexpected error log:\n%s", errLog.String())
+	}
+
+	res := Search(strings.NewReader(in), Options{Lenient: true})
+	for res.Next() {
+	}
+	if errs := res.Errors(); errs.Skipped != 2 || len(errs.Samples) != 2 || errs.Samples[0].Line != 3 {
+		t.Errorf("unexpected errors summary: %+v", errs)
+	}
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestFastSearchOutputBatching(t *testing.T) {
+	out := &countingWriter{}
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if out.writes != 1 {
+		t.Errorf("expected single write, got %d", out.writes)
+	}
+
+	out = &countingWriter{}
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, Options{FlushSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if out.writes < 3 {
+		t.Errorf("expected flush on every line, got %d writes", out.writes)
+	}
+
+	out = &countingWriter{}
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, Options{SummaryOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "\nTotal unique browsers 3\n" {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestFastSearchBrowserFamilies(t *testing.T) {
+	out := new(bytes.Buffer)
+	opts := Options{BrowserFamilies: true, SummaryOnly: true, TopBrowsers: -1}
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\nTotal unique browser families 2\n" +
+		"\nTop 2 browsers by users:\n" +
+		"2\tAndroid Browser\n" +
+		"2\tIE\n"
+	if out.String() != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestFastSearchBrowsersMemory(t *testing.T) {
+	out := new(bytes.Buffer)
+	opts := Options{BrowsersMemory: 100, SummaryOnly: true, TopBrowsers: 1}
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\nTotal unique browsers 3 approximately\n" +
+		"\nTop browsers are not available over memory budget\n"
+	if out.String() != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// expandInputs resolves glob patterns and directories into the list of files.
+// Files of a directory are taken without recursion.
+func expandInputs(patterns []string) ([]string, error) {
+	var result []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				result = append(result, path)
+				continue
+			}
+			infos, err := ioutil.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			var files []string
+			for _, fi := range infos {
+				if fi.Mode().IsRegular() {
+					files = append(files, filepath.Join(path, fi.Name()))
+				}
+			}
+			sort.Strings(files)
+			result = append(result, files...)
+		}
+	}
+	return result, nil
+}
+
+func openLines(path string, useMmap bool) (lineSource, func() error, error) {
+	if useMmap {
+		lines, unmap, err := mmapLines(path)
+		if err != nil || lines != nil {
+			return lines, unmap, err
+		}
+	}
+	in, err := OpenInput(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bufio.NewReader(in), in.Close, nil
+}
+
+func searchFile(ctx context.Context, s *searcher, path, source string, w *userWriter) error {
+	lines, closeLines, err := openLines(path, s.opts.Mmap)
+	if err != nil {
+		return err
+	}
+	defer closeLines()
+	err = s.scan(ctx, lines, func(index int, user *User) error {
+		return w.writeUser(source, index, user)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// FastSearchFiles searches every file matched by patterns. If there is more
+// than one file, output lines are tagged with the source file. Browsers are
+// counted across all of the files.
+// With opts.Workers > 1 files are processed concurrently, output still
+// follows the order of files.
+func FastSearchFiles(ctx context.Context, patterns []string, out io.Writer, opts Options) error {
+	w := newUserWriter(out, opts)
+	defer w.Close()
+	paths, err := expandInputs(patterns)
+	if err != nil {
+		return err
+	}
+	source := func(path string) string {
+		if len(paths) == 1 {
+			return ""
+		}
+		return path
+	}
+	total := newSearcher(opts)
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if opts.Checkpoint != "" {
+		if len(paths) != 1 || opts.SampleReservoir > 0 {
+			return fmt.Errorf("checkpoint needs a single input file without reservoir sampling")
+		}
+		if err := searchFileResumable(ctx, total, paths[0], "", w); err != nil {
+			return err
+		}
+		total.writeSummary(w)
+		return w.Close()
+	}
+	if opts.Workers <= 1 {
+		for _, path := range paths {
+			if err := searchFile(ctx, total, path, source(path), w); err != nil {
+				return err
+			}
+		}
+		total.writeSummary(w)
+		return w.Close()
+	}
+
+	type fileResult struct {
+		s       *searcher
+		out     bytes.Buffer
+		matches matchBuffer
+		err     error
+	}
+	results := make([]fileResult, len(paths))
+	sem := make(chan struct{}, opts.Workers)
+	wg := sync.WaitGroup{}
+	for i, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(res *fileResult, path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res.s = newSearcher(opts)
+			fileOut := newUserWriter(&res.out, opts)
+			if opts.Sink != nil {
+				fileOut.sink = &res.matches
+			}
+			res.err = searchFile(ctx, res.s, path, source(path), fileOut)
+			if res.err == nil {
+				res.err = fileOut.Flush()
+			}
+		}(&results[i], path)
+	}
+	wg.Wait()
+	for i := range results {
+		if results[i].err != nil {
+			return results[i].err
+		}
+		if _, err := w.Write(results[i].out.Bytes()); err != nil {
+			return err
+		}
+		for _, m := range results[i].matches {
+			if err := w.sink.Send(m); err != nil {
+				return err
+			}
+		}
+		total.merge(results[i].s)
+	}
+	total.writeSummary(w)
+	return w.Close()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFastSearchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lines := strings.Split(testUsers, "\n")
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	ioutil.WriteFile(a, []byte(lines[1]), 0644)
+	ioutil.WriteFile(b, []byte(lines[0]+"\n"+lines[2]), 0644)
+
+	expected := "found users:\n" +
+		"[" + a + ":0] First <first [at] example.com>\n" +
+		"[" + b + ":1] Last <last [at] example.org>\n" +
+		"\nTotal unique browsers 3\n"
+	for _, opts := range []Options{{Workers: 1}, {Workers: 2}, {Mmap: true}} {
+		for _, patterns := range [][]string{{dir}, {filepath.Join(dir, "*.txt")}, {a, b}} {
+			out := new(bytes.Buffer)
+			if err := FastSearchFiles(context.Background(), patterns, out, opts); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != expected {
+				t.Errorf("%v, %+v\nGot:\n%v\nExpected:\n%v", patterns, opts, out.String(), expected)
+			}
+		}
+	}
+	if err := FastSearchFiles(context.Background(), []string{filepath.Join(dir, "missing")}, new(bytes.Buffer), Options{}); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
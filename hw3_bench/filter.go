@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxContainsPatterns is the number of prefilter patterns checked with
+// bytes.Contains, which is vectorized and beats the matcher for a few of
+// them (see BenchmarkPrefilter)
+const maxContainsPatterns = 6
+
+// defaultFilter is the original Android and MSIE users query
+const defaultFilter = `browsers contains "` + android + `" && browsers contains "` + msie + `"`
+
+// Filter is a compiled expression over user fields, e.g.
+//
+//	email endswith ".com" && (browsers contains "Android" || !name == "")
+//
+// Fields are name, email and browsers, operators are contains, startswith,
+// endswith and ==. A browsers term is true if any of the browsers matches.
+// Terms are combined with !, && and || and grouped with parentheses.
+type Filter struct {
+	root filterNode
+	// browserPatterns are values of browsers contains terms,
+	// only browsers containing one of them are counted
+	browserPatterns []string
+	// prefilter is a list of substrings one of which has to be in the raw
+	// line to match, nil means every line has to be parsed
+	prefilter [][]byte
+	// matcher replaces bytes.Contains for long lists of prefilter patterns
+	matcher *matcher
+}
+
+type filterNode interface {
+	eval(u *User) bool
+}
+
+type andNode struct{ left, right filterNode }
+type orNode struct{ left, right filterNode }
+type notNode struct{ expr filterNode }
+
+type termNode struct {
+	field string
+	op    string
+	value string
+}
+
+func (n andNode) eval(u *User) bool { return n.left.eval(u) && n.right.eval(u) }
+func (n orNode) eval(u *User) bool  { return n.left.eval(u) || n.right.eval(u) }
+func (n notNode) eval(u *User) bool { return !n.expr.eval(u) }
+
+func (n termNode) eval(u *User) bool {
+	switch n.field {
+	case "name":
+		return n.test(u.Name)
+	case "email":
+		return n.test(u.Email)
+	}
+	for _, browser := range u.Browsers {
+		if n.test(browser) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n termNode) test(s string) bool {
+	switch n.op {
+	case "contains":
+		return strings.Contains(s, n.value)
+	case "startswith":
+		return strings.HasPrefix(s, n.value)
+	case "endswith":
+		return strings.HasSuffix(s, n.value)
+	default:
+		return s == n.value
+	}
+}
+
+// requiredBrowsers returns patterns one of which must be in the browsers
+// of every matched user, nil if there is no such guarantee.
+func requiredBrowsers(n filterNode) []string {
+	switch node := n.(type) {
+	case andNode:
+		if left := requiredBrowsers(node.left); left != nil {
+			return left
+		}
+		return requiredBrowsers(node.right)
+	case orNode:
+		left := requiredBrowsers(node.left)
+		right := requiredBrowsers(node.right)
+		if left == nil || right == nil {
+			return nil
+		}
+		return append(left, right...)
+	case termNode:
+		if node.field == "browsers" && node.op == "contains" {
+			return []string{node.value}
+		}
+	}
+	return nil
+}
+
+func collectBrowserPatterns(n filterNode, patterns []string) []string {
+	switch node := n.(type) {
+	case andNode:
+		return collectBrowserPatterns(node.right, collectBrowserPatterns(node.left, patterns))
+	case orNode:
+		return collectBrowserPatterns(node.right, collectBrowserPatterns(node.left, patterns))
+	case notNode:
+		return collectBrowserPatterns(node.expr, patterns)
+	case termNode:
+		if node.field == "browsers" && node.op == "contains" && !isDuplicate(patterns, node.value) {
+			return append(patterns, node.value)
+		}
+	}
+	return patterns
+}
+
+func CompileFilter(expr string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.tokens[p.pos].text)
+	}
+	f := &Filter{
+		root:            root,
+		browserPatterns: collectBrowserPatterns(root, nil),
+	}
+	// every counted browser has to be seen, so the prefilter
+	// is built from all of the patterns, not only the required ones
+	if requiredBrowsers(root) != nil && jsonLiterals(f.browserPatterns) {
+		for _, pattern := range f.browserPatterns {
+			f.prefilter = append(f.prefilter, []byte(pattern))
+		}
+	}
+	if len(f.prefilter) > maxContainsPatterns {
+		f.matcher = newMatcher(f.prefilter)
+	}
+	return f, nil
+}
+
+// jsonLiterals reports whether the patterns are in the raw line as they are.
+// Other characters may be escaped in JSON, e.g. "/" as "\/" or "é" as
+// "\u00e9", and a line without the literal pattern may still match then.
+func jsonLiterals(patterns []string) bool {
+	for _, pattern := range patterns {
+		for i := 0; i < len(pattern); i++ {
+			if c := pattern[i]; c < 0x20 || c > 0x7e || strings.IndexByte(`"\/<>&`, c) >= 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *Filter) Match(u *User) bool {
+	return f.root.eval(u)
+}
+
+// countable reports whether browser has to be counted in unique browsers
+func (f *Filter) countable(browser string) bool {
+	if len(f.browserPatterns) == 0 {
+		return true
+	}
+	for _, pattern := range f.browserPatterns {
+		if strings.Contains(browser, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatch is a cheap check of the raw line before parsing
+func (f *Filter) mayMatch(line []byte) bool {
+	if f.prefilter == nil {
+		return true
+	}
+	if f.matcher != nil {
+		return f.matcher.match(line)
+	}
+	for _, pattern := range f.prefilter {
+		if bytes.Contains(line, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokenIdent tokenKind = iota
+	tokenString
+	tokenOp
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+}
+
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{tokenOp, string(c)})
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"),
+			strings.HasPrefix(expr[i:], "=="):
+			tokens = append(tokens, filterToken{tokenOp, expr[i : i+2]})
+			i += 2
+		case c == '!':
+			tokens = append(tokens, filterToken{tokenOp, "!"})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("filter: unterminated string at %d", i)
+			}
+			value, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("filter: bad string at %d: %s", i, err)
+			}
+			tokens = append(tokens, filterToken{tokenString, value})
+			i = end + 1
+		case unicode.IsLetter(rune(c)):
+			end := i
+			for end < len(expr) && unicode.IsLetter(rune(expr[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{tokenIdent, strings.ToLower(expr[i:end])})
+			i = end
+		default:
+			return nil, fmt.Errorf("filter: unexpected %q at %d", c, i)
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (p *filterParser) peekOp(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOp && p.tokens[p.pos].text == op
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, fmt.Errorf("filter: unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOp("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	switch {
+	case p.peekOp("!"):
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{expr}, nil
+	case p.peekOp("("):
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekOp(")") {
+			return nil, fmt.Errorf("filter: expected )")
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *filterParser) parseTerm() (filterNode, error) {
+	field, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case field.kind != tokenIdent:
+		return nil, fmt.Errorf("filter: expected field, got %q", field.text)
+	case field.text != "name" && field.text != "email" && field.text != "browsers":
+		return nil, fmt.Errorf("filter: unknown field %s", field.text)
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch op.text {
+	case "contains", "startswith", "endswith", "==":
+	default:
+		return nil, fmt.Errorf("filter: unknown operator %s", op.text)
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if value.kind != tokenString {
+		return nil, fmt.Errorf("filter: expected string after %s", op.text)
+	}
+	return termNode{field.text, op.text, value.text}, nil
+}
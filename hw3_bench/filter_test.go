@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	user := &User{
+		Name:     "Jo Doe",
+		Email:    "jo@example.com",
+		Browsers: []string{"Mozilla/5.0 (Linux; Android 4.4.2)", "Opera/9.80"},
+	}
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{`email endswith ".com" && browsers contains "Android"`, true},
+		{`email endswith ".org" || browsers startswith "Opera"`, true},
+		{`!(name == "Jo Doe")`, false},
+		{`browsers contains "Android" && browsers contains "MSIE"`, false},
+		{`name startswith "Jo" && !browsers == "Opera/9.80"`, false},
+	}
+	for _, c := range cases {
+		f, err := CompileFilter(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if f.Match(user) != c.match {
+			t.Errorf("%s: expected %v", c.expr, c.match)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`phone == "1"`,
+		`name like "a"`,
+		`name == `,
+		`name == "a" &&`,
+		`(name == "a"`,
+		`name == "a" name == "b"`,
+		`name == "a`,
+		`name == 'a'`,
+	} {
+		if _, err := CompileFilter(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestFilterPrefilter(t *testing.T) {
+	f := mustCompileFilter(`browsers contains "Android" && email endswith ".com"`)
+	if f.mayMatch([]byte(`{"browsers":["MSIE"]}`)) {
+		t.Error("line without Android should be skipped")
+	}
+	f = mustCompileFilter(`browsers contains "Android" || email endswith ".com"`)
+	if !f.mayMatch([]byte(`{"browsers":["MSIE"]}`)) {
+		t.Error("every line should be parsed")
+	}
+
+	// patterns which may be escaped in JSON aren't prefiltered
+	for expr, line := range map[string]string{
+		`browsers contains "Opera/9"`:    `{"browsers":["Opera\/9.80"]}`,
+		`browsers contains "Déjà"`:       `{"browsers":["D\u00e9j\u00e0 Vu"]}`,
+		`browsers contains "say \"hi\""`: `{"browsers":["say \"hi\""]}`,
+		`browsers contains "<b>"`:        `{"browsers":["\u003cb\u003e"]}`,
+	} {
+		f = mustCompileFilter(expr)
+		if !f.mayMatch([]byte(line)) {
+			t.Errorf("%s: escaped line %s should be parsed", expr, line)
+		}
+		var u User
+		if err := json.Unmarshal([]byte(line), &u); err != nil {
+			t.Fatal(err)
+		}
+		if !f.Match(&u) {
+			t.Errorf("%s: expected %s to match", expr, line)
+		}
+	}
+}
+
+func TestFastSearchFilter(t *testing.T) {
+	out := new(bytes.Buffer)
+	opts := Options{Filter: mustCompileFilter(`email endswith ".org"`)}
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, opts); err != nil {
+		t.Fatal(err)
+	}
+	expected := "found users:\n" +
+		"[2] Last <last [at] example.org>\n" +
+		"\nTotal unique browsers 4\n"
+	if out.String() != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestFilterManyPatterns(t *testing.T) {
+	expr := `browsers contains "a1" || browsers contains "a2" || browsers contains "a3" ||
+		browsers contains "a4" || browsers contains "a5" || browsers contains "a6" || browsers contains "MSIE"`
+	f, err := CompileFilter(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.matcher == nil {
+		t.Fatal("expected matcher prefilter")
+	}
+	if !f.mayMatch([]byte(`{"browsers":["Mozilla/4.0 (compatible; MSIE 8.0)"]}`)) {
+		t.Error("expected MSIE line to pass prefilter")
+	}
+	if f.mayMatch([]byte(`{"browsers":["Opera/9.80"]}`)) {
+		t.Error("expected Opera line to be filtered out")
+	}
+}
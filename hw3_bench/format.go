@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+)
+
+const (
+	FormatAuto = ""
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatTSV  = "tsv"
+
+	defaultBrowsersSeparator = "|"
+)
+
+// decodeFunc fills user from a single input line
+type decodeFunc func(line []byte, u *User) error
+
+func decodeJSON(line []byte, u *User) error {
+	return u.unmarshal(line)
+}
+
+// newDecoder chooses decoder by format, sniffing it from the first line
+// when format isn't set. Separated values must start with a header line
+// naming name, email and browsers columns, isHeader reports that the line
+// is consumed by the decoder.
+func newDecoder(format, browsersSep string, firstLine []byte) (decode decodeFunc, isHeader bool, err error) {
+	if format == FormatAuto {
+		format = sniffFormat(firstLine)
+	}
+	switch format {
+	case FormatJSON:
+		return decodeJSON, false, nil
+	case FormatCSV:
+		decode, err = newSeparatedDecoder(',', browsersSep, firstLine)
+	case FormatTSV:
+		decode, err = newSeparatedDecoder('\t', browsersSep, firstLine)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	return decode, true, err
+}
+
+func sniffFormat(line []byte) string {
+	line = bytes.TrimSpace(line)
+	switch {
+	case bytes.HasPrefix(line, []byte("{")):
+		return FormatJSON
+	case bytes.IndexByte(line, '\t') != -1:
+		return FormatTSV
+	default:
+		return FormatCSV
+	}
+}
+
+func readRecord(comma rune, line []byte) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(line))
+	r.Comma = comma
+	r.LazyQuotes = true
+	return r.Read()
+}
+
+func newSeparatedDecoder(comma rune, browsersSep string, header []byte) (decodeFunc, error) {
+	if browsersSep == "" {
+		browsersSep = defaultBrowsersSeparator
+	}
+	columns, err := readRecord(comma, header)
+	if err != nil {
+		return nil, fmt.Errorf("bad header: %s", err)
+	}
+	idx := map[string]int{"name": -1, "email": -1, "browsers": -1}
+	for i, col := range columns {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if _, ok := idx[col]; ok {
+			idx[col] = i
+		}
+	}
+	for col, i := range idx {
+		if i == -1 {
+			return nil, fmt.Errorf("bad header: missing %s column", col)
+		}
+	}
+	nameIdx, emailIdx, browsersIdx := idx["name"], idx["email"], idx["browsers"]
+	return func(line []byte, u *User) error {
+		record, err := readRecord(comma, line)
+		if err != nil {
+			return err
+		}
+		if len(record) != len(columns) {
+			return fmt.Errorf("expected %d fields, got %d", len(columns), len(record))
+		}
+		u.Name = record[nameIdx]
+		u.Email = record[emailIdx]
+		u.Browsers = u.Browsers[:0]
+		if record[browsersIdx] != "" {
+			u.Browsers = append(u.Browsers, strings.Split(record[browsersIdx], browsersSep)...)
+		}
+		return nil
+	}, nil
+}
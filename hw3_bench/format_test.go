@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFastSearchSeparated(t *testing.T) {
+	csvInput := "id,name,email,browsers\n" +
+		`1,Skip,skip@example.com,"Opera/9.80 (X11; Linux x86_64)"` + "\n" +
+		`2,First,first@example.com,"Mozilla/5.0 (Linux; Android 4.4.2)|Mozilla/4.0 (compatible; MSIE 8.0)"` + "\n" +
+		`3,Last,last@example.org,"Mozilla/5.0 (Linux; Android 5.0)|Mozilla/4.0 (compatible; MSIE 8.0)"`
+	tsvInput := "name\temail\tbrowsers\n" +
+		"Skip\tskip@example.com\tOpera/9.80 (X11; Linux x86_64)\n" +
+		"First\tfirst@example.com\tMozilla/5.0 (Linux; Android 4.4.2);Mozilla/4.0 (compatible; MSIE 8.0)\n" +
+		"Last\tlast@example.org\tMozilla/5.0 (Linux; Android 5.0);Mozilla/4.0 (compatible; MSIE 8.0)\n"
+
+	jsonOut := new(bytes.Buffer)
+	if err := FastSearchReader(strings.NewReader(testUsers), jsonOut); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		input string
+		opts  Options
+	}{
+		{csvInput, Options{}},
+		{csvInput, Options{Format: FormatCSV}},
+		{tsvInput, Options{BrowsersSeparator: ";"}},
+		{tsvInput, Options{Format: FormatTSV, BrowsersSeparator: ";"}},
+	}
+	for _, c := range cases {
+		out := new(bytes.Buffer)
+		if err := FastSearchOptions(strings.NewReader(c.input), out, c.opts); err != nil {
+			t.Errorf("%+v: %v", c.opts, err)
+			continue
+		}
+		if out.String() != jsonOut.String() {
+			t.Errorf("%+v\nGot:\n%v\nExpected:\n%v", c.opts, out.String(), jsonOut.String())
+		}
+	}
+
+	err := FastSearchOptions(strings.NewReader("name,browsers\n"), new(bytes.Buffer), Options{})
+	if err == nil || !strings.Contains(err.Error(), "missing email column") {
+		t.Errorf("expected header error, got %v", err)
+	}
+}
@@ -2,4 +2,7 @@ module hw3_bench
 
 go 1.13
 
-require github.com/mailru/easyjson v0.7.0
+require (
+	github.com/klauspost/compress v1.10.3
+	github.com/mailru/easyjson v0.7.0
+)
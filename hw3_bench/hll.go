@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+)
+
+// hyperLogLog estimates number of distinct strings in fixed 16KB
+// with about 1% standard error
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, hllRegisters)}
+}
+
+func (h *hyperLogLog) add(s string) {
+	// inlined FNV-1a doesn't allocate
+	x := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		x ^= uint64(s[i])
+		x *= 1099511628211
+	}
+	x = mix64(x)
+	idx := x >> (64 - hllPrecision)
+	// rank of the first set bit of the rest, sentinel bit limits it
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// mix64 is the murmur3 finalizer, fnv alone is not uniform enough
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i, rank := range other.registers {
+		if rank > h.registers[i] {
+			h.registers[i] = rank
+		}
+	}
+}
+
+func (h *hyperLogLog) estimate() int {
+	m := float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting is precise for small cardinalities
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int(math.Round(e))
+}
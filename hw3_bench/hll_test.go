@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		h := newHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.add("Mozilla/5.0 " + strconv.Itoa(i))
+			// duplicates don't change the estimate
+			h.add("Mozilla/5.0 " + strconv.Itoa(i/2))
+		}
+		est := h.estimate()
+		if diff := float64(est-n) / float64(n); diff > 0.03 || diff < -0.03 {
+			t.Errorf("%d distinct strings estimated as %d", n, est)
+		}
+	}
+
+	a, b := newHyperLogLog(), newHyperLogLog()
+	for i := 0; i < 20000; i++ {
+		a.add(strconv.Itoa(i))
+		b.add(strconv.Itoa(i + 10000))
+	}
+	a.merge(b)
+	if est := a.estimate(); est < 29000 || est > 31000 {
+		t.Errorf("merged estimate %d, expected about 30000", est)
+	}
+}
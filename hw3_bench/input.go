@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type multiCloser struct {
+	io.Reader
+	closers []func() error
+}
+
+func (m *multiCloser) Close() error {
+	var err error
+	for _, c := range m.closers {
+		if cerr := c(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// NewInputReader detects compression by magic bytes and returns reader
+// which transparently decompresses gzip and zstd streams.
+func NewInputReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return gz, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &multiCloser{zr, []func() error{func() error {
+			zr.Close()
+			return nil
+		}}}, nil
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// OpenInput opens file with NewInputReader, closing returned reader closes the file too.
+func OpenInput(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := NewInputReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &multiCloser{rc, []func() error{rc.Close, file.Close}}, nil
+}
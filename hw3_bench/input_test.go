@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNewInputReader(t *testing.T) {
+	plain := []byte(testUsers)
+
+	gzBuf := new(bytes.Buffer)
+	gz := gzip.NewWriter(gzBuf)
+	gz.Write(plain)
+	gz.Close()
+
+	zstdBuf := new(bytes.Buffer)
+	zw, err := zstd.NewWriter(zstdBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(plain)
+	zw.Close()
+
+	for name, data := range map[string][]byte{
+		"plain": plain,
+		"gzip":  gzBuf.Bytes(),
+		"zstd":  zstdBuf.Bytes(),
+		"empty": nil,
+	} {
+		r, err := NewInputReader(bytes.NewReader(data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		expected := plain
+		if name == "empty" {
+			expected = nil
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("%s: unexpected content %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+const usage = `usage: %[1]s [flags] [file|glob|dir|-]...
+       %[1]s bench
+
+Searches users with both Android and MSIE browsers or matching -filter
+or -patterns. Input is read from files or from stdin if there are no
+files or the only one is -, gzip and zstd input is decompressed.
+
+`
+
+// run is the command line tool, in and out are used for - input and output
+func run(ctx context.Context, args []string, in io.Reader, out io.Writer) (err error) {
+	if len(args) > 1 && args[1] == "bench" {
+		return runBench(out)
+	}
+	opts := Options{}
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), usage, args[0])
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "-", "output file, - for stdout")
+	flags.IntVar(&opts.TopBrowsers, "top", 0, "report N most popular browsers, -1 for all")
+	flags.IntVar(&opts.Workers, "workers", 1, "number of files processed concurrently")
+	flags.BoolVar(&opts.Mmap, "mmap", false, "map input files into memory")
+	flags.StringVar(&opts.Format, "format", FormatAuto, "input format: json, csv or tsv, detected if empty")
+	flags.StringVar(&opts.BrowsersSeparator, "browsers-sep", defaultBrowsersSeparator, "browsers separator for csv and tsv")
+	flags.IntVar(&opts.FlushSize, "flush-size", defaultFlushSize, "output batch size in bytes")
+	flags.BoolVar(&opts.SummaryOnly, "summary", false, "print only the summary without users")
+	flags.BoolVar(&opts.BrowserFamilies, "families", false, "count browser families instead of user agents")
+	flags.Int64Var(&opts.BrowsersMemory, "browsers-mem", 0, "memory budget in bytes for unique browsers, approximate count over it")
+	flags.BoolVar(&opts.Lenient, "lenient", false, "skip malformed lines")
+	verbose := flags.Bool("v", false, "log skipped lines to stderr")
+	filter := flags.String("filter", defaultFilter, "users filter expression")
+	patterns := flags.String("patterns", "", "comma separated substrings each of which has to be in user browsers, replaces -filter")
+	progress := flags.Bool("progress", false, "report progress to stderr")
+	sample := flags.String("sample", "", "process only every Nth record given as 1/N and extrapolate counts")
+	flags.IntVar(&opts.SampleReservoir, "reservoir", 0, "process a random sample of N records and extrapolate counts")
+	flags.StringVar(&opts.Checkpoint, "checkpoint", "", "save progress to the file and resume from it")
+	flags.IntVar(&opts.CheckpointLines, "checkpoint-lines", defaultCheckpointLines, "save checkpoint every N lines")
+	sinkFile := flags.String("sink-file", "", "append found users to the file instead of stdout")
+	sinkRotate := flags.Int64("sink-rotate", 0, "rotate sink file over N bytes")
+	sinkBackups := flags.Int("sink-backups", 3, "rotated sink files to keep")
+	sinkURL := flags.String("sink-url", "", "POST found users to the URL as JSON batches")
+	sinkBatch := flags.Int("sink-batch", defaultHTTPBatchSize, "users in one POST request")
+	if err := flags.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if *patterns != "" {
+		var terms []string
+		for _, pattern := range strings.Split(*patterns, ",") {
+			terms = append(terms, "browsers contains "+strconv.Quote(pattern))
+		}
+		*filter = strings.Join(terms, " && ")
+	}
+	f, err := CompileFilter(*filter)
+	if err != nil {
+		return err
+	}
+	opts.Filter = f
+	if *sample != "" {
+		if opts.SampleEvery, err = ParseSampleRate(*sample); err != nil {
+			return err
+		}
+	}
+	switch {
+	case *sinkFile != "":
+		opts.Sink, err = NewFileSink(FileSinkOptions{
+			Path:       *sinkFile,
+			MaxSize:    *sinkRotate,
+			MaxBackups: *sinkBackups,
+		})
+		if err != nil {
+			return err
+		}
+	case *sinkURL != "":
+		opts.Sink = NewHTTPSink(HTTPSinkOptions{URL: *sinkURL, BatchSize: *sinkBatch})
+	}
+	if *verbose {
+		opts.ErrorLog = os.Stderr
+	}
+	if *progress {
+		opts.Progress = func(p Progress) {
+			fmt.Fprintf(os.Stderr, "\r%d lines, %d bytes", p.Lines, p.Bytes)
+		}
+	}
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = file
+	}
+
+	inputs := flags.Args()
+	if len(inputs) > 0 && !(len(inputs) == 1 && inputs[0] == "-") {
+		for _, input := range inputs {
+			if input == "-" {
+				return fmt.Errorf("stdin can't be mixed with files")
+			}
+		}
+		return FastSearchFiles(ctx, inputs, out, opts)
+	}
+	if opts.Checkpoint != "" {
+		return fmt.Errorf("checkpoint needs an input file")
+	}
+	r, err := NewInputReader(in)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return FastSearchContext(ctx, r, out, opts)
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	if err := run(ctx, os.Args, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
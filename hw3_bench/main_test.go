@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -44,3 +47,44 @@ func BenchmarkFast(b *testing.B) {
 		FastSearch(ioutil.Discard)
 	}
 }
+
+func TestRun(t *testing.T) {
+	expected := new(bytes.Buffer)
+	FastSearch(expected)
+
+	out := new(bytes.Buffer)
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = run(context.Background(), []string{"hw3", "-patterns", "Android,MSIE", "-"}, bytes.NewReader(data), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("stdin results not match\nGot:\n%v\nExpected:\n%v", out.String(), expected.String())
+	}
+
+	dir, err := ioutil.TempDir("", "hw3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "out.txt")
+	err = run(context.Background(), []string{"hw3", "-o", output, "-workers", "2", filePath}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != expected.String() {
+		t.Errorf("file results not match\nGot:\n%s\nExpected:\n%v", result, expected.String())
+	}
+
+	err = run(context.Background(), []string{"hw3", filePath, "-"}, nil, out)
+	if err == nil {
+		t.Error("expected error for stdin mixed with files")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var errMmapUnsupported = errors.New("mmap is not supported on this platform")
+
+// bytesLines splits data into lines without copying.
+type bytesLines struct {
+	data []byte
+	pos  int
+}
+
+func (b *bytesLines) ReadSlice(delim byte) ([]byte, error) {
+	rest := b.data[b.pos:]
+	idx := bytes.IndexByte(rest, delim)
+	if idx == -1 {
+		b.pos = len(b.data)
+		return rest, io.EOF
+	}
+	b.pos += idx + 1
+	return rest[:idx+1], nil
+}
+
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic) || bytes.HasPrefix(data, zstdMagic)
+}
+
+// mmapLines maps file into memory if it's possible. It returns nil source
+// without error when caller should fall back to regular reading.
+func mmapLines(path string) (lineSource, func() error, error) {
+	data, unmap, err := mmapFile(path)
+	if err == errMmapUnsupported {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil || isCompressed(data) {
+		// empty or compressed files are streamed as usual
+		return nil, nil, unmap()
+	}
+	return &bytesLines{data: data}, unmap, nil
+}
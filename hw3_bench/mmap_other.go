@@ -0,0 +1,8 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+func mmapFile(path string) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
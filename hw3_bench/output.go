@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+const defaultFlushSize = 32 * 1024
+
+// userWriter collects output into a batch which is written to out
+// when it grows over flushSize, so matched users cost no write calls.
+// Matched users go to sink instead if it's set.
+type userWriter struct {
+	out         io.Writer
+	buf         []byte
+	flushSize   int
+	summaryOnly bool
+	sink        Sink
+	closed      bool
+}
+
+func newUserWriter(out io.Writer, opts Options) *userWriter {
+	flushSize := opts.FlushSize
+	if flushSize <= 0 {
+		flushSize = defaultFlushSize
+	}
+	return &userWriter{
+		out:         out,
+		buf:         make([]byte, 0, flushSize),
+		flushSize:   flushSize,
+		summaryOnly: opts.SummaryOnly,
+		sink:        opts.Sink,
+	}
+}
+
+func (w *userWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.flushSize {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *userWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// Close flushes the output and closes the sink, it's safe to call it twice
+func (w *userWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.Flush()
+	if w.sink != nil {
+		if sinkErr := w.sink.Close(); err == nil {
+			err = sinkErr
+		}
+	}
+	return err
+}
+
+func (w *userWriter) writeHeader() error {
+	if w.summaryOnly || w.sink != nil {
+		return nil
+	}
+	_, err := w.Write([]byte("found users:\n"))
+	return err
+}
+
+func (w *userWriter) writeUser(source string, index int, user *User) error {
+	switch {
+	case w.summaryOnly:
+		return nil
+	case w.sink != nil:
+		return w.sink.Send(Match{source, index, cloneUser(user)})
+	}
+	w.buf = appendUser(w.buf, source, index, user)
+	if len(w.buf) >= w.flushSize {
+		return w.Flush()
+	}
+	return nil
+}
+
+// appendUser formats matched user, source is added to the index if not empty
+func appendUser(buf []byte, source string, index int, user *User) []byte {
+	// email is already validated by scanner
+	atIdx := strings.Index(user.Email, "@")
+	buf = append(buf, '[')
+	if source != "" {
+		buf = append(buf, source...)
+		buf = append(buf, ':')
+	}
+	buf = strconv.AppendInt(buf, int64(index), 10)
+	buf = append(buf, "] "...)
+	buf = append(buf, user.Name...)
+	buf = append(buf, " <"...)
+	buf = append(buf, user.Email[:atIdx]...)
+	buf = append(buf, " [at] "...)
+	buf = append(buf, user.Email[atIdx+1:]...)
+	return append(buf, ">\n"...)
+}
+
+// cloneUser copies user out of the read buffer
+func cloneUser(u *User) User {
+	user := User{
+		Name:     string([]byte(u.Name)),
+		Email:    string([]byte(u.Email)),
+		Browsers: make([]string, len(u.Browsers)),
+	}
+	for i, browser := range u.Browsers {
+		user.Browsers[i] = string([]byte(browser))
+	}
+	return user
+}
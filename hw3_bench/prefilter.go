@@ -0,0 +1,84 @@
+package main
+
+// finalBit marks transitions into states where one of the patterns ends
+const finalBit = 1 << 30
+
+// matcher finds if any of the patterns occurs in the text in a single pass
+// (Aho-Corasick automaton). Failure links are folded into the dense
+// transition table when building, so the scan is one lookup per byte.
+type matcher struct {
+	next [][256]uint32
+}
+
+func newMatcher(patterns [][]byte) *matcher {
+	m := &matcher{next: make([][256]uint32, 1)}
+	var final []bool
+	final = append(final, false)
+	for _, pattern := range patterns {
+		state := uint32(0)
+		for _, c := range pattern {
+			if m.next[state][c] == 0 {
+				m.next = append(m.next, [256]uint32{})
+				final = append(final, false)
+				m.next[state][c] = uint32(len(m.next) - 1)
+			}
+			state = m.next[state][c]
+		}
+		final[state] = true
+	}
+
+	// breadth-first walk fills missing transitions with ones of the
+	// failure state, which is always closer to the root
+	fail := make([]uint32, len(m.next))
+	queue := make([]uint32, 0, len(m.next))
+	for _, child := range m.next[0] {
+		if child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		final[state] = final[state] || final[fail[state]]
+		for c, child := range m.next[state] {
+			fallback := m.next[fail[state]][c]
+			if child != 0 {
+				fail[child] = fallback
+				queue = append(queue, child)
+			} else {
+				m.next[state][c] = fallback
+			}
+		}
+	}
+	for state := range m.next {
+		for c, target := range m.next[state] {
+			if final[target] {
+				m.next[state][c] |= finalBit
+			}
+		}
+	}
+	return m
+}
+
+// match reports whether text contains any of the patterns
+func (m *matcher) match(text []byte) bool {
+	state := uint32(0)
+	next := m.next
+	root := &next[0]
+	for i := 0; i < len(text); i++ {
+		if state == 0 {
+			// independent lookups are cheaper than following transitions
+			for i < len(text) && root[text[i]] == 0 {
+				i++
+			}
+			if i == len(text) {
+				return false
+			}
+		}
+		state = next[state][text[i]]
+		if state&finalBit != 0 {
+			return true
+		}
+	}
+	return false
+}
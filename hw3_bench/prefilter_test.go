@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	patterns := [][]byte{[]byte("he"), []byte("she"), []byte("hers"), []byte("MSIE")}
+	m := newMatcher(patterns)
+	cases := []string{"", "h", "ushers", "ahishe", "xhxexrs", "Mozilla (MSIE 8.0)", "MSI", "shMSIE"}
+	for _, text := range cases {
+		expected := false
+		for _, p := range patterns {
+			expected = expected || bytes.Contains([]byte(text), p)
+		}
+		if got := m.match([]byte(text)); got != expected {
+			t.Errorf("%q: expected %v, got %v", text, expected, got)
+		}
+	}
+}
+
+func BenchmarkPrefilter(b *testing.B) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	all := []string{android, msie, "Nokia", "BlackBerry", "Kindle", "Opera Mini", "Fennec", "webOS", "Symbian", "Bada", "Silk", "Maemo", "Tizen", "Sailfish", "MeeGo", "Brew"}
+	for _, n := range []int{2, 6, 8, 16} {
+		var patterns [][]byte
+		for _, p := range all[:n] {
+			patterns = append(patterns, []byte(p))
+		}
+		f := &Filter{prefilter: patterns}
+		b.Run(fmt.Sprint("contains", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, line := range lines {
+					f.mayMatch(line)
+				}
+			}
+		})
+		f = &Filter{prefilter: patterns, matcher: newMatcher(patterns)}
+		b.Run(fmt.Sprint("matcher", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, line := range lines {
+					f.mayMatch(line)
+				}
+			}
+		})
+	}
+}
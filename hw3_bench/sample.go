@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (o Options) sampling() bool {
+	return o.SampleEvery > 1 || o.SampleReservoir > 0
+}
+
+func extrapolate(count int, scale float64) int {
+	return int(math.Round(float64(count) * scale))
+}
+
+// ParseSampleRate parses sampling rate written as "1/N"
+func ParseSampleRate(rate string) (int, error) {
+	parts := strings.SplitN(rate, "/", 2)
+	if len(parts) != 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("sample rate must be 1/N, got %q", rate)
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("sample rate must be 1/N, got %q", rate)
+	}
+	return n, nil
+}
+
+type sampledRecord struct {
+	index int
+	data  []byte
+}
+
+// reservoir keeps a uniform random sample of records (algorithm R),
+// records are returned in the input order when input is over.
+type reservoir struct {
+	size    int
+	seen    int
+	records []sampledRecord
+	rnd     *rand.Rand
+	filled  bool
+}
+
+func newReservoir(size int, seed int64) *reservoir {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &reservoir{
+		size:    size,
+		records: make([]sampledRecord, 0, size),
+		rnd:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// add copies data, it may refer to the read buffer
+func (r *reservoir) add(index int, data []byte) {
+	r.seen++
+	if len(r.records) < r.size {
+		r.records = append(r.records, sampledRecord{index, append([]byte(nil), data...)})
+		return
+	}
+	if i := r.rnd.Intn(r.seen); i < r.size {
+		r.records[i].index = index
+		r.records[i].data = append(r.records[i].data[:0], data...)
+	}
+}
+
+func (r *reservoir) fill() {
+	r.filled = true
+	sort.Slice(r.records, func(i, j int) bool {
+		return r.records[i].index < r.records[j].index
+	})
+}
+
+func (r *reservoir) pop() (sampledRecord, bool) {
+	if len(r.records) == 0 {
+		return sampledRecord{}, false
+	}
+	rec := r.records[0]
+	r.records = r.records[1:]
+	return rec, true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseSampleRate(t *testing.T) {
+	if n, err := ParseSampleRate("1/10"); err != nil || n != 10 {
+		t.Errorf("expected 10, got %d, %v", n, err)
+	}
+	for _, rate := range []string{"10", "2/10", "1/0", "1/x"} {
+		if _, err := ParseSampleRate(rate); err == nil {
+			t.Errorf("%s: expected error", rate)
+		}
+	}
+}
+
+func TestFastSearchSampleEvery(t *testing.T) {
+	out := new(bytes.Buffer)
+	opts := Options{SampleEvery: 2, TopBrowsers: 1}
+	if err := FastSearchOptions(strings.NewReader(testUsers), out, opts); err != nil {
+		t.Fatal(err)
+	}
+	// records 0 and 2 are sampled, so Last is found and counted twice
+	expected := "found users:\n" +
+		"[2] Last <last [at] example.org>\n" +
+		"\nTotal unique browsers 2 in sample\n" +
+		"Sampled 2 of 3 records, estimated found users 2\n" +
+		"\nTop 1 browsers by users:\n" +
+		"2\tMozilla/4.0 (compatible; MSIE 8.0)\n"
+	if out.String() != expected {
+		t.Errorf("Got:\n%v\nExpected:\n%v", out.String(), expected)
+	}
+}
+
+func TestFastSearchSampleReservoir(t *testing.T) {
+	for seed := int64(1); seed < 10; seed++ {
+		res := Search(strings.NewReader(testUsers), Options{SampleReservoir: 2, SampleSeed: seed})
+		prev := -1
+		for res.Next() {
+			if res.Index() <= prev {
+				t.Errorf("seed %d: users are out of order", seed)
+			}
+			prev = res.Index()
+		}
+		if err := res.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if res.s.records != 3 || res.s.sampled != 2 {
+			t.Errorf("seed %d: expected 2 of 3 records sampled, got %d of %d",
+				seed, res.s.sampled, res.s.records)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+)
+
+// Results iterates over users found by Search:
+//
+//	res := Search(r, Options{})
+//	for res.Next() {
+//		user := res.User()
+//	}
+//	if err := res.Err(); err != nil {
+//	}
+type Results struct {
+	s  *searcher
+	sc *lineScanner
+}
+
+func Search(r io.Reader, opts Options) *Results {
+	return SearchContext(context.Background(), r, opts)
+}
+
+func SearchContext(ctx context.Context, r io.Reader, opts Options) *Results {
+	s := newSearcher(opts)
+	return &Results{s, s.newScanner(ctx, bufio.NewReader(r))}
+}
+
+func (r *Results) Next() bool {
+	return r.sc.next()
+}
+
+// User returns the current user, it's safe to keep it after Next call
+func (r *Results) User() User {
+	return cloneUser(&r.s.user)
+}
+
+// Index is the line number of the current user
+func (r *Results) Index() int {
+	return r.sc.index
+}
+
+func (r *Results) Err() error {
+	return r.sc.err
+}
+
+// UniqueBrowsers returns number of unique browsers seen so far,
+// it's approximate if the memory budget is exceeded
+func (r *Results) UniqueBrowsers() int {
+	return r.s.uniqueBrowsers()
+}
+
+// Errors returns lines skipped so far in lenient mode
+func (r *Results) Errors() ParseErrors {
+	return r.s.errors
+}
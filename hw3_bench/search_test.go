@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSearchResults(t *testing.T) {
+	var names []string
+	var indexes []int
+	res := Search(strings.NewReader(testUsers), Options{})
+	for res.Next() {
+		names = append(names, res.User().Name)
+		indexes = append(indexes, res.Index())
+	}
+	if err := res.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "First,Last" || indexes[0] != 1 || indexes[1] != 2 {
+		t.Errorf("unexpected results: %v %v", names, indexes)
+	}
+	if res.UniqueBrowsers() != 3 {
+		t.Errorf("expected 3 unique browsers, got %d", res.UniqueBrowsers())
+	}
+	if res.Next() {
+		t.Error("Next should return false after the end")
+	}
+
+	res = Search(strings.NewReader(`{"name":`), Options{Filter: mustCompileFilter(`name == ""`)})
+	if res.Next() || res.Err() == nil {
+		t.Error("expected error on bad json")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Match is a found user delivered to a Sink
+type Match struct {
+	Source string `json:"source,omitempty"`
+	Index  int    `json:"index"`
+	User   User   `json:"user"`
+}
+
+// Sink receives found users instead of the output writer, which gets only
+// the summary then. Sink is closed when the search is over.
+type Sink interface {
+	Send(m Match) error
+	Close() error
+}
+
+// matchBuffer keeps matches of a file searched concurrently
+// until they can be sent in the order of files
+type matchBuffer []Match
+
+func (b *matchBuffer) Send(m Match) error {
+	*b = append(*b, m)
+	return nil
+}
+
+func (b *matchBuffer) Close() error { return nil }
+
+type chanSink chan<- Match
+
+// NewChanSink pushes matches onto ch and closes it with the sink
+func NewChanSink(ch chan<- Match) Sink {
+	return chanSink(ch)
+}
+
+func (s chanSink) Send(m Match) error {
+	s <- m
+	return nil
+}
+
+func (s chanSink) Close() error {
+	close(s)
+	return nil
+}
+
+type FileSinkOptions struct {
+	Path string
+	// MaxSize rotates the file when it grows over MaxSize bytes,
+	// 0 disables rotation
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept as Path.1, Path.2...,
+	// Path.1 being the newest
+	MaxBackups int
+}
+
+// fileSink appends matches to a file in the output format
+type fileSink struct {
+	opts FileSinkOptions
+	f    *os.File
+	size int64
+	buf  []byte
+}
+
+func NewFileSink(opts FileSinkOptions) (Sink, error) {
+	s := &fileSink{opts: opts}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) Send(m Match) error {
+	s.buf = appendUser(s.buf[:0], m.Source, m.Index, &m.User)
+	if s.opts.MaxSize > 0 && s.size > 0 && s.size+int64(len(s.buf)) > s.opts.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(s.buf)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	backup := func(i int) string { return s.opts.Path + "." + strconv.Itoa(i) }
+	if s.opts.MaxBackups > 0 {
+		for i := s.opts.MaxBackups - 1; i > 0; i-- {
+			if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.opts.Path, backup(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.opts.Path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+type HTTPSinkOptions struct {
+	URL string
+	// BatchSize is the number of matches in one request, 100 by default
+	BatchSize int
+	// Client is http.DefaultClient if nil
+	Client *http.Client
+}
+
+const defaultHTTPBatchSize = 100
+
+// httpSink POSTs matches to the URL as JSON arrays
+type httpSink struct {
+	opts  HTTPSinkOptions
+	batch []Match
+}
+
+func NewHTTPSink(opts HTTPSinkOptions) Sink {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultHTTPBatchSize
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &httpSink{opts: opts, batch: make([]Match, 0, opts.BatchSize)}
+}
+
+func (s *httpSink) Send(m Match) error {
+	s.batch = append(s.batch, m)
+	if len(s.batch) < s.opts.BatchSize {
+		return nil
+	}
+	return s.post()
+}
+
+func (s *httpSink) post() error {
+	body, err := json.Marshal(s.batch)
+	if err != nil {
+		return err
+	}
+	s.batch = s.batch[:0]
+	resp, err := s.opts.Client.Post(s.opts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sink %s: %s", s.opts.URL, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	return s.post()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChanSink(t *testing.T) {
+	ch := make(chan Match)
+	out := new(bytes.Buffer)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- FastSearchOptions(strings.NewReader(testUsers), out, Options{Sink: NewChanSink(ch)})
+	}()
+	var names []string
+	for m := range ch {
+		names = append(names, m.User.Name)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"First", "Last"}) {
+		t.Errorf("unexpected matches %v", names)
+	}
+	if out.String() != "\nTotal unique browsers 3\n" {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users.log")
+	for i := 0; i < 2; i++ {
+		sink, err := NewFileSink(FileSinkOptions{Path: path, MaxSize: 40, MaxBackups: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := FastSearchOptions(strings.NewReader(testUsers), ioutil.Discard, Options{Sink: sink}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every line is about 40 bytes, so each file holds one line
+	// and the oldest one is dropped
+	expected := map[string]string{
+		path:        "[2] Last <last [at] example.org>\n",
+		path + ".1": "[1] First <first [at] example.com>\n",
+		path + ".2": "[2] Last <last [at] example.org>\n",
+	}
+	for name, content := range expected {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, got %q", name, content, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no more than 2 backups")
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var batches [][]Match
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []Match
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Error(err)
+		}
+		batches = append(batches, batch)
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(HTTPSinkOptions{URL: srv.URL, BatchSize: 1})
+	err := FastSearchFiles(context.Background(), []string{"data/users.txt"}, ioutil.Discard, Options{Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) == 0 || len(batches[0]) != 1 || batches[0][0].User.Email == "" {
+		t.Errorf("unexpected batches %v", batches)
+	}
+}
+
+func TestHTTPSinkError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(HTTPSinkOptions{URL: srv.URL})
+	err := FastSearchOptions(strings.NewReader(testUsers), ioutil.Discard, Options{Sink: sink})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected 503 error, got %v", err)
+	}
+}
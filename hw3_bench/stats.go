@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+type browserCount struct {
+	name  string
+	users int
+}
+
+func isDuplicate(browsers []string, browser string) bool {
+	for _, b := range browsers {
+		if b == browser {
+			return true
+		}
+	}
+	return false
+}
+
+// topBrowsers returns n browsers with the most users, ties are ordered by name.
+// All browsers are returned when n is negative.
+func topBrowsers(counts map[string]*int, n int) []browserCount {
+	result := make([]browserCount, 0, len(counts))
+	for name, users := range counts {
+		result = append(result, browserCount{name, *users})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].users != result[j].users {
+			return result[i].users > result[j].users
+		}
+		return result[i].name < result[j].name
+	})
+	if n >= 0 && n < len(result) {
+		result = result[:n]
+	}
+	return result
+}
+
+// writeTopBrowsers reports users counts multiplied by scale
+func writeTopBrowsers(out io.Writer, counts map[string]*int, n int, scale float64) {
+	top := topBrowsers(counts, n)
+	fmt.Fprintf(out, "\nTop %d browsers by users:\n", len(top))
+	for _, b := range top {
+		fmt.Fprintf(out, "%d\t%s\n", extrapolate(b.users, scale), b.name)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/mailru/easyjson/jlexer"
+)
+
+// UnmarshalEasyJSON is a hand-written easyjson decoder which extracts only
+// name, email and browsers and skips everything else. Strings point into the
+// lexer input, so they are valid only until the input buffer is reused.
+func (u *User) UnmarshalEasyJSON(in *jlexer.Lexer) {
+	isTopLevel := in.IsStart()
+	if in.IsNull() {
+		if isTopLevel {
+			in.Consumed()
+		}
+		in.Skip()
+		return
+	}
+	u.Name = ""
+	u.Email = ""
+	u.Browsers = u.Browsers[:0]
+	in.Delim('{')
+	for !in.IsDelim('}') {
+		key := in.UnsafeString()
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "name":
+			u.Name = in.UnsafeString()
+		case "email":
+			u.Email = in.UnsafeString()
+		case "browsers":
+			in.Delim('[')
+			for !in.IsDelim(']') {
+				u.Browsers = append(u.Browsers, in.UnsafeString())
+				in.WantComma()
+			}
+			in.Delim(']')
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	in.Delim('}')
+	if isTopLevel {
+		in.Consumed()
+	}
+}
+
+func (u *User) unmarshal(data []byte) error {
+	in := jlexer.Lexer{Data: data}
+	u.UnmarshalEasyJSON(&in)
+	return in.Error()
+}
@@ -0,0 +1,70 @@
+package main
+
+import "strings"
+
+// uaRule maps a product token of user agent to the browser family,
+// version follows the token unless versionToken is set
+type uaRule struct {
+	token        string
+	family       string
+	versionToken string
+}
+
+// uaRules are checked in order, browsers based on other engines are
+// listed before the engines they mimic
+var uaRules = []uaRule{
+	{"Edge/", "Edge", ""},
+	{"OPR/", "Opera", ""},
+	{"Opera Mini/", "Opera Mini", ""},
+	{"Vivaldi/", "Vivaldi", ""},
+	{"UCBrowser/", "UC Browser", ""},
+	{"SeaMonkey/", "SeaMonkey", ""},
+	{"IEMobile/", "IE Mobile", ""},
+	{"IEMobile ", "IE Mobile", ""},
+	{"MSIE ", "IE", ""},
+	{"Trident/7.", "IE", "rv:"},
+	{"Chromium/", "Chromium", ""},
+	{"Chrome/", "Chrome", ""},
+	{"Firefox/", "Firefox", ""},
+	{"Opera/", "Opera", "Version/"},
+	{"Opera ", "Opera", ""},
+	{"Android", "Android Browser", "Version/"},
+	{"Safari/", "Safari", "Version/"},
+	{"Konqueror/", "Konqueror", ""},
+}
+
+// ParseUserAgent returns browser family and version of the user agent.
+// Unknown agents are reported by their first product name. Results refer
+// to ua, no copying is done.
+func ParseUserAgent(ua string) (family, version string) {
+	for _, rule := range uaRules {
+		idx := strings.Index(ua, rule.token)
+		if idx == -1 {
+			continue
+		}
+		version = leadingVersion(ua[idx+len(rule.token):])
+		if rule.versionToken != "" {
+			if vIdx := strings.Index(ua, rule.versionToken); vIdx != -1 {
+				version = leadingVersion(ua[vIdx+len(rule.versionToken):])
+			}
+		}
+		return rule.family, version
+	}
+	product := ua
+	if end := strings.IndexAny(product, " ;("); end != -1 {
+		product = product[:end]
+	}
+	if slash := strings.IndexByte(product, '/'); slash != -1 {
+		return product[:slash], leadingVersion(product[slash+1:])
+	}
+	return product, ""
+}
+
+// leadingVersion returns dotted number at the start of s
+func leadingVersion(s string) string {
+	end := 0
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.') {
+		end++
+	}
+	return strings.TrimRight(s[:end], ".")
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	cases := []struct {
+		ua, family, version string
+	}{
+		{"Mozilla/4.0 (compatible; MSIE 8.0)", "IE", "8.0"},
+		{"Mozilla/5.0 (Windows NT 6.3; Trident/7.0; rv:11.0) like Gecko", "IE", "11.0"},
+		{"Mozilla/4.0 (compatible; MSIE 6.0; Windows CE; IEMobile 8.12; MSIEMobile6.0)", "IE Mobile", "8.12"},
+		{"Mozilla/5.0 (Linux; Android 6.0.1; SM-G900H Build/MMB29K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.98 Mobile Safari/537.36", "Chrome", "52.0.2743.98"},
+		{"Mozilla/5.0 (Linux; U; Android 2.2; en-us; Droid Build/FRG22D) AppleWebKit/533.1 (KHTML, like Gecko) Version/4.0 Mobile Safari/533.1", "Android Browser", "4.0"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/45.0.2454.85 Safari/537.36 OPR/32.0.1948.25", "Opera", "32.0.1948.25"},
+		{"Opera/9.80 (Android; Opera Mini/7.5.33361/31.1543; U; en) Presto/2.8.119 Version/11.1010", "Opera Mini", "7.5.33361"},
+		{"Opera/9.80 (X11; Linux x86_64) Presto/2.12.388 Version/12.16", "Opera", "12.16"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11) AppleWebKit/601.1.56 (KHTML, like Gecko) Version/9.0 Safari/601.1.56", "Safari", "9.0"},
+		{"Mozilla/5.0 (X11; Linux i686; rv:43.0) Gecko/20100101 Firefox/43.0", "Firefox", "43.0"},
+		{"BlackBerry9700/5.0.0.351 Profile/MIDP-2.1 Configuration/CLDC-1.1 VendorID/123", "BlackBerry9700", "5.0.0.351"},
+		{"Facebot", "Facebot", ""},
+	}
+	for _, c := range cases {
+		family, version := ParseUserAgent(c.ua)
+		if family != c.family || version != c.version {
+			t.Errorf("%s: expected %s %s, got %s %s", c.ua, c.family, c.version, family, version)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBatch is the number of searches the server accepts in one batch
+const maxBatch = 100
+
+// BatchResult is the result of one request of FindUsersBatch, Err is the
+// error FindUsers would return for the request
+type BatchResult struct {
+	Response *SearchResponse
+	Err      error
+}
+
+// batchResult is a result of the batch response
+type batchResult struct {
+	Users  []User
+	Cursor string
+	Error  string
+}
+
+// FindUsersBatch does the requests in one round trip per 100 of them,
+// results are in the order of reqs. An invalid request fails only its own
+// result, the error is returned if the whole batch fails. Cache is not
+// used.
+func (srv *SearchClient) FindUsersBatch(reqs []SearchRequest) ([]BatchResult, error) {
+	// limits of the copies are changed
+	reqs = append([]SearchRequest(nil), reqs...)
+	results := make([]BatchResult, len(reqs))
+	// sent are indexes of reqs sent to the server
+	var sent []int
+	var queries []string
+	for i := range reqs {
+		params, err := searchParams(&reqs[i])
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
+		reqs[i].Limit++
+		params.Set("limit", strconv.Itoa(reqs[i].Limit))
+		sent = append(sent, i)
+		queries = append(queries, params.Encode())
+	}
+	for start := 0; start < len(queries); start += maxBatch {
+		end := start + maxBatch
+		if end > len(queries) {
+			end = len(queries)
+		}
+		batch, err := srv.sendBatch(queries[start:end], reqs[sent[start]])
+		if err != nil {
+			return nil, err
+		}
+		for j, result := range batch {
+			i := sent[start+j]
+			if result.Error != "" {
+				results[i].Err = badRequestError(result.Error, reqs[i])
+				continue
+			}
+			results[i].Response = newSearchResponse(result.Users, reqs[i].Limit, result.Cursor)
+		}
+	}
+	return results, nil
+}
+
+// sendBatch posts queries to /batch, req is used for the trace ID, context
+// and errors of the whole batch
+func (srv *SearchClient) sendBatch(queries []string, req SearchRequest) ([]batchResult, error) {
+	body, err := json.Marshal(queries)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(srv.URL, "/") + "/batch"
+	resp, err := srv.send(req.context(), http.MethodPost, url, body, traced(http.Header{"Content-Type": {"application/json"}}, req.TraceID))
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := statusError(resp.StatusCode, data, req); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var results []batchResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("cant unpack result json: %s", err)
+	}
+	if len(results) != len(queries) {
+		return nil, fmt.Errorf("expected %d batch results, got %d", len(queries), len(results))
+	}
+	return results, nil
+}
+
+// newSearchResponse makes the page of users found with one more user than
+// the limit, cursor is the one of the last user
+func newSearchResponse(data []User, limit int, cursor string) *SearchResponse {
+	result := SearchResponse{}
+	if len(data) == limit {
+		result.NextPage = true
+		result.Users = data[0 : len(data)-1]
+		// cursor of the extra user starts the next page with it
+		result.NextCursor = cursor
+	} else {
+		result.Users = data[0:len(data)]
+	}
+	return &result
+}
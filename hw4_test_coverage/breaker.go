@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without a request while CircuitBreaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests at once
+	BreakerOpen
+	// BreakerHalfOpen lets one probe request through
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker opens after Threshold consecutive failures of requests,
+// they are network errors, timeouts and 5xx statuses. Cooldown later one
+// probe request is let through, its success closes the breaker and its
+// failure opens it again. Breaker may be shared by several clients.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures, 0 means 5
+	Threshold int
+	// Cooldown is the time the breaker stays open, 0 means a second
+	Cooldown time.Duration
+	// OnStateChange is called on every change of the state
+	OnStateChange func(from, to BreakerState)
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow returns ErrCircuitOpen if the request has to fail at once,
+// done must be called for the allowed request
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	from := b.state
+	switch {
+	case b.state == BreakerOpen && b.clock().Sub(b.openedAt) >= b.cooldown():
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case b.state == BreakerOpen, b.state == BreakerHalfOpen && b.probing:
+		b.mu.Unlock()
+		return ErrCircuitOpen
+	case b.state == BreakerHalfOpen:
+		b.probing = true
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return nil
+}
+
+// done records the result of the allowed request
+func (b *CircuitBreaker) done(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	from := b.state
+	b.probing = false
+	switch {
+	case !failed:
+		b.failures = 0
+		b.state = BreakerClosed
+	case b.state == BreakerHalfOpen:
+		b.open()
+	default:
+		b.failures++
+		if b.failures >= b.threshold() {
+			b.open()
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = BreakerOpen
+	b.failures = 0
+	b.openedAt = b.clock()
+}
+
+// notify calls OnStateChange without the lock, so it may use the breaker
+func (b *CircuitBreaker) notify(from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold > 0 {
+		return b.Threshold
+	}
+	return 5
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown > 0 {
+		return b.Cooldown
+	}
+	return time.Second
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+// isFailure reports whether the request counts as a failure of the server
+func isFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
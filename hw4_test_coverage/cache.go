@@ -0,0 +1,173 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache keeps responses of recent FindUsers requests, the least
+// recently used one is evicted when it's full. Expired responses with ETag
+// are kept to be revalidated by If-None-Match. It may be shared by clients.
+type ResponseCache struct {
+	size int
+	ttl  time.Duration
+	// now is time.Now, tests replace it
+	now func() time.Time
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	// order has the most recently used entry in front
+	order *list.List
+
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	revalidations uint64
+}
+
+// ResponseCacheStats are counters of ResponseCache, expired entries are
+// counted as misses
+type ResponseCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Revalidations count expired responses confirmed by 304 status
+	Revalidations uint64
+	Len           int
+}
+
+type cacheEntry struct {
+	key     string
+	resp    SearchResponse
+	expires time.Time
+	etag    string
+}
+
+// NewResponseCache keeps up to size responses for ttl each
+func NewResponseCache(size int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		size:  size,
+		ttl:   ttl,
+		now:   time.Now,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+// cacheKey is the request URL and token, params are normalized
+// the way the server treats them
+func (srv *SearchClient) cacheKey(params url.Values) string {
+	normalized := url.Values{}
+	for name, values := range params {
+		normalized[name] = values
+	}
+	orderField := strings.ToLower(params.Get("order_field"))
+	if orderField == "" {
+		orderField = "name"
+	}
+	normalized.Set("order_field", orderField)
+	if normalized.Get("match") == "" {
+		normalized.Set("match", "substring")
+	}
+	return srv.URL + "?" + normalized.Encode() + "\x00" + srv.AccessToken
+}
+
+// get returns a copy of the cached response, nil cache has nothing
+func (c *ResponseCache) get(key string) (*SearchResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok || !c.now().Before(elem.Value.(*cacheEntry).expires) {
+		if ok && elem.Value.(*cacheEntry).etag == "" {
+			c.remove(elem)
+		}
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return copyResponse(&elem.Value.(*cacheEntry).resp), true
+}
+
+// validators is If-None-Match header of the cached response, nil if it has
+// no ETag
+func (c *ResponseCache) validators(key string) http.Header {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok && elem.Value.(*cacheEntry).etag != "" {
+		return http.Header{"If-None-Match": {elem.Value.(*cacheEntry).etag}}
+	}
+	return nil
+}
+
+// revalidate renews the cached response after 304 status and returns
+// its copy
+func (c *ResponseCache) revalidate(key string) (*SearchResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	entry.expires = c.now().Add(c.ttl)
+	c.revalidations++
+	c.order.MoveToFront(elem)
+	return copyResponse(&entry.resp), true
+}
+
+func (c *ResponseCache) put(key string, resp *SearchResponse, etag string) {
+	if c == nil || c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, resp: *copyResponse(resp), expires: c.now().Add(c.ttl), etag: etag}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *ResponseCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*cacheEntry).key)
+}
+
+func (c *ResponseCache) Stats() ResponseCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ResponseCacheStats{
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Revalidations: c.revalidations,
+		Len:           c.order.Len(),
+	}
+}
+
+// copyResponse keeps cached users from being changed by callers
+func copyResponse(resp *SearchResponse) *SearchResponse {
+	result := *resp
+	result.Users = append([]User(nil), resp.Users...)
+	return &result
+}
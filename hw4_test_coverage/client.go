@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,7 +23,8 @@ const (
 
 var (
 	errTest = errors.New("testing")
-	client  = &http.Client{Timeout: time.Second}
+	// defaultClient is used by SearchClient without HTTPClient
+	defaultClient = &http.Client{Timeout: time.Second}
 )
 
 type User struct {
@@ -33,6 +38,12 @@ type User struct {
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// NextCursor requests the next page with SearchRequest.Cursor
+	NextCursor string
+	// Total is the number of matched users on all pages, Offset is the
+	// one of the request. FindUsersBatch doesn't set them.
+	Total  int
+	Offset int
 }
 
 type SearchErrorResponse struct {
@@ -45,6 +56,9 @@ const (
 	OrderByDesc = 1
 
 	ErrorBadOrderField = `OrderField invalid`
+
+	// cursorHeader carries the cursor of the last user of the response
+	cursorHeader = "X-Last-Cursor"
 )
 
 type SearchRequest struct {
@@ -54,18 +68,218 @@ type SearchRequest struct {
 	OrderField string
 	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
 	OrderBy int
+	// Match is how Query is matched: substring (default), word or prefix,
+	// the last two require every word of Query to be found
+	Match string
+	// IgnoreCase matches Query case insensitively
+	IgnoreCase bool
+	// Expr is a search expression applied with Query and filters, e.g.
+	//	name:Boyd AND (age:>30 OR NOT gender:female) "ad minim"
+	// Terms are field:value for id, age, name, about and gender fields.
+	// Text values are case insensitive substrings, := requires the whole
+	// value, numbers may be compared with =, >, >=, < and <=. Bare values
+	// are searched in name and about. AND may be omitted.
+	Expr string
+	// AgeMin and AgeMax are inclusive bounds of Age, 0 means no bound
+	AgeMin int
+	AgeMax int
+	// Gender is male or female, any if empty
+	Gender string
+	// Cursor is SearchResponse.NextCursor of the previous page, Offset is
+	// ignored with it. Query and order must be the same as for that page.
+	Cursor string
+	// NoCache skips SearchClient.Cache lookup, the response still
+	// replaces the cached one
+	NoCache bool
+	// Fields are the fields of User to send, e.g. []string{"Id", "Name"},
+	// others are left zero. Empty means all of them, FindUsersBatch
+	// always gets all of them.
+	Fields []string
+	// TraceID identifies the request in logs of the server, it's sent in
+	// X-Request-Id. A new one is made if it's empty, pass the trace ID of
+	// an incoming request on to trace it across services, e.g.
+	// searchserver.TraceID(r.Context()). FindUsersBatch sends the one of
+	// the first request of every 100.
+	TraceID string
+	// Context cancels the call with its retries, nil is
+	// context.Background(). FindUsersBatch uses the one of the first
+	// request of every 100.
+	Context context.Context
 }
 
+func (req *SearchRequest) context() context.Context {
+	if req.Context == nil {
+		return context.Background()
+	}
+	return req.Context
+}
+
+// SearchClient is safe for concurrent use once it's set up, its fields must
+// not be changed while it's used. Retry, Cache, Breaker, Limiter and
+// Counters may be shared by several clients.
 type SearchClient struct {
 	// токен, по которому происходит авторизация на внешней системе, уходит туда через хедер
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// Retry repeats requests failed with timeout or 5xx, nil disables retries
+	Retry *RetryPolicy
+	// Cache keeps responses of repeated requests, nil disables caching
+	Cache *ResponseCache
+	// DisableCompression asks the server for uncompressed responses,
+	// gzip ones are decompressed otherwise
+	DisableCompression bool
+	// HTTPClient sends requests, it may be set up for TLS, proxies or
+	// connection pooling. nil is a client with a second timeout.
+	HTTPClient *http.Client
+	// Timeout limits every attempt of a call including reading of the
+	// response, streams too. 0 keeps the timeout of HTTPClient only.
+	Timeout time.Duration
+	// Breaker fails calls at once after consecutive failures of the
+	// server, nil disables it
+	Breaker *CircuitBreaker
+	// Limiter limits concurrent requests, nil means no limit
+	Limiter *InFlightLimiter
+	// Counters count requests, nil disables counting
+	Counters *Counters
+}
+
+func (srv *SearchClient) httpClient() *http.Client {
+	if srv.HTTPClient != nil {
+		return srv.HTTPClient
+	}
+	return defaultClient
+}
+
+// do sends GET request retrying it according to the policy,
+// the last response or error is returned
+func (srv *SearchClient) do(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	return srv.send(ctx, http.MethodGet, url, nil, header)
+}
+
+// send is do for any method, POST is not retried as it may be done twice.
+// Retries have the same trace ID, a new one is made if header has none.
+func (srv *SearchClient) send(ctx context.Context, method, url string, body []byte, header http.Header) (*http.Response, error) {
+	if header.Get(traceHeader) == "" {
+		header = traced(header, newTraceID())
+	}
+	policy := srv.Retry
+	if policy == nil || method == http.MethodPost {
+		policy = &RetryPolicy{}
+	}
+	policy.request()
+	for attempt := 1; ; attempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+		searcherReq, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			searcherReq.Header[name] = values
+		}
+		searcherReq.Header.Add("AccessToken", srv.AccessToken)
+		searcherReq.Header.Set("Accept-Encoding", srv.acceptEncoding())
+		if err := srv.Breaker.allow(); err != nil {
+			return nil, err
+		}
+		resp, err := srv.attempt(searcherReq)
+		srv.Breaker.done(isFailure(resp, err))
+		wait, ok := policy.wait(ctx, resp, attempt)
+		if attempt >= policy.MaxAttempts || !isRetryable(resp, err) || !ok || !policy.allow() {
+			if err != nil {
+				return nil, err
+			}
+			return decompress(resp)
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		srv.Counters.retried()
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// attempt sends the request within Timeout and a slot of Limiter, they
+// are held until the body of the response is closed
+func (srv *SearchClient) attempt(req *http.Request) (*http.Response, error) {
+	srv.Limiter.acquire()
+	srv.Counters.started()
+	cancel := func() {}
+	if srv.Timeout > 0 {
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(req.Context(), srv.Timeout)
+		req = req.WithContext(ctx)
+	}
+	done := func() {
+		cancel()
+		srv.Counters.finished()
+		srv.Limiter.release()
+	}
+	resp, err := srv.httpClient().Do(req)
+	srv.Counters.count(isFailure(resp, err), err)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &hookBody{ReadCloser: resp.Body, onClose: done}
+	return resp, nil
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 
+	searcherParams, err := searchParams(&req)
+	if err != nil {
+		return nil, err
+	}
+	// NextPage and Total are computed by the server
+	searcherParams.Add("envelope", "true")
+
+	cacheKey := srv.cacheKey(searcherParams)
+	if !req.NoCache {
+		if cached, ok := srv.Cache.get(cacheKey); ok {
+			return cached, nil
+		}
+	}
+
+	// expired responses are revalidated by their ETag
+	resp, err := srv.do(req.context(), srv.URL+"?"+searcherParams.Encode(), traced(srv.Cache.validators(cacheKey), req.TraceID))
+	if err != nil {
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+		}
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusNotModified {
+		if cached, ok := srv.Cache.revalidate(cacheKey); ok {
+			return cached, nil
+		}
+		return nil, fmt.Errorf("SearchServer response is not modified, but it's not cached")
+	}
+	if err := statusError(resp.StatusCode, body, req); err != nil {
+		return nil, err
+	}
+
+	result := SearchResponse{}
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("cant unpack result json: %s", err)
+	}
+	srv.Cache.put(cacheKey, &result, resp.Header.Get("ETag"))
+
+	return &result, err
+}
+
+// searchParams checks req and returns its params, req.Limit is capped
+func searchParams(req *SearchRequest) (url.Values, error) {
 	searcherParams := url.Values{}
 
 	if req.Limit < 0 {
@@ -79,57 +293,128 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 		return nil, fmt.Errorf("offset must be > 0")
 	}
 
-	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
+	if err := addFilterParams(searcherParams, *req); err != nil {
+		return nil, err
+	}
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
-	searcherParams.Add("query", req.Query)
-	searcherParams.Add("order_field", req.OrderField)
-	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
-
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
-	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	if req.Cursor != "" {
+		searcherParams.Add("cursor", req.Cursor)
+	}
+	return searcherParams, nil
+}
 
-	resp, err := client.Do(searcherReq)
-	if err != nil {
-		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+// addFilterParams checks and adds query, order and filters of req
+func addFilterParams(params url.Values, req SearchRequest) error {
+	switch req.Match {
+	case "", "substring", "word", "prefix":
+	default:
+		return fmt.Errorf("match must be substring, word or prefix, got %q", req.Match)
+	}
+	if req.AgeMin < 0 || req.AgeMax < 0 {
+		return fmt.Errorf("age must be >= 0")
+	}
+	if req.AgeMax != 0 && req.AgeMin > req.AgeMax {
+		return fmt.Errorf("age min %d is over age max %d", req.AgeMin, req.AgeMax)
+	}
+	if req.Gender != "" && req.Gender != "male" && req.Gender != "female" {
+		return fmt.Errorf("gender must be male or female, got %q", req.Gender)
+	}
+	for _, field := range req.Fields {
+		switch strings.ToLower(field) {
+		case "id", "name", "age", "about", "gender":
+		default:
+			return fmt.Errorf("unknown field %q", field)
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
 
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
+	params.Add("query", req.Query)
+	params.Add("order_field", req.OrderField)
+	params.Add("order_by", strconv.Itoa(req.OrderBy))
+	if req.Match != "" {
+		params.Add("match", req.Match)
+	}
+	if req.IgnoreCase {
+		params.Add("ignore_case", "true")
+	}
+	if req.Expr != "" {
+		params.Add("q", req.Expr)
+	}
+	if req.AgeMin != 0 {
+		params.Add("age_min", strconv.Itoa(req.AgeMin))
+	}
+	if req.AgeMax != 0 {
+		params.Add("age_max", strconv.Itoa(req.AgeMax))
+	}
+	if req.Gender != "" {
+		params.Add("gender", req.Gender)
+	}
+	if len(req.Fields) > 0 {
+		params.Add("fields", strings.Join(req.Fields, ","))
+	}
+	return nil
+}
+
+// AuthError is returned for 401 and 403 responses, errors.Is matches it
+// with ErrUnauthorized and ErrForbidden
+type AuthError struct {
+	Status int
+	// Reason is the error value of the server, e.g. ErrorTokenExpired
+	Reason string
+}
+
+var (
+	// ErrUnauthorized is a missing, unknown or expired token
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is a token without the scope required for the request
+	ErrForbidden = errors.New("forbidden")
+)
+
+func (e *AuthError) Error() string {
+	switch {
+	case e.Status == http.StatusForbidden:
+		return "AccessToken is not allowed to do it"
+	case e.Reason == "ErrorTokenExpired":
+		return "AccessToken expired"
+	}
+	return "Bad AccessToken"
+}
+
+func (e *AuthError) Is(target error) bool {
+	if e.Status == http.StatusForbidden {
+		return target == ErrForbidden
+	}
+	return target == ErrUnauthorized
+}
+
+// statusError is the error of the response status, nil if it's not an error
+func statusError(code int, body []byte, req SearchRequest) error {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		errResp := SearchErrorResponse{}
+		// old servers send no body
+		json.Unmarshal(body, &errResp)
+		return &AuthError{Status: code, Reason: errResp.Error}
 	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
+		return fmt.Errorf("SearchServer fatal error")
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("SearchServer rate limit exceeded")
 	case http.StatusBadRequest:
 		errResp := SearchErrorResponse{}
-		err = json.Unmarshal(body, &errResp)
+		err := json.Unmarshal(body, &errResp)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
-		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+			return fmt.Errorf("cant unpack error json: %s", err)
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		return badRequestError(errResp.Error, req)
 	}
+	return nil
+}
 
-	data := []User{}
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
-	}
-	result := SearchResponse{}
-	if len(data) == req.Limit {
-		result.NextPage = true
-		result.Users = data[0 : len(data)-1]
-	} else {
-		result.Users = data[0:len(data)]
+// badRequestError is the error of the reason of 400 response
+func badRequestError(reason string, req SearchRequest) error {
+	if reason == "ErrorBadOrderField" {
+		return fmt.Errorf("OrderFeld %s invalid", req.OrderField)
 	}
-
-	return &result, err
+	return fmt.Errorf("unknown bad request error: %s", reason)
 }
@@ -1,249 +1,52 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"sort"
-	"strconv"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
-)
-
-type SearchServer struct {
-	path string
-}
-
-type UserFromDS struct {
-	Id     int    `xml:"id"`
-	Age    int    `xml:"age"`
-	FName  string `xml:"first_name", json:"-"`
-	LName  string `xml:"last_name", json:"-"`
-	Name   string
-	About  string `xml:"about"`
-	Gender string `xml:"gender"`
-}
-
-type dataset struct {
-	Data []UserFromDS `xml:"row"`
-}
-
-type message struct {
-	orderField string
-	query      string
-	limit      int
-	orderBy    int
-}
-
-type BadOrderFieldError struct{}
-
-func (e BadOrderFieldError) Error() string {
-	return "ErrorBadOrderField"
-}
 
-type UnknownBadOrderFieldError struct{}
-
-func (e UnknownBadOrderFieldError) Error() string {
-	return "unknown bad orderField error"
-}
-
-type BadJSONError struct{}
-
-func (e BadJSONError) Error() string {
-	return "json is bad"
-}
-
-type BadJSONRequestError struct{}
-
-func (e BadJSONRequestError) Error() string {
-	return "json from request is bad"
-}
-
-type ServerError struct{}
-
-func (e ServerError) Error() string {
-	return "server error"
-}
+	"hw4_test_coverage/mockserver"
+	"hw4_test_coverage/searchserver"
+)
 
 const (
 	badJSON           string = "bad json"
 	invalidOrderField        = "order field invalid"
+	unknownError             = "unknown error"
 	serverErr                = "server error"
 	longWork                 = "long work"
 	correctToken             = "correctToken"
 	badToken                 = "badToken"
 )
 
-func parseOrderField(orderField string) error {
-	switch strings.ToLower(orderField) {
-	case "id", "name", "age":
-	case "":
-		orderField = "name"
-	case invalidOrderField:
-		return BadOrderFieldError{}
-	case badJSON:
-		return BadJSONRequestError{}
-	default:
-		return UnknownBadOrderFieldError{}
-	}
-	return nil
-}
-
-func parseQuery(query string) error {
-	switch query {
-	case badJSON:
-		return BadJSONError{}
-	case serverErr:
-		return ServerError{}
-	case longWork:
-		time.Sleep(time.Second)
-	}
-	return nil
-}
-
-func parseLimit(limit string) (int, error) {
-	return strconv.Atoi(limit)
-}
-
-func parseOrderBy(order string) (int, error) {
-	return strconv.Atoi(order)
-}
-
-func parseRequest(r *http.Request) (*message, error) {
-	var err error
-	order := r.FormValue("order_field")
-	if err = parseOrderField(order); err != nil {
-		return nil, err
-	}
-	query := r.FormValue("query")
-	if err = parseQuery(query); err != nil {
-		return nil, err
-	}
-	limitStr := r.FormValue("limit")
-	limit, err := parseLimit(limitStr)
-	if err != nil {
-		return nil, err
-	}
-	orderByStr := r.FormValue("order_by")
-	orderBy, err := parseOrderBy(orderByStr)
-	if err != nil {
-		return nil, err
-	}
-	result := message{order, query, limit, orderBy}
-
-	return &result, nil
-}
-
-type byId []UserFromDS
-type byName []UserFromDS
-type byAge []UserFromDS
-
-func (t byId) Len() int           { return len(t) }
-func (t byId) Less(i, j int) bool { return (t[i]).Id < (t[j]).Id }
-func (t byId) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
-
-func (t byName) Len() int           { return len(t) }
-func (t byName) Less(i, j int) bool { return (t[i]).Name < (t[j]).Name }
-func (t byName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
-
-func (t byAge) Len() int           { return len(t) }
-func (t byAge) Less(i, j int) bool { return (t[i]).Age < (t[j]).Age }
-func (t byAge) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
-
-func sortResult(orderBy int, orderField string, data []UserFromDS) {
-	var order func(s sort.Interface) sort.Interface
-	switch orderBy {
-	case -1:
-		order = sort.Reverse
-	case 1:
-		order = func(s sort.Interface) sort.Interface { return s }
-	case 0:
-		return
-	}
-	switch orderField {
-	case "id":
-		sort.Sort(order(byId(data)))
-	case "name":
-		sort.Sort(order(byName(data)))
-	case "age":
-		sort.Sort(order(byAge(data)))
-	}
-}
-
-func searchBy(query string, path string) ([]UserFromDS, error) {
-	file, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-	users := dataset{}
-
-	err = xml.Unmarshal(file, &users)
-	if err != nil {
-		return nil, err
-	}
-	var result []UserFromDS
-	if query == "" {
-		return users.Data, nil
-	}
-	for i, user := range users.Data {
-		users.Data[i].Name = users.Data[i].FName + " " + users.Data[i].LName
-		if strings.Contains(users.Data[i].Name, query) ||
-			strings.Contains(users.Data[i].About, query) {
-			result = append(result, user)
-		}
-	}
-	return result, nil
-}
-
-func limitResult(limit int, u []UserFromDS) []UserFromDS {
-	if limit >= len(u) {
-		return u
-	}
-	return u[:limit]
-}
-
-func isAuthorized(r *http.Request) bool {
-	token := r.Header.Get("AccessToken")
-	if token == "correctToken" {
-		return true
-	}
-	return false
-}
-
-func (ss *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !isAuthorized(r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
-	msg, err := parseRequest(r)
-	if err != nil {
-		switch err.(type) {
-		case ServerError:
-			w.WriteHeader(http.StatusInternalServerError)
-		case BadOrderFieldError, UnknownBadOrderFieldError:
-			w.WriteHeader(http.StatusBadRequest)
-			s := SearchErrorResponse{err.Error()}
-			resp, _ := json.Marshal(s)
-			w.Write(resp)
-		case BadJSONRequestError:
-			w.WriteHeader(http.StatusBadRequest)
-		case BadJSONError:
-		}
-		return
-	}
-	result, _ := searchBy(msg.query, ss.path)
-	sortResult(msg.orderBy, msg.orderField, result)
-	result = limitResult(msg.limit, result)
-	b, _ := json.Marshal(result)
-	w.Write(b)
+// faultyServer simulates failures of SearchServer for special queries
+// and order fields
+func faultyServer(next http.Handler) *mockserver.Server {
+	mock := mockserver.New(next)
+	mock.Add(
+		mockserver.ServerError().When(mockserver.Param("query", serverErr)),
+		mockserver.BadJSON().When(mockserver.Param("query", badJSON)),
+		mockserver.BadRequest("").When(mockserver.Param("order_field", badJSON)),
+		mockserver.BadRequest(unknownError).When(mockserver.Param("order_field", unknownError)),
+		mockserver.Delay(time.Second).When(mockserver.Param("query", longWork)),
+	)
+	return mock
 }
 
 func setup() SearchClient {
-	ss := SearchServer{"dataset.xml"}
-	srv := httptest.NewServer(&ss)
+	ss := searchserver.New("dataset.xml", correctToken)
+	srv := httptest.NewServer(faultyServer(ss))
 	return SearchClient{
 		AccessToken: correctToken, URL: srv.URL,
 	}
@@ -251,10 +54,10 @@ func setup() SearchClient {
 
 func TestBaseOk(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{26, 1, "W", "name", 1}
+	req := SearchRequest{Limit: 26, Offset: 1, Query: "W", OrderField: "name", OrderBy: 1}
 	result, err := cl.FindUsers(req)
-	if len(result.Users) != 4 {
-		t.Errorf("expected 4, got %d", len(result.Users))
+	if len(result.Users) != 3 {
+		t.Errorf("expected 3, got %d", len(result.Users))
 	}
 	if err != nil {
 		t.Error(err)
@@ -263,7 +66,7 @@ func TestBaseOk(t *testing.T) {
 
 func TestLimitOk(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{3, 1, "W", "name", 1}
+	req := SearchRequest{Limit: 3, Offset: 1, Query: "W", OrderField: "name", OrderBy: 1}
 	res, err := cl.FindUsers(req)
 	if len(res.Users) != 3 {
 		t.Errorf("wrong len of users, must be 3, have %d", len(res.Users))
@@ -275,7 +78,7 @@ func TestLimitOk(t *testing.T) {
 
 func TestLimitNeg(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{-1, 1, "W", "name", 1}
+	req := SearchRequest{Limit: -1, Offset: 1, Query: "W", OrderField: "name", OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "limit must be > 0"
 	if err.Error() != errResult {
@@ -285,7 +88,7 @@ func TestLimitNeg(t *testing.T) {
 
 func TestOfsetNeg(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{10, -1, "W", "name", 1}
+	req := SearchRequest{Limit: 10, Offset: -1, Query: "W", OrderField: "name", OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "offset must be > 0"
 	if err.Error() != errResult {
@@ -296,7 +99,7 @@ func TestOfsetNeg(t *testing.T) {
 func TestTokenBad(t *testing.T) {
 	cl := setup()
 	cl.AccessToken = badToken
-	req := SearchRequest{26, 1, "W", "name", 1}
+	req := SearchRequest{Limit: 26, Offset: 1, Query: "W", OrderField: "name", OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "Bad AccessToken"
 	if err.Error() != errResult {
@@ -306,7 +109,7 @@ func TestTokenBad(t *testing.T) {
 
 func TestOrderFieldBad(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{26, 1, "W", invalidOrderField, 1}
+	req := SearchRequest{Limit: 26, Offset: 1, Query: "W", OrderField: invalidOrderField, OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "OrderFeld"
 	if !strings.Contains(err.Error(), errResult) {
@@ -316,7 +119,7 @@ func TestOrderFieldBad(t *testing.T) {
 
 func TestUnknownOrderField(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{26, 1, "W", "something bad", 1}
+	req := SearchRequest{Limit: 26, Offset: 1, Query: "W", OrderField: unknownError, OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "unknown bad request error"
 	if !strings.Contains(err.Error(), errResult) {
@@ -326,7 +129,7 @@ func TestUnknownOrderField(t *testing.T) {
 
 func TestBadJsonRequest(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{26, 1, "W", badJSON, 1}
+	req := SearchRequest{Limit: 26, Offset: 1, Query: "W", OrderField: badJSON, OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "cant unpack error json"
 	if !strings.Contains(err.Error(), errResult) {
@@ -336,7 +139,7 @@ func TestBadJsonRequest(t *testing.T) {
 
 func TestBadJsonResult(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{5, 1, badJSON, "age", 1}
+	req := SearchRequest{Limit: 5, Offset: 1, Query: badJSON, OrderField: "age", OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "cant unpack result json"
 	if !strings.Contains(err.Error(), errResult) {
@@ -346,7 +149,7 @@ func TestBadJsonResult(t *testing.T) {
 
 func TestServerFatalError(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{5, 1, serverErr, "age", 1}
+	req := SearchRequest{Limit: 5, Offset: 1, Query: serverErr, OrderField: "age", OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "SearchServer fatal error"
 	if err.Error() != errResult {
@@ -357,7 +160,7 @@ func TestServerFatalError(t *testing.T) {
 func TestServerUnknownError(t *testing.T) {
 	cl := setup()
 	cl.URL = "smth"
-	req := SearchRequest{5, 1, serverErr, "age", 1}
+	req := SearchRequest{Limit: 5, Offset: 1, Query: serverErr, OrderField: "age", OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "unknown error"
 	if !strings.Contains(err.Error(), errResult) {
@@ -367,10 +170,916 @@ func TestServerUnknownError(t *testing.T) {
 
 func TestServerSlow(t *testing.T) {
 	cl := setup()
-	req := SearchRequest{5, 1, longWork, "age", 1}
+	req := SearchRequest{Limit: 5, Offset: 1, Query: longWork, OrderField: "age", OrderBy: 1}
 	_, err := cl.FindUsers(req)
 	errResult := "timeout for"
 	if !strings.Contains(err.Error(), errResult) {
 		t.Errorf("expected %s, got %v", errResult, err)
 	}
 }
+
+// setupFlaky fails first failures requests with status
+func setupFlaky(failures, status int, policy *RetryPolicy) (SearchClient, *mockserver.Server) {
+	fs := mockserver.New(searchserver.New("dataset.xml", correctToken))
+	fs.Add(mockserver.Scenario{Status: status}.Times(failures))
+	srv := httptest.NewServer(fs)
+	return SearchClient{AccessToken: correctToken, URL: srv.URL, Retry: policy}, fs
+}
+
+func TestRetryRecovers(t *testing.T) {
+	cl, fs := setupFlaky(2, http.StatusServiceUnavailable, &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Jitter: 0.5})
+	res, err := cl.FindUsers(SearchRequest{Limit: 3, Offset: 0, Query: "W", OrderField: "name", OrderBy: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 3 || fs.Requests() != 3 {
+		t.Errorf("expected 3 users after 3 requests, got %d after %d", len(res.Users), fs.Requests())
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	cl, fs := setupFlaky(10, http.StatusInternalServerError, &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	_, err := cl.FindUsers(SearchRequest{Limit: 3, Offset: 0, Query: "W", OrderField: "name", OrderBy: 1})
+	if err == nil || err.Error() != "SearchServer fatal error" {
+		t.Errorf("expected fatal error, got %v", err)
+	}
+	if fs.Requests() != 3 {
+		t.Errorf("expected 3 requests, got %d", fs.Requests())
+	}
+}
+
+func TestRetryNotForClientErrors(t *testing.T) {
+	cl, fs := setupFlaky(10, http.StatusUnauthorized, &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	if _, err := cl.FindUsers(SearchRequest{Limit: 3, Offset: 0, Query: "W", OrderField: "name", OrderBy: 1}); err == nil {
+		t.Error("expected error")
+	}
+	if fs.Requests() != 1 {
+		t.Errorf("expected single request, got %d", fs.Requests())
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 5, Backoff: time.Millisecond, BudgetRatio: 0.5}
+	cl, fs := setupFlaky(100, http.StatusInternalServerError, policy)
+	for i := 0; i < 4; i++ {
+		cl.FindUsers(SearchRequest{Limit: 3, Offset: 0, Query: "W", OrderField: "name", OrderBy: 1})
+	}
+	// 4 requests earn 2 retries
+	if fs.Requests() != 6 {
+		t.Errorf("expected 6 requests, got %d", fs.Requests())
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, d := range expected {
+		if got := p.delay(i + 1); got != d*time.Millisecond {
+			t.Errorf("retry %d: expected %v, got %v", i+1, d*time.Millisecond, got)
+		}
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := p.delay(1); d < 5*time.Millisecond || d > 15*time.Millisecond {
+			t.Fatalf("delay %v is out of jitter range", d)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	if !isRetryable(nil, timeoutError{}) {
+		t.Error("timeout must be retried")
+	}
+	if isRetryable(nil, errTest) {
+		t.Error("unknown error must not be retried")
+	}
+}
+
+func TestBadURL(t *testing.T) {
+	cl := setup()
+	cl.URL = "http://bad host"
+	_, err := cl.FindUsers(SearchRequest{Limit: 3, Offset: 0, Query: "W", OrderField: "name", OrderBy: 1})
+	if err == nil || !strings.Contains(err.Error(), "unknown error") {
+		t.Errorf("expected unknown error, got %v", err)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	cl := setup()
+	for _, orderBy := range []int{-1, 0, 1} {
+		all, err := cl.FindUsers(SearchRequest{Limit: 25, Query: "a", OrderField: "age", OrderBy: orderBy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := SearchRequest{Limit: 4, Query: "a", OrderField: "age", OrderBy: orderBy}
+		var paged []User
+		for {
+			res, err := cl.FindUsers(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paged = append(paged, res.Users...)
+			if !res.NextPage {
+				break
+			}
+			req.Cursor = res.NextCursor
+		}
+		n := len(all.Users)
+		if len(paged) < n {
+			n = len(paged)
+		}
+		for i := 0; i < n; i++ {
+			if paged[i] != all.Users[i] {
+				t.Fatalf("order %d: user %d differs: %+v != %+v", orderBy, i, paged[i], all.Users[i])
+			}
+		}
+		if !all.NextPage && len(paged) != len(all.Users) {
+			t.Errorf("order %d: expected %d users, got %d", orderBy, len(all.Users), len(paged))
+		}
+	}
+}
+
+func TestCursorMismatch(t *testing.T) {
+	cl := setup()
+	res, err := cl.FindUsers(SearchRequest{Limit: 2, Query: "a", OrderField: "age", OrderBy: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cl.FindUsers(SearchRequest{Limit: 2, Query: "b", OrderField: "age", OrderBy: 1, Cursor: res.NextCursor})
+	if err == nil || !strings.Contains(err.Error(), "ErrorBadCursor") {
+		t.Errorf("expected bad cursor error, got %v", err)
+	}
+}
+
+func TestAgeGenderFilters(t *testing.T) {
+	cl := setup()
+	res, err := cl.FindUsers(SearchRequest{Limit: 25, OrderField: "age", OrderBy: 1, AgeMin: 30, AgeMax: 35, Gender: "female"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) == 0 {
+		t.Fatal("expected some users")
+	}
+	for _, u := range res.Users {
+		if u.Age < 30 || u.Age > 35 || u.Gender != "female" {
+			t.Errorf("user %+v doesn't match filters", u)
+		}
+	}
+}
+
+func TestAgeGenderValidation(t *testing.T) {
+	cl := setup()
+	cases := map[string]SearchRequest{
+		"age must be >= 0":         {Limit: 1, AgeMin: -1},
+		"age min 40 is over":       {Limit: 1, AgeMin: 40, AgeMax: 30},
+		"gender must be male or f": {Limit: 1, Gender: "other"},
+		"match must be substring":  {Limit: 1, Match: "regexp"},
+	}
+	for expected, req := range cases {
+		_, err := cl.FindUsers(req)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestMatchOptions(t *testing.T) {
+	cl := setup()
+	res, err := cl.FindUsers(SearchRequest{Limit: 25, Query: "BOYD", Match: "prefix", IgnoreCase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 1 || res.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("expected Boyd Wolf, got %+v", res.Users)
+	}
+}
+
+func TestFindUsersStream(t *testing.T) {
+	cl := setup()
+	stream, err := cl.FindUsersStream(SearchRequest{OrderField: "id", OrderBy: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var ids []int
+	for stream.Next() {
+		ids = append(ids, stream.User().Id)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	// more users than FindUsers returns at once
+	if len(ids) != 35 || ids[0] != 0 || ids[34] != 34 {
+		t.Errorf("expected ids 0..34, got %v", ids)
+	}
+
+	limited, err := cl.FindUsersStream(SearchRequest{Limit: 2, Query: "Boyd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer limited.Close()
+	count := 0
+	for limited.Next() {
+		count++
+	}
+	if count != 1 {
+		t.Errorf("expected 1 user, got %d", count)
+	}
+}
+
+func TestFindUsersStreamErrors(t *testing.T) {
+	cl := setup()
+	cases := map[string]SearchRequest{
+		"limit must be > 0":     {Limit: -1},
+		"gender must be male":   {Gender: "other"},
+		"OrderFeld bad invalid": {OrderField: "bad"},
+		"SearchServer fatal":    {Query: serverErr},
+		"unknown bad request":   {OrderField: unknownError},
+	}
+	for expected, req := range cases {
+		_, err := cl.FindUsersStream(req)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected %s, got %v", expected, err)
+		}
+	}
+
+	stream, err := cl.FindUsersStream(SearchRequest{Query: badJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	if stream.Next() || stream.Next() || stream.Err() == nil {
+		t.Errorf("expected json error, got %v", stream.Err())
+	}
+
+	cl.AccessToken = "bad"
+	if _, err := cl.FindUsersStream(SearchRequest{}); err == nil || err.Error() != "Bad AccessToken" {
+		t.Errorf("expected bad token error, got %v", err)
+	}
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(searchserver.New("dataset.xml", correctToken))
+	defer srv.Close()
+	cl := SearchClient{AccessToken: correctToken, URL: srv.URL}
+	// the default client doesn't trust the test certificate
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1}); err == nil {
+		t.Fatal("expected certificate error")
+	}
+	cl.HTTPClient = srv.Client()
+	res, err := cl.FindUsers(SearchRequest{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 1 {
+		t.Errorf("expected 1 user, got %d", len(res.Users))
+	}
+}
+
+func TestAuthErrors(t *testing.T) {
+	cases := map[string]struct {
+		status int
+		reason string
+		target error
+	}{
+		"Bad AccessToken":                     {http.StatusUnauthorized, "ErrorBadToken", ErrUnauthorized},
+		"AccessToken expired":                 {http.StatusUnauthorized, "ErrorTokenExpired", ErrUnauthorized},
+		"AccessToken is not allowed to do it": {http.StatusForbidden, "ErrorForbidden", ErrForbidden},
+	}
+	for expected, c := range cases {
+		c := c
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(c.status)
+			json.NewEncoder(w).Encode(SearchErrorResponse{c.reason})
+		}))
+		cl := SearchClient{URL: srv.URL}
+		_, err := cl.FindUsers(SearchRequest{Limit: 1})
+		srv.Close()
+		authErr, ok := err.(*AuthError)
+		if !ok || err.Error() != expected || !errors.Is(err, c.target) || authErr.Reason != c.reason {
+			t.Errorf("expected %s, got %#v", expected, err)
+		}
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	fs := mockserver.New(searchserver.New("dataset.xml", correctToken))
+	srv := httptest.NewServer(fs)
+	defer srv.Close()
+	cache := NewResponseCache(2, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cl := SearchClient{AccessToken: correctToken, URL: srv.URL, Cache: cache}
+
+	find := func(req SearchRequest) *SearchResponse {
+		res, err := cl.FindUsers(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	first := find(SearchRequest{Limit: 30, Query: "Boyd"})
+	first.Users[0].Name = "changed"
+	// the same request normalized the way the server does it
+	second := find(SearchRequest{Limit: 25, Query: "Boyd", OrderField: "Name"})
+	if fs.Requests() != 1 || second.Users[0].Name != "Boyd Wolf" {
+		t.Errorf("expected cached copy, got %d requests, %+v", fs.Requests(), second.Users)
+	}
+	find(SearchRequest{Limit: 1, Query: "Boyd", NoCache: true})
+	find(SearchRequest{Limit: 1, Query: "Boyd"})
+	if fs.Requests() != 2 {
+		t.Errorf("expected NoCache response to be cached, got %d requests", fs.Requests())
+	}
+
+	find(SearchRequest{Limit: 2})
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Len != 2 {
+		t.Errorf("expected eviction, got %+v", stats)
+	}
+	now = now.Add(time.Hour)
+	find(SearchRequest{Limit: 2})
+	if fs.Requests() != 4 {
+		t.Errorf("expected expired response to be requested, got %d requests", fs.Requests())
+	}
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// errors are not cached
+	cl.AccessToken = badToken
+	for i := 0; i < 2; i++ {
+		if _, err := cl.FindUsers(SearchRequest{Limit: 2}); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if fs.Requests() != 6 {
+		t.Errorf("expected errors to be requested again, got %d requests", fs.Requests())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	limiter := searchserver.NewRateLimiter(1, 1)
+	srv := httptest.NewServer(limiter.Handler(searchserver.New("dataset.xml", correctToken)))
+	defer srv.Close()
+	cl := SearchClient{AccessToken: correctToken, URL: srv.URL}
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := cl.FindUsers(SearchRequest{Limit: 1})
+	if err == nil || err.Error() != "SearchServer rate limit exceeded" {
+		t.Errorf("expected rate limit error, got %v", err)
+	}
+	// backoff is much shorter than Retry-After, the retry waits for the latter
+	cl.Retry = &RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	start := time.Now()
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("expected retry after a second, got %s", elapsed)
+	}
+
+	header := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {value}}}
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := retryAfter(header(date)); d < 58*time.Second || d > time.Minute {
+		t.Errorf("expected a minute for %s, got %s", date, d)
+	}
+	for _, value := range []string{"", "soon", "-1"} {
+		if d := retryAfter(header(value)); d != 0 {
+			t.Errorf("expected no delay for %q, got %s", value, d)
+		}
+	}
+}
+
+// setupRetryAfter responds with 503 and Retry-After value, requests are
+// counted
+func setupRetryAfter(value string) (*httptest.Server, *int32) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", value)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	return srv, &requests
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	srv, requests := setupRetryAfter("3600")
+	defer srv.Close()
+	for _, policy := range []*RetryPolicy{
+		{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 100 * time.Millisecond},
+		{MaxAttempts: 3, Backoff: time.Millisecond},
+	} {
+		atomic.StoreInt32(requests, 0)
+		cl := SearchClient{AccessToken: correctToken, URL: srv.URL, Retry: policy}
+		start := time.Now()
+		if _, err := cl.FindUsers(SearchRequest{Limit: 1}); err == nil {
+			t.Error("expected error of the unavailable server")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second || atomic.LoadInt32(requests) != 1 {
+			t.Errorf("expected no retry, got %d requests in %s", atomic.LoadInt32(requests), elapsed)
+		}
+	}
+}
+
+func TestRetryContext(t *testing.T) {
+	srv, requests := setupRetryAfter("1")
+	defer srv.Close()
+	cl := SearchClient{AccessToken: correctToken, URL: srv.URL, Retry: &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}}
+
+	// Retry-After is past the deadline, so the response is returned at once
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1, Context: ctx}); err == nil || atomic.LoadInt32(requests) != 1 {
+		t.Errorf("expected error after a request, got %v after %d", err, atomic.LoadInt32(requests))
+	}
+
+	// the call is canceled while it waits for the retry
+	atomic.StoreInt32(requests, 0)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := cl.FindUsers(SearchRequest{Limit: 1, Context: ctx})
+	if !errors.Is(err, context.Canceled) || time.Since(start) > 500*time.Millisecond || atomic.LoadInt32(requests) != 1 {
+		t.Errorf("expected canceled wait, got %v after %d requests in %s", err, atomic.LoadInt32(requests), time.Since(start))
+	}
+}
+
+// encodingServer records Accept-Encoding of the last request
+type encodingServer struct {
+	encoding string
+	next     http.Handler
+}
+
+func (s *encodingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.encoding = r.Header.Get("Accept-Encoding")
+	s.next.ServeHTTP(w, r)
+}
+
+func TestCompression(t *testing.T) {
+	es := &encodingServer{next: searchserver.New("dataset.xml", correctToken)}
+	srv := httptest.NewServer(es)
+	defer srv.Close()
+	cl := SearchClient{AccessToken: correctToken, URL: srv.URL}
+	var results [][]User
+	for _, disable := range []bool{false, true} {
+		cl.DisableCompression = disable
+		res, err := cl.FindUsers(SearchRequest{Limit: 25, OrderField: "id", OrderBy: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[bool]string{false: "gzip", true: "identity"}[disable]; es.encoding != expected {
+			t.Errorf("expected Accept-Encoding %s, got %s", expected, es.encoding)
+		}
+		results = append(results, res.Users)
+	}
+	if len(results[0]) != 25 || !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("expected the same users with and without compression")
+	}
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte("not gzip"))
+	}))
+	defer broken.Close()
+	cl.URL = broken.URL
+	cl.DisableCompression = false
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1}); err == nil {
+		t.Error("expected gzip error")
+	}
+}
+
+func TestUserChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "dataset.xml")
+	ioutil.WriteFile(path, data, 0644)
+
+	ss := searchserver.New(path)
+	ss.Tokens = searchserver.NewTokenStore(
+		searchserver.Token{Value: correctToken, Scope: searchserver.ScopeAdmin},
+		searchserver.Token{Value: badToken, Scope: searchserver.ScopeRead},
+	)
+	srv := httptest.NewServer(ss)
+	defer srv.Close()
+	cl := SearchClient{AccessToken: correctToken, URL: srv.URL}
+
+	created, err := cl.CreateUser(User{Name: "Ann Lee", Age: 30, Gender: "female"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id != 35 {
+		t.Errorf("expected id 35, got %+v", created)
+	}
+	created.About = "changed"
+	if updated, err := cl.UpdateUser(*created); err != nil || updated.About != "changed" {
+		t.Errorf("expected updated user, got %+v %v", updated, err)
+	}
+	res, err := cl.FindUsers(SearchRequest{Limit: 5, Query: "Ann Lee"})
+	if err != nil || len(res.Users) != 1 || res.Users[0].About != "changed" {
+		t.Errorf("expected changed user to be found, got %+v %v", res, err)
+	}
+	if err := cl.DeleteUser(created.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.DeleteUser(created.Id); err != ErrUserNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if _, err := cl.UpdateUser(User{Id: 100, Name: "A B", Gender: "male"}); err != ErrUserNotFound {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if _, err := cl.CreateUser(User{Name: "Ann"}); err == nil || !strings.Contains(err.Error(), "ErrorBadUser") {
+		t.Errorf("expected bad user error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cl.CreateUserContext(ctx, User{Name: "Bob Lee", Gender: "male"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled create, got %v", err)
+	}
+	if err := cl.DeleteUserContext(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled delete, got %v", err)
+	}
+
+	cl.AccessToken = badToken
+	if err := cl.DeleteUser(0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected forbidden, got %v", err)
+	}
+	readOnly := httptest.NewServer(searchserver.New("dataset.yaml"))
+	defer readOnly.Close()
+	cl.URL = readOnly.URL
+	if err := cl.DeleteUser(0); err == nil || !strings.Contains(err.Error(), "can't be changed") {
+		t.Errorf("expected read only error, got %v", err)
+	}
+}
+
+func TestExpr(t *testing.T) {
+	cl := setup()
+	res, err := cl.FindUsers(SearchRequest{Limit: 25, Expr: "name:boyd OR (age:>=36 AND gender:=male)", OrderField: "id", OrderBy: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) == 0 || res.Users[0].Name != "Boyd Wolf" {
+		t.Fatalf("expected Boyd Wolf first, got %+v", res.Users)
+	}
+	for _, u := range res.Users[1:] {
+		if u.Age < 36 || u.Gender != "male" {
+			t.Errorf("user %+v doesn't match", u)
+		}
+	}
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1, Expr: "age:old"}); err == nil || !strings.Contains(err.Error(), "ErrorBadExpr") {
+		t.Errorf("expected bad expression error, got %v", err)
+	}
+}
+
+func TestFindUsersBatch(t *testing.T) {
+	cl := setup()
+	reqs := []SearchRequest{
+		{Limit: 3, Query: "W", OrderField: "name", OrderBy: 1},
+		{Limit: 1, OrderField: "About"},
+		{Limit: -1},
+		{Limit: 1, OrderField: "id", OrderBy: 1},
+	}
+	results, err := cl.FindUsersBatch(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(reqs) {
+		t.Fatalf("expected %d results, got %d", len(reqs), len(results))
+	}
+	single, _ := cl.FindUsers(reqs[0])
+	// batch responses have no Total
+	single.Total = 0
+	if results[0].Err != nil || !reflect.DeepEqual(results[0].Response, single) {
+		t.Errorf("expected %+v, got %+v %v", single, results[0].Response, results[0].Err)
+	}
+	if results[1].Err == nil || results[1].Err.Error() != "OrderFeld About invalid" {
+		t.Errorf("expected bad order field, got %v", results[1].Err)
+	}
+	if results[2].Err == nil || results[2].Response != nil {
+		t.Errorf("expected bad limit, got %+v", results[2])
+	}
+	if res := results[3].Response; res == nil || len(res.Users) != 1 || res.Users[0].Id != 0 || !res.NextPage {
+		t.Errorf("unexpected result %+v", results[3])
+	}
+	if reqs[0].Limit != 3 {
+		t.Errorf("requests must not be changed, limit is %d", reqs[0].Limit)
+	}
+
+	// more than a batch of the server is sent in parts
+	many := make([]SearchRequest, maxBatch+5)
+	for i := range many {
+		many[i] = SearchRequest{Limit: 1, OrderField: "id", OrderBy: 1, AgeMin: i % 40}
+	}
+	results, err = cl.FindUsersBatch(many)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(many) || results[maxBatch+1].Response == nil {
+		t.Errorf("expected %d results, got %d", len(many), len(results))
+	}
+
+	cl.AccessToken = badToken
+	if _, err := cl.FindUsersBatch(reqs); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected unauthorized, got %v", err)
+	}
+	cl.URL = "http://127.0.0.1:1"
+	if _, err := cl.FindUsersBatch(reqs); err == nil || !strings.Contains(err.Error(), unknownError) {
+		t.Errorf("expected unknown error, got %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	cl := setup()
+	cl.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := cl.FindUsers(SearchRequest{Limit: 5, Query: longWork})
+	if err == nil || !strings.Contains(err.Error(), "timeout for") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("timeout took %s", d)
+	}
+	// the timeout doesn't break reading of fast responses
+	if res, err := cl.FindUsers(SearchRequest{Limit: 3, Query: "W"}); err != nil || len(res.Users) != 3 {
+		t.Errorf("expected 3 users, got %+v %v", res, err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	var changes []string
+	breaker := &CircuitBreaker{
+		Threshold: 2,
+		Cooldown:  time.Minute,
+		OnStateChange: func(from, to BreakerState) {
+			changes = append(changes, from.String()+">"+to.String())
+		},
+		now: func() time.Time { return now },
+	}
+	cl, fs := setupFlaky(3, http.StatusInternalServerError, nil)
+	cl.Breaker = breaker
+	req := SearchRequest{Limit: 1, Query: "W"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := cl.FindUsers(req); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected server error, got %v", err)
+		}
+	}
+	if _, err := cl.FindUsers(req); !errors.Is(err, ErrCircuitOpen) || fs.Requests() != 2 {
+		t.Errorf("expected open breaker without request, got %v after %d requests", err, fs.Requests())
+	}
+	// the failed probe opens it again
+	now = now.Add(time.Minute)
+	if _, err := cl.FindUsers(req); err == nil || errors.Is(err, ErrCircuitOpen) || fs.Requests() != 3 {
+		t.Errorf("expected failed probe, got %v after %d requests", err, fs.Requests())
+	}
+	if _, err := cl.FindUsers(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected open breaker, got %v", err)
+	}
+	now = now.Add(time.Minute)
+	if _, err := cl.FindUsers(req); err != nil || breaker.State() != BreakerClosed {
+		t.Errorf("expected closed breaker after probe, got %v %s", err, breaker.State())
+	}
+	expected := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	breaker := &CircuitBreaker{Threshold: 1, Cooldown: time.Nanosecond}
+	breaker.done(true)
+	time.Sleep(time.Millisecond)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("expected probe, got %v", err)
+	}
+	// only one probe at a time
+	if err := breaker.allow(); err != ErrCircuitOpen {
+		t.Errorf("expected open breaker during probe, got %v", err)
+	}
+	breaker.done(false)
+	if err := breaker.allow(); err != nil || breaker.State() != BreakerClosed {
+		t.Errorf("expected closed breaker, got %v %s", err, breaker.State())
+	}
+	var nilBreaker *CircuitBreaker
+	if nilBreaker.allow() != nil || nilBreaker.State() != BreakerClosed {
+		t.Errorf("nil breaker must allow requests")
+	}
+}
+
+func TestFields(t *testing.T) {
+	cl := setup()
+	res, err := cl.FindUsers(SearchRequest{Limit: 2, OrderField: "id", OrderBy: 1, Fields: []string{"Id", "Name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []User{{Id: 0, Name: "Boyd Wolf"}, {Id: 1, Name: "Hilda Mayer"}}
+	if !reflect.DeepEqual(res.Users, expected) || !res.NextPage {
+		t.Errorf("expected %+v, got %+v", expected, res)
+	}
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1, Fields: []string{"Password"}}); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("expected unknown field, got %v", err)
+	}
+}
+
+func TestPageMetadata(t *testing.T) {
+	cl := setup()
+	res, err := cl.FindUsers(SearchRequest{Limit: 2, Offset: 1, Query: "W", OrderField: "id", OrderBy: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 2 || res.Total != 4 || res.Offset != 1 || !res.NextPage || res.NextCursor == "" {
+		t.Errorf("unexpected first page %+v", res)
+	}
+	res, err = cl.FindUsers(SearchRequest{Limit: 3, Query: "W", OrderField: "id", OrderBy: 1, Cursor: res.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Users) != 1 || res.Total != 4 || res.NextPage || res.NextCursor != "" {
+		t.Errorf("unexpected last page %+v", res)
+	}
+}
+
+// concurrencyServer tracks the largest number of concurrent requests
+type concurrencyServer struct {
+	mu       sync.Mutex
+	current  int
+	max      int
+	next     http.Handler
+	duration time.Duration
+}
+
+func (s *concurrencyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+	time.Sleep(s.duration)
+	s.next.ServeHTTP(w, r)
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+}
+
+func TestConcurrentClient(t *testing.T) {
+	cs := &concurrencyServer{next: searchserver.New("dataset.xml", correctToken), duration: 5 * time.Millisecond}
+	mock := mockserver.New(cs)
+	mock.Add(mockserver.ServerError().Times(3))
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+	cl := SearchClient{
+		AccessToken: correctToken,
+		URL:         srv.URL,
+		Retry:       &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+		Cache:       NewResponseCache(10, time.Minute),
+		Limiter:     NewInFlightLimiter(2),
+		Counters:    &Counters{},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := cl.FindUsers(SearchRequest{Limit: 1 + i%5, Query: "W", NoCache: i%2 == 0})
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if cs.max > 2 {
+		t.Errorf("expected at most 2 concurrent requests, got %d", cs.max)
+	}
+	stats := cl.Counters.Stats()
+	if stats.Requests != int64(mock.Requests()) || stats.Retries != 3 || stats.Failures != 3 || stats.InFlight != 0 {
+		t.Errorf("unexpected stats %+v for %d requests", stats, mock.Requests())
+	}
+
+	cl.Timeout = time.Millisecond
+	cl.Retry = nil
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1, NoCache: true}); err == nil {
+		t.Fatal("expected timeout")
+	}
+	if stats := cl.Counters.Stats(); stats.Timeouts != 1 || stats.InFlight != 0 {
+		t.Errorf("expected a timeout, got %+v", stats)
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "dataset.xml")
+	ioutil.WriteFile(path, data, 0644)
+
+	mock := mockserver.New(searchserver.New(path))
+	srv := httptest.NewServer(mock)
+	defer srv.Close()
+	cache := NewResponseCache(10, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cl := SearchClient{URL: srv.URL, Cache: cache}
+
+	req := SearchRequest{Limit: 2, OrderField: "id", OrderBy: 1}
+	first, err := cl.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	second, err := cl.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) || mock.Requests() != 2 || cache.Stats().Revalidations != 1 {
+		t.Errorf("expected revalidated response after 2 requests, got %d requests, %+v", mock.Requests(), cache.Stats())
+	}
+	// the revalidated response is fresh again
+	if _, err := cl.FindUsers(req); err != nil || mock.Requests() != 2 {
+		t.Errorf("expected cached response, got %v after %d requests", err, mock.Requests())
+	}
+
+	if err := cl.DeleteUser(0); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Minute)
+	third, err := cl.FindUsers(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Users[0].Id != 1 || cache.Stats().Revalidations != 1 {
+		t.Errorf("expected changed users, got %+v, %+v", third.Users, cache.Stats())
+	}
+
+	mock.Add(mockserver.Scenario{Status: http.StatusNotModified})
+	cl.Cache = nil
+	if _, err := cl.FindUsers(req); err == nil || !strings.Contains(err.Error(), "not cached") {
+		t.Errorf("expected not cached error, got %v", err)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	var ids []string
+	fs := mockserver.New(searchserver.New("dataset.xml", correctToken))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get(searchserver.TraceHeader))
+		fs.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	cl := SearchClient{AccessToken: correctToken, URL: srv.URL}
+
+	req := SearchRequest{Limit: 1, TraceID: "incoming-trace"}
+	if _, err := cl.FindUsers(req); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := cl.FindUsersStream(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if _, err := cl.FindUsersBatch([]SearchRequest{req, {Limit: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"incoming-trace", "incoming-trace", "incoming-trace"}) {
+		t.Errorf("expected the trace id of the request, got %q", ids)
+	}
+
+	// retries of a request share a new trace id
+	ids = nil
+	fs.Add(mockserver.ServerError().Times(1))
+	cl.Retry = &RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}
+	if _, err := cl.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] == "" || ids[0] != ids[1] {
+		t.Errorf("expected the same trace id of 2 attempts, got %q", ids)
+	}
+}
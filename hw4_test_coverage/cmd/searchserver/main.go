@@ -0,0 +1,133 @@
+// Command searchserver serves search over the XML dataset of users:
+//
+//	go run ./cmd/searchserver -dataset dataset.xml -port 8080 -tokens secret
+//
+// Datasets may be XML, JSON lines or CSV files or a MySQL table:
+//
+//	go run ./cmd/searchserver -format sql -dataset 'user:pass@/db' -table users
+//
+// https is served with -tls-cert and -tls-key. Admin tokens may change users
+// with POST /users, PUT /users/{id} and DELETE /users/{id}. -grpc-port also
+// serves the search over gRPC, see searchgrpc/search.proto.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"google.golang.org/grpc"
+
+	"hw4_test_coverage/searchgrpc"
+	"hw4_test_coverage/searchserver"
+)
+
+func main() {
+	dataset := flag.String("dataset", "dataset.xml", "file with users or DSN of the sql database")
+	format := flag.String("format", "", "dataset format: xml, jsonl, csv or sql, detected by extension if empty")
+	table := flag.String("table", "users", "users table of the sql database")
+	port := flag.Int("port", 8080, "port to listen on")
+	grpcPort := flag.Int("grpc-port", 0, "port of the gRPC search, not served if 0")
+	tokens := flag.String("tokens", "", "comma separated read access tokens")
+	adminTokens := flag.String("admin-tokens", "", "comma separated admin access tokens")
+	tokensFile := flag.String("tokens-file", "", "JSON file of tokens with scopes and expiry, authorization is disabled without any tokens")
+	certFile := flag.String("tls-cert", "", "PEM certificate file, https is served if it's set")
+	keyFile := flag.String("tls-key", "", "PEM key file of the certificate")
+	rate := flag.Float64("rate", 0, "requests per second per token, unlimited if 0")
+	burst := flag.Int("burst", 10, "requests per token over the rate at once")
+	check := flag.Duration("check", time.Second, "how often dataset is checked for changes")
+	logRequests := flag.Bool("log-requests", false, "log requests as JSON lines to stderr")
+	flag.Parse()
+
+	store, err := tokenStore(*tokensFile, *tokens, *adminTokens)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := source(*format, *dataset, *table, *check)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := searchserver.NewWithSource(src)
+	if store.Len() > 0 {
+		srv.Tokens = store
+	}
+	if *grpcPort != 0 {
+		go serveGRPC(srv, *grpcPort)
+	}
+	var handler http.Handler = srv
+	if *rate > 0 {
+		handler = searchserver.NewRateLimiter(*rate, *burst).Handler(srv)
+	}
+	if *logRequests {
+		handler = searchserver.LogRequests(searchserver.NewJSONLogger(os.Stderr), handler)
+	}
+	addr := fmt.Sprintf(":%d", *port)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if *certFile != "" {
+		log.Printf("serving %s at %s with https", *dataset, addr)
+		log.Fatal(server.ListenAndServeTLS(*certFile, *keyFile))
+	}
+	log.Printf("serving %s at %s", *dataset, addr)
+	log.Fatal(server.ListenAndServe())
+}
+
+func serveGRPC(srv *searchserver.SearchServer, port int) {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := grpc.NewServer()
+	searchgrpc.NewServer(srv).Register(server)
+	log.Printf("serving gRPC at %s", lis.Addr())
+	log.Fatal(server.Serve(lis))
+}
+
+func source(format, dataset, table string, check time.Duration) (searchserver.DataSource, error) {
+	if format == "sql" {
+		db, err := sql.Open("mysql", dataset)
+		if err != nil {
+			return nil, err
+		}
+		if err = db.Ping(); err != nil {
+			return nil, err
+		}
+		return searchserver.NewSQLSource(db, "mysql", table)
+	}
+	src, err := searchserver.NewSource(format, dataset)
+	if err != nil {
+		return nil, err
+	}
+	cached := searchserver.NewCachedSource(src, dataset)
+	cached.CheckInterval = check
+	return cached, nil
+}
+
+func tokenStore(path, read, admin string) (*searchserver.TokenStore, error) {
+	store := searchserver.NewTokenStore()
+	if path != "" {
+		var err error
+		if store, err = searchserver.LoadTokenStore(path); err != nil {
+			return nil, err
+		}
+	}
+	for scope, values := range map[searchserver.Scope]string{searchserver.ScopeRead: read, searchserver.ScopeAdmin: admin} {
+		if values == "" {
+			continue
+		}
+		for _, value := range strings.Split(values, ",") {
+			store.Add(searchserver.Token{Value: value, Scope: scope})
+		}
+	}
+	return store, nil
+}
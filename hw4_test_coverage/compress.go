@@ -0,0 +1,45 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+)
+
+// gzipBody closes both the decompressor and the response body
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// acceptEncoding is the Accept-Encoding header of the client requests
+func (srv *SearchClient) acceptEncoding() string {
+	if srv.DisableCompression {
+		return "identity"
+	}
+	return "gzip"
+}
+
+// decompress replaces gzip body of the response with the decompressed one,
+// the transport doesn't do it as Accept-Encoding is set explicitly
+func decompress(resp *http.Response) (*http.Response, error) {
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return resp, nil
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	resp.Body = gzipBody{gz, resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
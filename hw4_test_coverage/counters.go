@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ClientStats are the values of Counters
+type ClientStats struct {
+	// Requests are sent attempts, retries included
+	Requests int64
+	Retries  int64
+	Timeouts int64
+	// Failures are attempts failed with network errors, timeouts
+	// included, or 5xx statuses
+	Failures int64
+	// InFlight are attempts waiting for the response or reading it
+	InFlight int64
+}
+
+// Counters count requests of clients, they may be shared by several
+// clients
+type Counters struct {
+	requests int64
+	retries  int64
+	timeouts int64
+	failures int64
+	inFlight int64
+}
+
+func (c *Counters) Stats() ClientStats {
+	return ClientStats{
+		Requests: atomic.LoadInt64(&c.requests),
+		Retries:  atomic.LoadInt64(&c.retries),
+		Timeouts: atomic.LoadInt64(&c.timeouts),
+		Failures: atomic.LoadInt64(&c.failures),
+		InFlight: atomic.LoadInt64(&c.inFlight),
+	}
+}
+
+// started and finished count an attempt
+func (c *Counters) started() {
+	if c != nil {
+		atomic.AddInt64(&c.requests, 1)
+		atomic.AddInt64(&c.inFlight, 1)
+	}
+}
+
+func (c *Counters) finished() {
+	if c != nil {
+		atomic.AddInt64(&c.inFlight, -1)
+	}
+}
+
+func (c *Counters) retried() {
+	if c != nil {
+		atomic.AddInt64(&c.retries, 1)
+	}
+}
+
+// count records the result of the attempt
+func (c *Counters) count(failed bool, err error) {
+	if c == nil {
+		return
+	}
+	if failed {
+		atomic.AddInt64(&c.failures, 1)
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		atomic.AddInt64(&c.timeouts, 1)
+	}
+}
+
+// InFlightLimiter limits concurrent requests, others wait for a slot.
+// It may be shared by several clients.
+type InFlightLimiter struct {
+	slots chan struct{}
+}
+
+// NewInFlightLimiter allows n concurrent requests, n < 1 means 1
+func NewInFlightLimiter(n int) *InFlightLimiter {
+	if n < 1 {
+		n = 1
+	}
+	return &InFlightLimiter{slots: make(chan struct{}, n)}
+}
+
+func (l *InFlightLimiter) acquire() {
+	if l != nil {
+		l.slots <- struct{}{}
+	}
+}
+
+func (l *InFlightLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// hookBody calls onClose once when the body of the response is closed
+type hookBody struct {
+	io.ReadCloser
+	once    sync.Once
+	onClose func()
+}
+
+func (b *hookBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.onClose)
+	return err
+}
@@ -0,0 +1,9 @@
+module hw4_test_coverage
+
+go 1.13
+
+require (
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/golang/protobuf v1.3.2
+	google.golang.org/grpc v1.23.1
+)
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// main is a command line client of the search service:
+//
+//	go run . -url http://localhost:8080 -token secret -query Boyd
+func main() {
+	cl := SearchClient{}
+	req := SearchRequest{}
+	flag.StringVar(&cl.URL, "url", "http://localhost:8080", "search service URL")
+	flag.StringVar(&cl.AccessToken, "token", "", "access token")
+	flag.BoolVar(&cl.DisableCompression, "no-gzip", false, "ask for uncompressed responses")
+	flag.StringVar(&req.Query, "query", "", "substring of name or about")
+	flag.StringVar(&req.Expr, "q", "", `search expression, e.g. "name:Boyd AND age:>30"`)
+	flag.StringVar(&req.Match, "match", "", "substring, word or prefix")
+	flag.BoolVar(&req.IgnoreCase, "ignore-case", false, "match query case insensitively")
+	flag.StringVar(&req.OrderField, "order-field", "", "id, name or age")
+	flag.IntVar(&req.OrderBy, "order-by", 0, "-1 descending, 0 as is, 1 ascending")
+	flag.IntVar(&req.Limit, "limit", 25, "users per page")
+	flag.IntVar(&req.Offset, "offset", 0, "users to skip")
+	retries := flag.Int("retries", 1, "attempts for timeouts, 429 and 5xx responses")
+	flag.DurationVar(&cl.Timeout, "timeout", 0, "limit of every attempt, 0 keeps the timeout of the HTTP client")
+	caFile := flag.String("ca", "", "PEM file of CA certificates to trust for https")
+	stream := flag.Bool("stream", false, "stream all users up to limit, 0 is no limit")
+	flag.StringVar(&req.TraceID, "trace-id", "", "X-Request-Id of the request, a new one if empty")
+	fields := flag.String("fields", "", "comma separated fields to get, e.g. id,name, all if empty")
+	flag.Parse()
+	if *fields != "" {
+		req.Fields = strings.Split(*fields, ",")
+	}
+	cl.Retry = &RetryPolicy{MaxAttempts: *retries, Backoff: 100 * time.Millisecond, Jitter: 0.2}
+	if *caFile != "" {
+		var err error
+		if cl.HTTPClient, err = tlsClient(*caFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *stream {
+		if err := printStream(cl, req); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	resp, err := cl.FindUsers(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, user := range resp.Users {
+		printUser(user)
+	}
+	if resp.NextPage {
+		fmt.Printf("... of %d\n", resp.Total)
+	}
+}
+
+func printStream(cl SearchClient, req SearchRequest) error {
+	stream, err := cl.FindUsersStream(req)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for stream.Next() {
+		printUser(stream.User())
+	}
+	return stream.Err()
+}
+
+func printUser(user User) {
+	fmt.Printf("%d\t%s\t%d\t%s\n", user.Id, user.Name, user.Age, user.Gender)
+}
+
+// tlsClient trusts certificates signed by CA of the file
+func tlsClient(caFile string) (*http.Client, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Timeout: 5 * time.Second, Transport: transport}, nil
+}
@@ -0,0 +1,212 @@
+// Package mockserver injects faults into a search service, so tests of
+// SearchClient can check its error paths:
+//
+//	mock := mockserver.New(searchserver.New("dataset.xml"))
+//	mock.Add(mockserver.ServerError().When(mockserver.Param("query", "boom")))
+//	mock.Add(mockserver.Delay(time.Second).Times(2))
+//	srv := httptest.NewServer(mock)
+//
+// Scenarios are checked in the order they were added, the first matching
+// one applies, requests without one are passed to the service.
+// Random scenarios are driven by the seed, so fuzz tests may reproduce
+// failures by it.
+package mockserver
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Scenario is a fault of the requests it matches
+type Scenario struct {
+	// Match selects requests, nil matches all of them
+	Match func(r *http.Request) bool
+	// Delay is waited before the response
+	Delay time.Duration
+	// Status and Body are the response, the request is passed to the
+	// service after Delay if Status is 0
+	Status int
+	Body   string
+	// Count is the number of requests the scenario applies to,
+	// 0 means all of them
+	Count int
+	// Rate is the probability of the fault for a matched request,
+	// 0 means 1
+	Rate float64
+}
+
+// ServerError responds with 500
+func ServerError() Scenario {
+	return Scenario{Status: http.StatusInternalServerError}
+}
+
+// BadJSON responds with 200 and malformed JSON
+func BadJSON() Scenario {
+	return Scenario{Status: http.StatusOK, Body: "{"}
+}
+
+// BadRequest responds with 400 and ErrorResponse of the reason,
+// empty reason gives an empty body
+func BadRequest(reason string) Scenario {
+	s := Scenario{Status: http.StatusBadRequest}
+	if reason != "" {
+		body, _ := json.Marshal(struct{ Error string }{reason})
+		s.Body = string(body)
+	}
+	return s
+}
+
+// Delay passes requests to the service after d, clients with a shorter
+// timeout fail
+func Delay(d time.Duration) Scenario {
+	return Scenario{Delay: d}
+}
+
+// When returns the scenario matching requests which match all of the
+// functions
+func (s Scenario) When(match ...func(r *http.Request) bool) Scenario {
+	s.Match = func(r *http.Request) bool {
+		for _, m := range match {
+			if !m(r) {
+				return false
+			}
+		}
+		return true
+	}
+	return s
+}
+
+// Times returns the scenario applied to n requests
+func (s Scenario) Times(n int) Scenario {
+	s.Count = n
+	return s
+}
+
+// WithRate returns the scenario applied to the rate of matched requests
+func (s Scenario) WithRate(rate float64) Scenario {
+	s.Rate = rate
+	return s
+}
+
+// Param matches requests with the value of the query or form parameter
+func Param(name, value string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		return r.FormValue(name) == value
+	}
+}
+
+// Path matches requests to the path
+func Path(path string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		return r.URL.Path == path
+	}
+}
+
+// Server is the handler injecting faults into the service, it's safe
+// for concurrent use
+type Server struct {
+	next http.Handler
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	scenarios []*scenario
+	requests  int
+	faults    int
+}
+
+type scenario struct {
+	Scenario
+	applied int
+}
+
+// New injects faults into next, the seed of random scenarios is 1
+func New(next http.Handler) *Server {
+	return &Server{next: next, rand: rand.New(rand.NewSource(1))}
+}
+
+// Seed sets the seed of random scenarios
+func (s *Server) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rand = rand.New(rand.NewSource(seed))
+}
+
+func (s *Server) Add(scenarios ...Scenario) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range scenarios {
+		s.scenarios = append(s.scenarios, &scenario{Scenario: sc})
+	}
+}
+
+// Reset removes scenarios and counters
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scenarios = nil
+	s.requests = 0
+	s.faults = 0
+}
+
+// Requests is the number of served requests
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Faults is the number of requests a scenario was applied to
+func (s *Server) Faults() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.faults
+}
+
+// pick returns the scenario of the request, nil if there is none
+func (s *Server) pick(r *http.Request) *Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	for _, sc := range s.scenarios {
+		if sc.Count > 0 && sc.applied >= sc.Count {
+			continue
+		}
+		if sc.Match != nil && !sc.Match(r) {
+			continue
+		}
+		if sc.Rate > 0 && s.rand.Float64() >= sc.Rate {
+			continue
+		}
+		sc.applied++
+		s.faults++
+		return &sc.Scenario
+	}
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sc := s.pick(r)
+	if sc == nil {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	if sc.Delay > 0 {
+		select {
+		case <-time.After(sc.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if sc.Status == 0 {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	if sc.Body != "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(sc.Status)
+	w.Write([]byte(sc.Body))
+}
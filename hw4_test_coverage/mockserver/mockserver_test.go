@@ -0,0 +1,97 @@
+package mockserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("[]"))
+})
+
+func serve(s *Server, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+func TestScenarios(t *testing.T) {
+	s := New(ok)
+	s.Add(
+		ServerError().When(Param("query", "boom")).Times(1),
+		BadJSON().When(Path("/bad"), Param("query", "json")),
+		BadRequest("ErrorBadOrderField").When(Param("order_field", "x")),
+		BadRequest("").When(Param("order_field", "empty")),
+	)
+	cases := []struct {
+		target string
+		status int
+		body   string
+	}{
+		{"/?query=boom", http.StatusInternalServerError, ""},
+		// the first scenario is used up
+		{"/?query=boom", http.StatusOK, "[]"},
+		{"/bad?query=json", http.StatusOK, "{"},
+		{"/?query=json", http.StatusOK, "[]"},
+		{"/?order_field=x", http.StatusBadRequest, `{"Error":"ErrorBadOrderField"}`},
+		{"/?order_field=empty", http.StatusBadRequest, ""},
+	}
+	for _, c := range cases {
+		w := serve(s, c.target)
+		if w.Code != c.status || w.Body.String() != c.body {
+			t.Errorf("%s: expected %d %q, got %d %q", c.target, c.status, c.body, w.Code, w.Body)
+		}
+	}
+	if s.Requests() != len(cases) || s.Faults() != 4 {
+		t.Errorf("expected %d requests and 4 faults, got %d and %d", len(cases), s.Requests(), s.Faults())
+	}
+	s.Reset()
+	if w := serve(s, "/?query=boom"); w.Code != http.StatusOK || s.Requests() != 1 || s.Faults() != 0 {
+		t.Errorf("expected no faults after reset, got %d", w.Code)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	s := New(ok)
+	s.Add(Delay(10 * time.Millisecond))
+	start := time.Now()
+	if w := serve(s, "/"); w.Code != http.StatusOK || time.Since(start) < 10*time.Millisecond {
+		t.Errorf("expected delayed response, got %d after %s", w.Code, time.Since(start))
+	}
+
+	// canceled requests are not waited for
+	s.Reset()
+	s.Add(Delay(time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if w.Body.Len() != 0 {
+		t.Errorf("expected no response, got %q", w.Body)
+	}
+}
+
+func TestRate(t *testing.T) {
+	failures := func(seed int64) []int {
+		s := New(ok)
+		s.Seed(seed)
+		s.Add(ServerError().WithRate(0.3))
+		var statuses []int
+		for i := 0; i < 100; i++ {
+			statuses = append(statuses, serve(s, "/").Code)
+		}
+		if s.Faults() < 15 || s.Faults() > 45 {
+			t.Errorf("expected about 30 faults, got %d", s.Faults())
+		}
+		return statuses
+	}
+	first, second := failures(7), failures(7)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("the same seed must give the same faults, request %d differs", i)
+		}
+	}
+}
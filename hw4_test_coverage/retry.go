@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy repeats requests failed with timeout, 429 or 5xx status,
+// Retry-After header of the response replaces the backoff. Retry-After over
+// MaxBackoff (maxRetryAfter if it's 0) or a delay past the deadline of the
+// call returns the response instead.
+// Policy may be shared by several clients, they share the budget then.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, 0 and 1 disable retries
+	MaxAttempts int
+	// Backoff is the delay before the first retry, it's doubled for every
+	// next one up to MaxBackoff if it's set
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter randomizes delay by the fraction of it, 0.2 gives ±20%
+	Jitter float64
+	// BudgetRatio limits retries to the ratio of requests, 0.1 allows one
+	// retry per ten requests on average, 0 means no limit
+	BudgetRatio float64
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+// maxRetryAfter is the longest Retry-After waited without MaxBackoff
+const maxRetryAfter = time.Minute
+
+// maxBudgetTokens keeps budget from growing while everything is fine,
+// so a burst of failures can't retry too much
+const maxBudgetTokens = 10
+
+func (p *RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			d = p.MaxBackoff
+			break
+		}
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// wait is the delay before the retry, false if it's too long to wait
+func (p *RetryPolicy) wait(ctx context.Context, resp *http.Response, retry int) (time.Duration, bool) {
+	d := p.delay(retry)
+	if after := retryAfter(resp); after > 0 {
+		limit := p.MaxBackoff
+		if limit <= 0 {
+			limit = maxRetryAfter
+		}
+		if after > limit {
+			return 0, false
+		}
+		d = after
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return 0, false
+	}
+	return d, true
+}
+
+// sleep waits d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// request is called for every request to earn budget
+func (p *RetryPolicy) request() {
+	if p.BudgetRatio <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.tokens += p.BudgetRatio
+	if p.tokens > maxBudgetTokens {
+		p.tokens = maxBudgetTokens
+	}
+	p.mu.Unlock()
+}
+
+// allow reports whether retry fits the budget and spends it
+func (p *RetryPolicy) allow() bool {
+	if p.BudgetRatio <= 0 {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		netErr, ok := err.(net.Error)
+		return ok && netErr.Timeout()
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryAfter is the delay of Retry-After header in seconds or
+// as HTTP date, 0 if there is none
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(time.Now()) {
+		return time.Until(date)
+	}
+	return 0
+}
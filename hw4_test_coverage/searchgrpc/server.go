@@ -0,0 +1,111 @@
+// Package searchgrpc serves the search of searchserver over gRPC for
+// internal services preferring protobuf to the HTTP API. Messages and stubs
+// in search.pb.go are generated from search.proto with
+//
+//	protoc --go_out=plugins=grpc:. search.proto
+package searchgrpc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"hw4_test_coverage/searchserver"
+)
+
+// TokenKey is the metadata key of the access token, the same as
+// AccessToken header of the HTTP API
+const TokenKey = "accesstoken"
+
+// Server implements SearchServer over the HTTP one, so both of them share
+// the source, the tokens and the filtering
+type Server struct {
+	srv *searchserver.SearchServer
+}
+
+func NewServer(srv *searchserver.SearchServer) *Server {
+	return &Server{srv}
+}
+
+// Register adds the service to the gRPC server
+func (s *Server) Register(grpcServer *grpc.Server) {
+	RegisterSearchServer(grpcServer, s)
+}
+
+func (s *Server) FindUsers(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	if err := s.srv.Authorize(token(ctx), searchserver.ScopeRead); err != nil {
+		return nil, grpcError(err)
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, searchserver.ErrorBadLimit)
+	}
+	users, cursor, err := s.srv.Find(params(req))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &SearchResponse{Users: make([]*User, 0, len(users))}
+	// the cursor of the extra user starts the next page with it
+	if len(users) > int(req.Limit) {
+		users = users[:req.Limit]
+		resp.NextCursor = cursor
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, &User{
+			Id:     int32(user.Id),
+			Name:   user.Name,
+			Age:    int32(user.Age),
+			About:  user.About,
+			Gender: user.Gender,
+		})
+	}
+	return resp, nil
+}
+
+func token(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(TokenKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// params converts the request to parameters of the HTTP API, one more
+// user is requested for the next cursor
+func params(req *SearchRequest) url.Values {
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(int(req.Limit)+1))
+	params.Set("offset", strconv.Itoa(int(req.Offset)))
+	params.Set("query", req.Query)
+	params.Set("order_field", req.OrderField)
+	params.Set("order_by", strconv.Itoa(int(req.OrderBy)))
+	params.Set("match", req.Match)
+	params.Set("ignore_case", strconv.FormatBool(req.IgnoreCase))
+	params.Set("q", req.Expr)
+	params.Set("age_min", strconv.Itoa(int(req.AgeMin)))
+	params.Set("age_max", strconv.Itoa(int(req.AgeMax)))
+	params.Set("gender", req.Gender)
+	params.Set("cursor", req.Cursor)
+	return params
+}
+
+// grpcError maps errors of the search to status codes, messages are the
+// ones of ErrorResponse of the HTTP API
+func grpcError(err error) error {
+	if authErr, ok := err.(*searchserver.AuthError); ok {
+		code := codes.Unauthenticated
+		if authErr.Status == http.StatusForbidden {
+			code = codes.PermissionDenied
+		}
+		return status.Error(code, authErr.Reason)
+	}
+	if searchserver.IsBadRequest(err) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, "search failed")
+}
@@ -0,0 +1,126 @@
+package searchgrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"hw4_test_coverage/searchserver"
+)
+
+func setup(t *testing.T) (SearchClient, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := searchserver.New("../dataset.xml")
+	srv.Tokens = searchserver.NewTokenStore(
+		searchserver.Token{Value: "reader", Scope: searchserver.ScopeRead},
+	)
+	grpcServer := grpc.NewServer()
+	NewServer(srv).Register(grpcServer)
+	go grpcServer.Serve(lis)
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSearchClient(conn), func() {
+		conn.Close()
+		grpcServer.Stop()
+	}
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), TokenKey, token)
+}
+
+func TestFindUsers(t *testing.T) {
+	client, teardown := setup(t)
+	defer teardown()
+
+	resp, err := client.FindUsers(withToken("reader"), &SearchRequest{
+		Limit:      2,
+		OrderField: "id",
+		OrderBy:    1,
+		Gender:     "male",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Users) != 2 || resp.Users[0].Id != 0 || resp.Users[1].Id != 2 {
+		t.Fatalf("unexpected users %v", resp.Users)
+	}
+	if resp.Users[0].Name != "Boyd Wolf" || resp.NextCursor == "" {
+		t.Errorf("unexpected response %v", resp)
+	}
+
+	next, err := client.FindUsers(withToken("reader"), &SearchRequest{
+		Limit:      1,
+		OrderField: "id",
+		OrderBy:    1,
+		Gender:     "male",
+		Cursor:     resp.NextCursor,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Users) != 1 || next.Users[0].Id <= 2 {
+		t.Errorf("unexpected next page %v", next.Users)
+	}
+
+	last, err := client.FindUsers(withToken("reader"), &SearchRequest{Limit: 100, OrderBy: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(last.Users) == 0 || last.NextCursor != "" {
+		t.Errorf("expected the last page without cursor, got %d users, cursor %q", len(last.Users), last.NextCursor)
+	}
+}
+
+func TestFindUsersErrors(t *testing.T) {
+	client, teardown := setup(t)
+	defer teardown()
+
+	cases := []struct {
+		token   string
+		req     *SearchRequest
+		code    codes.Code
+		message string
+	}{
+		{"", &SearchRequest{}, codes.Unauthenticated, searchserver.ErrorBadToken},
+		{"reader", &SearchRequest{OrderField: "About"}, codes.InvalidArgument, searchserver.ErrorBadOrderField},
+		{"reader", &SearchRequest{OrderBy: 2}, codes.InvalidArgument, searchserver.ErrorBadOrderBy},
+		{"reader", &SearchRequest{Limit: -1}, codes.InvalidArgument, searchserver.ErrorBadLimit},
+		{"reader", &SearchRequest{Expr: "age:"}, codes.InvalidArgument, searchserver.ErrorBadExpr},
+	}
+	for _, c := range cases {
+		_, err := client.FindUsers(withToken(c.token), c.req)
+		s, _ := status.FromError(err)
+		if s.Code() != c.code || s.Message() != c.message {
+			t.Errorf("%v: expected %v %s, got %v", c.req, c.code, c.message, err)
+		}
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{&searchserver.AuthError{Status: http.StatusUnauthorized, Reason: searchserver.ErrorTokenExpired}, codes.Unauthenticated},
+		{&searchserver.AuthError{Status: http.StatusForbidden, Reason: searchserver.ErrorForbidden}, codes.PermissionDenied},
+		{errors.New("db is down"), codes.Internal},
+	}
+	for _, c := range cases {
+		if code := status.Code(grpcError(c.err)); code != c.code {
+			t.Errorf("%v: expected %v, got %v", c.err, c.code, code)
+		}
+	}
+}
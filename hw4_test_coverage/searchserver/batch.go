@@ -0,0 +1,65 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// ErrorBadBatch is the error value of a malformed or too large batch
+const ErrorBadBatch = "ErrorBadBatch"
+
+const (
+	// maxBatch is the number of searches of a batch request
+	maxBatch = 100
+	// maxBatchBody limits the body of batch requests
+	maxBatchBody = 1 << 20
+)
+
+// BatchResult is the result of one search of a batch, Error is the value
+// of ErrorResponse the search would fail with, Users and Cursor are empty
+// then
+type BatchResult struct {
+	Users  []User
+	Cursor string `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// serveBatch handles POST /batch, the body is a JSON array of query
+// strings of searches, e.g. ["query=Boyd&order_by=0&limit=2"], results are
+// in the same order. A malformed search fails only its own result.
+// Users are not projected by fields param.
+func (srv *SearchServer) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var queries []string
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBatchBody)).Decode(&queries); err != nil ||
+		len(queries) > maxBatch {
+		srv.metrics.queryError(ErrorBadBatch)
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{ErrorBadBatch})
+		return
+	}
+	results := make([]BatchResult, len(queries))
+	for i, query := range queries {
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			srv.metrics.queryError(ErrorBadBatch)
+			results[i].Error = ErrorBadBatch
+			continue
+		}
+		users, cursor, err := srv.Find(params)
+		switch {
+		case IsBadRequest(err):
+			srv.metrics.queryError(err.Error())
+			results[i].Error = err.Error()
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		default:
+			results[i] = BatchResult{Users: users, Cursor: cursor}
+		}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,55 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSearchServerBatch(t *testing.T) {
+	srv := New("../dataset.xml")
+
+	w := send(srv, "POST", "/batch", `["query=Boyd&order_by=0&limit=1", "order_field=About&order_by=0&limit=1", "order_field=id&order_by=1&limit=2", "%zz"]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", w.Code, w.Body)
+	}
+	var results []BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	if len(results[0].Users) != 1 || results[0].Users[0].Name != "Boyd Wolf" || results[0].Cursor == "" {
+		t.Errorf("unexpected first result %+v", results[0])
+	}
+	if results[1].Error != ErrorBadOrderField || results[1].Users != nil {
+		t.Errorf("expected %s, got %+v", ErrorBadOrderField, results[1])
+	}
+	if len(results[2].Users) != 2 || results[2].Users[0].Id != 0 || results[2].Users[1].Id != 1 {
+		t.Errorf("unexpected third result %+v", results[2])
+	}
+	if results[3].Error != ErrorBadBatch {
+		t.Errorf("expected %s, got %+v", ErrorBadBatch, results[3])
+	}
+
+	cases := []struct {
+		method, body string
+		status       int
+	}{
+		{"GET", "", http.StatusMethodNotAllowed},
+		{"POST", `{"query":"Boyd"}`, http.StatusBadRequest},
+		{"POST", "[" + strings.Repeat(`"limit=1",`, maxBatch) + `"limit=1"]`, http.StatusBadRequest},
+		{"POST", `[]`, http.StatusOK},
+	}
+	for _, c := range cases {
+		if w := send(srv, c.method, "/batch", c.body); w.Code != c.status {
+			t.Errorf("%s %.20s: expected %d, got %d", c.method, c.body, c.status, w.Code)
+		}
+	}
+
+	if w := send(New("missing.xml"), "POST", "/batch", `["limit=1&order_by=0"]`); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for broken source, got %d", w.Code)
+	}
+}
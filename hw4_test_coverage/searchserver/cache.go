@@ -0,0 +1,196 @@
+package searchserver
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats are counters of CachedSource
+type CacheStats struct {
+	Hits    uint64
+	Reloads uint64
+	// Errors counts failed reloads, old users are served then
+	Errors   uint64
+	Users    int
+	LoadedAt time.Time
+}
+
+type cacheState struct {
+	users   []User
+	index   *Index
+	modTime time.Time
+	size    int64
+	// generation tells apart states of the same file stats
+	generation uint64
+}
+
+// CachedSource keeps users of a file source in memory with their Index and
+// reloads them when modification time or size of the file changes. Reload
+// replaces users at once, requests in flight keep the old ones.
+type CachedSource struct {
+	src  DataSource
+	path string
+	// CheckInterval limits how often the file is checked, every call
+	// checks it if 0
+	CheckInterval time.Duration
+
+	// mu serializes reloads
+	mu        sync.Mutex
+	state     atomic.Value
+	checkedAt int64
+	hits      uint64
+	reloads   uint64
+	errors    uint64
+	// generations counts stored states, it's guarded by mu
+	generations uint64
+}
+
+func NewCachedSource(src DataSource, path string) *CachedSource {
+	return &CachedSource{src: src, path: path}
+}
+
+func (c *CachedSource) current() *cacheState {
+	state, _ := c.state.Load().(*cacheState)
+	return state
+}
+
+func (c *CachedSource) Users() ([]User, error) {
+	state, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return state.users, nil
+}
+
+func (c *CachedSource) Index() (*Index, error) {
+	state, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	return state.index, nil
+}
+
+// load returns the current state reloading it if the file has changed
+func (c *CachedSource) load() (*cacheState, error) {
+	state := c.current()
+	now := time.Now().UnixNano()
+	if state != nil && now-atomic.LoadInt64(&c.checkedAt) < int64(c.CheckInterval) {
+		atomic.AddUint64(&c.hits, 1)
+		return state, nil
+	}
+	atomic.StoreInt64(&c.checkedAt, now)
+	info, err := os.Stat(c.path)
+	if err == nil && state != nil && info.ModTime().Equal(state.modTime) && info.Size() == state.size {
+		atomic.AddUint64(&c.hits, 1)
+		return state, nil
+	}
+	return c.reload(info, err)
+}
+
+func (c *CachedSource) reload(info os.FileInfo, statErr error) (*cacheState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the file may be reloaded while waiting for the lock
+	if state := c.current(); state != nil && statErr == nil &&
+		info.ModTime().Equal(state.modTime) && info.Size() == state.size {
+		atomic.AddUint64(&c.hits, 1)
+		return state, nil
+	}
+	err := statErr
+	var users []User
+	if err == nil {
+		users, err = c.src.Users()
+	}
+	if err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		if state := c.current(); state != nil {
+			return state, nil
+		}
+		return nil, err
+	}
+	c.generations++
+	state := &cacheState{users, NewIndex(users), info.ModTime(), info.Size(), c.generations}
+	c.state.Store(state)
+	atomic.AddUint64(&c.reloads, 1)
+	return state, nil
+}
+
+// Create, Update and Delete write users to the file and replace the cached
+// ones, the file is not reloaded then
+func (c *CachedSource) Create(u User) (User, error) {
+	return c.change(func(users []User) ([]User, User, error) {
+		users, u := createUser(users, u)
+		return users, u, nil
+	})
+}
+
+func (c *CachedSource) Update(u User) (User, error) {
+	return c.change(func(users []User) ([]User, User, error) {
+		return updateUser(users, u)
+	})
+}
+
+func (c *CachedSource) Delete(id int) error {
+	_, err := c.change(func(users []User) ([]User, User, error) {
+		users, err := deleteUser(users, id)
+		return users, User{}, err
+	})
+	return err
+}
+
+// change applies the change to the current users and saves them,
+// the changed user is returned
+func (c *CachedSource) change(apply func(users []User) ([]User, User, error)) (User, error) {
+	writer, ok := c.src.(fileWriter)
+	if !ok {
+		return User{}, fmt.Errorf("source of %s can't be changed", c.path)
+	}
+	if _, err := c.load(); err != nil {
+		return User{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	users, user, err := apply(c.current().users)
+	if err != nil {
+		return User{}, err
+	}
+	if err = writer.writeUsers(c.path, users); err != nil {
+		return User{}, err
+	}
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return User{}, err
+	}
+	c.generations++
+	c.state.Store(&cacheState{users, NewIndex(users), info.ModTime(), info.Size(), c.generations})
+	return user, nil
+}
+
+// Version changes whenever users are reloaded or changed, it's the one
+// of users loaded last, so it's not a use of them
+func (c *CachedSource) Version() (string, error) {
+	state := c.current()
+	if state == nil {
+		var err error
+		if state, err = c.load(); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x-%x-%x", state.modTime.UnixNano(), state.size, state.generation), nil
+}
+
+func (c *CachedSource) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Reloads: atomic.LoadUint64(&c.reloads),
+		Errors:  atomic.LoadUint64(&c.errors),
+	}
+	if state := c.current(); state != nil {
+		stats.Users = len(state.users)
+		stats.LoadedAt = state.modTime
+	}
+	return stats
+}
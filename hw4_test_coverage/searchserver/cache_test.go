@@ -0,0 +1,74 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCachedSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "searchserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users.jsonl")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write(`{"id":1,"first_name":"Ann","age":30}`, start)
+
+	src, _ := NewSource("", path)
+	cached := NewCachedSource(src, path)
+	srv := NewWithSource(cached)
+	for i := 0; i < 3; i++ {
+		if code, users, _ := search(t, srv, "", "limit=10&order_by=0"); code != 200 || len(users) != 1 {
+			t.Fatalf("expected 1 user, got %d %+v", code, users)
+		}
+	}
+	if stats := cached.Stats(); stats.Reloads != 1 || stats.Hits != 2 || stats.Users != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	write(`{"id":1,"first_name":"Ann","age":30}
+{"id":2,"first_name":"Bob","age":40}`, start.Add(time.Minute))
+	if _, users, _ := search(t, srv, "", "limit=10&order_by=1&order_field=Age"); len(users) != 2 {
+		t.Fatalf("expected reloaded users, got %+v", users)
+	}
+	// sorting a response must not reorder cached users
+	if users, _ := cached.Users(); users[0].Id != 1 {
+		t.Errorf("cached users were modified: %+v", users)
+	}
+
+	// broken file keeps the last loaded users
+	write(`{`, start.Add(2*time.Minute))
+	if users, err := cached.Users(); err != nil || len(users) != 2 {
+		t.Errorf("expected old users, got %+v, %v", users, err)
+	}
+	if stats := cached.Stats(); stats.Reloads != 2 || stats.Errors != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	var stats CacheStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil || stats.Users != 2 {
+		t.Errorf("unexpected /stats response %d %s", w.Code, w.Body)
+	}
+
+	os.Remove(path)
+	if _, err := NewCachedSource(src, path).Users(); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
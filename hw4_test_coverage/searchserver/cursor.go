@@ -0,0 +1,81 @@
+package searchserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// CursorHeader carries the cursor of the last returned user, requesting
+// with it continues from that user inclusively
+const CursorHeader = "X-Last-Cursor"
+
+// cursor is a position in the sorted search result, it's bound to the
+// query and the order it was issued for
+type cursor struct {
+	Query      string `json:"q"`
+	Match      string `json:"m"`
+	IgnoreCase bool   `json:"ic,omitempty"`
+	Expr       string `json:"e,omitempty"`
+	OrderField string `json:"f"`
+	OrderBy    int    `json:"o"`
+	AgeMin     int    `json:"amin,omitempty"`
+	AgeMax     int    `json:"amax,omitempty"`
+	Gender     string `json:"g,omitempty"`
+	Id         int    `json:"i"`
+	Name       string `json:"n,omitempty"`
+	Age        int    `json:"a,omitempty"`
+	Pos        int    `json:"p"`
+}
+
+func newCursor(req *request, u *User) string {
+	c := cursor{
+		Query:      req.query,
+		Match:      req.match,
+		IgnoreCase: req.ignoreCase,
+		Expr:       req.q,
+		OrderField: req.orderField,
+		OrderBy:    req.orderBy,
+		AgeMin:     req.ageMin,
+		AgeMax:     req.ageMax,
+		Gender:     req.gender,
+		Id:         u.Id,
+		Name:       u.Name,
+		Age:        u.Age,
+		Pos:        u.pos,
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseCursor(req *request, value string) (*User, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, badRequest(ErrorBadCursor)
+	}
+	c := cursor{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, badRequest(ErrorBadCursor)
+	}
+	if c.Query != req.query || c.Match != req.match || c.IgnoreCase != req.ignoreCase || c.Expr != req.q || c.OrderField != req.orderField || c.OrderBy != req.orderBy ||
+		c.AgeMin != req.ageMin || c.AgeMax != req.ageMax || c.Gender != req.gender {
+		return nil, badRequest(ErrorBadCursor)
+	}
+	return &User{Id: c.Id, Name: c.Name, Age: c.Age, pos: c.Pos}, nil
+}
+
+// seek skips users sorted with less which are before the anchor,
+// binary search doesn't depend on how deep the page is
+func seek(users []User, anchor *User, less func(a, b *User) bool) []User {
+	start := sort.Search(len(users), func(i int) bool {
+		return !less(&users[i], anchor)
+	})
+	return users[start:]
+}
+
+func setCursor(w http.ResponseWriter, req *request, users []User) {
+	if len(users) > 0 {
+		w.Header().Set(CursorHeader, newCursor(req, &users[len(users)-1]))
+	}
+}
@@ -0,0 +1,58 @@
+package searchserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// VersionedSource is a DataSource knowing the version of its users,
+// search responses get ETag of it
+type VersionedSource interface {
+	DataSource
+	// Version changes whenever users change, it's called after the
+	// search, so it may be the version of users loaded by it
+	Version() (string, error)
+}
+
+// etag is the weak ETag of the search response, it's made of the version
+// of users and the params. Empty if the source has no version.
+func (srv *SearchServer) etag(r *http.Request) string {
+	src, ok := srv.Source.(VersionedSource)
+	if !ok {
+		return ""
+	}
+	version, err := src.Version()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(version + "\n" + r.URL.Query().Encode()))
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// notModified sets ETag header and writes 304 if If-None-Match has it,
+// it's called after the search, so the version is the one of the result
+func (srv *SearchServer) notModified(w http.ResponseWriter, r *http.Request) bool {
+	etag := srv.etag(r)
+	if etag == "" {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	if !etagMatch(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch is the weak comparison of If-None-Match with etag
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
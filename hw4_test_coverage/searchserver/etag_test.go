@@ -0,0 +1,86 @@
+package searchserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func getTagged(srv http.Handler, target, etag string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	r.Header.Set("If-None-Match", etag)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestSearchServerETag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "searchserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile("../dataset.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "dataset.xml")
+	ioutil.WriteFile(path, data, 0644)
+	srv := New(path)
+
+	target := "/?order_by=0&limit=30"
+	w := getTagged(srv, target, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with ETag, got %d %q", w.Code, etag)
+	}
+	w = getTagged(srv, target, `"other", `+etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+		t.Errorf("expected 304 without body, got %d %d bytes", w.Code, w.Body.Len())
+	}
+	if w = getTagged(srv, target+"&envelope=1", etag); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for other params, got %d", w.Code)
+	}
+	envelopeTag := w.Header().Get("ETag")
+	if w = getTagged(srv, target+"&envelope=1", envelopeTag); w.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for page, got %d", w.Code)
+	}
+
+	// changed users change the version
+	if w = send(srv, "DELETE", "/users/1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("expected deleted user, got %d", w.Code)
+	}
+	w = getTagged(srv, target, etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Errorf("expected 200 with new ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+
+	// sources without version have no ETag
+	src, _ := newFakeSource(t, "mysql", nil)
+	if w = getTagged(NewWithSource(src), target, "*"); w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+		t.Errorf("expected 200 without ETag, got %d %q", w.Code, w.Header().Get("ETag"))
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	cases := []struct {
+		header, etag string
+		match        bool
+	}{
+		{`W/"a"`, `W/"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`"b", W/"a"`, `W/"a"`, true},
+		{`*`, `W/"a"`, true},
+		{`"b"`, `W/"a"`, false},
+		{``, `W/"a"`, false},
+	}
+	for _, c := range cases {
+		if match := etagMatch(c.header, c.etag); match != c.match {
+			t.Errorf("%q %q: expected %v", c.header, c.etag, c.match)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package searchserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrorBadExpr is the ErrorResponse value of unparsable q parameter
+const ErrorBadExpr = "ErrorBadExpr"
+
+// expr is a compiled q parameter, e.g.
+//
+//	name:Boyd AND (age:>30 OR NOT gender:female) "ad minim"
+//
+// Terms are field:value, fields are id, age, name, about and gender. Name,
+// about and gender values are case insensitive substrings, := requires the
+// whole value. Id and age values are numbers with optional =, >, >=, < or
+// <= before them. A bare value is a substring of name or about. Terms are
+// combined with NOT, AND and OR and grouped with parentheses, AND may be
+// omitted.
+type expr interface {
+	eval(u *User) bool
+}
+
+type andExpr struct{ left, right expr }
+type orExpr struct{ left, right expr }
+type notExpr struct{ expr expr }
+
+type textTerm struct {
+	fields []string
+	exact  bool
+	// value is lower cased
+	value string
+}
+
+type numberTerm struct {
+	field string
+	op    string
+	value int
+}
+
+func (e andExpr) eval(u *User) bool { return e.left.eval(u) && e.right.eval(u) }
+func (e orExpr) eval(u *User) bool  { return e.left.eval(u) || e.right.eval(u) }
+func (e notExpr) eval(u *User) bool { return !e.expr.eval(u) }
+
+func (t textTerm) eval(u *User) bool {
+	for _, field := range t.fields {
+		var s string
+		switch field {
+		case "name":
+			s = u.Name
+		case "about":
+			s = u.About
+		default:
+			s = u.Gender
+		}
+		s = strings.ToLower(s)
+		if t.exact && s == t.value || !t.exact && strings.Contains(s, t.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t numberTerm) eval(u *User) bool {
+	n := u.Age
+	if t.field == "id" {
+		n = u.Id
+	}
+	switch t.op {
+	case ">":
+		return n > t.value
+	case ">=":
+		return n >= t.value
+	case "<":
+		return n < t.value
+	case "<=":
+		return n <= t.value
+	}
+	return n == t.value
+}
+
+type exprTokenKind int
+
+const (
+	exprWord exprTokenKind = iota
+	exprString
+	exprOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+}
+
+// tokenizeExpr splits words, quoted strings, parentheses and colons,
+// a colon is followed by its comparison operator if there is one
+func tokenizeExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, exprToken{exprOp, string(c)})
+			i++
+		case c == ':':
+			end := i + 1
+			for end < len(s) && strings.IndexByte("<>=", s[end]) >= 0 {
+				end++
+			}
+			tokens = append(tokens, exprToken{exprOp, s[i:end]})
+			i = end
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string at %d: %s", i, err)
+			}
+			tokens = append(tokens, exprToken{exprString, value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !unicode.IsSpace(rune(s[end])) && strings.IndexByte(`():"`, s[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, exprToken{exprWord, s[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func parseExpr(s string) (expr, error) {
+	tokens, err := tokenizeExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := exprParser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+// peek reports whether the next token is the operator or the keyword
+func (p *exprParser) peek(text string) bool {
+	if p.pos >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos]
+	return t.kind != exprString && t.text == text
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && !p.peek("OR") && !p.peek(")") {
+		if p.peek("AND") {
+			p.pos++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+	switch {
+	case p.peek("NOT"):
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{e}, nil
+	case p.peek("("):
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("expected )")
+		}
+		p.pos++
+		return e, nil
+	}
+	return p.parseTerm()
+}
+
+func (p *exprParser) parseTerm() (expr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	first := p.tokens[p.pos]
+	p.pos++
+	if first.kind == exprOp || first.kind == exprWord && (first.text == "AND" || first.text == "OR") {
+		return nil, fmt.Errorf("unexpected %q", first.text)
+	}
+	if first.kind == exprString || p.pos >= len(p.tokens) || p.tokens[p.pos].kind != exprOp ||
+		p.tokens[p.pos].text[0] != ':' {
+		return textTerm{fields: []string{"name", "about"}, value: strings.ToLower(first.text)}, nil
+	}
+	field := strings.ToLower(first.text)
+	op := strings.TrimPrefix(p.tokens[p.pos].text, ":")
+	p.pos++
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind == exprOp {
+		return nil, fmt.Errorf("expected value of %s", field)
+	}
+	value := p.tokens[p.pos].text
+	p.pos++
+	switch field {
+	case "id", "age":
+		switch op {
+		case "", "=", ">", ">=", "<", "<=":
+		default:
+			return nil, fmt.Errorf("unknown operator %s", op)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number, got %q", field, value)
+		}
+		return numberTerm{field, op, n}, nil
+	case "name", "about", "gender":
+		if op != "" && op != "=" {
+			return nil, fmt.Errorf("unknown operator %s for %s", op, field)
+		}
+		return textTerm{fields: []string{field}, exact: op == "=", value: strings.ToLower(value)}, nil
+	}
+	return nil, fmt.Errorf("unknown field %s", field)
+}
@@ -0,0 +1,75 @@
+package searchserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	users := []User{
+		{Id: 1, Age: 25, Name: "Boyd Wolf", About: "Nulla cillum enim", Gender: "male"},
+		{Id: 2, Age: 35, Name: "Hilda Mayer", About: "ad minim velit", Gender: "female"},
+		{Id: 3, Age: 40, Name: "Brooks Aguilar", About: "Velit ullamco", Gender: "male"},
+	}
+	cases := map[string][]int{
+		"name:boyd":                             {1},
+		"name:Boyd AND age:>30":                 {},
+		"age:>30":                               {2, 3},
+		"age:>=35 age:<=35":                     {2},
+		"id:<2 OR id:3":                         {1, 3},
+		"gender:=male":                          {1, 3},
+		"gender:male":                           {1, 2, 3},
+		"NOT gender:female":                     {1, 3},
+		`"ad minim"`:                            {2},
+		"velit":                                 {2, 3},
+		"velit AND (gender:=female OR age:<40)": {2},
+		`name:="hilda mayer"`:                   {2},
+		"about:velit NOT (id:2)":                {3},
+		"and":                                   {},
+	}
+	for q, expected := range cases {
+		e, err := parseExpr(q)
+		if err != nil {
+			t.Errorf("%s: %s", q, err)
+			continue
+		}
+		var ids []int
+		for i := range users {
+			if e.eval(&users[i]) {
+				ids = append(ids, users[i].Id)
+			}
+		}
+		if len(ids) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", q, expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != expected[i] {
+				t.Errorf("%s: expected %v, got %v", q, expected, ids)
+				break
+			}
+		}
+	}
+
+	for _, q := range []string{"", "(", "name:boyd)", "age:old", "age:=>1", "name:>a", "email:x", "name:", `"open`, "OR", ":x"} {
+		if _, err := parseExpr(q); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
+
+func TestSearchServerExpr(t *testing.T) {
+	srv := New("../dataset.xml")
+	code, users, _ := search(t, srv, "", "limit=100&order_by=0&q="+"gender%3A%3Dfemale+AND+age%3A%3E30")
+	if code != http.StatusOK || len(users) == 0 {
+		t.Fatalf("expected users, got %d", code)
+	}
+	for _, u := range users {
+		if u.Gender != "female" || u.Age <= 30 {
+			t.Errorf("user %+v doesn't match", u)
+		}
+	}
+	if code, _, errResp := search(t, srv, "", "limit=1&order_by=0&q=age%3Aold"); code != http.StatusBadRequest || errResp.Error != ErrorBadExpr {
+		t.Errorf("expected bad expression, got %d %s", code, errResp.Error)
+	}
+}
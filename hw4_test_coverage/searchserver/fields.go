@@ -0,0 +1,76 @@
+package searchserver
+
+import (
+	"strings"
+)
+
+// ErrorBadFields is the error value of unknown fields
+const ErrorBadFields = "ErrorBadFields"
+
+// fieldSet is the set of lower case field names of fields param,
+// nil means all of them
+type fieldSet map[string]bool
+
+// parseFields parses comma separated names of User fields, e.g. "id,name"
+func parseFields(value string) (fieldSet, error) {
+	if value == "" {
+		return nil, nil
+	}
+	fields := fieldSet{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "id", "name", "age", "about", "gender":
+			fields[name] = true
+		default:
+			return nil, badRequest(ErrorBadFields)
+		}
+	}
+	return fields, nil
+}
+
+// projectedUser is User with the selected fields only
+type projectedUser struct {
+	Id     *int    `json:",omitempty"`
+	Age    *int    `json:",omitempty"`
+	Name   *string `json:",omitempty"`
+	About  *string `json:",omitempty"`
+	Gender *string `json:",omitempty"`
+}
+
+// project returns the value to encode for u, u itself if all fields
+// are selected
+func (fields fieldSet) project(u *User) interface{} {
+	if fields == nil {
+		return u
+	}
+	p := &projectedUser{}
+	if fields["id"] {
+		p.Id = &u.Id
+	}
+	if fields["age"] {
+		p.Age = &u.Age
+	}
+	if fields["name"] {
+		p.Name = &u.Name
+	}
+	if fields["about"] {
+		p.About = &u.About
+	}
+	if fields["gender"] {
+		p.Gender = &u.Gender
+	}
+	return p
+}
+
+// projectUsers returns the value to encode for users
+func (fields fieldSet) projectUsers(users []User) interface{} {
+	if fields == nil {
+		return users
+	}
+	result := make([]interface{}, len(users))
+	for i := range users {
+		result[i] = fields.project(&users[i])
+	}
+	return result
+}
@@ -0,0 +1,31 @@
+package searchserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchServerFields(t *testing.T) {
+	srv := New("../dataset.xml")
+
+	w := send(srv, "GET", "/?order_field=id&order_by=1&limit=2&fields=Id,%20name", "")
+	expected := `[{"Id":0,"Name":"Boyd Wolf"},{"Id":1,"Name":"Hilda Mayer"}]`
+	if w.Code != http.StatusOK || w.Body.String() != expected {
+		t.Errorf("expected %s, got %d %s", expected, w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest("GET", "/?order_field=id&order_by=1&limit=2&fields=age", nil)
+	r.Header.Set("Accept", NDJSONType)
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if expected := "{\"Age\":22}\n{\"Age\":21}\n"; w.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, w.Body)
+	}
+
+	w = send(srv, "GET", "/?order_by=0&limit=1&fields=id,password", "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadFields) {
+		t.Errorf("expected %s, got %d %s", ErrorBadFields, w.Code, w.Body)
+	}
+}
@@ -0,0 +1,71 @@
+package searchserver
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchServerGzip(t *testing.T) {
+	srv := New("../dataset.xml")
+	cases := []struct {
+		query, encoding, accept string
+		gzipped                 bool
+	}{
+		{"limit=30&order_by=0", "gzip", "", true},
+		{"limit=30&order_by=0", "deflate, gzip;q=0.5", "", true},
+		{"limit=30&order_by=0", "gzip;q=0", "", false},
+		{"limit=30&order_by=0", "", "", false},
+		// short bodies are not worth it
+		{"limit=1&order_by=0", "gzip", "", false},
+		{"limit=1&order_by=7", "gzip", "", false},
+		{"order_by=0", "gzip", NDJSONType, true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/?"+c.query, nil)
+		r.Header.Set("Accept-Encoding", c.encoding)
+		r.Header.Set("Accept", c.accept)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != c.gzipped {
+			t.Errorf("%s %q: expected gzip %v, got %v", c.query, c.encoding, c.gzipped, gzipped)
+			continue
+		}
+		if !gzipped {
+			continue
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(gz)
+		if c.accept == NDJSONType {
+			count := 0
+			for ; dec.More(); count++ {
+				if err := dec.Decode(&User{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if count != 35 {
+				t.Errorf("expected 35 streamed users, got %d", count)
+			}
+			continue
+		}
+		var users []User
+		if err := dec.Decode(&users); err != nil || len(users) != 30 {
+			t.Errorf("expected 30 users, got %d %v", len(users), err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/?limit=1&order_by=0", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("AccessToken", "bad")
+	w := httptest.NewRecorder()
+	New("../dataset.xml", "good").ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected plain 401, got %d %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,207 @@
+package searchserver
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// match values, how query is matched against Name and About
+const (
+	// matchSubstring finds query anywhere in a field
+	matchSubstring = "substring"
+	// matchWord requires every word of query to be a word of a field
+	matchWord = "word"
+	// matchPrefix requires every word of query to start a word of a field
+	matchPrefix = "prefix"
+)
+
+// trigramLen is the length of substrings indexed for substring matching,
+// shorter queries are matched with a scan
+const trigramLen = 3
+
+// Index is an inverted index of lower cased Name and About. It selects
+// candidates for a query in dataset order, they are checked with the exact
+// match, so the result is the same as of a scan.
+type Index struct {
+	users []User
+	// words are postings of words, sorted lists of users positions
+	words map[string][]int
+	// sortedWords are keys of words for prefix lookups
+	sortedWords []string
+	// trigrams are postings of substrings of trigramLen bytes
+	trigrams map[string][]int
+}
+
+// IndexedSource is a DataSource which keeps an index of its users,
+// SearchServer uses it instead of scanning users
+type IndexedSource interface {
+	DataSource
+	Index() (*Index, error)
+}
+
+func NewIndex(users []User) *Index {
+	idx := &Index{
+		users:    users,
+		words:    make(map[string][]int),
+		trigrams: make(map[string][]int),
+	}
+	for i := range users {
+		for _, field := range []string{users[i].Name, users[i].About} {
+			field = strings.ToLower(field)
+			for _, word := range splitWords(field) {
+				idx.words[word] = addPosting(idx.words[word], i)
+			}
+			for j := 0; j+trigramLen <= len(field); j++ {
+				trigram := field[j : j+trigramLen]
+				idx.trigrams[trigram] = addPosting(idx.trigrams[trigram], i)
+			}
+		}
+	}
+	idx.sortedWords = make([]string, 0, len(idx.words))
+	for word := range idx.words {
+		idx.sortedWords = append(idx.sortedWords, word)
+	}
+	sort.Strings(idx.sortedWords)
+	return idx
+}
+
+// addPosting appends position to the sorted postings once,
+// users are added in order so only the last one may be the same
+func addPosting(postings []int, pos int) []int {
+	if n := len(postings); n > 0 && postings[n-1] == pos {
+		return postings
+	}
+	return append(postings, pos)
+}
+
+// candidates returns positions of users which may match the query,
+// nil means every user has to be checked
+func (idx *Index) candidates(req *request) []int {
+	query := strings.ToLower(req.query)
+	if req.match == matchSubstring {
+		if len(query) < trigramLen {
+			return nil
+		}
+		result := idx.trigrams[query[:trigramLen]]
+		for j := 1; j+trigramLen <= len(query) && len(result) > 0; j++ {
+			result = intersect(result, idx.trigrams[query[j:j+trigramLen]])
+		}
+		return nonNil(result)
+	}
+	words := splitWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+	var result []int
+	for i, word := range words {
+		postings := idx.words[word]
+		if req.match == matchPrefix {
+			postings = idx.prefixPostings(word)
+		}
+		if i == 0 {
+			result = postings
+		} else {
+			result = intersect(result, postings)
+		}
+	}
+	return nonNil(result)
+}
+
+// prefixPostings merges postings of all words starting with prefix
+func (idx *Index) prefixPostings(prefix string) []int {
+	start := sort.SearchStrings(idx.sortedWords, prefix)
+	end := start
+	for end < len(idx.sortedWords) && strings.HasPrefix(idx.sortedWords[end], prefix) {
+		end++
+	}
+	if end-start == 1 {
+		return idx.words[idx.sortedWords[start]]
+	}
+	seen := make(map[int]bool)
+	var result []int
+	for _, word := range idx.sortedWords[start:end] {
+		for _, pos := range idx.words[word] {
+			if !seen[pos] {
+				seen[pos] = true
+				result = append(result, pos)
+			}
+		}
+	}
+	sort.Ints(result)
+	return result
+}
+
+// search is searchBy over candidates of the index
+func (idx *Index) search(req *request) []User {
+	positions := idx.candidates(req)
+	if positions == nil {
+		return searchBy(req, idx.users)
+	}
+	candidates := make([]User, len(positions))
+	for i, pos := range positions {
+		candidates[i] = idx.users[pos]
+	}
+	return searchBy(req, candidates)
+}
+
+func intersect(a, b []int) []int {
+	result := make([]int, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// nonNil tells no candidates from no index lookup
+func nonNil(positions []int) []int {
+	if positions == nil {
+		return []int{}
+	}
+	return positions
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchQuery reports whether Name or About of user matches the query
+func matchQuery(req *request, user *User) bool {
+	if req.query == "" {
+		return true
+	}
+	query, name, about := req.query, user.Name, user.About
+	if req.ignoreCase {
+		query, name, about = strings.ToLower(query), strings.ToLower(name), strings.ToLower(about)
+	}
+	if req.match == matchSubstring {
+		return strings.Contains(name, query) || strings.Contains(about, query)
+	}
+	fieldWords := append(splitWords(name), splitWords(about)...)
+	for _, word := range splitWords(query) {
+		if !containsWord(fieldWords, word, req.match == matchPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsWord(words []string, word string, prefix bool) bool {
+	for _, w := range words {
+		if w == word || prefix && strings.HasPrefix(w, word) {
+			return true
+		}
+	}
+	return false
+}
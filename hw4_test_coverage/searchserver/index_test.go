@@ -0,0 +1,51 @@
+package searchserver
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestIndexSameAsScan(t *testing.T) {
+	users, err := XMLSource{Path: "../dataset.xml"}.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx := NewIndex(users)
+	queries := []string{"", "a", "Bo", "Boyd", "boyd", "BOYD", "Boyd Wolf", "wolf boyd", "sunt", "Sunt ex",
+		"nisi.", "dolor", "dol", "zzz", "!!", "mollit Lorem"}
+	for _, match := range []string{matchSubstring, matchWord, matchPrefix} {
+		for _, ignoreCase := range []bool{false, true} {
+			for _, query := range queries {
+				req := &request{query: query, match: match, ignoreCase: ignoreCase}
+				expected := searchBy(req, users)
+				if got := idx.search(req); !reflect.DeepEqual(got, expected) {
+					t.Errorf("%s %v %q: expected %d users, got %d", match, ignoreCase, query, len(expected), len(got))
+				}
+			}
+		}
+	}
+}
+
+func TestSearchServerMatch(t *testing.T) {
+	srv := New("../dataset.xml")
+	cases := map[string]int{
+		"query=boyd":                                   0,
+		"query=boyd&ignore_case=1":                     1,
+		"query=Boy&match=word":                         0,
+		"query=Boy&match=prefix":                       1,
+		"query=wolf+boy&match=prefix&ignore_case=true": 1,
+	}
+	for query, expected := range cases {
+		code, users, _ := search(t, srv, "", "limit=100&order_by=0&"+query)
+		if code != http.StatusOK || len(users) != expected {
+			t.Errorf("%s: expected %d users, got %d %+v", query, expected, code, users)
+		}
+	}
+	for _, query := range []string{"match=regexp", "ignore_case=maybe"} {
+		code, _, errResp := search(t, srv, "", "limit=1&order_by=0&"+query)
+		if code != http.StatusBadRequest || errResp.Error != ErrorBadMatch {
+			t.Errorf("%s: expected 400 %s, got %d %s", query, ErrorBadMatch, code, errResp.Error)
+		}
+	}
+}
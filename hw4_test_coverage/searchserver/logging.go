@@ -0,0 +1,106 @@
+package searchserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogEntry describes a served request
+type LogEntry struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	// TraceID is the one of the response, see TraceHeader
+	TraceID string `json:"trace_id,omitempty"`
+	// TokenID identifies AccessToken without revealing it, it's empty
+	// for requests without token
+	TokenID string `json:"token_id,omitempty"`
+	Query   string `json:"query,omitempty"`
+	Expr    string `json:"q,omitempty"`
+	// Limit and Offset are -1 if they are missing or malformed
+	Limit      int     `json:"limit"`
+	Offset     int     `json:"offset"`
+	Status     int     `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Logger gets an entry per request, it may be called concurrently
+type Logger interface {
+	Log(entry LogEntry)
+}
+
+// LoggerFunc adapts a function to Logger
+type LoggerFunc func(entry LogEntry)
+
+func (f LoggerFunc) Log(entry LogEntry) {
+	f(entry)
+}
+
+// JSONLogger writes entries as JSON lines
+type JSONLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{enc: json.NewEncoder(w)}
+}
+
+func (l *JSONLogger) Log(entry LogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enc.Encode(entry)
+}
+
+// LogRequests logs requests to next, it's meant to be the outermost
+// handler, so requests rejected by RateLimiter are logged too
+func LogRequests(logger Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		r = traceRequest(rec, r)
+		next.ServeHTTP(rec, r)
+		params := r.URL.Query()
+		entry := LogEntry{
+			Time:       start,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			TraceID:    TraceID(r.Context()),
+			TokenID:    tokenID(r.Header.Get("AccessToken")),
+			Query:      params.Get("query"),
+			Expr:       params.Get("q"),
+			Limit:      intParam(params.Get("limit")),
+			Offset:     intParam(params.Get("offset")),
+			Status:     rec.status,
+			DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		logger.Log(entry)
+	})
+}
+
+// tokenID is the prefix of SHA-256 of the token, it's enough to tell
+// tokens apart in logs
+func tokenID(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+func intParam(value string) int {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
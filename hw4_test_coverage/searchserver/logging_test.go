@@ -0,0 +1,64 @@
+package searchserver
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRequests(t *testing.T) {
+	var entries []LogEntry
+	srv := New("../dataset.xml", "secret")
+	limiter := NewRateLimiter(1, 1)
+	handler := LogRequests(LoggerFunc(func(entry LogEntry) {
+		entries = append(entries, entry)
+	}), limiter.Handler(srv))
+
+	for _, target := range []string{"/?query=Boyd&limit=2&offset=1&order_by=0", "/?query=Boyd&limit=2&offset=1&order_by=0", "/?limit=x"} {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("AccessToken", "secret")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	first := entries[0]
+	if first.Method != "GET" || first.Path != "/" || first.Query != "Boyd" || first.Limit != 2 ||
+		first.Offset != 1 || first.Status != http.StatusOK || first.TokenID != tokenID("secret") {
+		t.Errorf("unexpected entry %+v", first)
+	}
+	if first.TokenID == "" || strings.Contains(first.TokenID, "secret") {
+		t.Errorf("token id must identify the token without revealing it, got %q", first.TokenID)
+	}
+	// the limiter rejects the second request
+	if entries[1].Status != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %+v", entries[1])
+	}
+	if entries[2].Limit != -1 || entries[2].Offset != -1 {
+		t.Errorf("expected missing limit and offset, got %+v", entries[2])
+	}
+	if entries[3].TokenID != "" || entries[3].Path != "/healthz" {
+		t.Errorf("unexpected entry %+v", entries[3])
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewJSONLogger(buf)
+	logger.Log(LogEntry{Time: time.Unix(0, 0).UTC(), Method: "GET", Path: "/", Limit: 1, Offset: -1, Status: 200, DurationMs: 1.5})
+	logger.Log(LogEntry{Method: "POST", Path: "/batch"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", buf)
+	}
+	expected := `{"time":"1970-01-01T00:00:00Z","method":"GET","path":"/","limit":1,"offset":-1,"status":200,"duration_ms":1.5}`
+	if lines[0] != expected {
+		t.Errorf("expected %s, got %s", expected, lines[0])
+	}
+}
@@ -0,0 +1,172 @@
+package searchserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are upper bounds of request duration histogram in seconds
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// metrics are counters of SearchServer in Prometheus text format
+type metrics struct {
+	mu sync.Mutex
+	// requests are counted by handler and status
+	requests map[requestKey]uint64
+	// queryErrors are counted by ErrorResponse value
+	queryErrors map[string]uint64
+	// buckets are counts of durations per latencyBuckets, not cumulative
+	buckets  []uint64
+	duration float64
+	count    uint64
+	// datasetUsers is the number of users of the last search, -1 if
+	// there was none
+	datasetUsers int
+}
+
+type requestKey struct {
+	handler string
+	status  int
+}
+
+func (m *metrics) init() {
+	if m.requests == nil {
+		m.requests = make(map[requestKey]uint64)
+		m.queryErrors = make(map[string]uint64)
+		m.buckets = make([]uint64, len(latencyBuckets))
+		m.datasetUsers = -1
+	}
+}
+
+func (m *metrics) observe(handler string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.init()
+	m.requests[requestKey{handler, status}]++
+	seconds := d.Seconds()
+	m.duration += seconds
+	m.count++
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		m.buckets[i]++
+	}
+}
+
+func (m *metrics) queryError(value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.init()
+	m.queryErrors[value]++
+}
+
+func (m *metrics) setDatasetUsers(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.init()
+	m.datasetUsers = n
+}
+
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.init()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].handler < keys[j].handler ||
+			keys[i].handler == keys[j].handler && keys[i].status < keys[j].status
+	})
+	fmt.Fprintln(w, "# HELP searchserver_requests_total Requests by handler and status code.")
+	fmt.Fprintln(w, "# TYPE searchserver_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "searchserver_requests_total{handler=%q,code=\"%d\"} %d\n", key.handler, key.status, m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP searchserver_request_duration_seconds Request duration.")
+	fmt.Fprintln(w, "# TYPE searchserver_request_duration_seconds histogram")
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += m.buckets[i]
+		fmt.Fprintf(w, "searchserver_request_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "searchserver_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "searchserver_request_duration_seconds_sum %g\n", m.duration)
+	fmt.Fprintf(w, "searchserver_request_duration_seconds_count %d\n", m.count)
+
+	errors := make([]string, 0, len(m.queryErrors))
+	for value := range m.queryErrors {
+		errors = append(errors, value)
+	}
+	sort.Strings(errors)
+	fmt.Fprintln(w, "# HELP searchserver_query_errors_total Bad requests by error.")
+	fmt.Fprintln(w, "# TYPE searchserver_query_errors_total counter")
+	for _, value := range errors {
+		fmt.Fprintf(w, "searchserver_query_errors_total{error=%q} %d\n", value, m.queryErrors[value])
+	}
+
+	if m.datasetUsers >= 0 {
+		fmt.Fprintln(w, "# HELP searchserver_dataset_users Users in the dataset.")
+		fmt.Fprintln(w, "# TYPE searchserver_dataset_users gauge")
+		fmt.Fprintf(w, "searchserver_dataset_users %d\n", m.datasetUsers)
+	}
+}
+
+// statusRecorder keeps the status of the response for metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// pinger is a DataSource which can check it's available without loading
+type pinger interface {
+	Ping() error
+}
+
+// serveHealth is 200 if users can be loaded, 503 otherwise
+func (srv *SearchServer) serveHealth(w http.ResponseWriter) {
+	var err error
+	if p, ok := srv.Source.(pinger); ok {
+		err = p.Ping()
+	} else {
+		_, err = srv.Source.Users()
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "unavailable")
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (srv *SearchServer) serveMetrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	srv.metrics.write(w)
+}
@@ -0,0 +1,57 @@
+package searchserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func get(srv http.Handler, target, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	r.Header.Set("AccessToken", token)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestHealthz(t *testing.T) {
+	if w := get(New("../dataset.xml", "secret"), "/healthz", ""); w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("expected ok, got %d %s", w.Code, w.Body)
+	}
+	if w := get(NewWithSource(errSource{errors.New("broken")}), "/healthz", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", w.Code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	srv := New("../dataset.xml")
+	get(srv, "/?limit=1&order_by=0", "")
+	get(srv, "/?limit=1&order_by=0", "")
+	get(srv, "/?limit=x&order_by=0", "")
+	get(srv, "/healthz", "")
+	w := get(srv, "/metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`searchserver_requests_total{handler="search",code="200"} 2`,
+		`searchserver_requests_total{handler="search",code="400"} 1`,
+		`searchserver_requests_total{handler="healthz",code="200"} 1`,
+		`searchserver_request_duration_seconds_bucket{le="+Inf"} 4`,
+		`searchserver_request_duration_seconds_count 4`,
+		`searchserver_query_errors_total{error="ErrorBadLimit"} 1`,
+		`searchserver_dataset_users 35`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %s in\n%s", line, body)
+		}
+	}
+
+	srv.Tokens = NewTokenStore(Token{Value: "reader", Scope: ScopeRead})
+	if w := get(srv, "/metrics", "reader"); w.Code != http.StatusForbidden {
+		t.Errorf("expected metrics to require admin, got %d", w.Code)
+	}
+}
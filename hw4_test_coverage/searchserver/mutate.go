@@ -0,0 +1,147 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error values of ErrorResponse for changes of users
+const (
+	ErrorBadUser      = "ErrorBadUser"
+	ErrorUserNotFound = "ErrorUserNotFound"
+	ErrorReadOnly     = "ErrorReadOnly"
+)
+
+// maxUserBody limits the body of create and update requests
+const maxUserBody = 1 << 20
+
+// ErrNotFound is returned by WritableSource for unknown id
+var ErrNotFound = errors.New("user not found")
+
+// WritableSource is a DataSource which can change its users,
+// changes are visible to the next Users call
+type WritableSource interface {
+	DataSource
+	// Create adds the user with id next to the largest one
+	Create(u User) (User, error)
+	// Update replaces the user with the same id keeping its position
+	Update(u User) (User, error)
+	Delete(id int) error
+}
+
+// userBody is the body of create and update requests, Id of User is
+// ignored, it's assigned by the server or taken from the path
+type userBody struct {
+	// Name is the first name and the last one after a space
+	Name   string
+	Age    int
+	About  string
+	Gender string
+}
+
+func parseUser(r *http.Request) (User, error) {
+	body := userBody{}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxUserBody)).Decode(&body); err != nil {
+		return User{}, badRequest(ErrorBadUser)
+	}
+	// the dataset keeps the first name and the rest of the name apart
+	name := strings.SplitN(strings.TrimSpace(body.Name), " ", 2)
+	if len(name) < 2 || name[0] == "" || strings.TrimSpace(name[1]) == "" ||
+		body.Age < 0 || body.Gender != "male" && body.Gender != "female" {
+		return User{}, badRequest(ErrorBadUser)
+	}
+	u := User{FName: name[0], LName: strings.TrimSpace(name[1]), Age: body.Age, About: body.About, Gender: body.Gender}
+	u.Name = u.FName + " " + u.LName
+	return u, nil
+}
+
+// serveUsers handles POST /users, PUT /users/{id} and DELETE /users/{id}
+func (srv *SearchServer) serveUsers(w http.ResponseWriter, r *http.Request) {
+	src, ok := srv.Source.(WritableSource)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, ErrorResponse{ErrorReadOnly})
+		return
+	}
+	idPart := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/users"), "/")
+	var id int
+	var err error
+	switch {
+	case r.Method == http.MethodPost && idPart == "":
+	case (r.Method == http.MethodPut || r.Method == http.MethodDelete) && idPart != "":
+		if id, err = strconv.Atoi(idPart); err != nil {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{ErrorUserNotFound})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var user User
+	if r.Method != http.MethodDelete {
+		if user, err = parseUser(r); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+			return
+		}
+		user.Id = id
+	}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodPost:
+		status = http.StatusCreated
+		user, err = src.Create(user)
+	case http.MethodPut:
+		user, err = src.Update(user)
+	default:
+		status = http.StatusNoContent
+		err = src.Delete(id)
+	}
+	switch {
+	case err == ErrNotFound:
+		writeJSON(w, http.StatusNotFound, ErrorResponse{ErrorUserNotFound})
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+	case status == http.StatusNoContent:
+		w.WriteHeader(status)
+	default:
+		writeJSON(w, status, user)
+	}
+}
+
+// createUser, updateUser and deleteUser return changed copies of users
+func createUser(users []User, u User) ([]User, User) {
+	u.Id = 0
+	for i := range users {
+		if users[i].Id >= u.Id {
+			u.Id = users[i].Id + 1
+		}
+	}
+	u.pos = len(users)
+	return append(users[:len(users):len(users)], u), u
+}
+
+func updateUser(users []User, u User) ([]User, User, error) {
+	for i := range users {
+		if users[i].Id == u.Id {
+			u.pos = users[i].pos
+			changed := append([]User(nil), users...)
+			changed[i] = u
+			return changed, u, nil
+		}
+	}
+	return nil, User{}, ErrNotFound
+}
+
+func deleteUser(users []User, id int) ([]User, error) {
+	for i := range users {
+		if users[i].Id == id {
+			changed := make([]User, 0, len(users)-1)
+			changed = append(append(changed, users[:i]...), users[i+1:]...)
+			return completeUsers(changed), nil
+		}
+	}
+	return nil, ErrNotFound
+}
@@ -0,0 +1,115 @@
+package searchserver
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func send(srv http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	return w
+}
+
+func TestSearchServerUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "searchserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	original, err := XMLSource{"../dataset.xml"}.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{FormatXML, FormatJSONLines, FormatCSV} {
+		path := filepath.Join(dir, "users."+format)
+		src, _ := NewSource(format, path)
+		if err := src.(fileWriter).writeUsers(path, original); err != nil {
+			t.Fatal(err)
+		}
+		cached := NewCachedSource(src, path)
+		srv := NewWithSource(cached)
+
+		w := send(srv, "POST", "/users", `{"Id":3,"Name":"Ann  Lee","Age":30,"About":"new","Gender":"female"}`)
+		if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `{"Id":35,`) ||
+			!strings.Contains(w.Body.String(), `"Name":"Ann Lee"`) {
+			t.Errorf("%s: expected created user 35, got %d %s", format, w.Code, w.Body)
+		}
+		w = send(srv, "PUT", "/users/0", `{"Name":"Boyd Wolfe","Age":23,"Gender":"male"}`)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"Name":"Boyd Wolfe"`) {
+			t.Errorf("%s: expected updated user, got %d %s", format, w.Code, w.Body)
+		}
+		if w = send(srv, "DELETE", "/users/1", ""); w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected deleted user, got %d %s", format, w.Code, w.Body)
+		}
+
+		// changes are in the file
+		users, err := src.Users()
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		expected := append([]User{original[0]}, original[2:]...)
+		expected[0].LName, expected[0].Age, expected[0].About = "Wolfe", 23, ""
+		expected = append(expected, User{Id: 35, FName: "Ann", LName: "Lee", Name: "Ann Lee", Age: 30, About: "new", Gender: "female"})
+		completeUsers(expected)
+		if !reflect.DeepEqual(users, expected) {
+			for i := range users {
+				if !reflect.DeepEqual(users[i], expected[i]) {
+					t.Errorf("%s: expected user %+v in file, got %+v", format, expected[i], users[i])
+					break
+				}
+			}
+		}
+		if cachedUsers, _ := cached.Users(); !reflect.DeepEqual(cachedUsers, expected) {
+			t.Errorf("%s: cached users differ from the file", format)
+		}
+		if code, found, _ := search(t, srv, "", "limit=10&order_by=0&query=Wolfe"); code != http.StatusOK || len(found) != 1 {
+			t.Errorf("%s: expected updated user to be found, got %+v", format, found)
+		}
+	}
+}
+
+func TestSearchServerUsersErrors(t *testing.T) {
+	srv := NewWithSource(NewCachedSource(XMLSource{"../dataset.xml"}, "../dataset.xml"))
+	cases := []struct {
+		method, target, body string
+		status               int
+	}{
+		{"PUT", "/users/100", `{"Name":"A B","Gender":"male"}`, http.StatusNotFound},
+		{"DELETE", "/users/100", ``, http.StatusNotFound},
+		{"DELETE", "/users/x", ``, http.StatusNotFound},
+		{"POST", "/users/1", `{}`, http.StatusMethodNotAllowed},
+		{"PUT", "/users", `{}`, http.StatusMethodNotAllowed},
+		{"POST", "/users", `{"Name":"Ann","Gender":"female"}`, http.StatusBadRequest},
+		{"POST", "/users", `{"Name":"Ann Lee","Gender":"robot"}`, http.StatusBadRequest},
+		{"POST", "/users", `{"Name":"Ann Lee","Gender":"male","Age":-1}`, http.StatusBadRequest},
+		{"POST", "/users", `{`, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if w := send(srv, c.method, c.target, c.body); w.Code != c.status {
+			t.Errorf("%s %s %s: expected %d, got %d", c.method, c.target, c.body, c.status, w.Code)
+		}
+	}
+
+	readOnly := NewWithSource(errSource{errors.New("broken")})
+	if w := send(readOnly, "DELETE", "/users/1", ""); w.Code != http.StatusNotImplemented {
+		t.Errorf("expected 501 for read only source, got %d", w.Code)
+	}
+	srv.Tokens = NewTokenStore(Token{Value: "reader", Scope: ScopeRead})
+	r := httptest.NewRequest("DELETE", "/users/1", nil)
+	r.Header.Set("AccessToken", "reader")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected changes to require admin, got %d", w.Code)
+	}
+}
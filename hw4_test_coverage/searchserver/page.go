@@ -0,0 +1,59 @@
+package searchserver
+
+import (
+	"net/http"
+)
+
+// ErrorBadEnvelope is the error value of malformed envelope param
+const ErrorBadEnvelope = "ErrorBadEnvelope"
+
+// page is the response of requests with envelope param, Users are
+// projected by fields
+type page struct {
+	Users interface{}
+	// Total is the number of matched users on all pages
+	Total int
+	// Offset is the number of skipped users, it's 0 with cursor
+	Offset   int
+	NextPage bool
+	// NextCursor is the cursor of the first user of the next page
+	NextCursor string `json:",omitempty"`
+}
+
+// findPage is find counting all matched users, so neither the limit nor
+// the cursor can be done by the source
+func (srv *SearchServer) findPage(req *request) (*page, error) {
+	all := *req
+	all.limit = noLimit
+	all.after = nil
+	users, err := srv.search(&all)
+	if err != nil {
+		return nil, err
+	}
+	less := userLess(req.orderBy, req.orderField)
+	sortUsers(less, users)
+	p := &page{Total: len(users), Offset: req.skip()}
+	if req.after != nil {
+		users = seek(users, req.after, less)
+	}
+	users = skipUsers(p.Offset, users)
+	if len(users) > req.limit {
+		p.NextPage = true
+		p.NextCursor = newCursor(req, &users[req.limit])
+		users = users[:req.limit]
+	}
+	p.Users = req.fields.projectUsers(users)
+	return p, nil
+}
+
+func (srv *SearchServer) servePage(w http.ResponseWriter, r *http.Request, req *request) {
+	p, err := srv.findPage(req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if srv.notModified(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
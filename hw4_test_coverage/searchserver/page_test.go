@@ -0,0 +1,84 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testPage struct {
+	Users      []User
+	Total      int
+	Offset     int
+	NextPage   bool
+	NextCursor string
+}
+
+func getPage(t *testing.T, srv http.Handler, query string) testPage {
+	w := send(srv, "GET", "/?"+query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: expected 200, got %d %s", query, w.Code, w.Body)
+	}
+	var p testPage
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSearchServerPage(t *testing.T) {
+	srv := New("../dataset.xml")
+
+	query := "query=W&order_field=id&order_by=1&limit=2&envelope=true"
+	first := getPage(t, srv, query+"&offset=1")
+	if len(first.Users) != 2 || first.Total != 4 || first.Offset != 1 || !first.NextPage || first.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	// offset is ignored with cursor
+	second := getPage(t, srv, query+"&offset=1&cursor="+url.QueryEscape(first.NextCursor))
+	if len(second.Users) != 1 || second.Users[0].Id <= first.Users[1].Id || second.Total != 4 ||
+		second.Offset != 0 || second.NextPage || second.NextCursor != "" {
+		t.Errorf("unexpected second page %+v", second)
+	}
+
+	first = getPage(t, srv, query+"&offset=0")
+	second = getPage(t, srv, query+"&offset=2")
+	if len(first.Users) != 2 || !first.NextPage || len(second.Users) != 2 || second.NextPage ||
+		second.Users[0].Id <= first.Users[1].Id {
+		t.Errorf("unexpected pages by offset %+v and %+v", first, second)
+	}
+	if past := getPage(t, srv, query+"&offset=10"); len(past.Users) != 0 || past.Total != 4 || past.NextPage {
+		t.Errorf("unexpected page past the end %+v", past)
+	}
+
+	w := send(srv, "GET", "/?order_by=0&limit=1&envelope=true&fields=id", "")
+	if !strings.HasPrefix(w.Body.String(), `{"Users":[{"Id":0}],"Total":35,`) {
+		t.Errorf("expected projected users, got %s", w.Body)
+	}
+	w = send(srv, "GET", "/?order_by=0&limit=1&envelope=yes", "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrorBadEnvelope) {
+		t.Errorf("expected %s, got %d %s", ErrorBadEnvelope, w.Code, w.Body)
+	}
+	if w = send(New("missing.xml"), "GET", "/?order_by=0&limit=1&envelope=1", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for broken source, got %d", w.Code)
+	}
+}
+
+func TestSQLSourcePage(t *testing.T) {
+	rows := []User{
+		{Id: 1, FName: "Ann", LName: "Lee", Age: 30, Gender: "female"},
+		{Id: 2, FName: "Bob", LName: "Ray", Age: 40, Gender: "male"},
+		{Id: 3, FName: "Cid", LName: "Roe", Age: 50, Gender: "male"},
+	}
+	src, fake := newFakeSource(t, "mysql", rows)
+	p := getPage(t, NewWithSource(src), "order_field=id&order_by=1&limit=1&envelope=1")
+	if len(p.Users) != 1 || p.Total != 3 || !p.NextPage {
+		t.Errorf("unexpected page %+v", p)
+	}
+	// all users are counted, so the database doesn't limit them
+	if strings.Contains(fake.query, "LIMIT") {
+		t.Errorf("unexpected limit in %s", fake.query)
+	}
+}
@@ -0,0 +1,91 @@
+package searchserver
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrorRateLimited is the ErrorResponse value of 429 status
+const ErrorRateLimited = "ErrorRateLimited"
+
+// maxIdleBuckets is the number of buckets kept before full ones are dropped
+const maxIdleBuckets = 1024
+
+// RateLimiter is a token bucket per AccessToken, requests over the rate
+// get 429 status with Retry-After header
+type RateLimiter struct {
+	// rate is tokens per second, burst is the bucket size
+	rate  float64
+	burst float64
+	// now is time.Now, tests replace it
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token of the key, if there is none it returns
+// how long to wait for it
+func (l *RateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.dropFull(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// dropFull forgets buckets which are full by now, they are the same as new
+func (l *RateLimiter) dropFull(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Handler limits requests to next by AccessToken header
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(r.Header.Get("AccessToken"))
+		if !ok {
+			// Retry-After is in whole seconds
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSON(w, http.StatusTooManyRequests, ErrorResponse{ErrorRateLimited})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
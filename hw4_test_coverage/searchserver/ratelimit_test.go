@@ -0,0 +1,48 @@
+package searchserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(0.5, 2)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Handler(New("../dataset.xml"))
+
+	request := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?limit=1&order_by=0", nil)
+		r.Header.Set("AccessToken", token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := request("a"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, w.Code)
+		}
+	}
+	w := request("a")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected 429 with Retry-After 2, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// buckets are per token
+	if w := request("b"); w.Code != http.StatusOK {
+		t.Errorf("expected other token to pass, got %d", w.Code)
+	}
+	now = now.Add(2 * time.Second)
+	if w := request("a"); w.Code != http.StatusOK {
+		t.Errorf("expected refilled bucket to pass, got %d", w.Code)
+	}
+
+	now = now.Add(time.Minute)
+	for i := 0; i < maxIdleBuckets; i++ {
+		limiter.allow(string(rune('c' + i)))
+	}
+	if len(limiter.buckets) > maxIdleBuckets {
+		t.Errorf("expected full buckets to be dropped, got %d", len(limiter.buckets))
+	}
+}
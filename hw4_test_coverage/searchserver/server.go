@@ -0,0 +1,511 @@
+// Package searchserver is the search service used by SearchClient,
+// it searches users of a dataset file or an SQL table.
+package searchserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// order_by values, the same as SearchRequest.OrderBy
+const (
+	orderDesc = -1
+	orderAsIs = 0
+	orderAsc  = 1
+)
+
+// noLimit is the limit of streams requested without it
+const noLimit = -1
+
+// errEnough stops streamSource.each after the limit
+var errEnough = errors.New("enough users")
+
+// Error values of ErrorResponse
+const (
+	ErrorBadOrderField = "ErrorBadOrderField"
+	ErrorBadLimit      = "ErrorBadLimit"
+	ErrorBadOffset     = "ErrorBadOffset"
+	ErrorBadOrderBy    = "ErrorBadOrderBy"
+	ErrorBadCursor     = "ErrorBadCursor"
+	ErrorBadAgeRange   = "ErrorBadAgeRange"
+	ErrorBadGender     = "ErrorBadGender"
+	ErrorBadMatch      = "ErrorBadMatch"
+)
+
+// User is a row of the dataset, Name is first_name + last_name
+type User struct {
+	Id     int    `xml:"id"`
+	Age    int    `xml:"age"`
+	FName  string `xml:"first_name" json:"-"`
+	LName  string `xml:"last_name" json:"-"`
+	Name   string `xml:"-"`
+	About  string `xml:"about"`
+	Gender string `xml:"gender"`
+	// pos is the position in the dataset
+	pos int
+}
+
+type dataset struct {
+	Data []User `xml:"row"`
+}
+
+type ErrorResponse struct {
+	Error string
+}
+
+type SearchServer struct {
+	Source DataSource
+	// Tokens are accepted values of AccessToken header, search and batch
+	// require read scope, stats, metrics and changes of users admin one,
+	// healthz none. Authorization is disabled if it's nil.
+	Tokens *TokenStore
+
+	metrics metrics
+}
+
+// New serves dataset file of the format detected by extension, the file
+// is cached and reloaded when it changes. Requests fail if the format is
+// unknown.
+func New(datasetPath string, tokens ...string) *SearchServer {
+	src, err := NewSource("", datasetPath)
+	if err != nil {
+		return NewWithSource(errSource{err}, tokens...)
+	}
+	return NewWithSource(NewCachedSource(src, datasetPath), tokens...)
+}
+
+// NewWithSource serves the source, tokens have read scope
+func NewWithSource(src DataSource, tokens ...string) *SearchServer {
+	srv := &SearchServer{Source: src}
+	if len(tokens) > 0 {
+		srv.Tokens = NewTokenStore()
+		for _, token := range tokens {
+			srv.Tokens.Add(Token{Value: token, Scope: ScopeRead})
+		}
+	}
+	return srv
+}
+
+type request struct {
+	query string
+	// match is one of match values, ignoreCase applies to all of them
+	match      string
+	ignoreCase bool
+	// q is the text of expr, nil if there is none
+	q          string
+	expr       expr
+	orderField string
+	orderBy    int
+	// limit is noLimit for streams without limit
+	limit  int
+	stream bool
+	offset int
+	// ageMin and ageMax are inclusive, 0 means no bound
+	ageMin int
+	ageMax int
+	gender string
+	// after is set by cursor, offset is ignored then
+	after *User
+	// fields are encoded fields of users, nil means all
+	fields fieldSet
+	// envelope wraps users in page, it's ignored by streams
+	envelope bool
+}
+
+// badRequest is an error reported to client in ErrorResponse
+type badRequest string
+
+func (e badRequest) Error() string {
+	return string(e)
+}
+
+func parseRequest(params url.Values, stream bool) (*request, error) {
+	req := &request{query: params.Get("query"), stream: stream}
+	req.orderField = strings.ToLower(params.Get("order_field"))
+	switch req.orderField {
+	case "id", "name", "age":
+	case "":
+		req.orderField = "name"
+	default:
+		return nil, badRequest(ErrorBadOrderField)
+	}
+	var err error
+	if req.orderBy, err = strconv.Atoi(params.Get("order_by")); err != nil ||
+		req.orderBy < orderDesc || req.orderBy > orderAsc {
+		return nil, badRequest(ErrorBadOrderBy)
+	}
+	if limit := params.Get("limit"); req.stream && limit == "" {
+		req.limit = noLimit
+	} else if req.limit, err = strconv.Atoi(limit); err != nil || req.limit < 0 {
+		return nil, badRequest(ErrorBadLimit)
+	}
+	if offset := params.Get("offset"); offset != "" {
+		if req.offset, err = strconv.Atoi(offset); err != nil || req.offset < 0 {
+			return nil, badRequest(ErrorBadOffset)
+		}
+	}
+	if err = parseMatch(params, req); err != nil {
+		return nil, err
+	}
+	if req.q = params.Get("q"); req.q != "" {
+		if req.expr, err = parseExpr(req.q); err != nil {
+			return nil, badRequest(ErrorBadExpr)
+		}
+	}
+	if err = parseFilters(params, req); err != nil {
+		return nil, err
+	}
+	if value := params.Get("cursor"); value != "" {
+		if req.after, err = parseCursor(req, value); err != nil {
+			return nil, err
+		}
+	}
+	if req.fields, err = parseFields(params.Get("fields")); err != nil {
+		return nil, err
+	}
+	if value := params.Get("envelope"); value != "" {
+		if req.envelope, err = strconv.ParseBool(value); err != nil {
+			return nil, badRequest(ErrorBadEnvelope)
+		}
+	}
+	return req, nil
+}
+
+func parseMatch(params url.Values, req *request) error {
+	req.match = strings.ToLower(params.Get("match"))
+	switch req.match {
+	case matchWord, matchPrefix, matchSubstring:
+	case "":
+		req.match = matchSubstring
+	default:
+		return badRequest(ErrorBadMatch)
+	}
+	if value := params.Get("ignore_case"); value != "" {
+		var err error
+		if req.ignoreCase, err = strconv.ParseBool(value); err != nil {
+			return badRequest(ErrorBadMatch)
+		}
+	}
+	return nil
+}
+
+func parseFilters(params url.Values, req *request) error {
+	var err error
+	for param, bound := range map[string]*int{"age_min": &req.ageMin, "age_max": &req.ageMax} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+		if *bound, err = strconv.Atoi(value); err != nil || *bound < 0 {
+			return badRequest(ErrorBadAgeRange)
+		}
+	}
+	if req.ageMax != 0 && req.ageMin > req.ageMax {
+		return badRequest(ErrorBadAgeRange)
+	}
+	req.gender = strings.ToLower(params.Get("gender"))
+	switch req.gender {
+	case "", "male", "female":
+	default:
+		return badRequest(ErrorBadGender)
+	}
+	return nil
+}
+
+// searchBy returns users with Name or About matching query, all of them
+// if query is empty, and applies q expression, age and gender filters. Result is a new
+// slice, so it may be sorted.
+func searchBy(req *request, users []User) []User {
+	result := make([]User, 0, len(users))
+	for i := range users {
+		if matches(req, &users[i]) {
+			result = append(result, users[i])
+		}
+	}
+	return result
+}
+
+// matches reports whether the user matches the query and filters
+func matches(req *request, user *User) bool {
+	switch {
+	case !matchQuery(req, user):
+	case req.expr != nil && !req.expr.eval(user):
+	case user.Age < req.ageMin:
+	case req.ageMax != 0 && user.Age > req.ageMax:
+	case req.gender != "" && user.Gender != req.gender:
+	default:
+		return true
+	}
+	return false
+}
+
+// userLess returns the order of users, ties are ordered by id,
+// so the order is total and cursors are unambiguous
+func userLess(orderBy int, orderField string) func(a, b *User) bool {
+	var less func(a, b *User) bool
+	switch {
+	case orderBy == orderAsIs:
+		return func(a, b *User) bool { return a.pos < b.pos }
+	case orderField == "id":
+		less = func(a, b *User) bool { return a.Id < b.Id }
+	case orderField == "age":
+		less = func(a, b *User) bool { return a.Age < b.Age || a.Age == b.Age && a.Id < b.Id }
+	default:
+		less = func(a, b *User) bool { return a.Name < b.Name || a.Name == b.Name && a.Id < b.Id }
+	}
+	if orderBy == orderDesc {
+		return func(a, b *User) bool { return less(b, a) }
+	}
+	return less
+}
+
+func sortUsers(less func(a, b *User) bool, users []User) {
+	sort.Slice(users, func(i, j int) bool {
+		return less(&users[i], &users[j])
+	})
+}
+
+// skip is the number of users skipped before the page, offset is
+// ignored with cursor
+func (req *request) skip() int {
+	if req.after != nil {
+		return 0
+	}
+	return req.offset
+}
+
+func skipUsers(skip int, users []User) []User {
+	if skip >= len(users) {
+		return users[len(users):]
+	}
+	return users[skip:]
+}
+
+func limitUsers(limit int, users []User) []User {
+	if limit == noLimit || limit >= len(users) {
+		return users
+	}
+	return users[:limit]
+}
+
+// authorized checks the token has the scope and writes the error if not
+func (srv *SearchServer) authorized(w http.ResponseWriter, r *http.Request, scope Scope) bool {
+	err := srv.Authorize(r.Header.Get("AccessToken"), scope)
+	if err != nil {
+		authErr := err.(*AuthError)
+		writeJSON(w, authErr.Status, ErrorResponse{authErr.Reason})
+		return false
+	}
+	return true
+}
+
+// AuthError is a failed authorization, Status is 401 or 403
+type AuthError struct {
+	Status int
+	// Reason is one of ErrorBadToken, ErrorTokenExpired and ErrorForbidden
+	Reason string
+}
+
+func (e *AuthError) Error() string {
+	return e.Reason
+}
+
+// Authorize returns *AuthError if the token doesn't allow the scope,
+// other APIs of the server use it
+func (srv *SearchServer) Authorize(token string, scope Scope) error {
+	if srv.Tokens == nil {
+		return nil
+	}
+	if status, reason := srv.Tokens.check(token, scope); status != 0 {
+		return &AuthError{status, reason}
+	}
+	return nil
+}
+
+// IsBadRequest reports whether the error of Find is caused by params,
+// Error of it is the ErrorResponse value
+func IsBadRequest(err error) bool {
+	_, ok := err.(badRequest)
+	return ok
+}
+
+// Find searches users with params of the search request, cursor is the
+// one of the last user. Other APIs of the server use it.
+func (srv *SearchServer) Find(params url.Values) (users []User, cursor string, err error) {
+	req, err := parseRequest(params, false)
+	if err != nil {
+		return nil, "", err
+	}
+	if users, err = srv.find(req); err != nil || len(users) == 0 {
+		return users, "", err
+	}
+	return users, newCursor(req, &users[len(users)-1]), nil
+}
+
+// find returns the page of users of the request
+func (srv *SearchServer) find(req *request) ([]User, error) {
+	users, err := srv.search(req)
+	if err != nil {
+		return nil, err
+	}
+	less := userLess(req.orderBy, req.orderField)
+	sortUsers(less, users)
+	if req.after != nil {
+		users = seek(users, req.after, less)
+	}
+	return limitUsers(req.limit, skipUsers(req.skip(), users)), nil
+}
+
+// each passes users of find one by one, users of streamSource aren't
+// kept in memory
+func (srv *SearchServer) each(req *request, fn func(u *User) error) error {
+	streamer, ok := srv.Source.(streamSource)
+	if !ok {
+		users, err := srv.find(req)
+		for i := 0; err == nil && i < len(users); i++ {
+			err = fn(&users[i])
+		}
+		return err
+	}
+	skip, limit := req.skip(), req.limit
+	err := streamer.each(req, func(u *User) error {
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		if limit == 0 {
+			return errEnough
+		}
+		if limit != noLimit {
+			limit--
+		}
+		return fn(u)
+	})
+	if err == errEnough {
+		return nil
+	}
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (srv *SearchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	r = traceRequest(w, r)
+	if acceptsGzip(r) {
+		gw := newGzipWriter(w)
+		defer gw.Close()
+		w = gw
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	handler := "search"
+	switch r.URL.Path {
+	case "/healthz":
+		handler = "healthz"
+		srv.serveHealth(rec)
+	case "/metrics":
+		handler = "metrics"
+		if srv.authorized(rec, r, ScopeAdmin) {
+			srv.serveMetrics(rec)
+		}
+	case "/stats":
+		handler = "stats"
+		if srv.authorized(rec, r, ScopeAdmin) {
+			srv.serveStats(rec)
+		}
+	case "/batch":
+		handler = "batch"
+		if srv.authorized(rec, r, ScopeRead) {
+			srv.serveBatch(rec, r)
+		}
+	default:
+		if r.Method != http.MethodGet && strings.HasPrefix(r.URL.Path, "/users") {
+			handler = "users"
+			if srv.authorized(rec, r, ScopeAdmin) {
+				srv.serveUsers(rec, r)
+			}
+			break
+		}
+		if srv.authorized(rec, r, ScopeRead) {
+			srv.serveSearch(rec, r)
+		}
+	}
+	srv.metrics.observe(handler, rec.status, time.Since(start))
+}
+
+func (srv *SearchServer) serveSearch(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	req, err := parseRequest(r.Form, wantsStream(r))
+	if err != nil {
+		srv.metrics.queryError(err.Error())
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+		return
+	}
+	if req.envelope && !req.stream {
+		srv.servePage(w, r, req)
+		return
+	}
+	if req.stream {
+		writeStream(w, func(fn func(u *User) error) error {
+			return srv.each(req, fn)
+		}, req.fields)
+		return
+	}
+	users, err := srv.find(req)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if srv.notModified(w, r) {
+		return
+	}
+	setCursor(w, req, users)
+	writeJSON(w, http.StatusOK, req.fields.projectUsers(users))
+}
+
+// search is done by the source or with its index if it can
+func (srv *SearchServer) search(req *request) ([]User, error) {
+	if searcher, ok := srv.Source.(searchSource); ok {
+		return searcher.search(req)
+	}
+	if indexed, ok := srv.Source.(IndexedSource); ok {
+		idx, err := indexed.Index()
+		if err != nil {
+			return nil, err
+		}
+		srv.metrics.setDatasetUsers(len(idx.users))
+		return idx.search(req), nil
+	}
+	users, err := srv.Source.Users()
+	if err != nil {
+		return nil, err
+	}
+	srv.metrics.setDatasetUsers(len(users))
+	return searchBy(req, users), nil
+}
+
+// serveStats reports CacheStats of the source if it's cached
+func (srv *SearchServer) serveStats(w http.ResponseWriter) {
+	cached, ok := srv.Source.(*CachedSource)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, cached.Stats())
+}
@@ -0,0 +1,171 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func search(t *testing.T, srv http.Handler, token, query string) (int, []User, ErrorResponse) {
+	r := httptest.NewRequest("GET", "/?"+query, nil)
+	r.Header.Set("AccessToken", token)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	var users []User
+	var errResp ErrorResponse
+	switch w.Code {
+	case http.StatusOK:
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatal(err)
+		}
+	case http.StatusBadRequest:
+		if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, users, errResp
+}
+
+func TestSearchServerOrder(t *testing.T) {
+	srv := New("../dataset.xml")
+	code, users, _ := search(t, srv, "", "limit=100&order_field=&order_by=1")
+	if code != http.StatusOK || len(users) != 35 {
+		t.Fatalf("expected all 35 users, got %d: %d users", code, len(users))
+	}
+	for i := 1; i < len(users); i++ {
+		if users[i-1].Name > users[i].Name {
+			t.Fatalf("users are not sorted by name: %q before %q", users[i-1].Name, users[i].Name)
+		}
+	}
+
+	_, users, _ = search(t, srv, "", "limit=3&order_field=age&order_by=-1&query=W")
+	if len(users) != 3 || users[0].Age < users[1].Age || users[1].Age < users[2].Age {
+		t.Errorf("expected 3 users by age descending, got %+v", users)
+	}
+}
+
+func TestSearchServerOffset(t *testing.T) {
+	srv := New("../dataset.xml")
+	_, all, _ := search(t, srv, "", "limit=10&order_field=id&order_by=1")
+	_, users, _ := search(t, srv, "", "limit=3&offset=2&order_field=id&order_by=1")
+	if len(users) != 3 || users[0].Id != all[2].Id || users[2].Id != all[4].Id {
+		t.Errorf("expected users from %+v, got %+v", all[2], users)
+	}
+	if _, users, _ = search(t, srv, "", "limit=3&offset=100&order_by=0"); len(users) != 0 {
+		t.Errorf("expected no users past the end, got %+v", users)
+	}
+
+	r := httptest.NewRequest("GET", "/?order_field=id&order_by=1&offset=33", nil)
+	r.Header.Set("Accept", NDJSONType)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	var last User
+	dec := json.NewDecoder(w.Body)
+	count := 0
+	for ; dec.More(); count++ {
+		if err := dec.Decode(&last); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count != 2 || last.Id != 34 {
+		t.Errorf("expected 2 streamed users up to 34, got %d up to %d", count, last.Id)
+	}
+}
+
+func TestSearchServerErrors(t *testing.T) {
+	srv := New("../dataset.xml", "token")
+	if code, _, _ := search(t, srv, "bad", "limit=1&order_by=0"); code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", code)
+	}
+	cases := map[string]string{
+		"limit=1&order_by=0&order_field=about": ErrorBadOrderField,
+		"limit=x&order_by=0":                   ErrorBadLimit,
+		"limit=1&order_by=2":                   ErrorBadOrderBy,
+		"limit=1&order_by=0&offset=-1":         ErrorBadOffset,
+	}
+	for query, expected := range cases {
+		code, _, errResp := search(t, srv, "token", query)
+		if code != http.StatusBadRequest || errResp.Error != expected {
+			t.Errorf("%s: expected 400 %s, got %d %s", query, expected, code, errResp.Error)
+		}
+	}
+
+	srv = New("missing.xml")
+	if code, _, _ := search(t, srv, "", "limit=1&order_by=0"); code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for missing dataset, got %d", code)
+	}
+}
+
+func TestSearchServerCursor(t *testing.T) {
+	srv := New("../dataset.xml")
+	r := httptest.NewRequest("GET", "/?limit=5&order_field=name&order_by=1", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	var first []User
+	json.Unmarshal(w.Body.Bytes(), &first)
+	cursor := w.Header().Get(CursorHeader)
+	if cursor == "" {
+		t.Fatal("expected cursor header")
+	}
+	// the next page starts with the last user inclusively
+	code, next, _ := search(t, srv, "", "limit=2&order_field=name&order_by=1&cursor="+cursor)
+	if code != http.StatusOK || len(next) != 2 || next[0].Id != first[4].Id {
+		t.Errorf("expected page from %+v, got %d %+v", first[4], code, next)
+	}
+	code, _, errResp := search(t, srv, "", "limit=2&order_field=name&order_by=1&cursor=!!")
+	if code != http.StatusBadRequest || errResp.Error != ErrorBadCursor {
+		t.Errorf("expected bad cursor, got %d %s", code, errResp.Error)
+	}
+}
+
+func TestSearchServerFilters(t *testing.T) {
+	srv := New("../dataset.xml")
+	code, users, _ := search(t, srv, "", "limit=100&order_by=0&age_min=21&age_max=30&gender=male")
+	if code != http.StatusOK || len(users) == 0 {
+		t.Fatalf("expected users, got %d", code)
+	}
+	for _, u := range users {
+		if u.Age < 21 || u.Age > 30 || u.Gender != "male" {
+			t.Errorf("user %+v doesn't match filters", u)
+		}
+	}
+	cases := map[string]string{
+		"age_min=x":             ErrorBadAgeRange,
+		"age_min=31&age_max=30": ErrorBadAgeRange,
+		"gender=robot":          ErrorBadGender,
+	}
+	for query, expected := range cases {
+		code, _, errResp := search(t, srv, "", "limit=1&order_by=0&"+query)
+		if code != http.StatusBadRequest || errResp.Error != expected {
+			t.Errorf("%s: expected 400 %s, got %d %s", query, expected, code, errResp.Error)
+		}
+	}
+}
+
+func TestSearchServerStream(t *testing.T) {
+	srv := New("../dataset.xml")
+	for query, expected := range map[string]int{"order_by=0": 35, "order_by=0&limit=3": 3} {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("Accept", NDJSONType)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); w.Code != http.StatusOK || ct != NDJSONType {
+			t.Fatalf("%s: expected 200 %s, got %d %s", query, NDJSONType, w.Code, ct)
+		}
+		dec := json.NewDecoder(w.Body)
+		count := 0
+		for ; dec.More(); count++ {
+			if err := dec.Decode(&User{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count != expected {
+			t.Errorf("%s: expected %d users, got %d", query, expected, count)
+		}
+	}
+	// limit is required without stream
+	if code, _, errResp := search(t, srv, "", "order_by=0"); code != http.StatusBadRequest || errResp.Error != ErrorBadLimit {
+		t.Errorf("expected bad limit, got %d %s", code, errResp.Error)
+	}
+}
@@ -0,0 +1,246 @@
+package searchserver
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DataSource loads users of the dataset in the dataset order,
+// returned users may be shared and must not be modified
+type DataSource interface {
+	Users() ([]User, error)
+}
+
+// Dataset formats
+const (
+	FormatXML       = "xml"
+	FormatJSONLines = "jsonl"
+	FormatCSV       = "csv"
+)
+
+// NewSource returns file DataSource of the format,
+// the format is detected by file extension if it's empty
+func NewSource(format, path string) (DataSource, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if format == "ndjson" {
+			format = FormatJSONLines
+		}
+	}
+	switch format {
+	case FormatXML:
+		return XMLSource{path}, nil
+	case FormatJSONLines:
+		return JSONLinesSource{path}, nil
+	case FormatCSV:
+		return CSVSource{path}, nil
+	}
+	return nil, fmt.Errorf("unknown dataset format %q of %s", format, path)
+}
+
+// fileWriter is a file source which can write users back
+type fileWriter interface {
+	writeUsers(path string, users []User) error
+}
+
+// writeFile replaces the file at once with what write writes
+func writeFile(path string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	buf := bufio.NewWriter(tmp)
+	if err = write(buf); err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// errSource fails every load, it keeps the error of NewSource
+type errSource struct {
+	err error
+}
+
+func (s errSource) Users() ([]User, error) {
+	return nil, s.err
+}
+
+// completeUsers sets fields which are not stored in the dataset
+func completeUsers(users []User) []User {
+	for i := range users {
+		users[i].Name = users[i].FName + " " + users[i].LName
+		users[i].pos = i
+	}
+	return users
+}
+
+// XMLSource reads <root><row>...</row></root> file
+type XMLSource struct {
+	Path string
+}
+
+func (s XMLSource) Users() ([]User, error) {
+	file, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	users := dataset{}
+	if err = xml.Unmarshal(file, &users); err != nil {
+		return nil, err
+	}
+	return completeUsers(users.Data), nil
+}
+
+// xmlDataset is dataset with the root element of the file
+type xmlDataset struct {
+	XMLName xml.Name `xml:"root"`
+	Data    []User   `xml:"row"`
+}
+
+func (s XMLSource) writeUsers(path string, users []User) error {
+	return writeFile(path, func(w io.Writer) error {
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(xmlDataset{Data: users}); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	})
+}
+
+// JSONLinesSource reads a JSON object per line with the same fields
+// as XML rows
+type JSONLinesSource struct {
+	Path string
+}
+
+type jsonUser struct {
+	Id     int    `json:"id"`
+	Age    int    `json:"age"`
+	FName  string `json:"first_name"`
+	LName  string `json:"last_name"`
+	About  string `json:"about"`
+	Gender string `json:"gender"`
+}
+
+func (s JSONLinesSource) Users() ([]User, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var users []User
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		u := jsonUser{}
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", s.Path, line, err)
+		}
+		users = append(users, User{Id: u.Id, Age: u.Age, FName: u.FName, LName: u.LName, About: u.About, Gender: u.Gender})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return completeUsers(users), nil
+}
+
+func (s JSONLinesSource) writeUsers(path string, users []User) error {
+	return writeFile(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, u := range users {
+			err := enc.Encode(jsonUser{Id: u.Id, Age: u.Age, FName: u.FName, LName: u.LName, About: u.About, Gender: u.Gender})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CSVSource reads a file with header of XML row field names,
+// columns may go in any order
+type CSVSource struct {
+	Path string
+}
+
+func (s CSVSource) Users() ([]User, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := csv.NewReader(file)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: header: %s", s.Path, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"id", "first_name", "last_name", "age", "about", "gender"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%s: no %s column", s.Path, name)
+		}
+	}
+	var users []User
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", s.Path, err)
+		}
+		u := User{
+			FName:  record[columns["first_name"]],
+			LName:  record[columns["last_name"]],
+			About:  record[columns["about"]],
+			Gender: record[columns["gender"]],
+		}
+		if u.Id, err = strconv.Atoi(record[columns["id"]]); err != nil {
+			return nil, fmt.Errorf("%s: bad id: %s", s.Path, err)
+		}
+		if u.Age, err = strconv.Atoi(record[columns["age"]]); err != nil {
+			return nil, fmt.Errorf("%s: bad age: %s", s.Path, err)
+		}
+		users = append(users, u)
+	}
+	return completeUsers(users), nil
+}
+
+func (s CSVSource) writeUsers(path string, users []User) error {
+	return writeFile(path, func(w io.Writer) error {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "first_name", "last_name", "age", "about", "gender"})
+		for _, u := range users {
+			cw.Write([]string{strconv.Itoa(u.Id), u.FName, u.LName, strconv.Itoa(u.Age), u.About, u.Gender})
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
@@ -0,0 +1,60 @@
+package searchserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "searchserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"users.jsonl": `{"id":1,"first_name":"Ann","last_name":"Lee","age":30,"about":"likes go","gender":"female"}
+
+{"id":2,"first_name":"Bob","last_name":"Ray","age":40,"about":"hates \"xml\"","gender":"male"}
+`,
+		"users.csv": `gender,id,first_name,last_name,age,about
+female,1,Ann,Lee,30,likes go
+male,2,Bob,Ray,40,"hates ""xml"""
+`,
+		"users.xml": `<root>
+<row><id>1</id><first_name>Ann</first_name><last_name>Lee</last_name><age>30</age><about>likes go</about><gender>female</gender></row>
+<row><id>2</id><first_name>Bob</first_name><last_name>Ray</last_name><age>40</age><about>hates "xml"</about><gender>male</gender></row>
+</root>`,
+	}
+	expected := []User{
+		{Id: 1, Age: 30, FName: "Ann", LName: "Lee", Name: "Ann Lee", About: "likes go", Gender: "female", pos: 0},
+		{Id: 2, Age: 40, FName: "Bob", LName: "Ray", Name: "Bob Ray", About: `hates "xml"`, Gender: "male", pos: 1},
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		src, err := NewSource("", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, err := src.Users()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !reflect.DeepEqual(users, expected) {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, users)
+		}
+	}
+
+	if _, err := NewSource("", "users.yaml"); err == nil {
+		t.Error("expected unknown format error")
+	}
+	if code, _, _ := search(t, New("users.yaml"), "", "limit=1&order_by=0"); code != 500 {
+		t.Errorf("expected 500 for unknown format, got %d", code)
+	}
+}
@@ -0,0 +1,318 @@
+package searchserver
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// searchSource is a DataSource which searches users itself. Result has to
+// be the same as searchBy over Users after sort, seek and limit of
+// ServeHTTP, so those may be done by the source too. Offset is skipped by
+// ServeHTTP, so the limit of the source counts the skipped users too.
+type searchSource interface {
+	DataSource
+	search(req *request) ([]User, error)
+}
+
+// streamSource is a searchSource which passes users of search one by one,
+// so streams don't keep all of them in memory
+type streamSource interface {
+	searchSource
+	each(req *request, fn func(u *User) error) error
+}
+
+// sqlDialect has expressions which differ between databases
+type sqlDialect struct {
+	// concat joins expressions as strings
+	concat func(exprs ...string) string
+	// binary makes expression compared byte by byte
+	binary func(expr string) string
+	// duplicate reports whether err is a duplicate key of the insert
+	duplicate func(err error) bool
+}
+
+var sqlDialects = map[string]sqlDialect{
+	"mysql": {
+		concat: func(exprs ...string) string { return "CONCAT(" + strings.Join(exprs, ", ") + ")" },
+		binary: func(expr string) string { return "BINARY " + expr },
+		duplicate: func(err error) bool {
+			var mysqlErr *mysql.MySQLError
+			return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+		},
+	},
+	"sqlite": {
+		concat: func(exprs ...string) string { return strings.Join(exprs, " || ") },
+		binary: func(expr string) string { return expr + " COLLATE BINARY" },
+		duplicate: func(err error) bool {
+			return strings.Contains(err.Error(), "UNIQUE constraint failed")
+		},
+	},
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLSource searches users table with columns id, first_name, last_name,
+// age, about and gender. Filters, order and limit are done by the database,
+// the dataset order is the id order.
+type SQLSource struct {
+	DB      *sql.DB
+	table   string
+	dialect sqlDialect
+}
+
+// NewSQLSource returns source of the table, driver is the name of the
+// database/sql driver: mysql, sqlite or sqlite3
+func NewSQLSource(db *sql.DB, driver, table string) (*SQLSource, error) {
+	if driver == "sqlite3" {
+		driver = "sqlite"
+	}
+	dialect, ok := sqlDialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sql driver %q", driver)
+	}
+	if !sqlIdentifier.MatchString(table) {
+		return nil, fmt.Errorf("bad table name %q", table)
+	}
+	return &SQLSource{DB: db, table: table, dialect: dialect}, nil
+}
+
+func (s *SQLSource) Users() ([]User, error) {
+	return s.query(s.selectUsers() + " ORDER BY id")
+}
+
+func (s *SQLSource) Ping() error {
+	return s.DB.Ping()
+}
+
+func (s *SQLSource) selectUsers() string {
+	return "SELECT id, first_name, last_name, age, about, gender FROM " + s.table
+}
+
+func (s *SQLSource) query(query string, args ...interface{}) ([]User, error) {
+	var users []User
+	err := s.scan(query, args, func(u *User) error {
+		users = append(users, *u)
+		return nil
+	})
+	return users, err
+}
+
+// scan calls fn for every user of the query until it returns an error
+func (s *SQLSource) scan(query string, args []interface{}, fn func(u *User) error) error {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u := User{}
+		if err := rows.Scan(&u.Id, &u.FName, &u.LName, &u.Age, &u.About, &u.Gender); err != nil {
+			return err
+		}
+		u.Name = u.FName + " " + u.LName
+		u.pos = u.Id
+		if err := fn(&u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLSource) search(req *request) ([]User, error) {
+	var users []User
+	err := s.each(req, func(u *User) error {
+		users = append(users, *u)
+		return nil
+	})
+	return users, err
+}
+
+// each passes users of search one by one as rows are read
+func (s *SQLSource) each(req *request, fn func(u *User) error) error {
+	query, args, exact := s.buildQuery(req)
+	return s.scan(query, args, func(u *User) error {
+		if !exact && !matches(req, u) {
+			return nil
+		}
+		return fn(u)
+	})
+}
+
+// buildQuery translates the request to SQL. Query matching is not exact
+// as LIKE and LOWER work differently in databases, so it only narrows
+// users, they are checked with searchBy and limit isn't applied then.
+// Expression of q is not translated, it's checked with searchBy too.
+func (s *SQLSource) buildQuery(req *request) (query string, args []interface{}, exact bool) {
+	var where []string
+	name := s.dialect.concat("first_name", "' '", "last_name")
+	if patterns := likePatterns(req); len(patterns) > 0 {
+		for _, pattern := range patterns {
+			where = append(where, "(LOWER("+name+") LIKE ? ESCAPE '!' OR LOWER(about) LIKE ? ESCAPE '!')")
+			args = append(args, pattern, pattern)
+		}
+	}
+	if req.ageMin != 0 {
+		where = append(where, "age >= ?")
+		args = append(args, req.ageMin)
+	}
+	if req.ageMax != 0 {
+		where = append(where, "age <= ?")
+		args = append(args, req.ageMax)
+	}
+	if req.gender != "" {
+		where = append(where, "gender = ?")
+		args = append(args, req.gender)
+	}
+
+	column := "id"
+	switch {
+	case req.orderBy == orderAsIs:
+	case req.orderField == "age":
+		column = "age"
+	case req.orderField == "name":
+		column = s.dialect.binary(name)
+	}
+	direction := "ASC"
+	if req.orderBy == orderDesc {
+		direction = "DESC"
+	}
+	if req.after != nil {
+		// rows from the anchor inclusively, ties are ordered by id
+		op := map[string]string{"ASC": ">", "DESC": "<"}[direction]
+		var value interface{} = req.after.Id
+		switch column {
+		case "age":
+			value = req.after.Age
+		case "id":
+			// pos is id for this source, it's the anchor of as is order
+			value = req.after.pos
+		default:
+			value = req.after.Name
+		}
+		if column == "id" {
+			where = append(where, "id "+op+"= ?")
+			args = append(args, value)
+		} else {
+			where = append(where, "("+column+" "+op+" ? OR "+column+" = ? AND id "+op+"= ?)")
+			args = append(args, value, value, req.after.Id)
+		}
+	}
+
+	query = s.selectUsers()
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + column + " " + direction
+	if column != "id" {
+		query += ", id " + direction
+	}
+	exact = req.query == "" && req.expr == nil
+	if exact && req.limit != noLimit {
+		query += " LIMIT ?"
+		args = append(args, req.skip()+req.limit)
+	}
+	return query, args, exact
+}
+
+// likePatterns are lower cased LIKE patterns every matching user has,
+// none if the query may be lower cased differently by the database
+func likePatterns(req *request) []string {
+	query := strings.ToLower(req.query)
+	for _, r := range query {
+		if r >= 0x80 {
+			return nil
+		}
+	}
+	words := []string{query}
+	if req.match != matchSubstring {
+		words = splitWords(query)
+	}
+	var patterns []string
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		escaped := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(word)
+		patterns = append(patterns, "%"+escaped+"%")
+	}
+	return patterns
+}
+
+// createAttempts is the number of ids Create tries, concurrent creates
+// may take the same next id and all but one of them fail then
+const createAttempts = 5
+
+// Create, Update and Delete change the table in transactions, so updates
+// of missing users are found. Create takes the next id and tries again
+// with a new one if the insert is a duplicate key, so the id column must
+// be the primary or a unique key.
+func (s *SQLSource) Create(u User) (User, error) {
+	var err error
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		err = s.inTx(func(tx *sql.Tx) error {
+			row := tx.QueryRow("SELECT COALESCE(MAX(id) + 1, 0) FROM " + s.table)
+			if err := row.Scan(&u.Id); err != nil {
+				return err
+			}
+			_, err := tx.Exec("INSERT INTO "+s.table+" (id, first_name, last_name, age, about, gender) VALUES (?, ?, ?, ?, ?, ?)",
+				u.Id, u.FName, u.LName, u.Age, u.About, u.Gender)
+			return err
+		})
+		if err == nil || !s.dialect.duplicate(err) {
+			break
+		}
+	}
+	u.pos = u.Id
+	return u, err
+}
+
+func (s *SQLSource) Update(u User) (User, error) {
+	err := s.inTx(func(tx *sql.Tx) error {
+		if err := s.exists(tx, u.Id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE "+s.table+" SET first_name = ?, last_name = ?, age = ?, about = ?, gender = ? WHERE id = ?",
+			u.FName, u.LName, u.Age, u.About, u.Gender, u.Id)
+		return err
+	})
+	u.pos = u.Id
+	return u, err
+}
+
+func (s *SQLSource) Delete(id int) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if err := s.exists(tx, id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM "+s.table+" WHERE id = ?", id)
+		return err
+	})
+}
+
+// exists is ErrNotFound if there is no user with the id, rows affected
+// can't tell it as MySQL doesn't count rows updated with the same values
+func (s *SQLSource) exists(tx *sql.Tx, id int) error {
+	var found int
+	err := tx.QueryRow("SELECT COUNT(*) FROM "+s.table+" WHERE id = ?", id).Scan(&found)
+	if err == nil && found == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+func (s *SQLSource) inTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
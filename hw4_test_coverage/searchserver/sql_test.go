@@ -0,0 +1,312 @@
+package searchserver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// fakeDB is a database/sql driver which returns rows of users for any
+// query and records the last query and the number of read rows. It also
+// answers the next id query of Create and inserts users, the first stale
+// next ids are the taken ones.
+type fakeDB struct {
+	mu    sync.Mutex
+	users []User
+	query string
+	args  []driver.Value
+	read  int
+	stale int
+}
+
+type fakeConn struct{ db *fakeDB }
+
+type fakeTx struct{}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+type fakeRows struct {
+	db    *fakeDB
+	users []User
+}
+
+// fakeID is the row of the next id query
+type fakeID struct{ id int }
+
+func (db *fakeDB) Open(name string) (driver.Conn, error)     { return fakeConn{db}, nil }
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+func (tx fakeTx) Commit() error                              { return nil }
+func (tx fakeTx) Rollback() error                            { return nil }
+func (s fakeStmt) Close() error                              { return nil }
+func (s fakeStmt) NumInput() int                             { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if !strings.HasPrefix(s.query, "INSERT") {
+		return nil, driver.ErrSkip
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	u := User{Id: int(args[0].(int64)), FName: args[1].(string), LName: args[2].(string),
+		Age: int(args[3].(int64)), About: args[4].(string), Gender: args[5].(string)}
+	for _, taken := range s.db.users {
+		if taken.Id == u.Id {
+			return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+		}
+	}
+	s.db.users = append(s.db.users, u)
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if strings.Contains(s.query, "MAX(id)") {
+		next := len(s.db.users)
+		if s.db.stale > 0 {
+			s.db.stale--
+			next = 0
+		}
+		return &fakeID{next}, nil
+	}
+	s.db.query, s.db.args = s.query, args
+	return &fakeRows{s.db, s.db.users}, nil
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id", "first_name", "last_name", "age", "about", "gender"}
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.users) == 0 {
+		return io.EOF
+	}
+	u := r.users[0]
+	r.users = r.users[1:]
+	r.db.read++
+	dest[0], dest[1], dest[2], dest[3], dest[4], dest[5] = int64(u.Id), u.FName, u.LName, int64(u.Age), u.About, u.Gender
+	return nil
+}
+
+func (r *fakeID) Columns() []string { return []string{"id"} }
+func (r *fakeID) Close() error      { return nil }
+
+func (r *fakeID) Next(dest []driver.Value) error {
+	if r.id < 0 {
+		return io.EOF
+	}
+	dest[0], r.id = int64(r.id), -1
+	return nil
+}
+
+func init() {
+	sql.Register("fakedb", &fakeDB{})
+}
+
+func newFakeSource(t *testing.T, driver string, users []User) (*SQLSource, *fakeDB) {
+	db, err := sql.Open("fakedb", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := db.Driver().(*fakeDB)
+	fake.users = users
+	src, err := NewSQLSource(db, driver, "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src, fake
+}
+
+func TestSQLSource(t *testing.T) {
+	rows := []User{
+		{Id: 1, FName: "Ann", LName: "Lee", Age: 30, About: "likes go", Gender: "female"},
+		{Id: 2, FName: "Bob", LName: "Ray", Age: 40, About: "hates xml", Gender: "male"},
+	}
+	src, fake := newFakeSource(t, "mysql", rows)
+	users, err := src.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[1].Name != "Bob Ray" || users[1].pos != 2 {
+		t.Errorf("unexpected users %+v", users)
+	}
+
+	// query is checked after the database, limit is not applied then
+	srv := NewWithSource(src)
+	code, users, _ := search(t, srv, "", "limit=1&order_by=1&order_field=age&query=go&gender=female")
+	if code != http.StatusOK || len(users) != 1 || users[0].Id != 1 {
+		t.Errorf("expected Ann, got %d %+v", code, users)
+	}
+	expected := "SELECT id, first_name, last_name, age, about, gender FROM users " +
+		"WHERE (LOWER(CONCAT(first_name, ' ', last_name)) LIKE ? ESCAPE '!' OR LOWER(about) LIKE ? ESCAPE '!') " +
+		"AND gender = ? ORDER BY age ASC, id ASC"
+	if fake.query != expected {
+		t.Errorf("expected query\n%s\ngot\n%s", expected, fake.query)
+	}
+	if args := []driver.Value{"%go%", "%go%", "female"}; !reflect.DeepEqual(fake.args, args) {
+		t.Errorf("expected args %v, got %v", args, fake.args)
+	}
+
+	if _, err := NewSQLSource(nil, "oracle", "users"); err == nil {
+		t.Error("expected unsupported driver error")
+	}
+	if _, err := NewSQLSource(nil, "mysql", "users; drop table users"); err == nil {
+		t.Error("expected bad table error")
+	}
+}
+
+func TestSQLSourceStream(t *testing.T) {
+	var rows []User
+	for id := 1; id <= 5; id++ {
+		rows = append(rows, User{Id: id, FName: "User", LName: strconv.Itoa(id), About: "likes go"})
+	}
+	src, fake := newFakeSource(t, "mysql", rows)
+	srv := NewWithSource(src)
+	for query, expected := range map[string][]int{
+		"order_field=id&order_by=1&offset=1&limit=2": {2, 3},
+		"order_field=id&order_by=1&query=go&limit=1": {1},
+		"order_field=id&order_by=1&offset=4":         {5},
+		"order_field=id&order_by=1&query=xml":        nil,
+	} {
+		fake.read = 0
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r.Header.Set("Accept", NDJSONType)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", query, w.Code)
+		}
+		var ids []int
+		for dec := json.NewDecoder(w.Body); dec.More(); {
+			var u User
+			if err := dec.Decode(&u); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, u.Id)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("%s: expected %v, got %v", query, expected, ids)
+		}
+		// rows after the limit aren't read
+		if len(expected) > 0 && fake.read > expected[len(expected)-1]+1 {
+			t.Errorf("%s: expected rows to be read up to the limit, %d are read", query, fake.read)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/?order_by=0", nil)
+	r.Header.Set("Accept", NDJSONType)
+	w := httptest.NewRecorder()
+	New("missing.xml").ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 for broken source, got %d", w.Code)
+	}
+}
+
+func TestSQLSourceCreate(t *testing.T) {
+	src, fake := newFakeSource(t, "mysql", nil)
+	var wg sync.WaitGroup
+	ids := make(chan int, 20)
+	for i := 0; i < cap(ids); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := src.Create(User{FName: "Ann", LName: "Lee", Age: 30})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids <- u.Id
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	taken := map[int]bool{}
+	for id := range ids {
+		if taken[id] {
+			t.Errorf("id %d is taken twice", id)
+		}
+		taken[id] = true
+	}
+	if len(taken) != cap(ids) || len(fake.users) != cap(ids) {
+		t.Errorf("expected %d users, got %d ids and %d users", cap(ids), len(taken), len(fake.users))
+	}
+
+	// next ids taken by others are tried again, but not forever
+	fake.stale = 2
+	if u, err := src.Create(User{FName: "Bob"}); err != nil || u.Id != cap(ids) {
+		t.Errorf("expected id %d, got %d %v", cap(ids), u.Id, err)
+	}
+	fake.stale = createAttempts
+	if _, err := src.Create(User{FName: "Cid"}); !src.dialect.duplicate(err) {
+		t.Errorf("expected duplicate key error, got %v", err)
+	}
+}
+
+func TestSQLBuildQuery(t *testing.T) {
+	src, _ := newFakeSource(t, "sqlite3", nil)
+	cases := []struct {
+		req   request
+		where string
+		args  []interface{}
+	}{
+		{
+			request{match: matchSubstring, orderBy: orderAsIs, limit: 5},
+			" ORDER BY id ASC LIMIT ?",
+			[]interface{}{5},
+		},
+		{
+			request{match: matchSubstring, orderBy: orderAsIs, limit: 5, offset: 2},
+			" ORDER BY id ASC LIMIT ?",
+			[]interface{}{7},
+		},
+		{
+			request{match: matchSubstring, orderField: "name", orderBy: orderDesc, limit: 3, offset: 4, ageMin: 20, ageMax: 30,
+				after: &User{Id: 7, Name: "Ann Lee"}},
+			" WHERE age >= ? AND age <= ? AND (first_name || ' ' || last_name COLLATE BINARY < ? OR " +
+				"first_name || ' ' || last_name COLLATE BINARY = ? AND id <= ?) " +
+				"ORDER BY first_name || ' ' || last_name COLLATE BINARY DESC, id DESC LIMIT ?",
+			[]interface{}{20, 30, "Ann Lee", "Ann Lee", 7, 3},
+		},
+		{
+			request{query: "50%_Go wolf", match: matchPrefix, orderField: "id", orderBy: orderAsc, after: &User{Id: 9, pos: 9}},
+			" WHERE (LOWER(first_name || ' ' || last_name) LIKE ? ESCAPE '!' OR LOWER(about) LIKE ? ESCAPE '!')" +
+				" AND (LOWER(first_name || ' ' || last_name) LIKE ? ESCAPE '!' OR LOWER(about) LIKE ? ESCAPE '!')" +
+				" AND (LOWER(first_name || ' ' || last_name) LIKE ? ESCAPE '!' OR LOWER(about) LIKE ? ESCAPE '!')" +
+				" AND id >= ? ORDER BY id ASC",
+			[]interface{}{"%50%", "%50%", "%go%", "%go%", "%wolf%", "%wolf%", 9},
+		},
+		{
+			request{query: "Ünal", match: matchSubstring, orderBy: orderAsIs},
+			" ORDER BY id ASC",
+			nil,
+		},
+	}
+	for i, c := range cases {
+		query, args, _ := src.buildQuery(&c.req)
+		if expected := src.selectUsers() + c.where; query != expected {
+			t.Errorf("%d: expected query\n%s\ngot\n%s", i, expected, query)
+		}
+		if !reflect.DeepEqual(args, c.args) {
+			t.Errorf("%d: expected args %v, got %v", i, c.args, args)
+		}
+	}
+	if patterns := likePatterns(&request{query: "5%_!", match: matchSubstring}); patterns[0] != "%5!%!_!!%" {
+		t.Errorf("bad escaping %v", patterns)
+	}
+}
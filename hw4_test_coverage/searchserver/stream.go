@@ -0,0 +1,60 @@
+package searchserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// NDJSONType is the content type of streamed responses,
+// a user per line
+const NDJSONType = "application/x-ndjson"
+
+// streamFlushUsers is the number of users written between flushes
+const streamFlushUsers = 100
+
+func wantsStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), NDJSONType)
+}
+
+// writeStream writes the fields of users passed by each as they are
+// encoded, neither the response nor users of streamSource are buffered.
+// An error before the first user is 500, later ones end the stream.
+func writeStream(w http.ResponseWriter, each func(fn func(u *User) error) error, fields fieldSet) {
+	var buf *bufio.Writer
+	var enc *json.Encoder
+	flusher, _ := w.(http.Flusher)
+	start := func() {
+		w.Header().Set("Content-Type", NDJSONType)
+		w.WriteHeader(http.StatusOK)
+		buf = bufio.NewWriter(w)
+		enc = json.NewEncoder(buf)
+	}
+	count := 0
+	err := each(func(u *User) error {
+		if buf == nil {
+			start()
+		}
+		if err := enc.Encode(fields.project(u)); err != nil {
+			return err
+		}
+		if count++; count%streamFlushUsers == 0 {
+			if err := buf.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if buf == nil {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		start()
+	}
+	buf.Flush()
+}
@@ -0,0 +1,132 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Scope is what a token allows, admin allows everything read does
+type Scope int
+
+const (
+	ScopeRead Scope = iota + 1
+	ScopeAdmin
+)
+
+// Error values of ErrorResponse for 401 and 403 statuses
+const (
+	ErrorBadToken     = "ErrorBadToken"
+	ErrorTokenExpired = "ErrorTokenExpired"
+	ErrorForbidden    = "ErrorForbidden"
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeRead:
+		return "read"
+	case ScopeAdmin:
+		return "admin"
+	}
+	return fmt.Sprintf("Scope(%d)", int(s))
+}
+
+func (s Scope) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Scope) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "read":
+		*s = ScopeRead
+	case "admin":
+		*s = ScopeAdmin
+	default:
+		return fmt.Errorf("unknown scope %q", text)
+	}
+	return nil
+}
+
+// Token is a value of AccessToken header
+type Token struct {
+	Value string `json:"token"`
+	Scope Scope  `json:"scope"`
+	// Expires is when the token stops working, zero is never
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// TokenStore keeps tokens accepted by SearchServer, it's safe to change
+// tokens while the server is running
+type TokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]Token
+	// now is time.Now, tests replace it
+	now func() time.Time
+}
+
+func NewTokenStore(tokens ...Token) *TokenStore {
+	s := &TokenStore{tokens: make(map[string]Token, len(tokens)), now: time.Now}
+	for _, token := range tokens {
+		s.Add(token)
+	}
+	return s
+}
+
+// LoadTokenStore reads a JSON array of tokens:
+//
+//	[{"token": "secret", "scope": "admin", "expires": "2030-01-01T00:00:00Z"}]
+func LoadTokenStore(path string) (*TokenStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for _, token := range tokens {
+		if token.Value == "" || token.Scope == 0 {
+			return nil, fmt.Errorf("%s: token and scope are required", path)
+		}
+	}
+	return NewTokenStore(tokens...), nil
+}
+
+func (s *TokenStore) Add(token Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.Value] = token
+}
+
+func (s *TokenStore) Remove(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, value)
+}
+
+// Len is the number of tokens, expired ones included
+func (s *TokenStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.tokens)
+}
+
+// check returns 401 or 403 status with the error value if the token
+// doesn't allow the scope, 0 if it does
+func (s *TokenStore) check(value string, scope Scope) (int, string) {
+	s.mu.RLock()
+	token, ok := s.tokens[value]
+	s.mu.RUnlock()
+	switch {
+	case !ok:
+		return http.StatusUnauthorized, ErrorBadToken
+	case !token.Expires.IsZero() && !s.now().Before(token.Expires):
+		return http.StatusUnauthorized, ErrorTokenExpired
+	case token.Scope < scope:
+		return http.StatusForbidden, ErrorForbidden
+	}
+	return 0, ""
+}
@@ -0,0 +1,77 @@
+package searchserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenStore(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewTokenStore(
+		Token{Value: "reader", Scope: ScopeRead},
+		Token{Value: "admin", Scope: ScopeAdmin, Expires: now.Add(time.Hour)},
+		Token{Value: "old", Scope: ScopeAdmin, Expires: now},
+	)
+	store.now = func() time.Time { return now }
+	srv := NewWithSource(New("../dataset.xml").Source)
+	srv.Tokens = store
+
+	cases := []struct {
+		path, token string
+		status      int
+		reason      string
+	}{
+		{"/", "reader", http.StatusOK, ""},
+		{"/", "admin", http.StatusOK, ""},
+		{"/", "old", http.StatusUnauthorized, ErrorTokenExpired},
+		{"/", "", http.StatusUnauthorized, ErrorBadToken},
+		{"/stats", "reader", http.StatusForbidden, ErrorForbidden},
+		{"/stats", "admin", http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path+"?limit=1&order_by=0", nil)
+		r.Header.Set("AccessToken", c.token)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s %s: expected %d, got %d", c.path, c.token, c.status, w.Code)
+		}
+		if c.reason != "" && w.Body.String() != `{"Error":"`+c.reason+`"}` {
+			t.Errorf("%s %s: expected %s, got %s", c.path, c.token, c.reason, w.Body)
+		}
+	}
+
+	store.Remove("reader")
+	if status, _ := store.check("reader", ScopeRead); status != http.StatusUnauthorized {
+		t.Errorf("expected removed token to be rejected, got %d", status)
+	}
+}
+
+func TestLoadTokenStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "searchserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens.json")
+	ioutil.WriteFile(path, []byte(`[{"token":"a","scope":"admin","expires":"2030-01-01T00:00:00Z"},{"token":"r","scope":"read"}]`), 0644)
+	store, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 2 || store.tokens["a"].Scope != ScopeAdmin || store.tokens["a"].Expires.Year() != 2030 {
+		t.Errorf("unexpected tokens %+v", store.tokens)
+	}
+	for _, content := range []string{`[{"token":"a","scope":"root"}]`, `[{"token":"a"}]`, `{`} {
+		ioutil.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadTokenStore(path); err == nil {
+			t.Errorf("%s: expected error", content)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package searchserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// TraceHeader carries the trace ID of a request, the response has the
+// same one
+const TraceHeader = "X-Request-Id"
+
+// maxTraceID is the longest trace ID accepted from clients
+const maxTraceID = 64
+
+type traceKey struct{}
+
+// WithTraceID returns the context carrying the trace ID
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceKey{}, id)
+}
+
+// TraceID is the trace ID of the context, handlers wrapped by
+// SearchServer or LogRequests get one from the request context, so it may
+// be passed on to other services. Empty if there is none.
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceKey{}).(string)
+	return id
+}
+
+// traceRequest sets the trace ID of the request to its context and the
+// response. It's the one of the context if there is any, then the one of
+// TraceHeader, a new one is made for missing or malformed ones.
+func traceRequest(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := TraceID(r.Context())
+	if id == "" {
+		id = r.Header.Get(TraceHeader)
+		if !validTraceID(id) {
+			id = newTraceID()
+		}
+		r = r.WithContext(WithTraceID(r.Context(), id))
+	}
+	w.Header().Set(TraceHeader, id)
+	return r
+}
+
+// validTraceID allows IDs safe to be logged
+func validTraceID(id string) bool {
+	if id == "" || len(id) > maxTraceID {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newTraceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,59 @@
+package searchserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTraceRequests(t *testing.T) {
+	var entries []LogEntry
+	srv := New("../dataset.xml", "secret")
+	handler := LogRequests(LoggerFunc(func(entry LogEntry) {
+		entries = append(entries, entry)
+	}), srv)
+
+	cases := []struct {
+		header string
+		logged bool
+		keep   bool
+	}{
+		{"abc-123", false, true},
+		{"abc-123", true, true},
+		{"", false, false},
+		{"", true, false},
+		{"bad id\n", true, false},
+		{strings.Repeat("a", maxTraceID+1), true, false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/?query=Boyd", nil)
+		r.Header.Set("AccessToken", "secret")
+		if c.header != "" {
+			r.Header.Set(TraceHeader, c.header)
+		}
+		w := httptest.NewRecorder()
+		if c.logged {
+			handler.ServeHTTP(w, r)
+		} else {
+			srv.ServeHTTP(w, r)
+		}
+		id := w.Header().Get(TraceHeader)
+		if c.keep && id != c.header || !c.keep && (id == c.header || !validTraceID(id)) {
+			t.Errorf("%q: unexpected trace id %q", c.header, id)
+		}
+		if c.logged && entries[len(entries)-1].TraceID != id {
+			t.Errorf("%q: expected logged trace id %q, got %+v", c.header, id, entries[len(entries)-1])
+		}
+	}
+
+	// the trace of the incoming context is kept
+	r := httptest.NewRequest("GET", "/healthz", nil)
+	r.Header.Set(TraceHeader, "header")
+	r = r.WithContext(WithTraceID(context.Background(), "context"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if id := w.Header().Get(TraceHeader); id != "context" {
+		t.Errorf("expected trace id of the context, got %q", id)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ndjsonType is the content type of streamed users, a JSON object per line
+const ndjsonType = "application/x-ndjson"
+
+// UserStream iterates over streamed users:
+//
+//	stream, err := cl.FindUsersStream(req)
+//	...
+//	defer stream.Close()
+//	for stream.Next() {
+//		user := stream.User()
+//	}
+//	err = stream.Err()
+type UserStream struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+	user User
+	err  error
+}
+
+// FindUsersStream requests users one by one without buffering them,
+// Limit is not capped and 0 means all users. Offset and Cursor are not
+// supported.
+func (srv *SearchClient) FindUsersStream(req SearchRequest) (*UserStream, error) {
+	if req.Limit < 0 {
+		return nil, fmt.Errorf("limit must be > 0")
+	}
+	params := url.Values{}
+	if err := addFilterParams(params, req); err != nil {
+		return nil, err
+	}
+	if req.Limit > 0 {
+		params.Add("limit", strconv.Itoa(req.Limit))
+	}
+
+	resp, err := srv.do(req.context(), srv.URL+"?"+params.Encode(), traced(http.Header{"Accept": {ndjsonType}}, req.TraceID))
+	if err != nil {
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return nil, fmt.Errorf("timeout for %s", params.Encode())
+		}
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if err := statusError(resp.StatusCode, body, req); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return &UserStream{body: resp.Body, dec: json.NewDecoder(resp.Body)}, nil
+}
+
+// Next reads the next user, false is returned at the end or on error
+func (s *UserStream) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	s.user = User{}
+	if err := s.dec.Decode(&s.user); err != nil {
+		if err != io.EOF {
+			s.err = fmt.Errorf("cant unpack result json: %s", err)
+		}
+		return false
+	}
+	return true
+}
+
+func (s *UserStream) User() User {
+	return s.user
+}
+
+func (s *UserStream) Err() error {
+	return s.err
+}
+
+func (s *UserStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// traceHeader carries the trace ID of a request, the server logs it and
+// sends it back
+const traceHeader = "X-Request-Id"
+
+// traced sets the trace ID to the header, the header is kept if id is
+// empty, send makes a new one then
+func traced(header http.Header, id string) http.Header {
+	if id == "" {
+		return header
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(traceHeader, id)
+	return header
+}
+
+func newTraceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrUserNotFound is returned by UpdateUser and DeleteUser for unknown id
+var ErrUserNotFound = errors.New("user not found")
+
+// CreateUser adds the user, Id is assigned by the server. Name has to be
+// the first name and the last one after a space. Changes require admin
+// token.
+func (srv *SearchClient) CreateUser(user User) (*User, error) {
+	return srv.CreateUserContext(context.Background(), user)
+}
+
+// CreateUserContext is CreateUser canceled with ctx
+func (srv *SearchClient) CreateUserContext(ctx context.Context, user User) (*User, error) {
+	return srv.sendUser(ctx, http.MethodPost, "", user)
+}
+
+// UpdateUser replaces the user with the same Id
+func (srv *SearchClient) UpdateUser(user User) (*User, error) {
+	return srv.UpdateUserContext(context.Background(), user)
+}
+
+// UpdateUserContext is UpdateUser canceled with ctx
+func (srv *SearchClient) UpdateUserContext(ctx context.Context, user User) (*User, error) {
+	return srv.sendUser(ctx, http.MethodPut, strconv.Itoa(user.Id), user)
+}
+
+func (srv *SearchClient) DeleteUser(id int) error {
+	return srv.DeleteUserContext(context.Background(), id)
+}
+
+// DeleteUserContext is DeleteUser canceled with ctx
+func (srv *SearchClient) DeleteUserContext(ctx context.Context, id int) error {
+	_, err := srv.changeUser(ctx, http.MethodDelete, strconv.Itoa(id), nil)
+	return err
+}
+
+func (srv *SearchClient) sendUser(ctx context.Context, method, id string, user User) (*User, error) {
+	body, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	data, err := srv.changeUser(ctx, method, id, body)
+	if err != nil {
+		return nil, err
+	}
+	result := &User{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("cant unpack result json: %s", err)
+	}
+	return result, nil
+}
+
+// changeUser sends the request to /users/id and returns the response body
+func (srv *SearchClient) changeUser(ctx context.Context, method, id string, body []byte) ([]byte, error) {
+	url := strings.TrimSuffix(srv.URL, "/") + "/users"
+	if id != "" {
+		url += "/" + id
+	}
+	resp, err := srv.send(ctx, method, url, body, http.Header{"Content-Type": {"application/json"}})
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return data, nil
+	case http.StatusNotFound:
+		return nil, ErrUserNotFound
+	case http.StatusNotImplemented:
+		return nil, fmt.Errorf("SearchServer dataset can't be changed")
+	}
+	if err := statusError(resp.StatusCode, data, SearchRequest{}); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("unexpected status %s", resp.Status)
+}
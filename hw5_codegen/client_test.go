@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGeneratedClient(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+	ctx := context.Background()
+	cl := NewMyApiClient(ts.URL, "100500")
+
+	user, err := cl.Profile(ctx, ProfileParams{Login: "rvasily"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 42 || user.FullName != "Vasily Romanov" {
+		t.Errorf("unexpected user %+v", user)
+	}
+
+	created, err := cl.Create(ctx, CreateParams{Login: "client_user", Name: "Client", Status: "moderator", Age: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = cl.Profile(ctx, ProfileParams{Login: "client_user"})
+	if err != nil || user.ID != created.ID || user.Status != statusModerator {
+		t.Errorf("expected created user %d, got %+v, %v", created.ID, user, err)
+	}
+
+	cases := []struct {
+		call   func() error
+		status int
+		text   string
+	}{
+		{func() error { _, err := cl.Profile(ctx, ProfileParams{Login: "nobody"}); return err },
+			http.StatusNotFound, "user not exist"},
+		{func() error { _, err := cl.Profile(ctx, ProfileParams{Login: "bad_user"}); return err },
+			http.StatusInternalServerError, "bad user"},
+		{func() error { _, err := cl.Create(ctx, CreateParams{Login: "short"}); return err },
+			http.StatusBadRequest, "login len must be >= 10"},
+		{func() error { _, err := cl.Create(ctx, CreateParams{Login: "client_user"}); return err },
+			http.StatusConflict, "user client_user exist"},
+		{func() error {
+			_, err := NewMyApiClient(ts.URL, "").Create(ctx, CreateParams{Login: "client_user2"})
+			return err
+		}, http.StatusForbidden, "unauthorized"},
+	}
+	for _, c := range cases {
+		err, ok := c.call().(ApiError)
+		if !ok || err.HTTPStatus != c.status || err.Error() != c.text {
+			t.Errorf("expected %d %q, got %#v", c.status, c.text, err)
+		}
+	}
+}
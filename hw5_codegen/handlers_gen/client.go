@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// GetMethodResultTypeName is the type of the first result of the method
+func GetMethodResultTypeName(method *ast.FuncDecl) string {
+	return types.ExprString(method.Type.Results.List[0].Type)
+}
+
+// EncodeValue returns the expression of the query value of expr, it's the
+// value of the field of the config
+func EncodeValue(cfg *fieldConfig, expr string) string {
+	switch cfg.Type {
+	case "int":
+		return fmt.Sprintf("strconv.Itoa(%s)", expr)
+	case "int64":
+		return fmt.Sprintf("strconv.FormatInt(%s, 10)", expr)
+	case "uint", "uint64":
+		return fmt.Sprintf("strconv.FormatUint(uint64(%s), 10)", expr)
+	case "time.Time":
+		if strings.HasPrefix(expr, "*") {
+			expr = "(" + expr + ")"
+		}
+		if cfg.Layout == "unix" {
+			return fmt.Sprintf("strconv.FormatInt(%s.Unix(), 10)", expr)
+		}
+		return fmt.Sprintf("%s.Format(%q)", expr, cfg.Layout)
+	case "float64":
+		return fmt.Sprintf("strconv.FormatFloat(%s, 'g', -1, 64)", expr)
+	case "bool":
+		return fmt.Sprintf("strconv.FormatBool(%s)", expr)
+	case "[]string":
+		return fmt.Sprintf("strings.Join(%s, \",\")", expr)
+	}
+	return expr
+}
+
+// requestMethod is the HTTP method clients send, it's POST for JSON body
+// and files and GET for any method handlers otherwise
+func (t *tmplData) requestMethod(cfg *methodConfig, paramType string) string {
+	switch {
+	case cfg.HTTPMethod != "":
+		return cfg.HTTPMethod
+	case t.HasSource(paramType, "json") || t.HasFiles(paramType):
+		return "POST"
+	}
+	return "GET"
+}
+
+// ClientMethod is the expression of the HTTP method the client sends
+func (t *tmplData) ClientMethod(cfg *methodConfig, paramType string) string {
+	switch method := t.requestMethod(cfg, paramType); {
+	case cfg.HTTPMethod != "":
+		return strconv.Quote(method)
+	case method == "POST":
+		return "http.MethodPost"
+	}
+	return "http.MethodGet"
+}
+
+// requestSource is the source of the param in requests of clients and
+// tests. Params without source are in the form of POST requests without
+// JSON body, they are in the query otherwise.
+func (t *tmplData) requestSource(cfg *methodConfig, paramType string, p param) string {
+	switch {
+	case p.Cfg.Type == "file":
+		return "file"
+	case p.Cfg.Source != "":
+		return p.Cfg.Source
+	case (cfg.HTTPMethod == "POST" || t.HasFiles(paramType)) && !t.HasSource(paramType, "json"):
+		return "form"
+	}
+	return "query"
+}
+
+// ClientSetParam returns the statement adding the param to the request by
+// its source
+func (t *tmplData) ClientSetParam(cfg *methodConfig, paramType string, p param, expr string) string {
+	switch source := t.requestSource(cfg, paramType, p); source {
+	case "file":
+		return fmt.Sprintf("req.setFile(%q, %s)", p.Name, expr)
+	case "json":
+		if p.Cfg.Type == "time.Time" {
+			// times are sent in the layout of the field
+			expr = EncodeValue(p.Cfg, expr)
+		}
+		return fmt.Sprintf("req.setJSON(%q, %s)", p.Name, expr)
+	default:
+		return fmt.Sprintf("req.%s.Set(%q, %s)", source, p.Name, EncodeValue(p.Cfg, expr))
+	}
+}
+
+// generateClient makes a client per receiver type of the handlers, it's
+// written to the package of the handlers, so they share the types. dir is
+// the directory of templates overriding the default one.
+func generateClient(buf bytes.Buffer, data *tmplData, dir string) (bytes.Buffer, error) {
+	funcMap := make(template.FuncMap)
+	funcMap["GetRecvTypes"] = GetRecvTypes
+	funcMap["GetMethodName"] = GetMethodName
+	funcMap["GetMethodParamTypeName"] = GetMethodParamTypeName
+	funcMap["GetMethodResultTypeName"] = GetMethodResultTypeName
+	funcMap["EncodeValue"] = EncodeValue
+
+	tmpl, err := loadTemplate(dir, "client", tmplClient, funcMap)
+	if err != nil {
+		return buf, err
+	}
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+var tmplClient = `
+package {{.PackageName}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// strconv is used by params of some types only
+var _ = strconv.Itoa
+
+// apiRequest is the params of the handler by their sources, JSON body is
+// sent instead of the form if it's not nil. The form is multipart with
+// files.
+type apiRequest struct {
+	query  url.Values
+	form   url.Values
+	header http.Header
+	json   map[string]interface{}
+	files  map[string]*multipart.FileHeader
+}
+
+func newAPIRequest() *apiRequest {
+	return &apiRequest{query: url.Values{}, form: url.Values{}, header: http.Header{}}
+}
+
+// setJSON sets the value of the body, dots of the name are the keys of
+// nested objects
+func (ar *apiRequest) setJSON(name string, value interface{}) {
+	if ar.json == nil {
+		ar.json = map[string]interface{}{}
+	}
+	object := ar.json
+	keys := strings.Split(name, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := object[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			object[key] = next
+		}
+		object = next
+	}
+	object[keys[len(keys)-1]] = value
+}
+
+// setFile adds the file to the multipart form, nil is skipped
+func (ar *apiRequest) setFile(name string, file *multipart.FileHeader) {
+	if file == nil {
+		return
+	}
+	if ar.files == nil {
+		ar.files = map[string]*multipart.FileHeader{}
+	}
+	ar.files[name] = file
+}
+
+// multipartBody writes the form and files of the request
+func (ar *apiRequest) multipartBody() (io.Reader, string, error) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for name, values := range ar.form {
+		for _, value := range values {
+			if err := mw.WriteField(name, value); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	for name, file := range ar.files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", fmt.Sprintf("form-data; name=%q; filename=%q", name, file.Filename))
+		header.Set("Content-Type", file.Header.Get("Content-Type"))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		f, err := file.Open()
+		if err != nil {
+			return nil, "", err
+		}
+		_, err = io.Copy(part, f)
+		f.Close()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf, mw.FormDataContentType(), nil
+}
+
+// apiCall sends params of the handler and decodes its response to result,
+// errors of the handler are ApiError with the status of the response
+func apiCall(ctx context.Context, client *http.Client, baseURL, authHeader, auth, method, path string, params *apiRequest, result interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	target := strings.TrimSuffix(baseURL, "/") + path
+	if len(params.query) > 0 {
+		target += "?" + params.query.Encode()
+	}
+	var reqBody io.Reader
+	contentType := ""
+	switch {
+	case params.json != nil:
+		buf, err := json.Marshal(params.json)
+		if err != nil {
+			return err
+		}
+		reqBody, contentType = bytes.NewReader(buf), "application/json"
+	case params.files != nil:
+		var err error
+		if reqBody, contentType, err = params.multipartBody(); err != nil {
+			return err
+		}
+	case method == http.MethodPost:
+		reqBody, contentType = strings.NewReader(params.form.Encode()), "application/x-www-form-urlencoded"
+	}
+	req, err := http.NewRequest(method, target, reqBody)
+	if err != nil {
+		return err
+	}
+	for name, values := range params.header {
+		req.Header[name] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if authHeader != "" {
+		req.Header.Set(authHeader, auth)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	ar := APIResponse{Response: result}
+	if err := json.Unmarshal(body, &ar); err != nil {
+		if resp.StatusCode/100 != 2 {
+			return ApiError{resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)}
+		}
+		return fmt.Errorf("cant unpack response json: %s", err)
+	}
+	// methods may set other successful statuses, like 201
+	if ar.Error != "" || resp.StatusCode/100 != 2 {
+		return ApiError{resp.StatusCode, errors.New(ar.Error)}
+	}
+	return nil
+}
+
+{{define "auth"}}
+{{- if eq .AuthScheme "header"}}{{printf "%q" .AuthHeader}}, c.Auth
+{{- else if eq .AuthScheme "bearer"}}"Authorization", "Bearer "+c.Auth
+{{- else if eq .AuthScheme "method"}}"Authorization", c.Auth
+{{- else}}"", ""
+{{- end}}
+{{- end}}
+
+{{range $recvTypeName, $methods := GetRecvTypes .Methods}}
+// {{$recvTypeName}}Client calls handlers of {{$recvTypeName}} served at URL
+type {{$recvTypeName}}Client struct {
+	URL string
+	// Auth is sent to handlers requiring authorization, it's the token
+	// of bearer auth and the Authorization header of Authorize method
+	Auth string
+	// HTTPClient sends requests, nil is http.DefaultClient
+	HTTPClient *http.Client
+}
+
+func New{{$recvTypeName}}Client(url, auth string) *{{$recvTypeName}}Client {
+	return &{{$recvTypeName}}Client{URL: url, Auth: auth}
+}
+{{range $method := $methods}}
+{{- $methodName := GetMethodName $method}}
+{{- $methodCfg := $.GetMethodConfig $method}}
+{{- $paramType := GetMethodParamTypeName $method 1}}
+{{- $resultType := GetMethodResultTypeName $method}}
+// {{$methodName}} calls {{$methodCfg.URL}}
+func (c *{{$recvTypeName}}Client) {{$methodName}}(ctx context.Context, in {{$paramType}}) ({{$resultType}}, error) {
+	req := newAPIRequest()
+	{{range $param := $.GetParams $paramType -}}
+	{{if $param.Cfg.Optional -}}
+	if in.{{$param.Path}} != nil {
+		{{$.ClientSetParam $methodCfg $paramType $param (printf "*in.%s" $param.Path)}}
+	}
+	{{else -}}
+	{{$.ClientSetParam $methodCfg $paramType $param (printf "in.%s" $param.Path)}}
+	{{end -}}
+	{{end -}}
+	var result {{$resultType}}
+	err := apiCall(ctx, c.HTTPClient, c.URL, {{template "auth" $methodCfg}}, {{$.ClientMethod $methodCfg $paramType}}, "{{$methodCfg.URL}}", req, &result)
+	return result, err
+}
+{{end}}
+{{end}}
+`
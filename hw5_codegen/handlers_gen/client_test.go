@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateClient(t *testing.T) {
+	for _, srcs := range [][]string{
+		{filepath.Join("testdata", "types.go")},
+		{filepath.Join("testdata", "multi", "api.go"), filepath.Join("testdata", "multi", "params.go")},
+	} {
+		data, err := parseSrc(srcs, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := generateClient(bytes.Buffer{}, data, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err = formatCode(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typeCheck(t, srcs, generate(t, srcs...), client.Bytes())
+	}
+}
+
+func TestEncodeValue(t *testing.T) {
+	cases := map[string]string{
+		"int":      "strconv.Itoa(in.X)",
+		"float64":  "strconv.FormatFloat(in.X, 'g', -1, 64)",
+		"bool":     "strconv.FormatBool(in.X)",
+		"string":   "in.X",
+		"[]string": `strings.Join(in.X, ",")`,
+	}
+	for typeName, expected := range cases {
+		if expr := EncodeValue(&fieldConfig{Type: typeName}, "in.X"); expr != expected {
+			t.Errorf("%s: expected %s, got %s", typeName, expected, expr)
+		}
+	}
+	if expr := EncodeValue(&fieldConfig{Type: "int", Optional: true}, "*in.X"); !strings.HasSuffix(expr, "(*in.X)") {
+		t.Errorf("unexpected optional value %s", expr)
+	}
+}
@@ -1,46 +1,289 @@
+// Command codegen generates HTTP handlers of methods with apigen:api
+// comments. It runs under go:generate with flags of the files, like
+//
+//	//go:generate codegen -src api.go,params.go -dst api_gen.go
+//
+// files of other packages of directories in -src are skipped then.
+//
+// The generated code requires Go 1.19 for http.MaxBytesError of body
+// limits, and Go 1.22 for patterns of http.ServeMux with -router mux.
 package main
 
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"go/types"
+	"io/ioutil"
+	"net/textproto"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
+	"unicode"
 )
 
 type tmplData struct {
 	PackageName string
 	Methods     []*ast.FuncDecl
-	MethodsCfg  map[string]*methodConfig
-	StructsCfg  map[string]map[string]*fieldConfig
+	// MethodsCfg are configs of Methods by their receiver type and name,
+	// like MyApi.Profile
+	MethodsCfg map[string]*methodConfig
+	StructsCfg map[string]map[string]*fieldConfig
+	// Structs are param types of Methods
+	Structs map[string]*ast.StructType
+	// Router is mux or chi to register handlers on http.ServeMux or
+	// chi.Router matching methods, handlers have ServeHTTP if it's empty
+	Router string
+	// Instrument adds events of requests for counters, latency and logs
+	// of the host app
+	Instrument bool
+	// Gzip compresses responses of clients accepting gzip encoding
+	Gzip bool
+	// StructuredErrors adds the code and errors of params to responses,
+	// all errors of params are returned then
+	StructuredErrors bool
+	// Constructors are NewT functions of the package returning *T or T
+	// without params, generated tests make receivers with them
+	Constructors map[string]bool
+	// Interfaces are the receiver types declared as interfaces, their
+	// handlers are methods of TDHandler adapters embedding them
+	Interfaces map[string]bool
+	// Imports are the paths of packages of custom validators by their
+	// names
+	Imports map[string]string
+	// Types are type declarations of the package, TypeScript types of
+	// results are made of them
+	Types map[string]*ast.TypeSpec
+	// Principals are the types of principals Authorize methods return
+	// with the error by their receiver types
+	Principals map[string]string
+	// Split generates Part of the handlers only, it's the code of the
+	// receiver type or the shared runtime if it's empty
+	Split bool
+	Part  string
 }
 
 type methodConfig struct {
 	URL        string `json:"url"`
 	Auth       bool   `json:"auth"`
 	HTTPMethod string `json:"method"`
+	// AuthScheme is how authorized requests are checked:
+	// header - AuthHeader must be AuthToken, X-Auth and 100500 by default,
+	// bearer - Authorization must be "Bearer AuthToken",
+	// method - the receiver's Authorize(r *http.Request) error allows them,
+	// ApiError sets the status of the response, it's 403 otherwise. It may
+	// return the principal before the error, like (*User, error).
+	AuthScheme string `json:"auth_scheme"`
+	AuthHeader string `json:"auth_header"`
+	AuthToken  string `json:"auth_token"`
+	// TimeoutMS is the timeout of the method call context, the response is
+	// 504 if the method fails after it. No timeout if it's 0.
+	TimeoutMS int `json:"timeout_ms"`
+	// CORSOrigins are origins allowed to call the method, "*" is any one,
+	// CORSMethods are allowed by preflight requests, the method of the
+	// handler by default. No CORS headers if there are no origins.
+	CORSOrigins []string `json:"cors_origins"`
+	CORSMethods []string `json:"cors_methods"`
+	// Version is the prefix of URL, like v2 for /v2/user, so versions of
+	// the endpoint are served by methods with their own params
+	Version string `json:"version"`
+	// Context are values added to the context of the method call:
+	// request_id - X-Request-Id header or the random ID, it's sent back in
+	// the header of the response, see RequestIDFromContext,
+	// principal - the principal of method auth, see <Type>PrincipalFromContext
+	Context []string `json:"context"`
+	// MaxBodyKB is the limit of the request body in KB, the response is 413
+	// if the body is larger. No limit if it's 0.
+	MaxBodyKB int `json:"max_body_kb"`
+	// CacheTTLMS is how long successful responses of GET methods are cached
+	// by their params, concurrent requests of the params wait for the first
+	// one. No cache if it's 0.
+	CacheTTLMS int `json:"cache_ttl_ms"`
+}
+
+// contextValues are the values of the context config of methods
+var contextValues = map[string]bool{
+	"request_id": true,
+	"principal":  true,
+}
+
+// HasContext reports whether the value is added to the context of the
+// method call
+func (c *methodConfig) HasContext(value string) bool {
+	for _, v := range c.Context {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCORSMethods are the methods allowed by preflight requests
+func (c *methodConfig) GetCORSMethods() string {
+	switch {
+	case len(c.CORSMethods) > 0:
+		return strings.Join(c.CORSMethods, ", ")
+	case c.HTTPMethod != "":
+		return c.HTTPMethod
+	}
+	return "GET, POST"
+}
+
+// GetCORSHeaders are the headers allowed by preflight requests, they are
+// the ones of forms and auth
+func (c *methodConfig) GetCORSHeaders() string {
+	switch {
+	case !c.Auth:
+		return "Content-Type"
+	case c.AuthScheme == "header":
+		return "Content-Type, " + c.AuthHeader
+	}
+	return "Content-Type, Authorization"
 }
 
 type fieldConfig struct {
 	Required bool
 	HasMin   bool
 	HasMax   bool
-	Min      int
-	Max      int
-	Enum     []string
-	Alias    string
-	Default  string
+	// Min and Max are integers for all types but float64
+	Min     float64
+	Max     float64
+	Enum    []string
+	Alias   string
+	Default string
+	// DefaultEnv and DefaultConst are the environment variable and the
+	// constant of the package of default=env:VAR and default=const:Name
+	DefaultEnv   string
+	DefaultConst string
+	// Type is one of supportedTypes, Optional fields are pointers to it,
+	// they are left nil without value
+	Type     string
+	Optional bool
+	// Regexp and Format (email, url or uuid) are checked for non empty
+	// values of string fields and items of []string ones
+	Regexp string
+	Format string
+	// MaxLen, Prefix, Suffix and Charset (one of charsets) are checked for
+	// string fields, len=N token is min and max length of N
+	HasMaxLen bool
+	MaxLen    int
+	Prefix    string
+	Suffix    string
+	Charset   string
+	// MaxSize is the limit of file size in bytes, ContentTypes are the
+	// ones files may have, like image/png or image/*
+	MaxSize      int64
+	ContentTypes []string
+	// Layout is the layout of time.Time fields, RFC3339 by default, it's
+	// unix for seconds since the epoch
+	Layout string
+	// Source is where the value is read from: query, form, header or json
+	// body, it's FormValue of query and form if it's empty
+	Source string
+	// EnumType is the string type generated for Enum of string and
+	// []string fields, it has the constants of Enum values
+	EnumType string
+	// Custom is the function of the package or of the imported one, like
+	// validators.CheckInn, checking the value after other rules. It takes
+	// the value of the field type and returns the error.
+	Custom string
+	// Struct is the type of nested struct fields, their params are named
+	// with the prefix of Alias and dot. Embedded ones have no prefix.
+	Struct   string
+	Embedded bool
+}
+
+// param is the field of the param struct or of the structs nested in it,
+// Name is the dotted name of the param, Path is the selector of the field
+type param struct {
+	Name string
+	Path string
+	Cfg  *fieldConfig
+}
+
+// formats are the tokens of value formats
+var formats = map[string]bool{
+	"email": true,
+	"url":   true,
+	"uuid":  true,
 }
 
+// sources are the tokens of where values are read from
+var sources = map[string]bool{
+	"query":  true,
+	"form":   true,
+	"header": true,
+	"json":   true,
+}
+
+// charsets are the tokens of characters string values may have
+var charsets = map[string]bool{
+	"alpha": true,
+	"alnum": true,
+	"digit": true,
+	"hex":   true,
+	"ascii": true,
+}
+
+// supportedTypes are the field types values are parsed to, []string
+// values are comma separated. file is *multipart.FileHeader of multipart
+// forms. time.Time is parsed in the layout of the field.
+var supportedTypes = map[string]bool{
+	"int":       true,
+	"int64":     true,
+	"uint":      true,
+	"uint64":    true,
+	"float64":   true,
+	"bool":      true,
+	"string":    true,
+	"[]string":  true,
+	"file":      true,
+	"time.Time": true,
+}
+
+// numberTypes are supportedTypes of numbers, their min and max are the
+// bounds of values
+var numberTypes = map[string]bool{
+	"int":     true,
+	"int64":   true,
+	"uint":    true,
+	"uint64":  true,
+	"float64": true,
+}
+
+// timeLayouts are the layouts of layout=Name tokens, unix is seconds since
+// the epoch. Other values of the token are layouts of the time package.
+var timeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"DateOnly":    "2006-01-02",
+	"unix":        "unix",
+}
+
+// maxExactBound is the greatest bound of integers kept exactly in float64
+// bounds of the config
+const maxExactBound = 1 << 53
+
 type mWalker struct {
 	methods []*ast.FuncDecl
+	// principals are the types of principals of Authorize methods by
+	// their receiver types
+	principals map[string]string
+	// interfaces are the interfaces with API methods
+	interfaces map[string]bool
 }
 
 func getPackageName(file *ast.File) string {
@@ -51,11 +294,34 @@ func GetMethodName(method *ast.FuncDecl) string {
 	return method.Name.Name
 }
 
+// handlerNames are the names handlers use, receivers named like them
+// are renamed
+var handlerNames = map[string]bool{
+	"w": true, "r": true, "p": true, "ctx": true, "cancel": true, "err": true, "sw": true, "gw": true,
+	"status": true, "principal": true, "authErr": true, "jsonErr": true, "result": true, "meta": true,
+	"name": true, "value": true, "values": true, "apiError": true,
+	"http": true, "context": true, "time": true, "json": true, "fmt": true, "errors": true,
+}
+
+// GetMethodRecvName is the name of the receiver in the handler of the
+// method, it's srv if the receiver is unnamed or its name is used by the
+// handler
 func GetMethodRecvName(method *ast.FuncDecl) string {
 	if len(method.Recv.List[0].Names) > 0 {
-		return method.Recv.List[0].Names[0].Name
+		if name := method.Recv.List[0].Names[0].Name; name != "_" && !handlerNames[name] {
+			return name
+		}
+	}
+	return "srv"
+}
+
+// HandlerType is the type of the handlers of the receiver type, it's the
+// adapter of interfaces
+func (t *tmplData) HandlerType(recvName string) string {
+	if t.Interfaces[recvName] {
+		return recvName + "Handler"
 	}
-	return ""
+	return recvName
 }
 
 func GetMethodRecvTypeName(method *ast.FuncDecl) string {
@@ -77,14 +343,39 @@ func getTypeNameFromExpr(expr ast.Expr) string {
 	return name
 }
 
-func GetStructTypes(methods []*ast.FuncDecl) map[string]*ast.StructType {
-	structs := make(map[string]*ast.StructType)
-	for _, method := range methods {
-		expr := getMethodParamTypeExpr(method, 1)
-		structName := GetMethodParamTypeName(method, 1)
-		structs[structName] = getStructTypeFromExpr(expr)
+// InPart reports whether the code of the receiver type is generated, the
+// shared runtime is the code of ""
+func (t *tmplData) InPart(recvName string) bool {
+	return !t.Split || t.Part == recvName
+}
+
+// StructRecv is the receiver type of methods with params of the struct,
+// nested structs included, it's empty if they are of several receivers
+func (t *tmplData) StructRecv(structName string) string {
+	recvName := ""
+	for _, method := range t.Methods {
+		if !t.usesStruct(GetMethodParamTypeName(method, 1), structName) {
+			continue
+		}
+		if recvName != "" && recvName != GetMethodRecvTypeName(method) {
+			return ""
+		}
+		recvName = GetMethodRecvTypeName(method)
+	}
+	return recvName
+}
+
+// usesStruct reports whether the struct is the params or nested in them
+func (t *tmplData) usesStruct(paramsName, structName string) bool {
+	if paramsName == structName {
+		return true
 	}
-	return structs
+	for _, cfg := range t.StructsCfg[paramsName] {
+		if cfg.Struct != "" && t.usesStruct(cfg.Struct, structName) {
+			return true
+		}
+	}
+	return false
 }
 
 func GetRecvTypes(methods []*ast.FuncDecl) map[string][]*ast.FuncDecl {
@@ -104,34 +395,75 @@ func GetStructFields(s *ast.StructType) map[string]*ast.Field {
 	}
 	result := make(map[string]*ast.Field)
 	for _, field := range s.Fields.List {
-		name := field.Names[0].Name
-		result[name] = field
+		result[getFieldName(field)] = field
 	}
 	return result
 }
 
-func GetFieldTypeName(field *ast.Field) string {
-	return getTypeNameFromExpr(field.Type)
+// getFieldName is the name of the field, embedded fields are named by
+// their type
+func getFieldName(field *ast.Field) string {
+	if len(field.Names) == 0 {
+		return getTypeNameFromExpr(field.Type)
+	}
+	return field.Names[0].Name
 }
 
-func getStructTypeFromExpr(expr ast.Expr) *ast.StructType {
-	var st *ast.StructType
+// getFieldType returns one of supportedTypes, pointers are optional
+// fields of the type they point to
+func getFieldType(expr ast.Expr) (typeName string, optional bool, err error) {
+	if types.ExprString(expr) == "*multipart.FileHeader" {
+		// files are nil without value
+		return "file", false, nil
+	}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+		optional = true
+	}
+	switch node := expr.(type) {
+	case *ast.Ident:
+		typeName = node.Name
+	case *ast.ArrayType:
+		if elt, ok := node.Elt.(*ast.Ident); ok && node.Len == nil {
+			typeName = "[]" + elt.Name
+		}
+	case *ast.SelectorExpr:
+		typeName = types.ExprString(node)
+	}
+	if !supportedTypes[typeName] || optional && typeName == "[]string" {
+		return "", false, fmt.Errorf("unsupported field type: %s", types.ExprString(expr))
+	}
+	return typeName, optional, nil
+}
+
+// getStructTypeFromExpr finds the struct type in types declared by the
+// package, so it may be declared in any of its files
+func getStructTypeFromExpr(expr ast.Expr, typeSpecs map[string]*ast.TypeSpec) (*ast.StructType, error) {
 	switch node := expr.(type) {
 	case *ast.Ident:
-		v, ok := node.Obj.Decl.(*ast.TypeSpec)
+		spec, ok := typeSpecs[node.Name]
 		if !ok {
-			panic("should be ok")
+			return nil, fmt.Errorf("type %s is not declared in the package", node.Name)
 		}
-		st, ok = v.Type.(*ast.StructType)
+		st, ok := spec.Type.(*ast.StructType)
 		if !ok {
-			panic("should be ok")
+			return nil, fmt.Errorf("type %s is not a struct", node.Name)
 		}
+		return st, nil
 	case *ast.StarExpr:
-		st = getStructTypeFromExpr(node.X)
+		return getStructTypeFromExpr(node.X, typeSpecs)
 	default:
-		panic("unknown type, expected only ast.Ident or ast.StarExpr")
+		return nil, fmt.Errorf("unknown type, expected only ast.Ident or ast.StarExpr")
+	}
+}
+
+// checkMethodParams checks that the method takes the context and the
+// params only
+func checkMethodParams(method *ast.FuncDecl) error {
+	if method.Type.Params.NumFields() != 2 || len(method.Type.Params.List) != 2 {
+		return fmt.Errorf("method %s must have two parameters: context and params", GetMethodName(method))
 	}
-	return st
+	return nil
 }
 
 func checkMethodParamIdx(method *ast.FuncDecl, idx int) {
@@ -150,10 +482,56 @@ func GetMethodParamTypeName(method *ast.FuncDecl, idx int) string {
 	return getTypeNameFromExpr(method.Type.Params.List[idx].Type)
 }
 
-func (t *tmplData) GetMethodConfig(methodName string) *methodConfig {
-	cfg, ok := t.MethodsCfg[methodName]
+// ReturnsMeta reports whether the method returns ResponseMeta between the
+// result and the error, it sets the status and headers of the response
+func ReturnsMeta(method *ast.FuncDecl) bool {
+	results := method.Type.Results
+	return results != nil && len(results.List) == 3 && types.ExprString(results.List[1].Type) == "ResponseMeta"
+}
+
+// HasContext reports whether some methods have the value in their context
+func (t *tmplData) HasContext(value string) bool {
+	for _, method := range t.Methods {
+		if t.GetMethodConfig(method).HasContext(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContextPrincipals are Principals of receiver types of the methods having
+// principal in their context
+func (t *tmplData) ContextPrincipals() map[string]string {
+	principals := make(map[string]string)
+	for _, method := range t.Methods {
+		recvType := GetMethodRecvTypeName(method)
+		if t.GetMethodConfig(method).HasContext("principal") {
+			principals[recvType] = t.Principals[recvType]
+		}
+	}
+	return principals
+}
+
+// HasResponseMeta reports whether some methods return ResponseMeta
+func (t *tmplData) HasResponseMeta() bool {
+	for _, method := range t.Methods {
+		if ReturnsMeta(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// methodKey is the key of the method in MethodsCfg, methods of different
+// types may have the same name, like versions of the API
+func methodKey(method *ast.FuncDecl) string {
+	return GetMethodRecvTypeName(method) + "." + GetMethodName(method)
+}
+
+func (t *tmplData) GetMethodConfig(method *ast.FuncDecl) *methodConfig {
+	cfg, ok := t.MethodsCfg[methodKey(method)]
 	if !ok {
-		panic("no such method, but should: " + methodName)
+		panic("no such method, but should: " + methodKey(method))
 	}
 	return cfg
 }
@@ -171,11 +549,124 @@ func (t *tmplData) GetFieldConfig(structName, fieldName string) *fieldConfig {
 	panic("can't find field with name: " + fieldName)
 }
 
+// setDefaultTimeout sets the timeout of methods without timeout_ms
+func (t *tmplData) setDefaultTimeout(ms int) error {
+	if ms < 0 {
+		return fmt.Errorf("timeout must be >= 0")
+	}
+	for _, cfg := range t.MethodsCfg {
+		if cfg.TimeoutMS == 0 {
+			cfg.TimeoutMS = ms
+		}
+	}
+	return nil
+}
+
+// setDefaultMaxBody sets the body limit of methods without max_body_kb,
+// maxsize of files is added to it, so uploads of them aren't rejected
+func (t *tmplData) setDefaultMaxBody(kb int) error {
+	if kb < 0 {
+		return fmt.Errorf("max body must be >= 0")
+	}
+	if kb == 0 {
+		return nil
+	}
+	for _, method := range t.Methods {
+		cfg := t.GetMethodConfig(method)
+		if cfg.MaxBodyKB != 0 {
+			continue
+		}
+		cfg.MaxBodyKB = kb
+		for _, p := range t.GetParams(GetMethodParamTypeName(method, 1)) {
+			cfg.MaxBodyKB += int((p.Cfg.MaxSize + 1023) >> 10)
+		}
+	}
+	return nil
+}
+
+// HasCache reports whether some methods cache their responses
+func (t *tmplData) HasCache() bool {
+	for _, cfg := range t.MethodsCfg {
+		if cfg.CacheTTLMS > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasBodyLimit reports whether some methods limit the request body
+func (t *tmplData) HasBodyLimit() bool {
+	for _, cfg := range t.MethodsCfg {
+		if cfg.MaxBodyKB > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSource reports whether some params of the struct are read from the
+// source
+func (t *tmplData) HasSource(structName, source string) bool {
+	for _, p := range t.GetParams(structName) {
+		if p.Cfg.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFiles reports whether some params of the struct are files
+func (t *tmplData) HasFiles(structName string) bool {
+	for _, p := range t.GetParams(structName) {
+		if p.Cfg.Type == "file" {
+			return true
+		}
+	}
+	return false
+}
+
+// setDefaultCORS sets the origins of methods without cors_origins
+func (t *tmplData) setDefaultCORS(origins []string) {
+	for _, cfg := range t.MethodsCfg {
+		if len(cfg.CORSOrigins) == 0 {
+			cfg.CORSOrigins = origins
+		}
+	}
+}
+
+// GetParams returns params of the struct in the order of fields, fields
+// of nested structs are in place of them. Fields with errors are skipped.
+func (t *tmplData) GetParams(structName string) []param {
+	var params []param
+	for _, field := range t.Structs[structName].Fields.List {
+		name := getFieldName(field)
+		if _, ok := t.StructsCfg[structName][name]; !ok {
+			continue
+		}
+		cfg := t.GetFieldConfig(structName, name)
+		if cfg.Struct == "" {
+			params = append(params, param{cfg.Alias, name, cfg})
+			continue
+		}
+		for _, p := range t.GetParams(cfg.Struct) {
+			if !cfg.Embedded {
+				p.Name = cfg.Alias + "." + p.Name
+			}
+			p.Path = name + "." + p.Path
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
 func selectorExprToStr(se *ast.SelectorExpr) string {
 	ident := se.X.(*ast.Ident)
 	return ident.Name + "." + se.Sel.Name
 }
 
+// versionRegexp matches versions of methods
+var versionRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
 func parseMethodConfig(method *ast.FuncDecl) (*methodConfig, error) {
 	configRaw := strings.TrimPrefix(method.Doc.Text(), "apigen:api")
 	config := methodConfig{}
@@ -183,85 +674,396 @@ func parseMethodConfig(method *ast.FuncDecl) (*methodConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.TimeoutMS < 0 {
+		return nil, fmt.Errorf("timeout_ms must be >= 0")
+	}
+	if config.MaxBodyKB < 0 {
+		return nil, fmt.Errorf("max_body_kb must be >= 0")
+	}
+	if config.CacheTTLMS < 0 {
+		return nil, fmt.Errorf("cache_ttl_ms must be >= 0")
+	}
+	if config.CacheTTLMS > 0 && config.HTTPMethod != "GET" {
+		return nil, fmt.Errorf("cache_ttl_ms requires GET method")
+	}
+	if config.Version != "" {
+		if !versionRegexp.MatchString(config.Version) {
+			return nil, fmt.Errorf("bad version %q, expected a path segment like v2", config.Version)
+		}
+		config.URL = "/" + config.Version + config.URL
+	}
+	for _, value := range config.Context {
+		if !contextValues[value] {
+			return nil, fmt.Errorf("unknown context value %q, expected request_id or principal", value)
+		}
+	}
+	if config.HasContext("principal") && (!config.Auth || config.AuthScheme != "method") {
+		return nil, fmt.Errorf("principal of context requires method auth_scheme")
+	}
+	if config.HasContext("principal") && config.CacheTTLMS > 0 {
+		// responses of principals would be shared by the params
+		return nil, fmt.Errorf("cache_ttl_ms can't be used with principal of context")
+	}
+	if !config.Auth {
+		return &config, nil
+	}
+	switch config.AuthScheme {
+	case "", "header":
+		config.AuthScheme = "header"
+		if config.AuthHeader == "" {
+			config.AuthHeader = "X-Auth"
+		}
+		if config.AuthToken == "" {
+			config.AuthToken = "100500"
+		}
+	case "bearer":
+		if config.AuthToken == "" {
+			return nil, fmt.Errorf("auth_token is required for bearer auth")
+		}
+	case "method":
+	default:
+		return nil, fmt.Errorf("unknown auth_scheme %q, expected header, bearer or method", config.AuthScheme)
+	}
 	return &config, nil
 }
 
-func newTmplDataFrom(methods []*ast.FuncDecl, pkgName string) (*tmplData, error) {
+// newTmplDataFrom checks methods and their param types, all errors are
+// returned at once with their positions
+func newTmplDataFrom(fset *token.FileSet, methods []*ast.FuncDecl, principals map[string]string, typeSpecs map[string]*ast.TypeSpec, imports map[string]string, pkgName string) (*tmplData, error) {
+	var errs scanner.ErrorList
+	addErr := func(pos token.Pos, err error) {
+		errs.Add(fset.Position(pos), err.Error())
+	}
 	methodConfigs := make(map[string]*methodConfig)
+	fieldConfigs := make(map[string]map[string]*fieldConfig)
+	structs := make(map[string]*ast.StructType)
+	// enumTypes are the generated types of enums and their constants
+	enumTypes := make(map[string]bool)
+	// customImports are the imports of custom validators
+	customImports := make(map[string]string)
+	// addStruct adds configs of the struct fields and of the structs nested
+	// in it
+	var addStruct func(structName string, st *ast.StructType)
+	addStruct = func(structName string, st *ast.StructType) {
+		if _, ok := fieldConfigs[structName]; ok {
+			return
+		}
+		structs[structName] = st
+		fieldConfigs[structName] = make(map[string]*fieldConfig)
+		for _, field := range st.Fields.List {
+			if len(field.Names) > 1 {
+				// Ignore corner cases like these. For simplicity reasons.
+				// type s struct { a, b, c int }
+				addErr(field.Pos(), fmt.Errorf("fields of %s must be declared one per line", structName))
+				continue
+			}
+			nestedName, nested, err := getNestedStruct(field, typeSpecs)
+			if err != nil {
+				addErr(field.Pos(), fmt.Errorf("field %s.%s: %s", structName, getFieldName(field), err))
+				continue
+			}
+			name := getFieldName(field)
+			var cfg *fieldConfig
+			if nested != nil {
+				cfg, err = parseStructFieldConfig(field, nestedName)
+			} else {
+				cfg, err = parseFieldConfig(field)
+			}
+			if err != nil {
+				addErr(field.Tag.Pos(), fmt.Errorf("field %s.%s: %s", structName, name, err))
+				continue
+			}
+			if cfg == nil {
+				addErr(field.Pos(), fmt.Errorf("field %s.%s has no apivalidator tag", structName, name))
+				continue
+			}
+			if len(cfg.Enum) > 0 && (cfg.Type == "string" || cfg.Type == "[]string") {
+				cfg.EnumType = structName + name
+				if err := checkEnumType(cfg, typeSpecs, enumTypes); err != nil {
+					addErr(field.Tag.Pos(), fmt.Errorf("field %s.%s: %s", structName, name, err))
+					continue
+				}
+			}
+			if pkg := strings.Split(cfg.Custom, "."); len(pkg) == 2 {
+				if imports[pkg[0]] == "" {
+					addErr(field.Tag.Pos(), fmt.Errorf("field %s.%s: package %s of custom validator is not imported", structName, name, pkg[0]))
+					continue
+				}
+				customImports[pkg[0]] = imports[pkg[0]]
+			}
+			fieldConfigs[structName][name] = cfg
+			if nested != nil {
+				addStruct(nestedName, nested)
+			}
+		}
+	}
+	var valid []*ast.FuncDecl
+	// handledBy are the methods handling URLs of the receiver types
+	handledBy := make(map[string]string)
 	for _, method := range methods {
+		if method.Recv == nil {
+			addErr(method.Doc.Pos(), fmt.Errorf("apigen:api function %s must be a method", GetMethodName(method)))
+			continue
+		}
 		cfg, err := parseMethodConfig(method)
 		if err != nil {
-			return nil, err
+			addErr(method.Doc.Pos(), fmt.Errorf("bad apigen:api config of %s: %s", GetMethodName(method), err))
+			continue
+		}
+		route := GetMethodRecvTypeName(method) + " " + cfg.URL
+		if other, ok := handledBy[route]; ok {
+			addErr(method.Doc.Pos(), fmt.Errorf("%s of %s is already handled by %s", cfg.URL, GetMethodName(method), other))
+			continue
+		}
+		handledBy[route] = GetMethodName(method)
+		if err := checkMethodParams(method); err != nil {
+			addErr(method.Type.Params.Pos(), err)
+			continue
+		}
+		if results := method.Type.Results; results == nil || len(results.List) != results.NumFields() ||
+			results.NumFields() != 2 && !ReturnsMeta(method) {
+			addErr(method.Type.Params.End(), fmt.Errorf("method %s must return the result, optional ResponseMeta and error", GetMethodName(method)))
+			continue
+		}
+		if ReturnsMeta(method) && typeSpecs["ResponseMeta"] != nil {
+			addErr(typeSpecs["ResponseMeta"].Pos(), fmt.Errorf("ResponseMeta is declared by generated code"))
+			continue
 		}
-		methodConfigs[GetMethodName(method)] = cfg
-	}
-	fieldConfigs := make(map[string]map[string]*fieldConfig)
-	for _, method := range methods {
 		// skip first parameter (ctx)
 		expr := getMethodParamTypeExpr(method, 1)
-		paramStruct := getStructTypeFromExpr(expr)
-		paramTypeName := GetMethodParamTypeName(method, 1)
-		_, ok := fieldConfigs[paramTypeName]
-		if ok {
+		paramStruct, err := getStructTypeFromExpr(expr, typeSpecs)
+		if err != nil {
+			addErr(expr.Pos(), err)
 			continue
 		}
-		fieldConfigs[paramTypeName] = make(map[string]*fieldConfig)
-		for _, field := range paramStruct.Fields.List {
-			cfg, err := parseFieldConfig(field)
-			if err != nil {
-				return nil, err
-			}
-			if cfg == nil {
-				continue
+		methodConfigs[methodKey(method)] = cfg
+		valid = append(valid, method)
+		paramTypeName := GetMethodParamTypeName(method, 1)
+		addStruct(paramTypeName, paramStruct)
+	}
+	// conflicts are checked with the fields without errors, so all errors
+	// are found in one pass
+	data := &tmplData{
+		PackageName: pkgName,
+		Methods:     valid,
+		MethodsCfg:  methodConfigs,
+		StructsCfg:  fieldConfigs,
+		Structs:     structs,
+		Imports:     customImports,
+		Principals:  principals,
+	}
+	// named are the param types with checked names
+	named := make(map[string]bool)
+	for _, method := range valid {
+		paramTypeName := GetMethodParamTypeName(method, 1)
+		if !named[paramTypeName] {
+			named[paramTypeName] = true
+			for _, err := range checkParamNames(data.GetParams(paramTypeName)) {
+				addErr(getMethodParamTypeExpr(method, 1).Pos(), fmt.Errorf("%s: %s", paramTypeName, err))
 			}
-			fieldConfigs[paramTypeName][field.Names[0].Name] = cfg
+		}
+		if data.HasSource(paramTypeName, "form") && data.HasSource(paramTypeName, "json") {
+			addErr(getMethodParamTypeExpr(method, 1).Pos(), fmt.Errorf("params of %s can't be read from both form and json body", paramTypeName))
+		}
+		if data.HasFiles(paramTypeName) && data.HasSource(paramTypeName, "json") {
+			addErr(getMethodParamTypeExpr(method, 1).Pos(), fmt.Errorf("params of %s can't have both files and json body", paramTypeName))
+		}
+		if recvType := GetMethodRecvTypeName(method); data.GetMethodConfig(method).HasContext("principal") && principals[recvType] == "" {
+			addErr(method.Pos(), fmt.Errorf("principal of %s requires Authorize of %s returning the principal and the error", GetMethodName(method), recvType))
 		}
 	}
-	return &tmplData{pkgName, methods, methodConfigs, fieldConfigs}, nil
+	if len(errs) > 0 {
+		errs.Sort()
+		return nil, errs
+	}
+	return data, nil
 }
 
+// tagRegexp matches the value of apivalidator tag
+var tagRegexp = regexp.MustCompile(`apivalidator:"(([^\\]*?)|(.*?[^\\]))"`)
+
 func parseFieldConfig(field *ast.Field) (*fieldConfig, error) {
 	if field.Tag == nil || !strings.HasPrefix(field.Tag.Value, "`apivalidator:") {
 		return nil, nil
 
 	}
 	tag := field.Tag.Value
-	r, _ := regexp.Compile(`apivalidator:"(([^\\]*?)|(.*?[^\\]))"`)
-	submatch := r.FindStringSubmatch(tag)
+	submatch := tagRegexp.FindStringSubmatch(tag)
 	if len(submatch) == 0 {
 		return nil, fmt.Errorf("Non valid tag: %s", tag)
 	}
 	cfg := fieldConfig{}
-	for _, token := range strings.Split(submatch[1], ",") {
+	var err error
+	cfg.Type, cfg.Optional, err = getFieldType(field.Type)
+	if err != nil {
+		return nil, err
+	}
+	// exactLen of len=N token, it's -1 without it
+	exactLen := -1
+	// minToken and maxToken are parsed after the layout of times
+	var minToken, maxToken string
+	tokens := strings.Split(submatch[1], ",")
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
 		switch {
 		case strings.HasPrefix(token, "required"):
 			cfg.Required = true
 		case strings.HasPrefix(token, "paramname"):
-			cfg.Alias = strings.Split(token, "=")[1]
+			if cfg.Alias, err = tokenValue(token); err != nil {
+				return nil, err
+			}
 		case strings.HasPrefix(token, "enum"):
-			vals := strings.Split(token, "=")[1]
+			vals, err := tokenValue(token)
+			if err != nil {
+				return nil, err
+			}
 			for _, v := range strings.Split(vals, "|") {
 				cfg.Enum = append(cfg.Enum, v)
 			}
-		case strings.HasPrefix(token, "min"):
-			cfg.HasMin = true
-			min, err := strconv.Atoi(strings.Split(token, "=")[1])
-			if err != nil {
+		case strings.HasPrefix(token, "maxlen="):
+			if cfg.MaxLen, err = parseLength(token); err != nil {
 				return nil, err
 			}
-			cfg.Min = min
-		case strings.HasPrefix(token, "max"):
-			cfg.HasMax = true
-			max, err := strconv.Atoi(strings.Split(token, "=")[1])
+			cfg.HasMaxLen = true
+		case strings.HasPrefix(token, "len="):
+			if exactLen, err = parseLength(token); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "prefix="):
+			if cfg.Prefix, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "suffix="):
+			if cfg.Suffix, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "maxsize="):
+			if cfg.MaxSize, err = parseSize(token); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "content_type="):
+			contentTypes, err := tokenValue(token)
 			if err != nil {
 				return nil, err
 			}
-			cfg.Max = max
+			cfg.ContentTypes = strings.Split(contentTypes, "|")
+		case strings.HasPrefix(token, "source="):
+			if cfg.Source, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+			if !sources[cfg.Source] {
+				return nil, fmt.Errorf("unknown source: %s", cfg.Source)
+			}
+		case strings.HasPrefix(token, "custom="):
+			if cfg.Custom, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "charset="):
+			if cfg.Charset, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+			if !charsets[cfg.Charset] {
+				return nil, fmt.Errorf("unknown charset: %s", cfg.Charset)
+			}
+		case strings.HasPrefix(token, "layout="):
+			if cfg.Layout, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "min"):
+			cfg.HasMin, minToken = true, token
+		case strings.HasPrefix(token, "max"):
+			cfg.HasMax, maxToken = true, token
 		case strings.HasPrefix(token, "default"):
-			cfg.Default = strings.Split(token, "=")[1]
+			if cfg.Default, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(token, "regexp="):
+			// the pattern may have commas, so it's the rest of the tag
+			pattern := strings.TrimPrefix(strings.Join(tokens[i:], ","), "regexp=")
+			if cfg.Regexp, err = parsePattern(pattern); err != nil {
+				return nil, err
+			}
+			i = len(tokens)
+		case formats[token]:
+			cfg.Format = token
 		default:
-			panic(fmt.Sprintf("unknown token: %s", token))
+			return nil, fmt.Errorf("unknown token: %s", token)
+		}
+	}
+	if cfg.Layout != "" && cfg.Type != "time.Time" {
+		return nil, fmt.Errorf("layout is supported for time.Time only")
+	}
+	if cfg.Type == "time.Time" {
+		if cfg.Layout, err = parseLayout(cfg.Layout); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HasMin {
+		if cfg.Min, err = parseBound(minToken, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HasMax {
+		if cfg.Max, err = parseBound(maxToken, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.HasMin && cfg.HasMax && cfg.Min > cfg.Max {
+		return nil, fmt.Errorf("min is greater than max")
+	}
+	switch {
+	case strings.HasPrefix(cfg.Default, "env:"):
+		cfg.DefaultEnv, cfg.Default = strings.TrimPrefix(cfg.Default, "env:"), ""
+		if cfg.DefaultEnv == "" {
+			return nil, fmt.Errorf("default env has no variable name")
 		}
+	case strings.HasPrefix(cfg.Default, "const:"):
+		cfg.DefaultConst, cfg.Default = strings.TrimPrefix(cfg.Default, "const:"), ""
+		if !token.IsIdentifier(cfg.DefaultConst) {
+			return nil, fmt.Errorf("default const is not a name: %s", cfg.DefaultConst)
+		}
+	}
+	if cfg.Custom != "" {
+		for _, name := range strings.SplitN(cfg.Custom, ".", 2) {
+			if !token.IsIdentifier(name) {
+				return nil, fmt.Errorf("custom validator is not a function name: %s", cfg.Custom)
+			}
+		}
+	}
+	if (cfg.MaxSize > 0 || len(cfg.ContentTypes) > 0) && cfg.Type != "file" {
+		return nil, fmt.Errorf("maxsize and content_type are supported for files only")
+	}
+	if cfg.Type == "file" && (cfg.HasMin || cfg.HasMax || len(cfg.Enum) > 0 || cfg.Default != "" || cfg.DefaultEnv != "" || cfg.DefaultConst != "" || cfg.Regexp != "" || cfg.Format != "" ||
+		cfg.HasMaxLen || exactLen >= 0 || cfg.Prefix != "" || cfg.Suffix != "" || cfg.Charset != "") {
+		return nil, fmt.Errorf("files support required, paramname, maxsize and content_type only")
+	}
+	if cfg.Type == "file" && cfg.Source != "" && cfg.Source != "form" {
+		return nil, fmt.Errorf("files are read from multipart form only")
+	}
+	if cfg.Type == "bool" && (cfg.HasMin || cfg.HasMax) {
+		return nil, fmt.Errorf("min and max are not supported for bool")
+	}
+	if (cfg.Regexp != "" || cfg.Format != "") && cfg.Type != "string" && cfg.Type != "[]string" {
+		return nil, fmt.Errorf("regexp and formats are supported for string and []string only")
+	}
+	if (cfg.HasMaxLen || exactLen >= 0 || cfg.Prefix != "" || cfg.Suffix != "" || cfg.Charset != "") && cfg.Type != "string" {
+		return nil, fmt.Errorf("maxlen, len, prefix, suffix and charset are supported for string only")
+	}
+	if exactLen >= 0 {
+		if cfg.HasMin || cfg.HasMaxLen {
+			return nil, fmt.Errorf("len can't be used with min or maxlen")
+		}
+		cfg.HasMin, cfg.Min = true, float64(exactLen)
+		cfg.HasMaxLen, cfg.MaxLen = true, exactLen
+	}
+	if cfg.HasMin && cfg.HasMaxLen && int(cfg.Min) > cfg.MaxLen {
+		return nil, fmt.Errorf("min length is greater than maxlen")
+	}
+	if cfg.Required && (cfg.Default != "" || cfg.DefaultEnv != "" || cfg.DefaultConst != "") {
+		return nil, fmt.Errorf("required fields can't have a default")
+	}
+	if err := checkValues(&cfg); err != nil {
+		return nil, err
 	}
 	if len(cfg.Alias) == 0 {
 		cfg.Alias = strings.ToLower(field.Names[0].Name)
@@ -269,6 +1071,257 @@ func parseFieldConfig(field *ast.Field) (*fieldConfig, error) {
 	return &cfg, nil
 }
 
+// checkValues checks that the enum values and the default of the field
+// pass its rules, otherwise they would be rejected at runtime
+func checkValues(cfg *fieldConfig) error {
+	for _, value := range cfg.Enum {
+		item := *cfg
+		item.Enum = nil
+		if cfg.Type == "[]string" {
+			// min and max of slices are the counts of items
+			item.HasMin, item.HasMax = false, false
+		}
+		if rule := failedRule(&item, value); rule != "" && rule != "custom" {
+			return fmt.Errorf("enum value %q fails %s rule", value, rule)
+		}
+	}
+	if cfg.Default == "" {
+		return nil
+	}
+	if rule := failedRule(cfg, cfg.Default); rule != "" && rule != "custom" {
+		return fmt.Errorf("default %q fails %s rule", cfg.Default, rule)
+	}
+	return nil
+}
+
+// checkParamNames checks that params of the struct, including the ones of
+// nested and embedded structs, have unique names, names of headers are
+// compared in their canonical form
+func checkParamNames(params []param) []error {
+	var errs []error
+	paths := make(map[string]string)
+	for _, p := range params {
+		key := p.Name
+		if p.Cfg.Source == "header" {
+			key = textproto.CanonicalMIMEHeaderKey(p.Name)
+		}
+		if other, ok := paths[key]; ok {
+			errs = append(errs, fmt.Errorf("params %s and %s have the same name %s", other, p.Path, p.Name))
+			continue
+		}
+		paths[key] = p.Path
+	}
+	return errs
+}
+
+// EnumConstName is the name of the constant of the enum value, the value
+// is in camel case after the type name
+func EnumConstName(typeName, value string) string {
+	name := typeName
+	upper := true
+	for _, c := range value {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			if upper {
+				c = unicode.ToUpper(c)
+			}
+			name += string(c)
+			upper = false
+		default:
+			// separators are dropped, the next letter is in upper case
+			upper = true
+		}
+	}
+	return name
+}
+
+// checkEnumType checks that the enum type and its constants don't clash
+// with the types of the package and other enums
+func checkEnumType(cfg *fieldConfig, typeSpecs map[string]*ast.TypeSpec, enumTypes map[string]bool) error {
+	if typeSpecs[cfg.EnumType] != nil || enumTypes[cfg.EnumType] {
+		return fmt.Errorf("enum type %s is already declared", cfg.EnumType)
+	}
+	names := map[string]bool{cfg.EnumType: true}
+	for _, value := range cfg.Enum {
+		name := EnumConstName(cfg.EnumType, value)
+		if names[name] || typeSpecs[name] != nil || enumTypes[name] {
+			return fmt.Errorf("enum value %q can't be the constant %s", value, name)
+		}
+		names[name] = true
+	}
+	for name := range names {
+		enumTypes[name] = true
+	}
+	return nil
+}
+
+// getNestedStruct returns the struct type of the package the field is,
+// nil for fields of other types. Embedded fields must be such structs.
+func getNestedStruct(field *ast.Field, typeSpecs map[string]*ast.TypeSpec) (string, *ast.StructType, error) {
+	expr := field.Type
+	star, pointer := expr.(*ast.StarExpr)
+	if pointer {
+		expr = star.X
+	}
+	ident, ok := expr.(*ast.Ident)
+	var st *ast.StructType
+	if ok && typeSpecs[ident.Name] != nil {
+		st, _ = typeSpecs[ident.Name].Type.(*ast.StructType)
+	}
+	switch {
+	case st == nil && len(field.Names) == 0:
+		return "", nil, fmt.Errorf("embedded fields must be structs of the package")
+	case st == nil:
+		return "", nil, nil
+	case pointer:
+		return "", nil, fmt.Errorf("pointers to structs are not supported")
+	}
+	return ident.Name, st, nil
+}
+
+// parseStructFieldConfig returns the config of nested struct field, the
+// tag is optional and may have paramname only, embedded fields can't be
+// renamed
+func parseStructFieldConfig(field *ast.Field, structName string) (*fieldConfig, error) {
+	cfg := fieldConfig{Type: "struct", Struct: structName, Embedded: len(field.Names) == 0}
+	if field.Tag != nil && strings.HasPrefix(field.Tag.Value, "`apivalidator:") {
+		submatch := tagRegexp.FindStringSubmatch(field.Tag.Value)
+		if len(submatch) == 0 {
+			return nil, fmt.Errorf("Non valid tag: %s", field.Tag.Value)
+		}
+		for _, token := range strings.Split(submatch[1], ",") {
+			if !strings.HasPrefix(token, "paramname") || cfg.Embedded {
+				return nil, fmt.Errorf("unsupported token of struct field: %s", token)
+			}
+			var err error
+			if cfg.Alias, err = tokenValue(token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.Alias == "" && !cfg.Embedded {
+		cfg.Alias = strings.ToLower(field.Names[0].Name)
+	}
+	return &cfg, nil
+}
+
+// tokenValue returns the value of name=value token
+func tokenValue(token string) (string, error) {
+	parts := strings.SplitN(token, "=", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("token %s has no value", token)
+	}
+	return parts[1], nil
+}
+
+// parsePattern unquotes the pattern escaped as the tag is and checks it
+func parsePattern(pattern string) (string, error) {
+	pattern, err := strconv.Unquote(`"` + pattern + `"`)
+	if err != nil {
+		return "", fmt.Errorf("bad regexp escaping: %s", err)
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", err
+	}
+	return pattern, nil
+}
+
+// parseSize returns the size of maxsize=N token, it's in bytes or in KB
+// and MB with these suffixes
+func parseSize(token string) (int64, error) {
+	value, err := tokenValue(token)
+	if err != nil {
+		return 0, err
+	}
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(value, "KB"):
+		value, unit = strings.TrimSuffix(value, "KB"), 1<<10
+	case strings.HasSuffix(value, "MB"):
+		value, unit = strings.TrimSuffix(value, "MB"), 1<<20
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("bad size of %s", token)
+	}
+	return size * unit, nil
+}
+
+// parseLength returns the length of maxlen=N and len=N tokens
+func parseLength(token string) (int, error) {
+	value, err := tokenValue(token)
+	if err != nil {
+		return 0, err
+	}
+	length, err := strconv.Atoi(value)
+	if err != nil || length < 0 {
+		return 0, fmt.Errorf("bad length of %s", token)
+	}
+	return length, nil
+}
+
+// parseBound parses min or max token, it's the length for string and the
+// number of items for []string, unix seconds for time.Time
+func parseBound(token string, cfg *fieldConfig) (float64, error) {
+	value, err := tokenValue(token)
+	if err != nil {
+		return 0, err
+	}
+	switch cfg.Type {
+	case "float64":
+		return strconv.ParseFloat(value, 64)
+	case "time.Time":
+		t, err := parseTime(cfg.Layout, value)
+		if err != nil {
+			return 0, fmt.Errorf("bound of %s is not in layout %s", token, cfg.Layout)
+		}
+		return float64(t.Unix()), nil
+	case "uint", "uint64":
+		bound, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if bound > maxExactBound {
+			return 0, fmt.Errorf("bound of %s is greater than %d", token, uint64(maxExactBound))
+		}
+		return float64(bound), nil
+	}
+	bound, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if bound > maxExactBound || bound < -maxExactBound {
+		return 0, fmt.Errorf("bound of %s is out of range from %d to %d", token, -maxExactBound, maxExactBound)
+	}
+	return float64(bound), nil
+}
+
+// parseLayout returns the layout of the time package of layout=Name token,
+// RFC3339 if it's empty. Other layouts must have elements of times.
+func parseLayout(name string) (string, error) {
+	if name == "" {
+		return time.RFC3339, nil
+	}
+	if layout, ok := timeLayouts[name]; ok {
+		return layout, nil
+	}
+	// the layout is kept by the format without elements
+	if time.Date(2001, 3, 4, 5, 6, 7, 0, time.UTC).Format(name) == name {
+		return "", fmt.Errorf("layout %s has no elements of times", name)
+	}
+	return name, nil
+}
+
+// parseTime parses the value in the layout like generated handlers do, unix
+// is seconds since the epoch
+func parseTime(layout, value string) (time.Time, error) {
+	if layout == "unix" {
+		sec, err := strconv.ParseInt(value, 10, 64)
+		return time.Unix(sec, 0).UTC(), err
+	}
+	return time.Parse(layout, value)
+}
+
 func getMethodParamTypeExpr(method *ast.FuncDecl, idx int) ast.Expr {
 	checkMethodParamIdx(method, idx)
 	return method.Type.Params.List[idx].Type
@@ -279,11 +1332,27 @@ func (mw *mWalker) Visit(n ast.Node) ast.Visitor {
 		return nil
 	}
 
+	if spec, ok := n.(*ast.TypeSpec); ok {
+		if iface, ok := spec.Type.(*ast.InterfaceType); ok {
+			mw.visitInterface(spec.Name.Name, iface)
+		}
+		return mw
+	}
 	f, ok := n.(*ast.FuncDecl)
-	if !ok || f.Recv == nil {
-		// skip functions without recievers
+	if !ok {
 		return mw
 	}
+	if f.Recv == nil {
+		// functions without recievers are reported if they have apigen
+		// comment
+		if strings.HasPrefix(f.Doc.Text(), "apigen:api") {
+			mw.methods = append(mw.methods, f)
+		}
+		return mw
+	}
+	if f.Name.Name == "Authorize" && f.Type.Results.NumFields() == 2 {
+		mw.principals[GetMethodRecvTypeName(f)] = types.ExprString(f.Type.Results.List[0].Type)
+	}
 	if !strings.HasPrefix(f.Doc.Text(), "apigen:api") {
 		// skip methods without apigen comment
 		return mw
@@ -293,43 +1362,210 @@ func (mw *mWalker) Visit(n ast.Node) ast.Visitor {
 	return mw
 }
 
-func parseArgs(args []string) (src, dst string, err error) {
-	if len(args) < 3 {
+// visitInterface adds the methods of the interface with apigen comments,
+// they are declarations without bodies of the interface receiver
+func (mw *mWalker) visitInterface(name string, iface *ast.InterfaceType) {
+	for _, field := range iface.Methods.List {
+		fn, ok := field.Type.(*ast.FuncType)
+		if !ok || len(field.Names) == 0 {
+			// skip embedded interfaces
+			continue
+		}
+		f := &ast.FuncDecl{
+			Doc:  field.Doc,
+			Recv: &ast.FieldList{List: []*ast.Field{{Type: &ast.Ident{NamePos: field.Pos(), Name: name}}}},
+			Name: field.Names[0],
+			Type: fn,
+		}
+		if f.Name.Name == "Authorize" && f.Type.Results.NumFields() == 2 {
+			mw.principals[name] = types.ExprString(f.Type.Results.List[0].Type)
+		}
+		if !strings.HasPrefix(f.Doc.Text(), "apigen:api") {
+			continue
+		}
+		mw.interfaces[name] = true
+		mw.methods = append(mw.methods, f)
+	}
+}
+
+// parseArgs returns source files or package directories and the file to
+// write, it's the last argument. args are the ones left after flags, they
+// are replaced by comma separated src and dst of flags, like in
+// go:generate directives.
+func parseArgs(args []string, src, dst string) (srcs []string, dstFile string, err error) {
+	if src != "" || dst != "" {
+		if src == "" || dst == "" || len(args) > 0 {
+			err = fmt.Errorf("-src and -dst must be set together without arguments")
+			return
+		}
+		return strings.Split(src, ","), dst, nil
+	}
+	if len(args) < 2 {
 		err = fmt.Errorf("not enouth arguments")
 		return
 	}
-	src = args[1]
-	dst = args[2]
+	srcs = args[:len(args)-1]
+	dstFile = args[len(args)-1]
 	return
 }
 
-func parseSrc(src string) (data *tmplData, err error) {
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, src, nil, parser.ParseComments)
+// sourceFiles expands directories of srcs to their go files, test files
+// and dst are skipped
+func sourceFiles(srcs []string, dst string) ([]string, error) {
+	var files []string
+	for _, src := range srcs {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, src)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(src, "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range matches {
+			if strings.HasSuffix(file, "_test.go") || sameFile(file, dst) {
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
 	if err != nil {
-		return
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
+
+// parseSrc parses files of one package, methods and their param types
+// may be declared in any of them. Files of other packages than pkg are
+// skipped if it's set, so are outputs of the generator.
+func parseSrc(srcs []string, dst, pkg string) (data *tmplData, err error) {
+	files, err := sourceFiles(srcs, dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no go files in %s", strings.Join(srcs, ", "))
+	}
+	fset := token.NewFileSet()
+	mw := mWalker{principals: make(map[string]string), interfaces: make(map[string]bool)}
+	typeSpecs := make(map[string]*ast.TypeSpec)
+	constructors := make(map[string]bool)
+	imports := make(map[string]string)
+	pkgName := ""
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(src, []byte("// "+generatedNote)) {
+			continue
+		}
+		node, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if pkg != "" && getPackageName(node) != pkg {
+			continue
+		}
+		if pkgName == "" {
+			pkgName = getPackageName(node)
+		} else if pkgName != getPackageName(node) {
+			return nil, fmt.Errorf("%s: package %s, expected %s", file, getPackageName(node), pkgName)
+		}
+		ast.Walk(&mw, node)
+		collectTypes(node, typeSpecs)
+		collectConstructors(node, constructors)
+		collectImports(node, imports)
+	}
+	if pkgName == "" {
+		return nil, fmt.Errorf("no source files of package %s in %s", pkg, strings.Join(srcs, ", "))
 	}
-	mw := mWalker{}
-	ast.Walk(&mw, node)
-	tmplData, err := newTmplDataFrom(mw.methods, getPackageName(node))
+	tmplData, err := newTmplDataFrom(fset, mw.methods, mw.principals, typeSpecs, imports, pkgName)
 	if err != nil {
 		return nil, err
 	}
+	for name := range mw.interfaces {
+		if spec, ok := typeSpecs[name+"Handler"]; ok {
+			return nil, fmt.Errorf("%s: %sHandler is the handler of the interface %s, rename the type", fset.Position(spec.Pos()), name, name)
+		}
+	}
+	tmplData.Constructors = constructors
+	tmplData.Interfaces = mw.interfaces
+	tmplData.Types = typeSpecs
 	return tmplData, nil
 }
 
-func generateCode(buf bytes.Buffer, data *tmplData) (bytes.Buffer, error) {
+// collectTypes adds type declarations of the file to typeSpecs
+func collectTypes(file *ast.File, typeSpecs map[string]*ast.TypeSpec) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			typeSpecs[typeSpec.Name.Name] = typeSpec
+		}
+	}
+}
+
+// collectImports adds paths of the imports of the file by their names to
+// imports, the name is the last element of the path if it's not set
+func collectImports(file *ast.File, imports map[string]string) {
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = importPath
+	}
+}
+
+// collectConstructors adds NewT functions of the file returning *T or T
+// without params to constructors
+func collectConstructors(file *ast.File, constructors map[string]bool) {
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || !strings.HasPrefix(fn.Name.Name, "New") ||
+			fn.Type.Params.NumFields() != 0 || fn.Type.Results.NumFields() != 1 {
+			continue
+		}
+		if result := strings.TrimPrefix(types.ExprString(fn.Type.Results.List[0].Type), "*"); result == strings.TrimPrefix(fn.Name.Name, "New") {
+			constructors[fn.Name.Name] = true
+		}
+	}
+}
+
+// generateCode executes the handlers template, dir is the directory of
+// templates overriding the default one, it's empty to use the default
+func generateCode(buf bytes.Buffer, data *tmplData, dir string) (bytes.Buffer, error) {
 	funcMap := make(template.FuncMap)
-	funcMap["GetStructTypes"] = GetStructTypes
 	funcMap["GetStructFields"] = GetStructFields
-	funcMap["GetFieldTypeName"] = GetFieldTypeName
 	funcMap["GetRecvTypes"] = GetRecvTypes
 	funcMap["GetMethodName"] = GetMethodName
 	funcMap["GetMethodParamTypeName"] = GetMethodParamTypeName
 	funcMap["GetMethodRecvName"] = GetMethodRecvName
+	funcMap["EnumConstName"] = EnumConstName
+	funcMap["ReturnsMeta"] = ReturnsMeta
 
-	tmpl := template.New("handlers").Funcs(funcMap)
-	tmpl, err := tmpl.Parse(tmplHandlers)
+	tmpl, err := loadTemplate(dir, "handlers", tmplHandlers, funcMap)
 	if err != nil {
 		return buf, err
 	}
@@ -340,6 +1576,164 @@ func generateCode(buf bytes.Buffer, data *tmplData) (bytes.Buffer, error) {
 	return buf, nil
 }
 
+// generateParts executes the handlers template for the shared runtime and
+// for each receiver type, the code of receivers is keyed by their names
+func generateParts(data *tmplData, dir string) (bytes.Buffer, map[string]bytes.Buffer, error) {
+	parts := make(map[string]bytes.Buffer)
+	for recvName := range GetRecvTypes(data.Methods) {
+		parts[recvName] = bytes.Buffer{}
+	}
+	parts[""] = bytes.Buffer{}
+	for name := range parts {
+		part := *data
+		part.Split, part.Part = true, name
+		buf, err := generateCode(bytes.Buffer{}, &part, dir)
+		if err != nil {
+			return buf, nil, err
+		}
+		if buf, err = pruneImports(buf); err != nil {
+			return buf, nil, err
+		}
+		parts[name] = buf
+	}
+	runtime := parts[""]
+	delete(parts, "")
+	return runtime, parts, nil
+}
+
+// pruneImports drops the imports the code doesn't use, the template
+// imports everything handlers may need. Packages are referred by their
+// names or the last elements of their paths.
+func pruneImports(buf bytes.Buffer) (bytes.Buffer, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", buf.Bytes(), 0)
+	if err != nil {
+		return buf, err
+	}
+	used := make(map[string]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		// names of packages aren't resolved to objects of the file
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok && ident.Obj == nil {
+				used[ident.Name] = true
+			}
+		}
+		return true
+	})
+	// unused are the lines of unused imports, there is one per line
+	unused := make(map[int]bool)
+	for _, imp := range file.Imports {
+		name := path.Base(strings.Trim(imp.Path.Value, `"`))
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		if !used[name] && name != "_" {
+			unused[fset.Position(imp.Pos()).Line] = true
+		}
+	}
+	out := bytes.Buffer{}
+	for i, line := range strings.SplitAfter(buf.String(), "\n") {
+		if !unused[i+1] {
+			out.WriteString(line)
+		}
+	}
+	return formatCode(out)
+}
+
+// partFile is the file of the handlers of the receiver type next to the
+// shared runtime
+func partFile(dstFile, recvName string) string {
+	return filepath.Join(filepath.Dir(dstFile), strings.ToLower(recvName)+"_handlers.go")
+}
+
+// staleParts are the generated *_handlers.go files of the directory
+// which aren't written, like the ones of removed receivers or of split
+// handlers generated in one file again
+func staleParts(dir string, written map[string]bool) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*_handlers.go"))
+	if err != nil {
+		return nil, err
+	}
+	var stale []string
+	for _, file := range files {
+		if written[file] {
+			continue
+		}
+		if src, err := ioutil.ReadFile(file); err != nil || !bytes.HasPrefix(src, []byte("// "+generatedNote)) {
+			continue
+		}
+		stale = append(stale, file)
+	}
+	return stale, nil
+}
+
+// removeStaleParts removes the stale parts of the directory
+func removeStaleParts(dir string, written map[string]bool) error {
+	stale, err := staleParts(dir, written)
+	if err != nil {
+		return err
+	}
+	for _, file := range stale {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateNames are the templates the generator executes, the files of
+// the templates directory named after them replace the default ones
+var templateNames = map[string]bool{
+	"handlers": true,
+	"client":   true,
+	"tests":    true,
+	"ts":       true,
+	"proto":    true,
+	"grpc":     true,
+}
+
+// loadTemplate parses the default text of the template, name.tmpl of dir
+// replaces it. Other *.tmpl files of dir are parsed after it, so they may
+// redefine its sub-templates like "auth" of the client only.
+func loadTemplate(dir, name, text string, funcMap template.FuncMap) (*template.Template, error) {
+	if dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("templates directory %s is not found", dir)
+		}
+		custom, err := ioutil.ReadFile(filepath.Join(dir, name+".tmpl"))
+		switch {
+		case err == nil:
+			text = string(custom)
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+	tmpl, err := template.New(name).Funcs(funcMap).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %s", name, err)
+	}
+	if dir == "" {
+		return tmpl, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if templateNames[strings.TrimSuffix(filepath.Base(file), ".tmpl")] {
+			continue
+		}
+		partial, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.New(filepath.Base(file)).Parse(string(partial)); err != nil {
+			return nil, fmt.Errorf("template %s: %s", file, err)
+		}
+	}
+	return tmpl, nil
+}
+
 func formatCode(buf bytes.Buffer) (bytes.Buffer, error) {
 	formatted, err := format.Source(buf.Bytes())
 	if err != nil {
@@ -348,42 +1742,221 @@ func formatCode(buf bytes.Buffer) (bytes.Buffer, error) {
 	return *bytes.NewBuffer(formatted), nil
 }
 
+// generatedNote starts outputs of the generator as the comment, go files
+// with it are skipped as sources
+const generatedNote = "Code generated by handlers_gen. DO NOT EDIT."
+
+// withNote returns the output starting with generatedNote in the comment
+// of the prefix
+func withNote(comment string, buf bytes.Buffer) bytes.Buffer {
+	out := bytes.NewBufferString(comment + " " + generatedNote + "\n\n")
+	out.Write(buf.Bytes())
+	return *out
+}
+
+// writeToFile writes the file unless it has the same content, so builds
+// depending on it aren't dirty after go generate
 func writeToFile(dst string, buf bytes.Buffer) error {
-	fd, err := os.Create(dst)
-	if err != nil {
-		return err
+	if old, err := ioutil.ReadFile(dst); err == nil && bytes.Equal(old, buf.Bytes()) {
+		return nil
 	}
-	_, err = fd.Write(buf.Bytes())
-	if err != nil {
-		return err
+	return ioutil.WriteFile(dst, buf.Bytes(), 0666)
+}
+
+// output is the generated file, nil content removes it
+type output struct {
+	file    string
+	content []byte
+}
+
+// writeOutputs writes and removes the files of the outputs
+func writeOutputs(outputs []output) error {
+	for _, out := range outputs {
+		if out.content == nil {
+			if err := os.Remove(out.file); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeToFile(out.file, *bytes.NewBuffer(out.content)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// checkErr prints all errors of the list one per line and exits
 func checkErr(err error) {
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-		panic(nil)
+		scanner.PrintError(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
 func run() {
+	openAPI := flag.String("openapi", "", "write OpenAPI 3 spec of the handlers to the yaml file")
+	client := flag.String("client", "", "write clients of the handlers to the go file of the same package")
+	api := flag.String("api", "", "document handlers of this type only in OpenAPI spec")
+	timeout := flag.Int("timeout-ms", 0, "timeout of methods without timeout_ms in their config, no timeout if it's 0")
+	maxBody := flag.Int("max-body-kb", 1024, "request body limit of methods without max_body_kb in their config, maxsize of files is added to it, no limit if it's 0")
+	structuredErrors := flag.Bool("structured-errors", false, "add error codes and all errors of params as {field, rule, message} to responses")
+	corsOrigins := flag.String("cors-origins", "", "comma separated origins allowed to call methods without cors_origins, * is any one")
+	instrument := flag.Bool("instrument", false, "send events of requests to APIInstrument implemented by the app")
+	gzipFlag := flag.Bool("gzip", false, "compress responses with gzip if clients accept it")
+	router := flag.String("router", "", "register handlers on http.ServeMux (mux, Go 1.22+) or chi.Router (chi) instead of ServeHTTP")
+	src := flag.String("src", "", "comma separated source files and package directories, instead of arguments for go:generate")
+	dst := flag.String("dst", "", "write handlers to the go file, instead of the last argument")
+	pkg := flag.String("pkg", os.Getenv("GOPACKAGE"), "parse files of the package only, the one of go:generate directive by default")
+	genTests := flag.String("gen-tests", "", "write table-driven tests of the handlers to the _test.go file of the same package")
+	split := flag.Bool("split", false, "write handlers of each receiver type to its recv_handlers.go file next to the handlers file, it keeps the shared runtime")
+	ts := flag.String("ts", "", "write TypeScript interfaces of params and results and fetch clients of the handlers to the ts file")
+	protoFile := flag.String("proto", "", "write services of receiver types with messages of params and results to the proto file (experimental)")
+	grpcFile := flag.String("grpc", "", "write gRPC servers of receiver types delegating to their methods to the go file of the same package, requires -grpc-pb (experimental)")
+	grpcPB := flag.String("grpc-pb", "", "import path of the code protoc generates of the proto file, its go_package")
+	diff := flag.Bool("diff", false, "print unified diffs of the existing files and the generated ones instead of writing them, exit with status 1 if they differ")
+	templates := flag.String("templates", "", "directory of handlers.tmpl, client.tmpl, tests.tmpl, ts.tmpl, proto.tmpl, grpc.tmpl and templates they use, replacing the default ones")
 	// parse args
-	src, dst, err := parseArgs(os.Args)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), "Usage: codegen [flags] src.go... dst.go\n\n"+
+			"The generated code requires Go 1.19, or Go 1.22 with -router mux.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	srcs, dstFile, err := parseArgs(flag.Args(), *src, *dst)
 	checkErr(err)
 	// parse source code
-	data, err := parseSrc(src)
+	data, err := parseSrc(srcs, dstFile, *pkg)
 	checkErr(err)
+	checkErr(data.setDefaultTimeout(*timeout))
+	checkErr(data.setDefaultMaxBody(*maxBody))
+	data.StructuredErrors = *structuredErrors
+	data.Instrument = *instrument
+	data.Gzip = *gzipFlag
+	if *corsOrigins != "" {
+		data.setDefaultCORS(strings.Split(*corsOrigins, ","))
+	}
+	if *router != "" && *router != "mux" && *router != "chi" {
+		checkErr(fmt.Errorf("unknown router %q, expected mux or chi", *router))
+	}
+	data.Router = *router
+	if *genTests != "" && !strings.HasSuffix(*genTests, "_test.go") {
+		checkErr(fmt.Errorf("tests file %s must end with _test.go", *genTests))
+	}
+	if *ts != "" && !strings.HasSuffix(*ts, ".ts") {
+		checkErr(fmt.Errorf("TypeScript file %s must end with .ts", *ts))
+	}
+	if *protoFile != "" && !strings.HasSuffix(*protoFile, ".proto") {
+		checkErr(fmt.Errorf("proto file %s must end with .proto", *protoFile))
+	}
+	if *grpcFile != "" && (!strings.HasSuffix(*grpcFile, ".go") || *grpcPB == "") {
+		checkErr(fmt.Errorf("gRPC file %s must end with .go and requires -grpc-pb", *grpcFile))
+	}
 	// prepare and execute template
 	buf := bytes.Buffer{}
-	buf, err = generateCode(buf, data)
-	checkErr(err)
-	// format output from template
-	buf, err = formatCode(buf)
-	checkErr(err)
+	var parts map[string]bytes.Buffer
+	if *split {
+		buf, parts, err = generateParts(data, *templates)
+		checkErr(err)
+		for recvName := range parts {
+			if partFile(dstFile, recvName) == filepath.Clean(dstFile) {
+				checkErr(fmt.Errorf("handlers of %s would overwrite the handlers file %s", recvName, dstFile))
+			}
+		}
+	} else {
+		buf, err = generateCode(buf, data, *templates)
+		checkErr(err)
+		// format output from template
+		buf, err = formatCode(buf)
+		checkErr(err)
+	}
+	// generate spec, clients and tests before writing anything
+	var spec []byte
+	if *openAPI != "" {
+		spec, err = generateOpenAPI(data, *api)
+		checkErr(err)
+	}
+	clientBuf := bytes.Buffer{}
+	if *client != "" {
+		clientBuf, err = generateClient(clientBuf, data, *templates)
+		checkErr(err)
+		clientBuf, err = formatCode(clientBuf)
+		checkErr(err)
+	}
+	testsBuf := bytes.Buffer{}
+	if *genTests != "" {
+		testsBuf, err = generateTests(testsBuf, data, *templates)
+		checkErr(err)
+		testsBuf, err = formatCode(testsBuf)
+		checkErr(err)
+	}
+	tsBuf := bytes.Buffer{}
+	if *ts != "" {
+		tsBuf, err = generateTS(tsBuf, data, *templates)
+		checkErr(err)
+	}
+	protoBuf, grpcBuf := bytes.Buffer{}, bytes.Buffer{}
+	if *protoFile != "" || *grpcFile != "" {
+		pd, err := newProtoData(data, *grpcPB)
+		checkErr(err)
+		if *protoFile != "" {
+			protoBuf, err = generateProto(protoBuf, pd, *templates)
+			checkErr(err)
+		}
+		if *grpcFile != "" {
+			grpcBuf, err = generateGRPC(grpcBuf, pd, *templates)
+			checkErr(err)
+			grpcBuf, err = formatCode(grpcBuf)
+			checkErr(err)
+		}
+	}
 	// write generated code
-	err = writeToFile(dst, buf)
+	note := func(comment string, buf bytes.Buffer) []byte {
+		out := withNote(comment, buf)
+		return out.Bytes()
+	}
+	outputs := []output{{dstFile, note("//", buf)}}
+	written := make(map[string]bool)
+	recvNames := make([]string, 0, len(parts))
+	for recvName := range parts {
+		recvNames = append(recvNames, recvName)
+	}
+	sort.Strings(recvNames)
+	for _, recvName := range recvNames {
+		file := partFile(dstFile, recvName)
+		outputs = append(outputs, output{file, note("//", parts[recvName])})
+		written[file] = true
+	}
+	stale, err := staleParts(filepath.Dir(dstFile), written)
 	checkErr(err)
+	for _, file := range stale {
+		outputs = append(outputs, output{file, nil})
+	}
+	if *openAPI != "" {
+		outputs = append(outputs, output{*openAPI, note("#", *bytes.NewBuffer(spec))})
+	}
+	for _, gen := range []struct {
+		file string
+		buf  bytes.Buffer
+	}{
+		{*client, clientBuf},
+		{*genTests, testsBuf},
+		{*ts, tsBuf},
+		{*protoFile, protoBuf},
+		{*grpcFile, grpcBuf},
+	} {
+		if gen.file != "" {
+			outputs = append(outputs, output{gen.file, note("//", gen.buf)})
+		}
+	}
+	if *diff {
+		differ, err := diffOutputs(os.Stdout, outputs)
+		checkErr(err)
+		if differ {
+			os.Exit(1)
+		}
+		return
+	}
+	checkErr(writeOutputs(outputs))
 }
 
 func main() {
@@ -394,21 +1967,120 @@ var tmplHandlers = `
 package {{.PackageName}}
 
 import (
+	{{- if .HasCache}}
+	"bytes"
+	{{- end}}
+	{{- if .Gzip}}
+	"compress/gzip"
+	{{- end}}
+	"context"
+	{{- if .HasContext "request_id"}}
+	"crypto/rand"
+	{{- end}}
+	"crypto/subtle"
+	{{- if .HasContext "request_id"}}
+	"encoding/hex"
+	{{- end}}
+	{{- if .HasBodyLimit}}
+	"errors"
+	{{- end}}
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	{{- if or .Gzip .HasCache}}
+	"sync"
+	{{- end}}
+	"time"
+	"unicode/utf8"
 	"encoding/json"
+	{{- range $name, $path := .Imports}}
+	{{$name}} {{printf "%q" $path}}
+	{{- end}}
 )
+{{if .InPart ""}}
+// os is used by defaults of environment only
+var _ = os.Getenv
 
 type APIResponse struct {
 	Error string ` + "`json:\"error\"`" + `
 	Response interface{} ` + "`json:\"response,omitempty\"`" + `
+	{{- if .StructuredErrors}}
+	// Code is the status of errors in snake case, like bad_request
+	Code string ` + "`json:\"code,omitempty\"`" + `
+	// Errors are the errors of params
+	Errors fieldErrors ` + "`json:\"errors,omitempty\"`" + `
+	{{- end}}
 }
 
+{{if .HasResponseMeta -}}
+// ResponseMeta is returned by methods between the result and the error to
+// set the status of successful responses, 200 if it's 0, and headers of
+// all ones, like Location or Cache-Control
+type ResponseMeta struct {
+	Status int
+	Header http.Header
+}
+
+{{end -}}
+// fieldError is the error of the param, Rule is the apivalidator token it
+// fails, or the type of the param
+type fieldError struct {
+	Field   string ` + "`json:\"field\"`" + `
+	Rule    string ` + "`json:\"rule\"`" + `
+	Message string ` + "`json:\"message\"`" + `
+}
+
+func (e fieldError) Error() string {
+	return e.Message
+}
+
+func newFieldError(fieldName, rule, format string, args ...interface{}) error {
+	return fieldError{fieldName, rule, fmt.Sprintf(format, args...)}
+}
+{{- if .StructuredErrors}}
+
+// fieldErrors are all errors of the params
+type fieldErrors []fieldError
+
+func (e fieldErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fe := range e {
+		messages = append(messages, fe.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// appendErrors appends the errors of nested structs and fields
+func appendErrors(errs fieldErrors, err error) fieldErrors {
+	switch e := err.(type) {
+	case fieldErrors:
+		return append(errs, e...)
+	case fieldError:
+		return append(errs, e)
+	}
+	return append(errs, fieldError{Message: err.Error()})
+}
+
+// errorCode is the code of the status, like not_found
+func errorCode(status int) string {
+	if text := http.StatusText(status); text != "" {
+		return strings.ToLower(strings.Replace(text, " ", "_", -1))
+	}
+	return "error"
+}
+{{- end}}
+
 func requiredCheck(fieldName, value string) error {
 	if len(value) == 0 {
-		return fmt.Errorf("%s must me not empty", fieldName)
+		return newFieldError(fieldName, "required", "%s must me not empty", fieldName)
 	}
 	return nil
 }
@@ -416,24 +2088,216 @@ func requiredCheck(fieldName, value string) error {
 func boundCheck(fieldName, value string, hasMin, hasMax bool, min, max int) (int, error) {
 	val, err := strconv.Atoi(value)
 	if err != nil {
-		return 0, fmt.Errorf("%s must be int", fieldName)
+		return 0, newFieldError(fieldName, "type", "%s must be int", fieldName)
+	}
+	if hasMin && val < min {
+		return 0, newFieldError(fieldName, "min", "%s must be >= %d", fieldName, min)
+	}
+	if hasMax && val > max {
+		return 0, newFieldError(fieldName, "max", "%s must be <= %d", fieldName, max)
+	}
+	return val, nil
+}
+
+func floatBoundCheck(fieldName, value string, hasMin, hasMax bool, min, max float64) (float64, error) {
+	val, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, newFieldError(fieldName, "type", "%s must be float", fieldName)
+	}
+	if hasMin && val < min {
+		return 0, newFieldError(fieldName, "min", "%s must be >= %v", fieldName, min)
+	}
+	if hasMax && val > max {
+		return 0, newFieldError(fieldName, "max", "%s must be <= %v", fieldName, max)
+	}
+	return val, nil
+}
+
+func int64BoundCheck(fieldName, value string, hasMin, hasMax bool, min, max int64) (int64, error) {
+	val, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, newFieldError(fieldName, "type", "%s must be int64", fieldName)
 	}
 	if hasMin && val < min {
-		return 0, fmt.Errorf("%s must be >= %d", fieldName, min)
+		return 0, newFieldError(fieldName, "min", "%s must be >= %d", fieldName, min)
 	}
 	if hasMax && val > max {
-		return 0, fmt.Errorf("%s must be <= %d", fieldName, max)
+		return 0, newFieldError(fieldName, "max", "%s must be <= %d", fieldName, max)
+	}
+	return val, nil
+}
+
+// uintBoundCheck parses unsigned ints of the bit size, it's
+// strconv.IntSize for uint
+func uintBoundCheck(fieldName, value string, bitSize int, hasMin, hasMax bool, min, max uint64) (uint64, error) {
+	val, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		return 0, newFieldError(fieldName, "type", "%s must be uint", fieldName)
+	}
+	if hasMin && val < min {
+		return 0, newFieldError(fieldName, "min", "%s must be >= %d", fieldName, min)
+	}
+	if hasMax && val > max {
+		return 0, newFieldError(fieldName, "max", "%s must be <= %d", fieldName, max)
+	}
+	return val, nil
+}
+
+// timeCheck parses the time in the layout, unix is seconds since the
+// epoch. min and max are unix seconds.
+func timeCheck(fieldName, value, layout string, hasMin, hasMax bool, min, max int64) (time.Time, error) {
+	var val time.Time
+	var err error
+	if layout == "unix" {
+		var sec int64
+		if sec, err = strconv.ParseInt(value, 10, 64); err == nil {
+			val = time.Unix(sec, 0).UTC()
+		}
+	} else {
+		val, err = time.Parse(layout, value)
+	}
+	if err != nil {
+		return val, newFieldError(fieldName, "type", "%s must be time in layout %s", fieldName, layout)
+	}
+	if hasMin && val.Before(time.Unix(min, 0)) {
+		return val, newFieldError(fieldName, "min", "%s must be >= %s", fieldName, formatTime(time.Unix(min, 0), layout))
+	}
+	if hasMax && val.After(time.Unix(max, 0)) {
+		return val, newFieldError(fieldName, "max", "%s must be <= %s", fieldName, formatTime(time.Unix(max, 0), layout))
 	}
 	return val, nil
 }
 
-func lenCheck(fieldName, value string, hasMin bool, min int) error {
-	if hasMin && len(value) < min {
-		return fmt.Errorf("%s len must be >= %d", fieldName, min)
+func formatTime(t time.Time, layout string) string {
+	if layout == "unix" {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return t.UTC().Format(layout)
+}
+
+func boolCheck(fieldName, value string) (bool, error) {
+	val, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, newFieldError(fieldName, "type", "%s must be bool", fieldName)
+	}
+	return val, nil
+}
+
+func lenCheck(fieldName, value string, hasMin, hasMax bool, min, max int) error {
+	if hasMin && hasMax && min == max && len(value) != min {
+		return newFieldError(fieldName, "len", "%s len must be %d", fieldName, min)
+	}
+	if hasMin && len(value) < min {
+		return newFieldError(fieldName, "min", "%s len must be >= %d", fieldName, min)
+	}
+	if hasMax && len(value) > max {
+		return newFieldError(fieldName, "maxlen", "%s len must be <= %d", fieldName, max)
+	}
+	return nil
+}
+
+// affixCheck checks the prefix and the suffix of non empty values
+func affixCheck(fieldName, value, prefix, suffix string) error {
+	if len(value) > 0 && !strings.HasPrefix(value, prefix) {
+		return newFieldError(fieldName, "prefix", "%s must start with %s", fieldName, prefix)
+	}
+	if len(value) > 0 && !strings.HasSuffix(value, suffix) {
+		return newFieldError(fieldName, "suffix", "%s must end with %s", fieldName, suffix)
+	}
+	return nil
+}
+
+// charsetCheck checks that all characters of the value are of alpha,
+// alnum, digit, hex or ascii charset
+func charsetCheck(fieldName, value, charset string) error {
+	for _, c := range value {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		digit := c >= '0' && c <= '9'
+		valid := false
+		switch charset {
+		case "alpha":
+			valid = letter
+		case "alnum":
+			valid = letter || digit
+		case "digit":
+			valid = digit
+		case "hex":
+			valid = digit || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+		case "ascii":
+			valid = c < utf8.RuneSelf
+		}
+		if !valid {
+			return newFieldError(fieldName, "charset", "%s must have %s characters only", fieldName, charset)
+		}
+	}
+	return nil
+}
+
+func listCheck(fieldName, value string, hasMin, hasMax bool, min, max int) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	if hasMin && len(items) < min {
+		return nil, newFieldError(fieldName, "min", "%s must have >= %d items", fieldName, min)
+	}
+	if hasMax && len(items) > max {
+		return nil, newFieldError(fieldName, "max", "%s must have <= %d items", fieldName, max)
+	}
+	return items, nil
+}
+
+var uuidRegexp = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// formatCheck checks non empty values of email, url or uuid format
+func formatCheck(fieldName, value, format string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	valid := false
+	switch format {
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		valid = err == nil && addr.Address == value
+	case "url":
+		u, err := url.Parse(value)
+		valid = err == nil && u.Scheme != "" && u.Host != ""
+	case "uuid":
+		valid = uuidRegexp.MatchString(value)
+	}
+	if !valid {
+		return newFieldError(fieldName, format, "%s must be %s", fieldName, format)
+	}
+	return nil
+}
+
+func regexpCheck(fieldName, value string, re *regexp.Regexp) error {
+	if len(value) > 0 && !re.MatchString(value) {
+		return newFieldError(fieldName, "regexp", "%s must match %s", fieldName, re)
 	}
 	return nil
 }
 
+func enumCheck(fieldName, value string, variants []string) error {
+	for _, v := range variants {
+		if value == v {
+			return nil
+		}
+	}
+	return enumError(fieldName, variants)
+}
+
+// customError is the error of the custom validator of the param
+func customError(fieldName string, err error) error {
+	return newFieldError(fieldName, "custom", "%s: %s", fieldName, err)
+}
+
+func enumError(fieldName string, variants []string) error {
+	return newFieldError(fieldName, "enum", "%s must be one of [%s]", fieldName, strings.Join(variants, ", "))
+}
+
 func newResponse(result interface{}, err error) []byte {
 	ar := APIResponse{}
 	if err != nil {
@@ -446,140 +2310,918 @@ func newResponse(result interface{}, err error) []byte {
 	}
 	return buf
 }
+{{end}}
+{{define "fail"}}{{if .StructuredErrors}}errs = appendErrors(errs, err){{else}}return err{{end}}{{end}}
 
-{{range $structName, $struct := GetStructTypes .Methods}}
-func validate{{$structName}}(p *{{$structName}}, r *http.Request) error {
+{{range $structName, $struct := .Structs}}
+{{- if $.InPart ($.StructRecv $structName)}}
+// validate{{$structName}} reads params with the prefix of names, it's
+// the path of the nested struct
+func validate{{$structName}}(p *{{$structName}}, r *http.Request, prefix string) error {
+	{{if $.StructuredErrors -}}
+	// all errors of the params are returned
+	var errs fieldErrors
+	{{end -}}
 	{{range $fieldName, $field := GetStructFields $struct -}}
-	if err := validate{{$structName}}{{$fieldName}}(p, r); err != nil {
-		return err
+	{{$fieldCfg := $.GetFieldConfig $structName $fieldName -}}
+	{{if $fieldCfg.Struct -}}
+	if err := validate{{$fieldCfg.Struct}}(&p.{{$fieldName}}, r, prefix{{if not $fieldCfg.Embedded}}+"{{$fieldCfg.Alias}}."{{end}}); err != nil {
+		{{template "fail" $}}
+	}
+	{{else -}}
+	if err := validate{{$structName}}{{$fieldName}}(p, r, prefix); err != nil {
+		{{template "fail" $}}
+	}
+	{{end -}}
+	{{end -}}
+	{{if $.StructuredErrors -}}
+	if len(errs) > 0 {
+		return errs
 	}
 	{{end -}}
 	return nil
 }
 {{end}}
+{{- end}}
 
-{{range $structName, $struct := GetStructTypes .Methods}}
+{{range $structName, $struct := .Structs}}
+{{- if $.InPart ($.StructRecv $structName)}}
 {{range $fieldName, $field := GetStructFields $struct}}
-func validate{{$structName}}{{$fieldName}}(p *{{$structName}}, r *http.Request) (err error) {
-	{{$fieldCfg := $.GetFieldConfig $structName $fieldName -}}
-	valueRaw := r.FormValue("{{$fieldCfg.Alias}}")
+{{- $fieldCfg := $.GetFieldConfig $structName $fieldName}}
+{{- if not $fieldCfg.Struct}}
+{{if $fieldCfg.EnumType -}}
+// {{$fieldCfg.EnumType}} is the value of {{$fieldCfg.Alias}} param of {{$structName}}
+type {{$fieldCfg.EnumType}} string
+
+const (
+	{{range $value := $fieldCfg.Enum -}}
+	{{EnumConstName $fieldCfg.EnumType $value}} {{$fieldCfg.EnumType}} = {{printf "%q" $value}}
+	{{end -}}
+)
+
+// IsValid reports whether the value is one of the constants
+func (v {{$fieldCfg.EnumType}}) IsValid() bool {
+	switch v {
+	case {{range $i, $value := $fieldCfg.Enum}}{{if $i}}, {{end}}{{EnumConstName $fieldCfg.EnumType $value}}{{end}}:
+		return true
+	}
+	return false
+}
+
+{{end -}}
+{{if $fieldCfg.Regexp -}}
+var regexp{{$structName}}{{$fieldName}} = regexp.MustCompile({{printf "%q" $fieldCfg.Regexp}})
+{{end -}}
+func validate{{$structName}}{{$fieldName}}(p *{{$structName}}, r *http.Request, prefix string) (err error) {
+	name := prefix + "{{$fieldCfg.Alias}}"
+	{{- if eq $fieldCfg.Type "file"}}
+	if p.{{$fieldName}}, err = fileCheck(r, name, {{$fieldCfg.Required}}, {{$fieldCfg.MaxSize}}, {{printf "%#v" $fieldCfg.ContentTypes}}); err != nil {
+		return err
+	}
+	{{- if $fieldCfg.Custom}}
+	if p.{{$fieldName}} != nil {
+		if err := {{$fieldCfg.Custom}}(p.{{$fieldName}}); err != nil {
+			return customError(name, err)
+		}
+	}
+	{{- end}}
+	return nil
+	{{- else}}
+	{{if eq $fieldCfg.Source "query" -}}
+	valueRaw := r.URL.Query().Get(name)
+	{{- else if eq $fieldCfg.Source "form" -}}
+	valueRaw := r.PostFormValue(name)
+	{{- else if eq $fieldCfg.Source "header" -}}
+	valueRaw := r.Header.Get(name)
+	{{- else if eq $fieldCfg.Source "json" -}}
+	valueRaw := jsonValue(r, name)
+	{{- else -}}
+	valueRaw := r.FormValue(name)
+	{{- end}}
 	// default case
 	if len(valueRaw) == 0 {
-		valueRaw = "{{$fieldCfg.Default}}"
+		{{if $fieldCfg.DefaultEnv -}}
+		valueRaw = os.Getenv({{printf "%q" $fieldCfg.DefaultEnv}})
+		{{- else if $fieldCfg.DefaultConst -}}
+		valueRaw = fmt.Sprint({{$fieldCfg.DefaultConst}})
+		{{- else -}}
+		valueRaw = {{printf "%q" $fieldCfg.Default}}
+		{{- end}}
 	}
 	{{if $fieldCfg.Required -}}
-	if err := requiredCheck("{{$fieldCfg.Alias}}", valueRaw); err != nil {
+	if err := requiredCheck(name, valueRaw); err != nil {
 		return err
 	}
 	{{end -}}
-	{{$fieldTypeName := GetFieldTypeName $field -}}
-	{{if eq $fieldTypeName "int" -}}
+	{{if $fieldCfg.Optional -}}
+	// optional field is left nil
+	if len(valueRaw) == 0 {
+		return nil
+	}
+	{{end -}}
+	{{if eq $fieldCfg.Type "int" -}}
 	var value int
-	if value, err = boundCheck("{{$fieldCfg.Alias}}", valueRaw, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMax}}, {{$fieldCfg.Min}}, {{$fieldCfg.Max}}); err != nil {
+	if value, err = boundCheck(name, valueRaw, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMax}}, {{$fieldCfg.Min}}, {{$fieldCfg.Max}}); err != nil {
+		return err
+	}
+	{{end -}}
+	{{if eq $fieldCfg.Type "int64" -}}
+	var value int64
+	if value, err = int64BoundCheck(name, valueRaw, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMax}}, {{$fieldCfg.Min}}, {{$fieldCfg.Max}}); err != nil {
+		return err
+	}
+	{{end -}}
+	{{if or (eq $fieldCfg.Type "uint") (eq $fieldCfg.Type "uint64") -}}
+	var value64 uint64
+	if value64, err = uintBoundCheck(name, valueRaw, {{if eq $fieldCfg.Type "uint"}}strconv.IntSize{{else}}64{{end}}, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMax}}, {{$fieldCfg.Min}}, {{$fieldCfg.Max}}); err != nil {
+		return err
+	}
+	value := {{$fieldCfg.Type}}(value64)
+	{{end -}}
+	{{if eq $fieldCfg.Type "time.Time" -}}
+	var value time.Time
+	if value, err = timeCheck(name, valueRaw, {{printf "%q" $fieldCfg.Layout}}, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMax}}, {{$fieldCfg.Min}}, {{$fieldCfg.Max}}); err != nil {
+		return err
+	}
+	{{end -}}
+	{{if eq $fieldCfg.Type "float64" -}}
+	var value float64
+	if value, err = floatBoundCheck(name, valueRaw, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMax}}, {{$fieldCfg.Min}}, {{$fieldCfg.Max}}); err != nil {
+		return err
+	}
+	{{end -}}
+	{{if eq $fieldCfg.Type "bool" -}}
+	var value bool
+	if value, err = boolCheck(name, valueRaw); err != nil {
 		return err
 	}
 	{{end -}}
-	{{if eq $fieldTypeName "string" -}}
-	if err := lenCheck("{{$fieldCfg.Alias}}", valueRaw, {{$fieldCfg.HasMin}}, {{$fieldCfg.Min}}); err != nil {
+	{{if eq $fieldCfg.Type "string" -}}
+	if err := lenCheck(name, valueRaw, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMaxLen}}, {{$fieldCfg.Min}}, {{$fieldCfg.MaxLen}}); err != nil {
 		return err
 	}
 	value := valueRaw
+	{{if or $fieldCfg.Prefix $fieldCfg.Suffix -}}
+	if err := affixCheck(name, value, {{printf "%q" $fieldCfg.Prefix}}, {{printf "%q" $fieldCfg.Suffix}}); err != nil {
+		return err
+	}
+	{{end -}}
+	{{if $fieldCfg.Charset -}}
+	if err := charsetCheck(name, value, "{{$fieldCfg.Charset}}"); err != nil {
+		return err
+	}
+	{{end -}}
+	{{end -}}
+	{{if eq $fieldCfg.Type "[]string" -}}
+	var value []string
+	if value, err = listCheck(name, valueRaw, {{$fieldCfg.HasMin}}, {{$fieldCfg.HasMax}}, {{$fieldCfg.Min}}, {{$fieldCfg.Max}}); err != nil {
+		return err
+	}
+	{{end -}}
+	{{if $fieldCfg.EnumType -}}
+	{{if eq $fieldCfg.Type "[]string" -}}
+	for _, item := range value {
+		if !{{$fieldCfg.EnumType}}(item).IsValid() {
+			return enumError(name, {{printf "%#v" $fieldCfg.Enum}})
+		}
+	}
+	{{else -}}
+	if !{{$fieldCfg.EnumType}}(valueRaw).IsValid() {
+		return enumError(name, {{printf "%#v" $fieldCfg.Enum}})
+	}
+	{{end -}}
+	{{else if $fieldCfg.Enum -}}
+	if err := enumCheck(name, valueRaw, {{printf "%#v" $fieldCfg.Enum}}); err != nil {
+		return err
+	}
 	{{end -}}
-	{{if $fieldCfg.Enum -}}
-	enum := map[string]struct{}{
-		{{range $v := $fieldCfg.Enum -}}
-		"{{$v}}": struct{}{},
+	{{if or $fieldCfg.Regexp $fieldCfg.Format -}}
+	{{if eq $fieldCfg.Type "[]string" -}}
+	for _, item := range value {
+		{{if $fieldCfg.Regexp -}}
+		if err := regexpCheck(name, item, regexp{{$structName}}{{$fieldName}}); err != nil {
+			return err
+		}
+		{{end -}}
+		{{if $fieldCfg.Format -}}
+		if err := formatCheck(name, item, "{{$fieldCfg.Format}}"); err != nil {
+			return err
+		}
 		{{end -}}
 	}
-	if _, ok := enum[valueRaw]; !ok {
-		variants := strings.Join({{printf "%#v" $fieldCfg.Enum}}, ", ")
-		return fmt.Errorf("%s must be one of [%s]",
-			"{{$fieldCfg.Alias}}", variants)
+	{{else -}}
+	{{if $fieldCfg.Regexp -}}
+	if err := regexpCheck(name, value, regexp{{$structName}}{{$fieldName}}); err != nil {
+		return err
+	}
+	{{end -}}
+	{{if $fieldCfg.Format -}}
+	if err := formatCheck(name, value, "{{$fieldCfg.Format}}"); err != nil {
+		return err
+	}
+	{{end -}}
+	{{end -}}
+	{{end -}}
+	{{if $fieldCfg.Custom -}}
+	if err := {{$fieldCfg.Custom}}(value); err != nil {
+		return customError(name, err)
 	}
 	{{end -}}
-	p.{{$fieldName}} = value
+	p.{{$fieldName}} = {{if $fieldCfg.Optional}}&{{end}}value
 	return nil
+	{{- end}}
 }
+{{- end}}
 {{end}}
+{{- end}}
 {{end}}
 
 
+{{if eq .Router "mux" -}}
+{{range $recvName, $methods := GetRecvTypes .Methods}}
+{{- if $.InPart $recvName}}
+// Register registers handlers of {{$recvName}} on the mux, it matches
+// methods of the handlers with patterns of Go 1.22
+func (h *{{$.HandlerType $recvName}}) Register(mux *http.ServeMux) {
+	{{range $method := $methods -}}
+	{{$methodCfg := $.GetMethodConfig $method -}}
+	mux.Handle("{{with $methodCfg.HTTPMethod}}{{.}} {{end}}{{$methodCfg.URL}}", http.HandlerFunc(h.handler{{GetMethodName $method}}))
+	{{if and $methodCfg.HTTPMethod $methodCfg.CORSOrigins -}}
+	mux.Handle("OPTIONS {{$methodCfg.URL}}", http.HandlerFunc(h.handler{{GetMethodName $method}}))
+	{{end -}}
+	{{end -}}
+}
+{{end}}
+{{- end}}
+{{else if eq .Router "chi" -}}
+{{if .InPart "" -}}
+// MethodRouter is the router handlers are registered on, chi.Router is
+// the one
+type MethodRouter interface {
+	Method(method, pattern string, h http.Handler)
+	Handle(pattern string, h http.Handler)
+}
+{{end}}
+{{- range $recvName, $methods := GetRecvTypes .Methods}}
+{{- if $.InPart $recvName}}
+// Register registers handlers of {{$recvName}} on the router, it matches
+// methods of the handlers
+func (h *{{$.HandlerType $recvName}}) Register(r MethodRouter) {
+	{{range $method := $methods -}}
+	{{$methodCfg := $.GetMethodConfig $method -}}
+	{{if $methodCfg.HTTPMethod -}}
+	r.Method("{{$methodCfg.HTTPMethod}}", "{{$methodCfg.URL}}", http.HandlerFunc(h.handler{{GetMethodName $method}}))
+	{{if $methodCfg.CORSOrigins -}}
+	r.Method("OPTIONS", "{{$methodCfg.URL}}", http.HandlerFunc(h.handler{{GetMethodName $method}}))
+	{{end -}}
+	{{else -}}
+	r.Handle("{{$methodCfg.URL}}", http.HandlerFunc(h.handler{{GetMethodName $method}}))
+	{{end -}}
+	{{end -}}
+}
+{{end}}
+{{- end}}
+{{else -}}
 {{range $recvName, $methods := GetRecvTypes .Methods}}
-func (h *{{$recvName}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+{{- if $.InPart $recvName}}
+func (h *{{$.HandlerType $recvName}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 
 	{{- range $method := $methods -}}
 	{{$methodName := GetMethodName $method}}
-	{{$methodCfg := $.GetMethodConfig $methodName -}}
+	{{$methodCfg := $.GetMethodConfig $method -}}
 		
 	case "{{$methodCfg.URL}}":
 		h.handler{{$methodName}}(w, r)
 	{{end -}}
 	default:
+		{{- if $.StructuredErrors}}
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown method"))
+		{{- else}}
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("{\"error\": \"unknown method\"}"))
+		{{- end}}
+	}
+}
+{{end}}
+{{- end}}
+{{end}}
+{{if .InPart "" -}}
+func checkAuth(r *http.Request, header, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(header)), []byte(token)) == 1
+}
+
+func checkBearer(r *http.Request, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+{{- if .HasContext "request_id"}}
+type requestIDKey struct{}
+
+// withRequestID adds the ID of the request to its context, it's X-Request-Id
+// header or the random one. It's sent back in the header of the response.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get("X-Request-Id")
+	if id == "" {
+		buf := make([]byte, 16)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
 	}
+	w.Header().Set("X-Request-Id", id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// RequestIDFromContext returns the ID of the request of methods with
+// request_id in their context
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+{{end}}
+{{- end}}
+{{- range $recvTypeName, $principal := .ContextPrincipals}}
+{{- if $.InPart $recvTypeName}}
+type principalKey{{$recvTypeName}} struct{}
+
+// {{$recvTypeName}}PrincipalFromContext returns the principal Authorize of
+// {{$recvTypeName}} returns for methods with principal in their context
+func {{$recvTypeName}}PrincipalFromContext(ctx context.Context) ({{$principal}}, bool) {
+	principal, ok := ctx.Value(principalKey{{$recvTypeName}}{}).({{$principal}})
+	return principal, ok
 }
 {{end}}
+{{- end}}
+{{if .InPart "" -}}
+// withTimeout returns the context of the method call canceled after the
+// timeout
+func withTimeout(r *http.Request, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+{{- if .Instrument}}
+// Instrument receives events of requests of the handlers, the app counts,
+// times and logs them
+type Instrument interface {
+	ObserveRequest(e RequestEvent)
+}
+
+// RequestEvent is the request handled by Endpoint, it's like MyApi.Create
+type RequestEvent struct {
+	Endpoint string
+	Method   string
+	Path     string
+	Status   int
+	Duration time.Duration
+	// Error is the error of the response, empty on success
+	Error string
+}
+
+// APIInstrument receives events of all handlers, it's set before serving
+// requests. Nil disables events.
+var APIInstrument Instrument
+
+// statusWriter keeps the status and the error of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	err    error
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func observe(endpoint string, w *statusWriter, r *http.Request, start time.Time) {
+	if APIInstrument == nil {
+		return
+	}
+	e := RequestEvent{
+		Endpoint: endpoint,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Status:   w.status,
+		Duration: time.Since(start),
+	}
+	if w.err != nil {
+		e.Error = w.err.Error()
+	}
+	APIInstrument.ObserveRequest(e)
+}
+{{- end}}
+
+{{- if .Gzip}}
+// gzipWriters are the writers of compressed responses reused by handlers
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// gzipResponseWriter compresses the body of the response, the header is
+// written with the first part of the body, so its content type is detected
+// before compression. Responses without body like 204 aren't compressed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	status  int
+	started bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.start(b)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) start(b []byte) {
+	w.started = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if len(b) > 0 && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Close writes the header if there is no body and flushes the compressed
+// one, the writer is put back to the pool
+func (w *gzipResponseWriter) Close() error {
+	if !w.started && w.status != 0 {
+		w.start(nil)
+	}
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+	return err
+}
+
+// withGzip returns the writer compressing the response if the request
+// accepts gzip encoding, it's nil otherwise
+func withGzip(w http.ResponseWriter, r *http.Request) *gzipResponseWriter {
+	w.Header().Add("Vary", "Accept-Encoding")
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		// gzip;q=0 refuses the encoding
+		if len(parts) > 1 {
+			q, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(parts[1]), "q="), 64)
+			if err == nil && q == 0 {
+				return nil
+			}
+		}
+		return &gzipResponseWriter{ResponseWriter: w}
+	}
+	return nil
+}
+{{- end}}
+
+// writeError writes the response of the error with the status
+func writeError(w http.ResponseWriter, status int, err error) {
+	{{- if .Instrument}}
+	if sw, ok := w.(*statusWriter); ok {
+		sw.err = err
+	}
+	{{- end}}
+	w.WriteHeader(status)
+	{{- if .StructuredErrors}}
+	ar := APIResponse{Error: err.Error(), Code: errorCode(status)}
+	ar.Errors = appendErrors(nil, err)
+	if ar.Errors[0].Field == "" {
+		// the error isn't of params
+		ar.Errors = nil
+	}
+	buf, err := json.Marshal(ar)
+	if err != nil {
+		panic(err.Error())
+	}
+	w.Write(buf)
+	{{- else}}
+	w.Write(newResponse(nil, err))
+	{{- end}}
+}
+
+// setCORS sets CORS headers of allowed origins. OPTIONS requests are
+// responded here, it returns true for them, they are forbidden if they
+// aren't preflight requests of allowed origins.
+func setCORS(w http.ResponseWriter, r *http.Request, origins []string, methods, headers string) bool {
+	origin := r.Header.Get("Origin")
+	allowed := false
+	for _, o := range origins {
+		if origin != "" && (o == "*" || o == origin) {
+			allowed = true
+		}
+	}
+	w.Header().Add("Vary", "Origin")
+	if allowed {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	if !allowed || r.Header.Get("Access-Control-Request-Method") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", headers)
+	w.Header().Set("Access-Control-Max-Age", "600")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// maxMemory is the size of multipart forms kept in memory, files are
+// stored on disk after it
+const maxMemory = 32 << 20
+
+// fileCheck returns the file of the multipart form, it's nil if there is
+// none. Content types may have wildcards like image/*.
+func fileCheck(r *http.Request, fieldName string, required bool, maxSize int64, contentTypes []string) (*multipart.FileHeader, error) {
+	var file *multipart.FileHeader
+	if r.MultipartForm != nil && len(r.MultipartForm.File[fieldName]) > 0 {
+		file = r.MultipartForm.File[fieldName][0]
+	}
+	if file == nil {
+		if required {
+			return nil, newFieldError(fieldName, "required", "%s must me not empty", fieldName)
+		}
+		return nil, nil
+	}
+	if maxSize > 0 && file.Size > maxSize {
+		return nil, newFieldError(fieldName, "maxsize", "%s must be <= %d bytes", fieldName, maxSize)
+	}
+	if len(contentTypes) == 0 {
+		return file, nil
+	}
+	contentType := file.Header.Get("Content-Type")
+	for _, t := range contentTypes {
+		if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(t, "*")) {
+			return file, nil
+		}
+	}
+	return nil, newFieldError(fieldName, "content_type", "%s must be one of [%s]", fieldName, strings.Join(contentTypes, ", "))
+}
+
+type jsonBodyKey struct{}
+
+// withJSONBody decodes the JSON body of the request to its context, the
+// body is empty or an object
+func withJSONBody(r *http.Request) (*http.Request, error) {
+	body := map[string]interface{}{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil && err != io.EOF {
+		return r, fmt.Errorf("bad json body: %w", err)
+	}
+	return r.WithContext(context.WithValue(r.Context(), jsonBodyKey{}, body)), nil
+}
+
+// jsonValue is the value of the JSON body as the text of params, dots of
+// the name are the keys of nested objects, arrays are comma separated
+func jsonValue(r *http.Request, name string) string {
+	var value interface{} = r.Context().Value(jsonBodyKey{})
+	for _, key := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+{{- if .HasCache}}
+// responseCache keeps successful responses of the method by its params for
+// the TTL. Concurrent requests of the params wait for the first one, so the
+// method isn't called by all of them when the response expires.
+type responseCache struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[string]*cacheEntry
+	nextSweep time.Time
+}
+
+type cacheEntry struct {
+	// done is closed when the response is recorded, it's nil if the
+	// method fails
+	done     chan struct{}
+	response *cacheRecorder
+	expires  time.Time
+}
+
+// serve writes the cached response of the params or the one of serve, it's
+// cached if it's successful
+func (c *responseCache) serve(w http.ResponseWriter, params interface{}, serve func(w http.ResponseWriter)) {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		serve(w)
+		return
+	}
+	key := string(buf)
+	now := time.Now()
+	c.mu.Lock()
+	if now.After(c.nextSweep) {
+		// expired responses are dropped once per TTL
+		for key, entry := range c.entries {
+			if entry.response != nil && now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*cacheEntry)
+	}
+	entry := c.entries[key]
+	if entry != nil && (entry.response == nil || now.Before(entry.expires)) {
+		c.mu.Unlock()
+		<-entry.done
+		if entry.response != nil {
+			entry.response.writeTo(w)
+			return
+		}
+		// the first request failed, the method is called again
+		serve(w)
+		return
+	}
+	entry = &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.mu.Unlock()
+	recorder := &cacheRecorder{header: make(http.Header)}
+	defer func() {
+		c.mu.Lock()
+		if recorder.status >= http.StatusOK && recorder.status < http.StatusMultipleChoices {
+			entry.response, entry.expires = recorder, time.Now().Add(c.ttl)
+		} else {
+			// panics and errors aren't cached
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		close(entry.done)
+	}()
+	serve(recorder)
+	recorder.writeTo(w)
+}
+
+// cacheRecorder records the response to be cached
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
 
-func checkAuth(w http.ResponseWriter, r *http.Request) bool {
-	return r.Header.Get("X-Auth") == "100500"
+func (rec *cacheRecorder) writeTo(w http.ResponseWriter) {
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
 }
+{{end}}
 
+{{- if .HasBodyLimit}}
+// bodyTooLarge reports whether the error is of reading the body over the
+// limit of the method
+func bodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+{{end}}
 func checkMethod(method string, w http.ResponseWriter, r *http.Request) bool {
 	return r.Method == method
 }
 
-func checkPanic(w http.ResponseWriter) {
-	if e := recover(); e != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+// panicHook is implemented by receivers of handlers handling panics of
+// their methods, like logging them with the stack
+type panicHook interface {
+	OnPanic(r *http.Request, err interface{}, stack []byte)
 }
 
+// checkPanic recovers the panic of the handler, it's passed with its stack
+// to OnPanic of the receiver if there is one. The response is the internal
+// error, the panic isn't shown to clients.
+func checkPanic(w http.ResponseWriter, r *http.Request, recv interface{}) {
+	e := recover()
+	if e == nil {
+		return
+	}
+	stack := debug.Stack()
+	if hook, ok := recv.(panicHook); ok {
+		hook.OnPanic(r, e, stack)
+	}
+	writeError(w, http.StatusInternalServerError, fmt.Errorf("internal error"))
+	{{- if .Instrument}}
+	if sw, ok := w.(*statusWriter); ok {
+		sw.err = fmt.Errorf("panic: %v", e)
+	}
+	{{- end}}
+}
+{{end}}
 {{range $recvTypeName, $methods := GetRecvTypes .Methods}}
+{{- if $.InPart $recvTypeName}}
+{{- if index $.Interfaces $recvTypeName}}
+// {{$recvTypeName}}Handler serves the handlers of the implementation of
+// {{$recvTypeName}}
+type {{$recvTypeName}}Handler struct {
+	{{$recvTypeName}}
+}
+{{end}}
 {{range $method := $methods}}
 {{$methodName := GetMethodName $method}}
-{{$methodCfg := $.GetMethodConfig $methodName}}
+{{$methodCfg := $.GetMethodConfig $method}}
 {{$methodParamTypeName := GetMethodParamTypeName $method 1}}
 {{$recvName := GetMethodRecvName $method}}
-func ({{$recvName}} *{{$recvTypeName}}) handler{{$methodName}}(w http.ResponseWriter, r *http.Request) {
-	defer checkPanic(w)
-	{{- if $methodCfg.Auth}}
-	if !checkAuth(w, r) {
-		w.WriteHeader(http.StatusForbidden)
-		w.Write(newResponse(nil, fmt.Errorf("unauthorized")))
+{{- if $methodCfg.CacheTTLMS}}
+var cache{{$recvTypeName}}{{$methodName}} = &responseCache{ttl: {{$methodCfg.CacheTTLMS}} * time.Millisecond}
+{{end}}
+func ({{$recvName}} *{{$.HandlerType $recvTypeName}}) handler{{$methodName}}(w http.ResponseWriter, r *http.Request) {
+	{{- if $.Gzip}}
+	if gw := withGzip(w, r); gw != nil {
+		defer gw.Close()
+		w = gw
+	}
+	{{- end}}
+	{{- if $.Instrument}}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	defer observe("{{$recvTypeName}}.{{$methodName}}", sw, r, time.Now())
+	w = sw
+	{{- end}}
+	defer checkPanic(w, r, {{$recvName}}{{if index $.Interfaces $recvTypeName}}.{{$recvTypeName}}{{end}})
+	{{- if $methodCfg.HasContext "request_id"}}
+	r = withRequestID(w, r)
+	{{- end}}
+	{{- if $methodCfg.CORSOrigins}}
+	if setCORS(w, r, {{printf "%#v" $methodCfg.CORSOrigins}}, {{printf "%q" $methodCfg.GetCORSMethods}}, {{printf "%q" $methodCfg.GetCORSHeaders}}) {
+		return
+	}
+	{{- end}}
+	{{- if $methodCfg.HasContext "principal"}}
+	principal, authErr := {{$recvName}}.Authorize(r)
+	if authErr != nil {
+		status := http.StatusForbidden
+		if apiError, ok := authErr.(ApiError); ok {
+			status = apiError.HTTPStatus
+		}
+		writeError(w, status, authErr)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{{$recvTypeName}}{}, principal))
+	{{- else if eq $methodCfg.AuthScheme "method"}}
+	if {{if index $.Principals $recvTypeName}}_, {{end}}err := {{$recvName}}.Authorize(r); err != nil {
+		status := http.StatusForbidden
+		if apiError, ok := err.(ApiError); ok {
+			status = apiError.HTTPStatus
+		}
+		writeError(w, status, err)
+		return
+	}
+	{{- else if $methodCfg.Auth}}
+	if !{{if eq $methodCfg.AuthScheme "bearer"}}checkBearer(r, {{printf "%q" $methodCfg.AuthToken}}){{else}}checkAuth(r, {{printf "%q" $methodCfg.AuthHeader}}, {{printf "%q" $methodCfg.AuthToken}}){{end}} {
+		writeError(w, http.StatusForbidden, fmt.Errorf("unauthorized"))
 		return
 	}
 	{{end}}
-	{{- if $methodCfg.HTTPMethod}}
+	{{- if and $methodCfg.HTTPMethod (not $.Router)}}
 	if !checkMethod("{{$methodCfg.HTTPMethod}}", w, r) {
-		w.WriteHeader(http.StatusNotAcceptable)
-		w.Write(newResponse(nil, fmt.Errorf("bad method")))
+		writeError(w, http.StatusNotAcceptable, fmt.Errorf("bad method"))
 		return
 	}
 	{{end}}
+	{{- if $methodCfg.MaxBodyKB}}
+	r.Body = http.MaxBytesReader(w, r.Body, {{$methodCfg.MaxBodyKB}}<<10)
+	{{- if not (or ($.HasFiles $methodParamTypeName) ($.HasSource $methodParamTypeName "json"))}}
+	// errors of the form other than its size are left to params
+	if err := r.ParseForm(); err != nil && bodyTooLarge(err) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than {{$methodCfg.MaxBodyKB}} KB"))
+		return
+	}
+	{{- end}}
+	{{- end}}
+	{{- if $.HasFiles $methodParamTypeName}}
+	if err := r.ParseMultipartForm(maxMemory); err != nil && err != http.ErrNotMultipart {
+		{{- if $methodCfg.MaxBodyKB}}
+		if bodyTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than {{$methodCfg.MaxBodyKB}} KB"))
+			return
+		}
+		{{- end}}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad multipart form: %s", err))
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+	{{- end}}
+	{{- if $.HasSource $methodParamTypeName "json"}}
+	r, jsonErr := withJSONBody(r)
+	{{- if $methodCfg.MaxBodyKB}}
+	if jsonErr != nil && bodyTooLarge(jsonErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than {{$methodCfg.MaxBodyKB}} KB"))
+		return
+	}
+	{{- end}}
+	if jsonErr != nil {
+		writeError(w, http.StatusBadRequest, jsonErr)
+		return
+	}
+	{{- end}}
 	p := {{$methodParamTypeName}}{}
 	
-	err := validate{{$methodParamTypeName}}(&p, r)
+	err := validate{{$methodParamTypeName}}(&p, r, "")
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write(newResponse(nil, err))
+		writeError(w, http.StatusBadRequest, err)
 		return
 	}
 	
-	result, err := {{$recvName}}.{{$methodName}}(r.Context(), p)
+	{{if $methodCfg.CacheTTLMS -}}
+	cache{{$recvTypeName}}{{$methodName}}.serve(w, p, func(w http.ResponseWriter) {
+	{{end -}}
+	{{if $methodCfg.TimeoutMS -}}
+	ctx, cancel := withTimeout(r, {{$methodCfg.TimeoutMS}}*time.Millisecond)
+	defer cancel()
+	result, {{if ReturnsMeta $method}}meta, {{end}}err := {{$recvName}}.{{$methodName}}(ctx, p)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		writeError(w, http.StatusGatewayTimeout, fmt.Errorf("timeout"))
+		return
+	}
+	{{else -}}
+	result, {{if ReturnsMeta $method}}meta, {{end}}err := {{$recvName}}.{{$methodName}}(r.Context(), p)
+	{{end -}}
+	{{if ReturnsMeta $method -}}
+	for name, values := range meta.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	{{end -}}
 	if err != nil {
-		apiError, ok := err.(ApiError)
-		if !ok {
-			w.WriteHeader(http.StatusInternalServerError)
-		} else {
-			w.WriteHeader(apiError.HTTPStatus)
+		status := http.StatusInternalServerError
+		if apiError, ok := err.(ApiError); ok {
+			status = apiError.HTTPStatus
 		}
-		w.Write(newResponse(nil, err))
+		writeError(w, status, err)
 		return
 	}
+	{{- if ReturnsMeta $method}}
+	if meta.Status != 0 {
+		w.WriteHeader(meta.Status)
+	}
+	{{- end}}
 	w.Write(newResponse(result, err))
+	{{- if $methodCfg.CacheTTLMS}}
+	})
+	{{- end}}
 }
 {{end}}
+{{- end}}
 {{end}}
 `
@@ -0,0 +1,1509 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// generate returns the formatted code generated for the sources
+func generate(t *testing.T, srcs ...string) []byte {
+	data, err := parseSrc(srcs, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := generateCode(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = formatCode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sourceImporter is shared by tests, so packages are checked once
+var sourceImporter = importer.ForCompiler(token.NewFileSet(), "source", nil)
+
+// typeCheck checks the source files together with the generated code
+func typeCheck(t *testing.T, srcs []string, generated ...[]byte) {
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for i, code := range generated {
+		file, err := parser.ParseFile(fset, fmt.Sprintf("generated%d.go", i), code, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	for _, src := range srcs {
+		file, err := parser.ParseFile(fset, src, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	conf := types.Config{Importer: sourceImporter}
+	if _, err := conf.Check("api", fset, files, nil); err != nil {
+		t.Fatalf("generated code doesn't compile: %v", err)
+	}
+}
+
+// runGenerated runs the test of testdata/runtime against the handlers
+// generated for src, options set the flags of the generator
+func runGenerated(t *testing.T, src, test string, options func(data *tmplData)) {
+	if testing.Short() {
+		t.Skip("the generated handlers aren't run in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool isn't found")
+	}
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options != nil {
+		options(data)
+	}
+	buf, err := generateCode(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, err = formatCode(buf); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"api.go":          src,
+		"runtime_test.go": filepath.Join("testdata", "runtime", test),
+	}
+	for name, path := range files {
+		code, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), code, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "api_gen.go"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(goTool, "test", "-count=1", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("%s failed: %v\n%s", test, err, out)
+	}
+}
+
+func TestFieldTypes(t *testing.T) {
+	src := filepath.Join("testdata", "types.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]fieldConfig{
+		"Score":  {Type: "float64", HasMin: true, HasMax: true, Min: 0.5, Max: 10},
+		"Exact":  {Type: "bool"},
+		"Tags":   {Type: "[]string", HasMin: true, HasMax: true, Min: 1, Max: 2},
+		"Limit":  {Type: "int", Optional: true, HasMin: true, HasMax: true, Min: 1, Max: 100},
+		"Fuzzy":  {Type: "bool", Optional: true},
+		"Weight": {Type: "float64", Optional: true, HasMax: true, Max: 1},
+	}
+	for name, expected := range cases {
+		cfg := data.StructsCfg["SearchParams"][name]
+		if cfg.Type != expected.Type || cfg.Optional != expected.Optional ||
+			cfg.HasMin != expected.HasMin || cfg.HasMax != expected.HasMax ||
+			cfg.Min != expected.Min || cfg.Max != expected.Max {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, cfg)
+		}
+	}
+	contact := data.StructsCfg["ContactParams"]
+	if contact["Email"].Format != "email" || contact["Site"].Format != "url" || contact["ID"].Format != "uuid" ||
+		contact["Phone"].Regexp != `^\+?[0-9]{3,15}$` || contact["Aliases"].Regexp != "^[a-z]+$" || !contact["Aliases"].HasMax {
+		t.Errorf("unexpected formats %+v %+v %+v %+v %+v", contact["Email"], contact["Site"], contact["ID"], contact["Phone"], contact["Aliases"])
+	}
+	if code := contact["Code"]; !code.HasMin || code.Min != 6 || !code.HasMaxLen || code.MaxLen != 6 || code.Charset != "digit" {
+		t.Errorf("expected exact length 6 of digits, got %+v", code)
+	}
+	if file := contact["File"]; file.Prefix != "src/" || file.Suffix != ".go" || file.MaxLen != 64 || file.HasMin {
+		t.Errorf("unexpected File config %+v", file)
+	}
+	typeCheck(t, []string{src}, generate(t, src))
+}
+
+func TestTimeFields(t *testing.T) {
+	src := filepath.Join("testdata", "times.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]fieldConfig{
+		"ID":       {Type: "int64", HasMin: true, HasMax: true, Min: -5, Max: 1 << 53},
+		"Seq":      {Type: "uint", HasMax: true, Max: 1000},
+		"Size":     {Type: "uint64", Optional: true, HasMin: true, Min: 1},
+		"Start":    {Type: "time.Time", Layout: time.RFC3339, HasMin: true, HasMax: true, Min: 1577836800, Max: 1893456000},
+		"Deadline": {Type: "time.Time", Optional: true, Layout: "unix", HasMin: true, Min: 1577836800},
+		"Day":      {Type: "time.Time", Layout: "2006-01-02"},
+		"Local":    {Type: "time.Time", Optional: true, Layout: "02.01.2006"},
+	}
+	for name, expected := range cases {
+		cfg := data.StructsCfg["EventParams"][name]
+		if cfg.Type != expected.Type || cfg.Optional != expected.Optional || cfg.Layout != expected.Layout ||
+			cfg.HasMin != expected.HasMin || cfg.HasMax != expected.HasMax ||
+			cfg.Min != expected.Min || cfg.Max != expected.Max {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, cfg)
+		}
+	}
+	code := string(generate(t, src))
+	for _, part := range []string{
+		"if value, err = int64BoundCheck(name, valueRaw, true, true, -5, 9.007199254740992e+15); err != nil {",
+		"if value64, err = uintBoundCheck(name, valueRaw, strconv.IntSize, false, true, 0, 1000); err != nil {",
+		"value := uint64(value64)",
+		`if value, err = timeCheck(name, valueRaw, "02.01.2006", false, false, 0, 0); err != nil {`,
+		`if value, err = timeCheck(name, valueRaw, "unix", true, false, 1.5778368e+09, 0); err != nil {`,
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	client, err := generateClient(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, err := generateTests(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{
+		`req.query.Set("deadline", strconv.FormatInt((*in.Deadline).Unix(), 10))`,
+		`req.query.Set("day", in.Day.Format("2006-01-02"))`,
+		`req.setJSON("at", strconv.FormatInt(in.At.Unix(), 10))`,
+	} {
+		if !strings.Contains(client.String(), part) {
+			t.Errorf("expected %s in client", part)
+		}
+	}
+	typeCheck(t, []string{src}, []byte(code), client.Bytes(), tests.Bytes())
+
+	start := data.StructsCfg["EventParams"]["Start"]
+	rules := map[string]string{
+		"2020-01-01T00:00:00Z":      "",
+		"2019-12-31T23:59:59Z":      "min",
+		"2030-01-01T00:00:01+00:00": "max",
+		"2021-01-01":                "type",
+	}
+	for value, expected := range rules {
+		if rule := failedRule(start, value); rule != expected {
+			t.Errorf("%s: expected %q rule, got %q", value, expected, rule)
+		}
+	}
+	if rule := failedRule(data.StructsCfg["EventParams"]["Seq"], "-1"); rule != "type" {
+		t.Errorf("expected negative uint of type rule, got %q", rule)
+	}
+}
+
+func TestUnsupportedFields(t *testing.T) {
+	cases := map[string]string{
+		"map[string]int":                                                                         "unsupported field type",
+		"[]int":                                                                                  "unsupported field type",
+		"*[]string":                                                                              "unsupported field type",
+		"bool `apivalidator:\"min=1\"` //":                                                       "not supported for bool",
+		"int `apivalidator:\"email\"` //":                                                        "supported for string",
+		"string `apivalidator:\"regexp=[a-\"` //":                                                "missing closing",
+		"int `apivalidator:\"min=0.5\"` //":                                                      "invalid syntax",
+		"int `apivalidator:\"maxlen=3\"` //":                                                     "supported for string only",
+		"string `apivalidator:\"charset=latin\"` //":                                             "unknown charset",
+		"string `apivalidator:\"len=3,min=1\"` //":                                               "can't be used with min",
+		"string `apivalidator:\"maxlen=-1\"` //":                                                 "bad length",
+		"string `apivalidator:\"min=5,maxlen=3\"` //":                                            "greater than maxlen",
+		"string `apivalidator:\"enum=a-b|a_b\"` //":                                              "can't be the constant ParamsFieldAB",
+		"string `apivalidator:\"enum=|a\"` //":                                                   "can't be the constant ParamsField",
+		"string `apivalidator:\"source=cookie\"` //":                                             "unknown source",
+		"string `apivalidator:\"maxsize=10\"` //":                                                "supported for files only",
+		"*multipart.FileHeader `apivalidator:\"min=1\"` //":                                      "files support required",
+		"*multipart.FileHeader `apivalidator:\"source=json\"` //":                                "multipart form only",
+		"*multipart.FileHeader `apivalidator:\"maxsize=1GB\"` //":                                "bad size",
+		"string `apivalidator:\"default=env:\"` //":                                              "no variable name",
+		"int `apivalidator:\"default=const:pkg.Size\"` //":                                       "is not a name",
+		"*multipart.FileHeader `apivalidator:\"default=env:FILE\"` //":                           "files support required",
+		"string `apivalidator:\"custom=check-inn\"` //":                                          "not a function name",
+		"string `apivalidator:\"custom=validators.CheckInn\"` //":                                "validators of custom validator is not imported",
+		"string `apivalidator:\"source=json\"`\n\tForm string `apivalidator:\"source=form\"` //": "both form and json",
+		"[]time.Time":                                      "unsupported field type",
+		"int `apivalidator:\"layout=unix\"` //":            "supported for time.Time only",
+		"time.Time `apivalidator:\"layout=today\"` //":     "no elements of times",
+		"time.Time `apivalidator:\"min=2020-01-01\"` //":   "not in layout",
+		"uint `apivalidator:\"min=-1\"` //":                "invalid syntax",
+		"int64 `apivalidator:\"max=9007199254740993\"` //": "out of range",
+	}
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for fieldType, reason := range cases {
+		src := filepath.Join(dir, "api.go")
+		code := `package api
+
+type Api struct{}
+
+type Params struct {
+	Field ` + fieldType + " `apivalidator:\"required\"`" + `
+}
+
+// apigen:api {"url": "/"}
+func (a *Api) Do(ctx interface{}, in Params) (*Params, error) {
+	return &in, nil
+}
+`
+		if err := ioutil.WriteFile(src, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseSrc([]string{src}, "", ""); err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s: expected %q error, got %v", fieldType, reason, err)
+		}
+	}
+}
+
+func TestPackageParsing(t *testing.T) {
+	dir := filepath.Join("testdata", "multi")
+	files := []string{filepath.Join(dir, "api.go"), filepath.Join(dir, "params.go")}
+	generated := generate(t, dir)
+	if !bytes.Equal(generated, generate(t, files...)) {
+		t.Error("expected the same code for the package and its files")
+	}
+	typeCheck(t, files, generated)
+
+	// the output file of the package is skipped
+	dst := filepath.Join(dir, "api_gen.go")
+	if err := ioutil.WriteFile(dst, generated, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dst)
+	if data, err := parseSrc([]string{dir}, dst, ""); err != nil || len(data.Methods) != 2 {
+		t.Errorf("expected 2 methods without %s, got %v", dst, err)
+	}
+
+	if _, err := parseSrc([]string{files[0]}, "", ""); err == nil || !strings.Contains(err.Error(), "not declared") {
+		t.Errorf("expected undeclared type error, got %v", err)
+	}
+	if _, err := parseSrc([]string{dir, filepath.Join("testdata", "types.go")}, "", ""); err == nil || !strings.Contains(err.Error(), "package") {
+		t.Errorf("expected package mismatch error, got %v", err)
+	}
+}
+
+func TestGoGenerate(t *testing.T) {
+	argsCases := []struct {
+		args     []string
+		src, dst string
+		srcs     []string
+	}{
+		{[]string{"api.go", "api_gen.go"}, "", "", []string{"api.go"}},
+		{nil, "api.go,params.go", "api_gen.go", []string{"api.go", "params.go"}},
+		{[]string{"api.go"}, "", "", nil},
+		{nil, "api.go", "", nil},
+		{[]string{"api.go"}, "api.go", "api_gen.go", nil},
+	}
+	for _, c := range argsCases {
+		srcs, dst, err := parseArgs(c.args, c.src, c.dst)
+		if c.srcs == nil && err == nil || c.srcs != nil && (err != nil || dst != "api_gen.go" || strings.Join(srcs, ",") != strings.Join(c.srcs, ",")) {
+			t.Errorf("%v %q %q: unexpected %v %s %v", c.args, c.src, c.dst, srcs, dst, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	code, err := ioutil.ReadFile(filepath.Join("testdata", "types.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"api.go":   string(code),
+		"other.go": "package other\n",
+		// outputs of the generator aren't parsed
+		"api_client_gen.go": "// " + generatedNote + "\n\npackage api\n\nfunc (",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, err := parseSrc([]string{dir}, "", "api"); err != nil || data.PackageName != "api" || len(data.Methods) != 2 {
+		t.Errorf("expected 2 methods of package api, got %v", err)
+	}
+	if _, err := parseSrc([]string{dir}, "", ""); err == nil || !strings.Contains(err.Error(), "package other") {
+		t.Errorf("expected package mismatch error, got %v", err)
+	}
+	if _, err := parseSrc([]string{dir}, "", "main"); err == nil || !strings.Contains(err.Error(), "no source files of package main") {
+		t.Errorf("expected no files error, got %v", err)
+	}
+
+	// unchanged outputs aren't written
+	dst := filepath.Join(dir, "api_gen.go")
+	out := withNote("//", *bytes.NewBufferString("package api\n"))
+	if !strings.HasPrefix(out.String(), "// Code generated by handlers_gen. DO NOT EDIT.\n\npackage api") {
+		t.Errorf("unexpected header of %q", out.String())
+	}
+	if err := writeToFile(dst, out); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(dst, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeToFile(dst, out); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(dst); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("expected unchanged file to be kept, got %v", err)
+	}
+	if err := writeToFile(dst, *bytes.NewBufferString("package api\n")); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(dst); err != nil || string(content) != "package api\n" {
+		t.Errorf("expected changed file to be written, got %q %v", content, err)
+	}
+}
+
+func TestEnumTypes(t *testing.T) {
+	cases := map[string]string{
+		"user":    "StatusUser",
+		"en-US":   "StatusEnUS",
+		"no_auth": "StatusNoAuth",
+		"42":      "Status42",
+		"ёж":      "StatusЁж",
+	}
+	for value, expected := range cases {
+		if name := EnumConstName("Status", value); name != expected {
+			t.Errorf("%s: expected %s, got %s", value, expected, name)
+		}
+	}
+
+	src := filepath.Join("testdata", "types.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := data.StructsCfg["SearchParams"]
+	if fields["Tags"].EnumType != "SearchParamsTags" || fields["Lang"].EnumType != "SearchParamsLang" {
+		t.Errorf("unexpected enum types %+v %+v", fields["Tags"], fields["Lang"])
+	}
+	code := string(generate(t, src))
+	for _, decl := range []string{
+		"type SearchParamsLang string",
+		`SearchParamsLangRu SearchParamsLang = "ru"`,
+		"func (v SearchParamsTags) IsValid() bool",
+	} {
+		if !strings.Contains(code, decl) {
+			t.Errorf("expected %s in generated code", decl)
+		}
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	src := filepath.Join("testdata", "timeout.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := data.setDefaultTimeout(1000); err != nil {
+		t.Fatal(err)
+	}
+	if sleep, wait := data.MethodsCfg["Api.Sleep"].TimeoutMS, data.MethodsCfg["Api.Wait"].TimeoutMS; sleep != 50 || wait != 1000 {
+		t.Errorf("expected timeouts 50 and 1000, got %d and %d", sleep, wait)
+	}
+	if err := data.setDefaultTimeout(-1); err == nil {
+		t.Error("expected error of negative timeout")
+	}
+	code := string(generate(t, src))
+	if !strings.Contains(code, "withTimeout(r, 50*time.Millisecond)") || !strings.Contains(code, "http.StatusGatewayTimeout") {
+		t.Errorf("expected the timeout of Sleep in generated code")
+	}
+	typeCheck(t, []string{src}, []byte(code))
+
+	method := &ast.FuncDecl{Doc: &ast.CommentGroup{List: []*ast.Comment{{Text: `// apigen:api {"url": "/", "timeout_ms": -1}`}}}}
+	if _, err := parseMethodConfig(method); err == nil || !strings.Contains(err.Error(), "timeout_ms") {
+		t.Errorf("expected timeout_ms error, got %v", err)
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	src := filepath.Join("testdata", "upload.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.HasBodyLimit() {
+		t.Error("expected no body limits without max_body_kb")
+	}
+	if err := data.setDefaultMaxBody(-1); err == nil {
+		t.Error("expected error of negative limit")
+	}
+	// maxsize of Avatar is 1KB
+	if err := data.setDefaultMaxBody(16); err != nil {
+		t.Fatal(err)
+	}
+	if limit := data.MethodsCfg["Api.Upload"].MaxBodyKB; limit != 17 {
+		t.Errorf("expected the limit of 17 KB, got %d", limit)
+	}
+	buf, err := generateCode(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = formatCode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := buf.String()
+	for _, part := range []string{
+		"r.Body = http.MaxBytesReader(w, r.Body, 17<<10)",
+		"if bodyTooLarge(err) {\n\t\t\twriteError(w, http.StatusRequestEntityTooLarge",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	typeCheck(t, []string{src}, []byte(code))
+	spec, err := generateOpenAPI(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spec), `"413":`) {
+		t.Errorf("expected 413 response in spec:\n%s", spec)
+	}
+
+	method := &ast.FuncDecl{Doc: &ast.CommentGroup{List: []*ast.Comment{{Text: `// apigen:api {"url": "/", "max_body_kb": -1}`}}}}
+	if _, err := parseMethodConfig(method); err == nil || !strings.Contains(err.Error(), "max_body_kb") {
+		t.Errorf("expected max_body_kb error, got %v", err)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	src := filepath.Join("testdata", "cors.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Gzip, data.Instrument = true, true
+	buf, err := generateCode(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = formatCode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := buf.String()
+	for _, part := range []string{
+		`"compress/gzip"`,
+		"if gw := withGzip(w, r); gw != nil {\n\t\tdefer gw.Close()\n\t\tw = gw\n\t}\n\tsw := &statusWriter{",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	typeCheck(t, []string{src}, buf.Bytes())
+	if code := string(generate(t, src)); strings.Contains(code, "gzip") {
+		t.Error("expected no gzip without the flag")
+	}
+
+	runGenerated(t, filepath.Join("testdata", "cache.go"), "gzip_test.go", func(data *tmplData) {
+		data.Gzip = true
+	})
+}
+
+func TestCache(t *testing.T) {
+	src := filepath.Join("testdata", "cache.go")
+	code := string(generate(t, src))
+	for _, part := range []string{
+		"var cacheApiSearch = &responseCache{ttl: 100 * time.Millisecond}",
+		"cacheApiSlow.serve(w, p, func(w http.ResponseWriter) {\n\t\tctx, cancel := withTimeout(r, 50*time.Millisecond)",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	typeCheck(t, []string{src}, []byte(code))
+	if code := string(generate(t, filepath.Join("testdata", "cors.go"))); strings.Contains(code, "responseCache") {
+		t.Error("expected no cache without cache_ttl_ms")
+	}
+	runGenerated(t, src, "cache_test.go", nil)
+
+	cases := map[string]string{
+		`{"url": "/", "method": "GET", "cache_ttl_ms": -1}`:                                                                  "cache_ttl_ms must be >= 0",
+		`{"url": "/", "method": "POST", "cache_ttl_ms": 10}`:                                                                 "requires GET method",
+		`{"url": "/", "method": "GET", "cache_ttl_ms": 10, "auth": true, "auth_scheme": "method", "context": ["principal"]}`: "can't be used with principal",
+	}
+	for config, reason := range cases {
+		method := &ast.FuncDecl{Doc: &ast.CommentGroup{List: []*ast.Comment{{Text: "// apigen:api " + config}}}}
+		if _, err := parseMethodConfig(method); err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s: expected %q error, got %v", config, reason, err)
+		}
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	src := filepath.Join("testdata", "nested.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.StructuredErrors = true
+	buf, err := generateCode(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = formatCode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := buf.String()
+	for _, part := range []string{"Errors fieldErrors", "errs = appendErrors(errs, err)", "Code: errorCode(status)"} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	if strings.Contains(string(generate(t, src)), "fieldErrors") {
+		t.Error("expected plain errors without the flag")
+	}
+	typeCheck(t, []string{src}, buf.Bytes())
+
+	spec, err := generateOpenAPI(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spec), "rule:") {
+		t.Errorf("expected errors of params in spec:\n%s", spec)
+	}
+}
+
+func TestRouters(t *testing.T) {
+	dir := filepath.Join("testdata", "multi")
+	srcs := []string{filepath.Join(dir, "api.go"), filepath.Join(dir, "params.go")}
+	expected := map[string][]string{
+		"mux": {`mux.Handle("POST /order", http.HandlerFunc(h.handlerOrder))`, `mux.Handle("/user", http.HandlerFunc(h.handlerUser))`},
+		"chi": {`r.Method("POST", "/order", http.HandlerFunc(h.handlerOrder))`, `r.Handle("/user", http.HandlerFunc(h.handlerUser))`},
+	}
+	for router, parts := range expected {
+		data, err := parseSrc(srcs, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data.Router = router
+		buf, err := generateCode(bytes.Buffer{}, data, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err = formatCode(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code := buf.String()
+		for _, part := range parts {
+			if !strings.Contains(code, part) {
+				t.Errorf("%s: expected %s in generated code", router, part)
+			}
+		}
+		if strings.Contains(code, "ServeHTTP") || strings.Contains(code, "checkMethod(\"") {
+			t.Errorf("%s: expected no ServeHTTP and method checks", router)
+		}
+		typeCheck(t, srcs, buf.Bytes())
+	}
+}
+
+func TestCORS(t *testing.T) {
+	src := filepath.Join("testdata", "cors.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo, anyCfg := data.MethodsCfg["Api.Echo"], data.MethodsCfg["Api.Any"]
+	if echo.GetCORSMethods() != "POST" || echo.GetCORSHeaders() != "Content-Type, X-Auth" {
+		t.Errorf("unexpected CORS of Echo: %s, %s", echo.GetCORSMethods(), echo.GetCORSHeaders())
+	}
+	if anyCfg.GetCORSMethods() != "GET, POST, PUT" || anyCfg.GetCORSHeaders() != "Content-Type" {
+		t.Errorf("unexpected CORS of Any: %s, %s", anyCfg.GetCORSMethods(), anyCfg.GetCORSHeaders())
+	}
+	data.setDefaultCORS([]string{"https://a.com", "https://b.com"})
+	if origins := data.MethodsCfg["Api.Plain"].CORSOrigins; len(origins) != 2 {
+		t.Errorf("expected default origins of Plain, got %v", origins)
+	}
+	if origins := echo.CORSOrigins; len(origins) != 1 {
+		t.Errorf("expected origins of Echo config, got %v", origins)
+	}
+	code := generate(t, src)
+	if !bytes.Contains(code, []byte(`setCORS(w, r, []string{"https://example.com"}, "POST", "Content-Type, X-Auth")`)) {
+		t.Errorf("expected CORS of Echo in generated code")
+	}
+	typeCheck(t, []string{src}, code)
+
+	data.Router = "mux"
+	buf, err := generateCode(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `mux.Handle("OPTIONS /echo"`) {
+		t.Errorf("expected preflight route of Echo")
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	src := filepath.Join("testdata", "timeout.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.Instrument = true
+	buf, err := generateCode(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = formatCode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"var APIInstrument Instrument", `defer observe("Api.Sleep", sw, r, time.Now())`} {
+		if !strings.Contains(buf.String(), part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	if strings.Contains(string(generate(t, src)), "APIInstrument") {
+		t.Error("expected no instrumentation without the flag")
+	}
+	typeCheck(t, []string{src}, buf.Bytes())
+}
+
+func TestSources(t *testing.T) {
+	src := filepath.Join("testdata", "sources.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.HasSource("OrderParams", "json") || data.HasSource("OrderParams", "form") || !data.HasSource("LoginParams", "form") {
+		t.Error("unexpected sources of params")
+	}
+	code := string(generate(t, src))
+	for _, part := range []string{
+		`valueRaw := r.Header.Get(name)`,
+		`valueRaw := jsonValue(r, name)`,
+		`valueRaw := r.PostFormValue(name)`,
+		`valueRaw := r.URL.Query().Get(name)`,
+		`r, jsonErr := withJSONBody(r)`,
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	client, err := generateClient(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{
+		`req.header.Set("X-Request-Id", in.RequestID)`,
+		`req.setJSON("item.tags", in.Item.Tags)`,
+		`req.query.Set("next", in.Next)`,
+		`req.form.Set("lang", in.Lang)`,
+		`req.form.Set("login", in.Login)`,
+	} {
+		if !strings.Contains(client.String(), part) {
+			t.Errorf("expected %s in client", part)
+		}
+	}
+	typeCheck(t, []string{src}, []byte(code), client.Bytes())
+
+	spec, err := generateOpenAPI(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{
+		"name: \"X-Request-Id\"\n          in: \"header\"",
+		"application/json:",
+		"required:\n                    - \"name\"",
+		"application/x-www-form-urlencoded:",
+	} {
+		if !strings.Contains(string(spec), part) {
+			t.Errorf("expected %q in spec:\n%s", part, spec)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	src := filepath.Join("testdata", "defaults.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := data.StructsCfg["ListParams"]
+	if list["Region"].DefaultEnv != "API_REGION" || list["PageSize"].DefaultConst != "DefaultPageSize" ||
+		list["Sort"].Default != "name" || list["Region"].Default != "" || list["PageSize"].Default != "" {
+		t.Errorf("unexpected defaults %+v %+v %+v", list["Region"], list["PageSize"], list["Sort"])
+	}
+	code := string(generate(t, src))
+	for _, part := range []string{
+		`valueRaw = os.Getenv("API_REGION")`,
+		`valueRaw = fmt.Sprint(DefaultPageSize)`,
+		`valueRaw = "name"`,
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	typeCheck(t, []string{src}, []byte(code))
+
+	spec, err := generateOpenAPI(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{
+		`description: "defaults to API_REGION environment variable"`,
+		`description: "defaults to DefaultPageSize constant"`,
+		`default: "name"`,
+	} {
+		if !strings.Contains(string(spec), part) {
+			t.Errorf("expected %q in spec:\n%s", part, spec)
+		}
+	}
+}
+
+func TestCustomValidators(t *testing.T) {
+	src := filepath.Join("testdata", "custom.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inn := data.StructsCfg["CompanyParams"]["Inn"]; inn.Custom != "CheckInn" || len(data.Imports) != 0 {
+		t.Errorf("unexpected custom validator %+v, imports %v", inn, data.Imports)
+	}
+	code := string(generate(t, src))
+	for _, part := range []string{
+		"if err := CheckInn(value); err != nil {\n\t\treturn customError(name, err)",
+		"if err := checkTags(value); err != nil {",
+		"if err := checkLogo(p.Logo); err != nil {",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	typeCheck(t, []string{src}, []byte(code))
+
+	// validators of other packages are imported by generated code
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	api := filepath.Join(dir, "api.go")
+	err = ioutil.WriteFile(api, []byte(`package api
+
+import (
+	"context"
+
+	checks "example.com/validators"
+)
+
+type Api struct{}
+
+type Params struct {
+	Inn string `+"`apivalidator:\"custom=checks.CheckInn\"`"+`
+}
+
+// apigen:api {"url": "/"}
+func (a *Api) Do(ctx context.Context, in Params) (*Params, error) {
+	return &in, nil
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = string(generate(t, api))
+	for _, part := range []string{
+		`checks "example.com/validators"`,
+		"if err := checks.CheckInn(value); err != nil {",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code:\n%s", part, code)
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	src := filepath.Join("testdata", "versions.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"Users.Profile":   "/user/profile",
+		"Users.ProfileV2": "/v2/user/profile",
+		"Admins.Profile":  "/v3/user/profile",
+	}
+	for key, url := range expected {
+		if cfg := data.MethodsCfg[key]; cfg == nil || cfg.URL != url {
+			t.Errorf("%s: expected %s, got %+v", key, url, cfg)
+		}
+	}
+	if data.MethodsCfg["Users.Profile"].Auth || !data.MethodsCfg["Admins.Profile"].Auth {
+		t.Error("expected configs of methods of the same name to be kept apart")
+	}
+	code := string(generate(t, src))
+	for _, part := range []string{
+		"case \"/v2/user/profile\":\n\t\th.handlerProfileV2(w, r)",
+		"case \"/v3/user/profile\":\n\t\th.handlerProfile(w, r)",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	client, err := generateClient(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, []string{src}, []byte(code), client.Bytes())
+
+	cases := map[string]string{
+		`{"url": "/a", "version": "v2/beta"}`: "bad version",
+		`{"url": "/v2/a"}`:                    "/v2/a of Second is already handled by First",
+	}
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for config, reason := range cases {
+		api := filepath.Join(dir, "api.go")
+		code := `package api
+
+type Api struct{}
+
+type Params struct {
+	ID int ` + "`apivalidator:\"required\"`" + `
+}
+
+// apigen:api {"url": "/a", "version": "v2"}
+func (a *Api) First(ctx interface{}, in Params) (*Params, error) {
+	return &in, nil
+}
+
+// apigen:api ` + config + `
+func (a *Api) Second(ctx interface{}, in Params) (*Params, error) {
+	return &in, nil
+}
+`
+		if err := ioutil.WriteFile(api, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseSrc([]string{api}, "", ""); err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s: expected %q error, got %v", config, reason, err)
+		}
+	}
+}
+
+func TestPanics(t *testing.T) {
+	src := filepath.Join("testdata", "panic.go")
+	for _, instrument := range []bool{false, true} {
+		data, err := parseSrc([]string{src}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data.Instrument = instrument
+		buf, err := generateCode(bytes.Buffer{}, data, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf, err = formatCode(buf); err != nil {
+			t.Fatal(err)
+		}
+		for _, part := range []string{
+			"defer checkPanic(w, r, a)",
+			"stack := debug.Stack()",
+			"hook.OnPanic(r, e, stack)",
+			`writeError(w, http.StatusInternalServerError, fmt.Errorf("internal error"))`,
+		} {
+			if !strings.Contains(buf.String(), part) {
+				t.Errorf("expected %s in generated code", part)
+			}
+		}
+		typeCheck(t, []string{src}, buf.Bytes())
+	}
+}
+
+func TestReceivers(t *testing.T) {
+	src := filepath.Join("testdata", "receivers.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Methods) != 4 || !data.Interfaces["Store"] || data.Principals["Store"] != "string" || !data.Constructors["NewStore"] {
+		t.Fatalf("expected methods of Echo, Counter and Store, got %d methods of %v", len(data.Methods), data.Interfaces)
+	}
+	code := generate(t, src)
+	for _, part := range []string{
+		"func (e *Echo) handlerSay(",
+		"func (srv *Echo) handlerUnnamed(",
+		"func (srv *Counter) handlerCount(",
+		"type StoreHandler struct {\n\tStore\n}",
+		"func (h *StoreHandler) ServeHTTP(",
+		"func (srv *StoreHandler) handlerGet(",
+		"defer checkPanic(w, r, srv.Store)",
+		"principal, authErr := srv.Authorize(r)",
+	} {
+		if !bytes.Contains(code, []byte(part)) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	tests, err := generateTests(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tests, err = formatCode(tests); err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{"h := &Echo{}", "h := new(Counter)"} {
+		if !strings.Contains(tests.String(), part) {
+			t.Errorf("expected %s in tests", part)
+		}
+	}
+	if recv := data.NewRecv("Store"); recv != "&StoreHandler{NewStore()}" {
+		t.Errorf("expected Store made by NewStore, got %s", recv)
+	}
+	typeCheck(t, []string{src}, code, tests.Bytes())
+
+	pd, err := newProtoData(data, "example.com/api/pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpc, err := generateGRPC(bytes.Buffer{}, pd, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(grpc.String(), "Impl Store\n") || !strings.Contains(grpc.String(), "Impl *Echo\n") {
+		t.Errorf("expected the interface and the pointer of the struct in gRPC servers")
+	}
+
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	code2 := "package api\n\ntype StoreHandler struct{}\n\n" +
+		"type Store interface {\n\t// apigen:api {\"url\": \"/\"}\n\tGet(ctx interface{}, in Params) (*Params, error)\n}\n\n" +
+		"type Params struct {\n\tID int `apivalidator:\"required\"`\n}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "api.go"), []byte(code2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseSrc([]string{filepath.Join(dir, "api.go")}, "", ""); err == nil || !strings.Contains(err.Error(), "StoreHandler is the handler of the interface Store") {
+		t.Errorf("expected the error of StoreHandler, got %v", err)
+	}
+}
+
+func TestResponseMeta(t *testing.T) {
+	src := filepath.Join("testdata", "meta.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !data.HasResponseMeta() || !ReturnsMeta(data.Methods[0]) || ReturnsMeta(data.Methods[1]) {
+		t.Error("expected ResponseMeta of Create only")
+	}
+	code := string(generate(t, src))
+	for _, part := range []string{
+		"type ResponseMeta struct {",
+		"result, meta, err := a.Create(r.Context(), p)",
+		"w.Header().Add(name, value)",
+		"if meta.Status != 0 {\n\t\tw.WriteHeader(meta.Status)",
+		"result, err := a.Get(r.Context(), p)",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	client, err := generateClient(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, []string{src}, []byte(code), client.Bytes())
+	if strings.Contains(string(generate(t, filepath.Join("testdata", "types.go"))), "ResponseMeta") {
+		t.Error("expected no ResponseMeta without methods returning it")
+	}
+
+	cases := map[string]string{
+		"(*Params, http.Header, error)":                                            "must return the result, optional ResponseMeta and error",
+		"(*Params, ResponseMeta, error)\n\ntype ResponseMeta struct{}\n\nfunc f()": "ResponseMeta is declared by generated code",
+	}
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for results, reason := range cases {
+		api := filepath.Join(dir, "api.go")
+		code := `package api
+
+type Api struct{}
+
+type Params struct {
+	ID int ` + "`apivalidator:\"required\"`" + `
+}
+
+// apigen:api {"url": "/"}
+func (a *Api) Do(ctx interface{}, in Params) ` + results + ` {
+	panic("not implemented")
+}
+`
+		if err := ioutil.WriteFile(api, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseSrc([]string{api}, "", ""); err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s: expected %q error, got %v", results, reason, err)
+		}
+	}
+}
+
+func TestContextValues(t *testing.T) {
+	src := filepath.Join("testdata", "context.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Principals["Users"] != "*User" || !data.HasContext("request_id") {
+		t.Errorf("expected *User principal of Users, got %v", data.Principals)
+	}
+	code := string(generate(t, src))
+	for _, part := range []string{
+		`"crypto/rand"`,
+		"func RequestIDFromContext(ctx context.Context) (string, bool) {",
+		"func UsersPrincipalFromContext(ctx context.Context) (*User, bool) {",
+		"r = withRequestID(w, r)\n\tprincipal, authErr := u.Authorize(r)",
+		"r = r.WithContext(context.WithValue(r.Context(), principalKeyUsers{}, principal))",
+		"if _, err := u.Authorize(r); err != nil {",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in generated code", part)
+		}
+	}
+	typeCheck(t, []string{src}, []byte(code))
+	spec, err := generateOpenAPI(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spec), `name: "X-Request-Id"`) {
+		t.Errorf("expected X-Request-Id header in spec:\n%s", spec)
+	}
+	if code := string(generate(t, filepath.Join("testdata", "auth.go"))); strings.Contains(code, "crypto/rand") || strings.Contains(code, "PrincipalFromContext") {
+		t.Error("expected no context helpers without context of methods")
+	}
+
+	cases := map[string]string{
+		`{"url": "/", "context": ["user"]}`:                                             "unknown context value",
+		`{"url": "/", "auth": true, "context": ["principal"]}`:                          "requires method auth_scheme",
+		`{"url": "/", "auth": true, "auth_scheme": "method", "context": ["principal"]}`: "requires Authorize of Api returning the principal",
+	}
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for config, reason := range cases {
+		api := filepath.Join(dir, "api.go")
+		code := `package api
+
+type Api struct{}
+
+func (a *Api) Authorize(r interface{}) error {
+	return nil
+}
+
+type Params struct {
+	ID int ` + "`apivalidator:\"required\"`" + `
+}
+
+// apigen:api ` + config + `
+func (a *Api) Do(ctx interface{}, in Params) (*Params, error) {
+	return &in, nil
+}
+`
+		if err := ioutil.WriteFile(api, []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseSrc([]string{api}, "", ""); err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s: expected %q error, got %v", config, reason, err)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	src := filepath.Join("testdata", "versions.go")
+	for _, router := range []string{"", "mux", "chi"} {
+		data, err := parseSrc([]string{src}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data.Router, data.StructuredErrors, data.Instrument = router, true, true
+		runtime, parts, err := generateParts(data, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) != 2 {
+			t.Fatalf("expected parts of Users and Admins, got %d", len(parts))
+		}
+		usersBuf, adminsBuf := parts["Users"], parts["Admins"]
+		users, admins := usersBuf.String(), adminsBuf.String()
+		expected := map[string][]string{
+			runtime.String(): {"func writeError(", "func validateProfileV2Params("},
+			users:            {"func (u *Users) handlerProfileV2(", "func validateProfileParams("},
+			admins:           {"func (a *Admins) handlerProfile("},
+		}
+		for code, funcs := range expected {
+			for _, f := range funcs {
+				if strings.Count(runtime.String()+users+admins, f) != 1 || !strings.Contains(code, f) {
+					t.Errorf("%s: expected %s in one part only", router, f)
+				}
+			}
+		}
+		if strings.Contains(admins, `"strings"`) || strings.Contains(runtime.String(), ") handler") {
+			t.Errorf("%s: expected used imports and no handlers in the runtime:\n%s", router, admins)
+		}
+		typeCheck(t, []string{src}, runtime.Bytes(), usersBuf.Bytes(), adminsBuf.Bytes())
+	}
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stale := map[string]string{
+		"old_handlers.go":   "// " + generatedNote + "\n\npackage api\n",
+		"users_handlers.go": "// " + generatedNote + "\n\npackage api\n",
+		"my_handlers.go":    "package api\n",
+	}
+	for name, code := range stale {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(code), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := removeStaleParts(dir, map[string]bool{filepath.Join(dir, "users_handlers.go"): true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, kept := range map[string]bool{"old_handlers.go": false, "users_handlers.go": true, "my_handlers.go": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%s: expected kept %v, got %v", name, kept, err)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	src := filepath.Join("testdata", "upload.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	avatar := data.StructsCfg["UploadParams"]["Avatar"]
+	if avatar.Type != "file" || avatar.MaxSize != 1024 || len(avatar.ContentTypes) != 1 || !data.HasFiles("UploadParams") {
+		t.Errorf("unexpected config of Avatar: %+v", avatar)
+	}
+	code := generate(t, src)
+	if !bytes.Contains(code, []byte("r.ParseMultipartForm(maxMemory)")) {
+		t.Error("expected multipart form of Upload")
+	}
+	client, err := generateClient(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(client.String(), `req.setFile("avatar", in.Avatar)`) {
+		t.Error("expected files of Upload in client")
+	}
+	typeCheck(t, []string{src}, code, client.Bytes())
+
+	spec, err := generateOpenAPI(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spec), "multipart/form-data:") || !strings.Contains(string(spec), `format: "binary"`) {
+		t.Errorf("expected multipart body in spec:\n%s", spec)
+	}
+}
+
+func TestGenTests(t *testing.T) {
+	src := filepath.Join("testdata", "types.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, err := generateTests(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, err = formatCode(tests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{
+		`func TestApiContactHandler(t *testing.T) {`,
+		`{name: "bad method", method: http.MethodGet, auth: true, status: http.StatusNotAcceptable}`,
+		`name:   "tags enum"`,
+		`{"query", "tags", "go", 0}`,
+		`name:   "code len"`,
+		`{"form", "code", "111111", 0}`,
+		`name:   "file charset"`,
+		`{"form", "phone", "a", 0}`,
+		`h := &Api{}`,
+	} {
+		if !strings.Contains(tests.String(), part) {
+			t.Errorf("expected %s in tests:\n%s", part, tests.String())
+		}
+	}
+	typeCheck(t, []string{src}, generate(t, src), tests.Bytes())
+
+	cases := map[string]string{
+		"^[a-z]{2,}-[0-9]+$": "aa-0",
+		`^\+?[0-9]{3,15}$`:   "000",
+		"(go|rust)_v[1-9]":   "go_v1",
+		"^[^0-9]+$":          "a",
+	}
+	for pattern, expected := range cases {
+		if value, ok := regexpExample(pattern); !ok || value != expected {
+			t.Errorf("%s: expected %q example, got %q", pattern, expected, value)
+		}
+	}
+	// the generated test of the package with a constructor
+	multi := filepath.Join("testdata", "multi")
+	data, err = parseSrc([]string{multi}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tests, err = generateTests(bytes.Buffer{}, data, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !data.Constructors["NewApi"] || !strings.Contains(tests.String(), "h := NewApi()") {
+		t.Errorf("expected receivers made by NewApi, got %v", data.Constructors)
+	}
+}
+
+func TestTemplatesDir(t *testing.T) {
+	data, err := parseSrc([]string{filepath.Join("testdata", "types.go")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	templates := map[string]string{
+		"handlers.tmpl": `package {{.PackageName}}{{template "methods" .}}`,
+		"methods.tmpl":  `{{define "methods"}}{{range .Methods}} // {{GetMethodName .}}{{end}}{{end}}`,
+		// the client is the default one with its auth redefined
+		"auth.tmpl": `{{define "auth"}}"X-Custom", c.Auth{{end}}`,
+	}
+	for name, text := range templates {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf, err := generateCode(bytes.Buffer{}, data, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := buf.String(); code != "package api // Search // Contact" {
+		t.Errorf("unexpected code of custom template: %q", code)
+	}
+	buf, err = generateClient(bytes.Buffer{}, data, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := buf.String(); !strings.Contains(code, `"X-Custom", c.Auth`) || !strings.Contains(code, "func apiCall(") {
+		t.Errorf("expected default client with custom auth, got:\n%s", code)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "handlers.tmpl"), []byte("{{.Missing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateCode(bytes.Buffer{}, data, dir); err == nil || !strings.Contains(err.Error(), "template handlers") {
+		t.Errorf("expected template error, got %v", err)
+	}
+	if _, err := generateCode(bytes.Buffer{}, data, filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error of missing directory")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	src := filepath.Join("testdata", "errors.go")
+	_, err := parseSrc([]string{src}, "", "")
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		t.Fatalf("expected the list of errors, got %v", err)
+	}
+	expected := []string{
+		"errors.go:8:19: field BadParams.Name: unknown token: mandatory",
+		"errors.go:9:19: field BadParams.Alias: token paramname has no value",
+		"errors.go:10:19: field BadParams.Score: unsupported field type: complex128",
+		"errors.go:11:2: field BadParams.Plain has no apivalidator tag",
+		"errors.go:12:2: fields of BadParams must be declared one per line",
+		"errors.go:15:1: bad apigen:api config of BadConfig: invalid character 'u' looking for beginning of object key string",
+		"errors.go:21:29: method BadParamsCount must have two parameters: context and params",
+		"errors.go:26:47: type Missing is not declared in the package",
+		"errors.go:38:2: field BadNested.Level: embedded fields must be structs of the package",
+		"errors.go:39:2: field BadNested.Params: pointers to structs are not supported",
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("expected %d errors, got %d:\n%v", len(expected), len(list), err)
+	}
+	for i, e := range list {
+		if !strings.HasSuffix(e.Error(), expected[i]) {
+			t.Errorf("expected %q, got %q", expected[i], e)
+		}
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	cases := map[string][]string{
+		"values.go": {
+			"values.go:8:18: field ValueParams.Role: default \"guest\" fails enum rule",
+			"values.go:9:18: field ValueParams.Level: enum value \"x\" fails type rule",
+			"values.go:10:18: field ValueParams.Count: default \"20\" fails max rule",
+			"values.go:11:18: field ValueParams.Name: required fields can't have a default",
+			"values.go:13:18: field ValueParams.Tags: default \"a\" fails min rule",
+			"values.go:21:1: /a of Second is already handled by First",
+		},
+		"conflicts.go": {
+			"conflicts.go:21:45: ConflictParams: params ID and UserID have the same name id",
+			"conflicts.go:21:45: ConflictParams: params Limit and Paging.Limit have the same name limit",
+			"conflicts.go:21:45: ConflictParams: params Token and Auth have the same name X-Token",
+		},
+		"bounds.go": {
+			"bounds.go:8:17: field BoundParams.Age: min is greater than max",
+			"bounds.go:9:17: field BoundParams.Score: min is greater than max",
+			"bounds.go:10:17: field BoundParams.Tags: min is greater than max",
+		},
+		// conflicts are found with field errors
+		"mixed.go": {
+			"mixed.go:8:20: field MixedParams.Score: unsupported field type: complex128",
+			"mixed.go:16:45: MixedParams: params ID and UserID have the same name id",
+			"mixed.go:16:45: params of MixedParams can't be read from both form and json body",
+		},
+		"free.go": {
+			"free.go:11:1: apigen:api function Free must be a method",
+		},
+	}
+	for name, expected := range cases {
+		_, err := parseSrc([]string{filepath.Join("testdata", name)}, "", "")
+		list, ok := err.(scanner.ErrorList)
+		if !ok {
+			t.Fatalf("%s: expected the list of errors, got %v", name, err)
+		}
+		if len(list) != len(expected) {
+			t.Fatalf("%s: expected %d errors, got %d:\n%v", name, len(expected), len(list), err)
+		}
+		for i, e := range list {
+			if !strings.HasSuffix(e.Error(), expected[i]) {
+				t.Errorf("%s: expected %q, got %q", name, expected[i], e)
+			}
+		}
+	}
+}
+
+func TestNestedStructs(t *testing.T) {
+	src := filepath.Join("testdata", "nested.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []param{
+		{"limit", "Page.Limit", nil},
+		{"offset", "Page.Offset", nil},
+		{"query", "Query", nil},
+		{"location.home.city", "Location.Home.City", nil},
+		{"location.home.zip_code", "Location.Home.Zip", nil},
+		{"location.office.city", "Location.Work.City", nil},
+		{"location.office.zip_code", "Location.Work.Zip", nil},
+	}
+	params := data.GetParams("OrderParams")
+	if len(params) != len(expected) {
+		t.Fatalf("expected %d params, got %+v", len(expected), params)
+	}
+	for i, p := range params {
+		if p.Name != expected[i].Name || p.Path != expected[i].Path {
+			t.Errorf("expected %s %s, got %s %s", expected[i].Name, expected[i].Path, p.Name, p.Path)
+		}
+	}
+	client, err := generateClient(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, []string{src}, generate(t, src), client.Bytes())
+
+	cfg, err := parseStructFieldConfig(&ast.Field{Type: ast.NewIdent("Page")}, "Page")
+	if err != nil || !cfg.Embedded || cfg.Alias != "" {
+		t.Errorf("expected embedded Page, got %+v, %v", cfg, err)
+	}
+	field := &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent("Home")},
+		Type:  ast.NewIdent("Address"),
+		Tag:   &ast.BasicLit{Value: "`apivalidator:\"required\"`"},
+	}
+	if _, err := parseStructFieldConfig(field, "Address"); err == nil {
+		t.Error("expected error of required struct field")
+	}
+}
+
+func TestAuthSchemes(t *testing.T) {
+	src := filepath.Join("testdata", "auth.go")
+	data, err := parseSrc([]string{src}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]methodConfig{
+		"AuthApi.Key":    {AuthScheme: "header", AuthHeader: "X-Api-Key", AuthToken: "secret"},
+		"AuthApi.Bearer": {AuthScheme: "bearer", AuthToken: "secret"},
+		"AuthApi.Method": {AuthScheme: "method"},
+	}
+	for name, e := range expected {
+		cfg := data.MethodsCfg[name]
+		if cfg.AuthScheme != e.AuthScheme || cfg.AuthHeader != e.AuthHeader || cfg.AuthToken != e.AuthToken {
+			t.Errorf("%s: expected %+v, got %+v", name, e, cfg)
+		}
+	}
+	client, err := generateClient(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, []string{src}, generate(t, src), client.Bytes())
+
+	cases := map[string]string{
+		`{"url": "/", "auth": true, "auth_scheme": "basic"}`:  "unknown auth_scheme",
+		`{"url": "/", "auth": true, "auth_scheme": "bearer"}`: "auth_token is required",
+	}
+	for config, reason := range cases {
+		method := &ast.FuncDecl{Doc: &ast.CommentGroup{List: []*ast.Comment{{Text: "// apigen:api " + config}}}}
+		if _, err := parseMethodConfig(method); err == nil || !strings.Contains(err.Error(), reason) {
+			t.Errorf("%s: expected %q error, got %v", config, reason, err)
+		}
+	}
+	// the default is the header of the task
+	method := &ast.FuncDecl{Doc: &ast.CommentGroup{List: []*ast.Comment{{Text: `// apigen:api {"url": "/", "auth": true}`}}}}
+	if cfg, err := parseMethodConfig(method); err != nil || cfg.AuthHeader != "X-Auth" || cfg.AuthToken != "100500" {
+		t.Errorf("expected X-Auth 100500, got %+v, %v", cfg, err)
+	}
+}
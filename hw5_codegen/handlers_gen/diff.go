@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines around changes of hunks
+const diffContext = 3
+
+// maxDiffCells limits the table of common lines of changed parts of files,
+// larger parts are replaced as a whole
+const maxDiffCells = 1 << 22
+
+// diffOp is the line of the diff, Kind is ' ' for unchanged lines, '-'
+// for removed and '+' for added ones
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// diffFile returns the unified diff of the file and its generated content,
+// it's empty if they are equal. Missing files and nil content are
+// /dev/null.
+func diffFile(name string, content []byte) (string, error) {
+	old, err := ioutil.ReadFile(name)
+	oldName, newName := "a/"+name, "b/"+name
+	switch {
+	case os.IsNotExist(err):
+		oldName = "/dev/null"
+	case err != nil:
+		return "", err
+	}
+	if content == nil {
+		newName = "/dev/null"
+	}
+	return unifiedDiff(oldName, newName, old, content), nil
+}
+
+// diffOutputs writes the diffs of the outputs and their files to w, it
+// returns true if any of them differ
+func diffOutputs(w io.Writer, outputs []output) (bool, error) {
+	differ := false
+	for _, out := range outputs {
+		diff, err := diffFile(out.file, out.content)
+		if err != nil {
+			return false, err
+		}
+		if diff == "" {
+			continue
+		}
+		differ = true
+		if _, err := io.WriteString(w, diff); err != nil {
+			return false, err
+		}
+	}
+	return differ, nil
+}
+
+// unifiedDiff returns the unified diff of the texts, it's empty if they
+// are equal
+func unifiedDiff(oldName, newName string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+	out := &strings.Builder{}
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", oldName, newName)
+	// oldLine and newLine are the numbers of the lines before the op
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			oldLine, newLine = oldLine+1, newLine+1
+			i++
+			continue
+		}
+		// the hunk starts with the context before the change and ends when
+		// the next change is farther than the context after it
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops) && j <= end+2*diffContext+1; j++ {
+			if ops[j].Kind != ' ' {
+				end = j
+			}
+		}
+		stop := end + diffContext + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		hunkOld, hunkNew := oldLine-(i-start), newLine-(i-start)
+		var oldCount, newCount int
+		hunk := &strings.Builder{}
+		for _, op := range ops[start:stop] {
+			hunk.WriteByte(op.Kind)
+			hunk.WriteString(op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				hunk.WriteString("\n\\ No newline at end of file\n")
+			}
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(out, "@@ -%s +%s @@\n%s", hunkRange(hunkOld, oldCount), hunkRange(hunkNew, newCount), hunk)
+		for _, op := range ops[i:stop] {
+			if op.Kind != '+' {
+				oldLine++
+			}
+			if op.Kind != '-' {
+				newLine++
+			}
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+// hunkRange is the start and the count of lines of the hunk, the start of
+// the empty one is the line before it
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits the text after new lines
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the ops turning the lines of a to the ones of b, they
+// keep the longest common subsequence of the lines
+func diffLines(a, b []string) []diffOp {
+	var prefix, suffix []diffOp
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffOp{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	ops := prefix
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return append(ops, suffix...)
+	}
+	// common[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	common := make([][]int32, len(a)+1)
+	for i := range common {
+		common[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i, j = i+1, j+1
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return append(ops, suffix...)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(from, to int, replace map[int]string) string {
+		out := ""
+		for i := from; i <= to; i++ {
+			if line, ok := replace[i]; ok {
+				out += line
+				continue
+			}
+			out += strings.Repeat("x", i) + "\n"
+		}
+		return out
+	}
+	cases := []struct {
+		name, a, b, expected string
+	}{
+		{"equal", "a\nb\n", "a\nb\n", ""},
+		{"new file", "", "a\nb\n", "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n"},
+		{"removed file", "a\n", "", "--- a\n+++ b\n@@ -1 +0,0 @@\n-a\n"},
+		{
+			"no newline at end",
+			"a\nb", "a\nc\n",
+			"--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n",
+		},
+		{
+			"separate hunks",
+			lines(1, 20, nil), lines(1, 20, map[int]string{2: "two\n", 18: ""}),
+			"--- a\n+++ b\n@@ -1,5 +1,5 @@\n x\n-xx\n+two\n xxx\n xxxx\n xxxxx\n" +
+				"@@ -15,6 +15,5 @@\n " + strings.Repeat("x", 15) + "\n " + strings.Repeat("x", 16) + "\n " + strings.Repeat("x", 17) + "\n-" + strings.Repeat("x", 18) + "\n " + strings.Repeat("x", 19) + "\n " + strings.Repeat("x", 20) + "\n",
+		},
+		{
+			"merged hunks",
+			lines(1, 10, nil), lines(1, 10, map[int]string{2: "", 9: "nine\n"}),
+			"--- a\n+++ b\n@@ -1,10 +1,9 @@\n x\n-xx\n xxx\n xxxx\n xxxxx\n xxxxxx\n xxxxxxx\n xxxxxxxx\n-xxxxxxxxx\n+nine\n xxxxxxxxxx\n",
+		},
+	}
+	for _, c := range cases {
+		if diff := unifiedDiff("a", "b", []byte(c.a), []byte(c.b)); diff != c.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", c.name, c.expected, diff)
+		}
+	}
+}
+
+func TestDiffOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codegen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	same, changed, stale, missing := filepath.Join(dir, "same.go"), filepath.Join(dir, "changed.go"),
+		filepath.Join(dir, "stale.go"), filepath.Join(dir, "missing.go")
+	for _, file := range []string{same, changed, stale} {
+		if err := ioutil.WriteFile(file, []byte("package api\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	differ, err := diffOutputs(out, []output{{same, []byte("package api\n")}})
+	if err != nil || differ || out.Len() != 0 {
+		t.Fatalf("expected no diff of the same file, got %v %v %q", differ, err, out)
+	}
+	differ, err = diffOutputs(out, []output{
+		{same, []byte("package api\n")},
+		{changed, []byte("package api2\n")},
+		{stale, nil},
+		{missing, []byte("package api\n")},
+	})
+	if err != nil || !differ {
+		t.Fatalf("expected diff, got %v %v", differ, err)
+	}
+	for _, part := range []string{
+		"--- a/" + changed + "\n+++ b/" + changed + "\n@@ -1 +1 @@\n-package api\n+package api2\n",
+		"--- a/" + stale + "\n+++ /dev/null\n@@ -1 +0,0 @@\n-package api\n",
+		"--- /dev/null\n+++ b/" + missing + "\n@@ -0,0 +1 @@\n+package api\n",
+	} {
+		if !strings.Contains(out.String(), part) {
+			t.Errorf("expected %s in diff:\n%s", part, out)
+		}
+	}
+	if strings.Contains(out.String(), same) {
+		t.Errorf("unexpected diff of the same file:\n%s", out)
+	}
+	// nothing is written
+	if src, err := ioutil.ReadFile(changed); err != nil || string(src) != "package api\n" {
+		t.Errorf("expected the changed file kept, got %q %v", src, err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("expected the missing file not written, got %v", err)
+	}
+}
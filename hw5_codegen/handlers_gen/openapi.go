@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// yamlMap is the mapping of the spec keeping the order of keys
+type yamlMap []yamlItem
+
+type yamlItem struct {
+	Key   string
+	Value interface{}
+}
+
+// openAPIFormats are the formats of the spec for validator formats
+var openAPIFormats = map[string]string{
+	"email": "email",
+	"url":   "uri",
+	"uuid":  "uuid",
+}
+
+// generateOpenAPI makes OpenAPI 3 spec of the handlers, api is the
+// receiver type to document, all of them if it's empty. Handlers of
+// different types must have different URLs in the same spec.
+func generateOpenAPI(data *tmplData, api string) ([]byte, error) {
+	paths := make(map[string]yamlMap)
+	// servedBy are the types serving the paths
+	servedBy := make(map[string]string)
+	securitySchemes := yamlMap{}
+	seenSchemes := make(map[string]bool)
+	for _, method := range data.Methods {
+		recvType := GetMethodRecvTypeName(method)
+		if api != "" && recvType != api {
+			continue
+		}
+		cfg := data.GetMethodConfig(method)
+		if other, ok := servedBy[cfg.URL]; ok {
+			return nil, fmt.Errorf("%s is served by %s and %s, choose one of them with -api", cfg.URL, other, recvType)
+		}
+		servedBy[cfg.URL] = recvType
+		if name, scheme := securityScheme(cfg); scheme != nil && !seenSchemes[name] {
+			seenSchemes[name] = true
+			securitySchemes = append(securitySchemes, yamlItem{name, scheme})
+		}
+		if cfg.HTTPMethod != "" {
+			paths[cfg.URL] = yamlMap{{strings.ToLower(cfg.HTTPMethod), newOperation(data, method, cfg, "")}}
+			continue
+		}
+		// the handler accepts any method, operation IDs must be unique
+		paths[cfg.URL] = yamlMap{
+			{"get", newOperation(data, method, cfg, "Get")},
+			{"post", newOperation(data, method, cfg, "Post")},
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no handlers of %s", api)
+	}
+	urls := make([]string, 0, len(paths))
+	for url := range paths {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	pathItems := yamlMap{}
+	for _, url := range urls {
+		pathItems = append(pathItems, yamlItem{url, paths[url]})
+	}
+	title := data.PackageName
+	if api != "" {
+		title = api
+	}
+	components := yamlMap{}
+	if len(securitySchemes) > 0 {
+		components = append(components, yamlItem{"securitySchemes", securitySchemes})
+	}
+	properties := yamlMap{
+		{"error", yamlMap{{"type", "string"}}},
+		{"response", yamlMap{}},
+	}
+	if data.StructuredErrors {
+		properties = append(properties,
+			yamlItem{"code", yamlMap{{"type", "string"}}},
+			yamlItem{"errors", yamlMap{
+				{"type", "array"},
+				{"items", yamlMap{
+					{"type", "object"},
+					{"properties", yamlMap{
+						{"field", yamlMap{{"type", "string"}}},
+						{"rule", yamlMap{{"type", "string"}}},
+						{"message", yamlMap{{"type", "string"}}},
+					}},
+				}},
+			}},
+		)
+	}
+	spec := yamlMap{
+		{"openapi", "3.0.3"},
+		{"info", yamlMap{
+			{"title", title + " API"},
+			{"version", "1.0.0"},
+		}},
+		{"paths", pathItems},
+		{"components", append(components,
+			yamlItem{"schemas", yamlMap{
+				{"APIResponse", yamlMap{
+					{"type", "object"},
+					{"properties", properties},
+				}},
+			}},
+		)},
+	}
+	buf := &bytes.Buffer{}
+	writeYAML(buf, spec, 0)
+	return buf.Bytes(), nil
+}
+
+// newOperation documents the handler of the method, responses are the
+// ones the generated handler writes. suffix is added to the operation ID.
+func newOperation(data *tmplData, method *ast.FuncDecl, cfg *methodConfig, suffix string) yamlMap {
+	paramType := GetMethodParamTypeName(method, 1)
+	var params []interface{}
+	// body is the schema of params of the form or JSON body
+	body := newObjectSchema()
+	bodyType := "application/x-www-form-urlencoded"
+	if data.HasFiles(paramType) {
+		bodyType = "multipart/form-data"
+	}
+	for _, p := range data.GetParams(paramType) {
+		param := newParameter(p.Name, p.Cfg)
+		switch {
+		case p.Cfg.Source == "form" || p.Cfg.Source == "json" || p.Cfg.Type == "file":
+			keys := []string{p.Name}
+			if p.Cfg.Source == "json" {
+				// dots are the keys of nested objects
+				bodyType, keys = "application/json", strings.Split(p.Name, ".")
+			}
+			body = setProperty(body, keys, param[len(param)-1].Value, p.Cfg.Required)
+		default:
+			params = append(params, param)
+		}
+	}
+	if cfg.HasContext("request_id") {
+		params = append(params, yamlMap{
+			{"name", "X-Request-Id"},
+			{"in", "header"},
+			{"description", "ID of the request, it's random without it"},
+			{"schema", yamlMap{{"type", "string"}}},
+		})
+	}
+	response := func(description string) yamlMap {
+		return yamlMap{
+			{"description", description},
+			{"content", yamlMap{
+				{"application/json", yamlMap{
+					{"schema", yamlMap{{"$ref", "#/components/schemas/APIResponse"}}},
+				}},
+			}},
+		}
+	}
+	responses := yamlMap{
+		{"200", response("result of " + GetMethodName(method))},
+		{"400", response("invalid parameter")},
+	}
+	if cfg.Auth {
+		responses = append(responses, yamlItem{"403", response("unauthorized")})
+	}
+	switch {
+	case cfg.HTTPMethod != "" && data.Router != "":
+		// routers match methods
+		responses = append(responses, yamlItem{"405", response("bad method")})
+	case cfg.HTTPMethod != "":
+		responses = append(responses, yamlItem{"406", response("bad method")})
+	}
+	if cfg.MaxBodyKB > 0 {
+		responses = append(responses, yamlItem{"413", response("request body too large")})
+	}
+	responses = append(responses, yamlItem{"500", response("internal error")})
+	if cfg.TimeoutMS > 0 {
+		responses = append(responses, yamlItem{"504", response("timeout")})
+	}
+
+	op := yamlMap{
+		{"operationId", GetMethodRecvTypeName(method) + GetMethodName(method) + suffix},
+		{"tags", []interface{}{GetMethodRecvTypeName(method)}},
+	}
+	if len(params) > 0 {
+		op = append(op, yamlItem{"parameters", params})
+	}
+	if len(body[1].Value.(yamlMap)) > 0 {
+		op = append(op, yamlItem{"requestBody", yamlMap{
+			{"content", yamlMap{{bodyType, yamlMap{{"schema", body}}}}},
+		}})
+	}
+	if name, scheme := securityScheme(cfg); scheme != nil {
+		op = append(op, yamlItem{"security", []interface{}{yamlMap{{name, []interface{}{}}}}})
+	}
+	return append(op, yamlItem{"responses", responses})
+}
+
+// newObjectSchema is the schema of the object without properties, the
+// required ones are added after them
+func newObjectSchema() yamlMap {
+	return yamlMap{{"type", "object"}, {"properties", yamlMap{}}}
+}
+
+func isObjectSchema(schema yamlMap) bool {
+	return len(schema) >= 2 && schema[0].Value == "object" && schema[1].Key == "properties"
+}
+
+// setProperty adds the schema of the property to the object schema, the
+// keys before the last one are nested objects
+func setProperty(object yamlMap, keys []string, schema interface{}, required bool) yamlMap {
+	properties := object[1].Value.(yamlMap)
+	if len(keys) == 1 {
+		object[1].Value = append(properties, yamlItem{keys[0], schema})
+		if required {
+			if len(object) == 2 {
+				object = append(object, yamlItem{"required", []interface{}{}})
+			}
+			object[2].Value = append(object[2].Value.([]interface{}), keys[0])
+		}
+		return object
+	}
+	for i, item := range properties {
+		if nested, ok := item.Value.(yamlMap); ok && item.Key == keys[0] && isObjectSchema(nested) {
+			properties[i].Value = setProperty(nested, keys[1:], schema, required)
+			return object
+		}
+	}
+	nested := setProperty(newObjectSchema(), keys[1:], schema, required)
+	object[1].Value = append(properties, yamlItem{keys[0], nested})
+	return object
+}
+
+// securityScheme returns the name and the scheme of the method auth, nil
+// if it isn't authorized
+func securityScheme(cfg *methodConfig) (string, yamlMap) {
+	if !cfg.Auth {
+		return "", nil
+	}
+	switch cfg.AuthScheme {
+	case "bearer":
+		return "bearer", yamlMap{{"type", "http"}, {"scheme", "bearer"}}
+	case "method":
+		return "authorize", yamlMap{
+			{"type", "apiKey"},
+			{"in", "header"},
+			{"name", "Authorization"},
+			{"description", "checked by Authorize method of the API"},
+		}
+	}
+	return cfg.AuthHeader, yamlMap{{"type", "apiKey"}, {"in", "header"}, {"name", cfg.AuthHeader}}
+}
+
+// newParameter documents the query or header parameter of the field,
+// []string is comma separated. The schema is the last item.
+func newParameter(name string, cfg *fieldConfig) yamlMap {
+	schema := yamlMap{}
+	value := schema
+	switch cfg.Type {
+	case "int", "uint":
+		schema = append(schema, yamlItem{"type", "integer"})
+	case "int64", "uint64":
+		schema = append(schema, yamlItem{"type", "integer"}, yamlItem{"format", "int64"})
+	case "time.Time":
+		switch cfg.Layout {
+		case "unix":
+			schema = append(schema, yamlItem{"type", "integer"}, yamlItem{"format", "int64"})
+		case time.RFC3339, time.RFC3339Nano:
+			schema = append(schema, yamlItem{"type", "string"}, yamlItem{"format", "date-time"})
+		case timeLayouts["DateOnly"]:
+			schema = append(schema, yamlItem{"type", "string"}, yamlItem{"format", "date"})
+		default:
+			schema = append(schema, yamlItem{"type", "string"})
+		}
+	case "float64":
+		schema = append(schema, yamlItem{"type", "number"})
+	case "bool":
+		schema = append(schema, yamlItem{"type", "boolean"})
+	case "file":
+		schema = append(schema, yamlItem{"type", "string"}, yamlItem{"format", "binary"})
+	case "string", "[]string":
+		value = yamlMap{{"type", "string"}}
+		if cfg.Format != "" {
+			value = append(value, yamlItem{"format", openAPIFormats[cfg.Format]})
+		}
+		if cfg.Regexp != "" {
+			value = append(value, yamlItem{"pattern", cfg.Regexp})
+		}
+		if cfg.Type == "string" && cfg.HasMin {
+			value = append(value, yamlItem{"minLength", int(cfg.Min)})
+		}
+		if cfg.HasMaxLen {
+			value = append(value, yamlItem{"maxLength", cfg.MaxLen})
+		}
+		if len(cfg.Enum) > 0 {
+			value = append(value, yamlItem{"enum", enumValues(cfg)})
+		}
+		if cfg.Type == "string" {
+			schema = value
+		} else {
+			schema = yamlMap{{"type", "array"}, {"items", value}}
+			if cfg.HasMin {
+				schema = append(schema, yamlItem{"minItems", int(cfg.Min)})
+			}
+			if cfg.HasMax {
+				schema = append(schema, yamlItem{"maxItems", int(cfg.Max)})
+			}
+		}
+	}
+	if (cfg.Type == "uint" || cfg.Type == "uint64") && !cfg.HasMin {
+		schema = append(schema, yamlItem{"minimum", 0})
+	}
+	// bounds of times in other layouts are in the description
+	if numberTypes[cfg.Type] || cfg.Layout == "unix" {
+		if cfg.HasMin {
+			schema = append(schema, yamlItem{"minimum", number(cfg, cfg.Min)})
+		}
+		if cfg.HasMax {
+			schema = append(schema, yamlItem{"maximum", number(cfg, cfg.Max)})
+		}
+		if len(cfg.Enum) > 0 {
+			schema = append(schema, yamlItem{"enum", enumValues(cfg)})
+		}
+	}
+	if cfg.Default != "" {
+		schema = append(schema, yamlItem{"default", typedValue(cfg, cfg.Default)})
+	}
+	param := yamlMap{
+		{"name", name},
+		{"in", "query"},
+	}
+	if cfg.Source == "header" {
+		param[1].Value = "header"
+	}
+	if cfg.Required {
+		param = append(param, yamlItem{"required", true})
+	}
+	// the default is known at runtime only
+	switch {
+	case cfg.DefaultEnv != "":
+		param = append(param, yamlItem{"description", "defaults to " + cfg.DefaultEnv + " environment variable"})
+	case cfg.DefaultConst != "":
+		param = append(param, yamlItem{"description", "defaults to " + cfg.DefaultConst + " constant"})
+	case cfg.Type == "time.Time" && cfg.Layout != "unix":
+		param = append(param, yamlItem{"description", timeDescription(cfg)})
+	}
+	if cfg.Type == "[]string" {
+		param = append(param, yamlItem{"style", "form"}, yamlItem{"explode", false})
+	}
+	return append(param, yamlItem{"schema", schema})
+}
+
+func number(cfg *fieldConfig, value float64) interface{} {
+	if cfg.Type != "float64" {
+		return int64(value)
+	}
+	return value
+}
+
+// timeDescription describes the layout and bounds of the time param
+func timeDescription(cfg *fieldConfig) string {
+	description := "time in layout " + cfg.Layout
+	if cfg.HasMin {
+		description += ", from " + time.Unix(int64(cfg.Min), 0).UTC().Format(cfg.Layout)
+	}
+	if cfg.HasMax {
+		description += ", to " + time.Unix(int64(cfg.Max), 0).UTC().Format(cfg.Layout)
+	}
+	return description
+}
+
+func enumValues(cfg *fieldConfig) []interface{} {
+	var values []interface{}
+	for _, v := range cfg.Enum {
+		values = append(values, typedValue(cfg, v))
+	}
+	return values
+}
+
+// typedValue is the value of the tag in the type of the field, it's kept
+// as is if it can't be parsed
+func typedValue(cfg *fieldConfig, value string) interface{} {
+	switch cfg.Type {
+	case "int", "int64", "uint", "uint64", "time.Time":
+		if cfg.Type == "time.Time" && cfg.Layout != "unix" {
+			return value
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "float64":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case "[]string":
+		if !strings.Contains(value, ",") {
+			return value
+		}
+		var items []interface{}
+		for _, item := range strings.Split(value, ",") {
+			items = append(items, item)
+		}
+		return items
+	}
+	return value
+}
+
+// plainKey matches keys written without quotes
+var plainKey = regexp.MustCompile(`^[A-Za-z/$][A-Za-z0-9_/.$-]*$`)
+
+// writeYAML writes the value of yamlMap, []interface{} and scalars, the
+// block of mappings and sequences is indented
+func writeYAML(buf *bytes.Buffer, value interface{}, indent int) {
+	prefix := strings.Repeat(" ", indent)
+	switch v := value.(type) {
+	case yamlMap:
+		for _, item := range v {
+			key := item.Key
+			if !plainKey.MatchString(key) {
+				key = strconv.Quote(key)
+			}
+			buf.WriteString(prefix + key + ":")
+			writeYAMLValue(buf, item.Value, indent+2)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			// the first line of the element block follows the dash
+			elemBuf := &bytes.Buffer{}
+			if isScalar(elem) {
+				elemBuf.WriteString(prefix + "  " + yamlScalar(elem) + "\n")
+			} else {
+				writeYAML(elemBuf, elem, indent+2)
+			}
+			buf.WriteString(prefix + "- " + strings.TrimPrefix(elemBuf.String(), prefix+"  "))
+		}
+	}
+}
+
+func writeYAMLValue(buf *bytes.Buffer, value interface{}, indent int) {
+	switch v := value.(type) {
+	case yamlMap:
+		if len(v) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+	default:
+		buf.WriteString(" " + yamlScalar(value) + "\n")
+		return
+	}
+	buf.WriteString("\n")
+	writeYAML(buf, value, indent)
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case yamlMap, []interface{}:
+		return false
+	}
+	return true
+}
+
+// yamlScalar writes strings double quoted, their escapes are the ones of
+// YAML as well
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
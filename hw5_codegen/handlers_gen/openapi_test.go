@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	data, err := parseSrc([]string{filepath.Join("testdata", "multi")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := generateOpenAPI(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(filepath.Join("testdata", "multi.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(spec, expected) {
+		t.Errorf("unexpected spec:\n%s", spec)
+	}
+}
+
+func TestOpenAPIParameters(t *testing.T) {
+	data, err := parseSrc([]string{filepath.Join("testdata", "types.go")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := generateOpenAPI(data, "Api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range []string{
+		"type: \"number\"\n            minimum: 0.5\n            maximum: 10\n            default: 1\n",
+		"type: \"boolean\"\n            default: false\n",
+		"type: \"array\"\n            items:\n              type: \"string\"\n              enum:\n                - \"go\"\n",
+		"name: \"email\"\n          in: \"query\"\n          required: true\n          schema:\n            type: \"string\"\n            format: \"email\"\n",
+		"format: \"uri\"",
+		"format: \"uuid\"",
+		`pattern: "^\\+?[0-9]{3,15}$"`,
+	} {
+		if !strings.Contains(string(spec), part) {
+			t.Errorf("expected %q in spec:\n%s", part, spec)
+		}
+	}
+}
+
+func TestOpenAPIConflicts(t *testing.T) {
+	data, err := parseSrc([]string{filepath.Join("..", "api.go")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := generateOpenAPI(data, ""); err == nil || !strings.Contains(err.Error(), "-api") {
+		t.Errorf("expected the conflict of /user/create, got %v", err)
+	}
+	spec, err := generateOpenAPI(data, "OtherApi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(spec), "OtherApiCreate") || strings.Contains(string(spec), "/user/profile") {
+		t.Errorf("expected handlers of OtherApi only:\n%s", spec)
+	}
+	if _, err := generateOpenAPI(data, "Unknown"); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
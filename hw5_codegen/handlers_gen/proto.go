@@ -0,0 +1,544 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// protoParamTypes are proto types of params by supportedTypes
+var protoParamTypes = map[string]string{
+	"int":       "int64",
+	"int64":     "int64",
+	"uint":      "uint64",
+	"uint64":    "uint64",
+	"float64":   "double",
+	"bool":      "bool",
+	"string":    "string",
+	"[]string":  "string",
+	"time.Time": "string",
+}
+
+// protoBasicTypes are proto types of Go basic types
+var protoBasicTypes = map[string]string{
+	"string":  "string",
+	"bool":    "bool",
+	"int":     "int64",
+	"int8":    "int32",
+	"int16":   "int32",
+	"int32":   "int32",
+	"int64":   "int64",
+	"uint":    "uint64",
+	"uint8":   "uint32",
+	"uint16":  "uint32",
+	"uint32":  "uint32",
+	"uint64":  "uint64",
+	"float32": "float",
+	"float64": "double",
+	"byte":    "uint32",
+	"rune":    "int32",
+}
+
+// protoValue is the type of JSON values proto types can't describe
+const protoValue = "google.protobuf.Value"
+
+// protoInvalid matches characters field names can't have
+var protoInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// protoMessage is the message of params or of the JSON of results
+type protoMessage struct {
+	Name   string
+	Fields []protoField
+}
+
+// protoField is the field of the message, JSONName is the name of params
+// or of the JSON of results, Name is the valid proto name of it
+type protoField struct {
+	Label    string
+	Type     string
+	Name     string
+	Number   int
+	JSONName string
+}
+
+// protoData is the data of the proto and gRPC templates, messages of
+// params and results are converted with their JSON like the one of the
+// handlers
+type protoData struct {
+	*tmplData
+	// GoPackage is the import path of the code protoc generates
+	GoPackage string
+	Messages  []*protoMessage
+	// UsesValue reports whether some fields are google.protobuf.Value
+	UsesValue bool
+	declared  map[string]bool
+}
+
+// newProtoData declares messages of param structs and the types of method
+// results, results must be structs
+func newProtoData(data *tmplData, goPackage string) (*protoData, error) {
+	pd := &protoData{tmplData: data, GoPackage: goPackage, declared: make(map[string]bool)}
+	names := make([]string, 0, len(data.Structs))
+	for name := range data.Structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		msg := &protoMessage{Name: name}
+		if err := pd.addParams(msg, name); err != nil {
+			return nil, err
+		}
+		pd.Messages = append(pd.Messages, msg)
+	}
+	for _, method := range data.Methods {
+		if pd.ResultMessage(method) == "" {
+			return nil, fmt.Errorf("result of %s must be a struct of the package for gRPC", GetMethodName(method))
+		}
+	}
+	return pd, nil
+}
+
+// addParams adds fields of params of the struct to the message, fields of
+// embedded structs are the ones of the message
+func (pd *protoData) addParams(msg *protoMessage, structName string) error {
+	for _, field := range pd.Structs[structName].Fields.List {
+		cfg := pd.GetFieldConfig(structName, getFieldName(field))
+		switch {
+		case cfg.Embedded:
+			if err := pd.addParams(msg, cfg.Struct); err != nil {
+				return err
+			}
+		case cfg.Struct != "":
+			pd.addField(msg, "", cfg.Struct, cfg.Alias)
+		case cfg.Type == "file":
+			return fmt.Errorf("field %s.%s: files aren't supported by gRPC", structName, getFieldName(field))
+		case cfg.Type == "[]string":
+			pd.addField(msg, "repeated", "string", cfg.Alias)
+		case cfg.Layout == "unix":
+			pd.addField(msg, "optional", "int64", cfg.Alias)
+		default:
+			// params have presence, so defaults are set to missing ones only
+			pd.addField(msg, "optional", protoParamTypes[cfg.Type], cfg.Alias)
+		}
+	}
+	return nil
+}
+
+// addField adds the field named after its JSON name, the number of the
+// name is added if the message has the field of the same name
+func (pd *protoData) addField(msg *protoMessage, label, protoType, jsonName string) {
+	name := protoInvalid.ReplaceAllString(jsonName, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "f_" + name
+	}
+	for i, unique := 2, name; ; i++ {
+		taken := false
+		for _, field := range msg.Fields {
+			taken = taken || field.Name == unique
+		}
+		if !taken {
+			name = unique
+			break
+		}
+		unique = name + "_" + strconv.Itoa(i)
+	}
+	if protoType == protoValue {
+		// values may be arrays and null
+		pd.UsesValue, label = true, ""
+	}
+	msg.Fields = append(msg.Fields, protoField{label, protoType, name, len(msg.Fields) + 1, jsonName})
+}
+
+// ResultMessage is the message of the method result, it's empty if the
+// result isn't the struct of the package
+func (pd *protoData) ResultMessage(method *ast.FuncDecl) string {
+	expr := method.Type.Results.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	ident, ok := expr.(*ast.Ident)
+	if !ok || pd.Types[ident.Name] == nil {
+		return ""
+	}
+	if _, ok := pd.Types[ident.Name].Type.(*ast.StructType); !ok {
+		return ""
+	}
+	return pd.declare(ident.Name, pd.Types[ident.Name].Type.(*ast.StructType))
+}
+
+// declare adds the message of the struct to Messages once and returns its
+// name, param structs have Result suffix since their JSON differs from
+// their params
+func (pd *protoData) declare(name string, st *ast.StructType) string {
+	if pd.Structs[name] != nil {
+		name += "Result"
+	}
+	if pd.declared[name] {
+		return name
+	}
+	pd.declared[name] = true
+	msg := &protoMessage{Name: name}
+	pd.Messages = append(pd.Messages, msg)
+	pd.addJSONFields(msg, st)
+	return name
+}
+
+// addJSONFields adds the fields of the struct by encoding/json rules,
+// fields of embedded structs without names are the ones of the message
+func (pd *protoData) addJSONFields(msg *protoMessage, st *ast.StructType) {
+	for _, field := range st.Fields.List {
+		tag := ""
+		if field.Tag != nil {
+			if value, err := strconv.Unquote(field.Tag.Value); err == nil {
+				tag = reflect.StructTag(value).Get("json")
+			}
+		}
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		names := field.Names
+		if len(names) == 0 {
+			typeName := getTypeNameFromExpr(field.Type)
+			typeName = typeName[strings.LastIndex(typeName, ".")+1:]
+			if spec := pd.Types[typeName]; opts[0] == "" && spec != nil {
+				if st, ok := spec.Type.(*ast.StructType); ok {
+					pd.addJSONFields(msg, st)
+					continue
+				}
+			}
+			names = []*ast.Ident{ast.NewIdent(typeName)}
+		}
+		for _, ident := range names {
+			if !ident.IsExported() {
+				continue
+			}
+			name := opts[0]
+			if name == "" {
+				name = ident.Name
+			}
+			label, protoType := pd.protoType(msg.Name+ident.Name, field.Type)
+			for _, opt := range opts[1:] {
+				if opt == "string" {
+					label, protoType = "optional", "string"
+				}
+			}
+			pd.addField(msg, label, protoType, name)
+		}
+	}
+}
+
+// protoType is the label and the type of the field of the Go type, types
+// of the package and anonymous structs are messages. Values proto types
+// can't describe are google.protobuf.Value.
+func (pd *protoData) protoType(name string, expr ast.Expr) (string, string) {
+	switch node := expr.(type) {
+	case *ast.Ident:
+		if spec := pd.Types[node.Name]; spec != nil {
+			if st, ok := spec.Type.(*ast.StructType); ok {
+				return "", pd.declare(node.Name, st)
+			}
+			return pd.protoType(name, spec.Type)
+		}
+		if protoType, ok := protoBasicTypes[node.Name]; ok {
+			return "", protoType
+		}
+	case *ast.StarExpr:
+		label, protoType := pd.protoType(name, node.X)
+		if label == "" && protoBasicTypes[types.ExprString(node.X)] != "" {
+			// null is the missing value
+			label = "optional"
+		}
+		return label, protoType
+	case *ast.ArrayType:
+		if ident, ok := node.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			// []byte is base64 string
+			return "", "bytes"
+		}
+		label, protoType := pd.protoType(name, node.Elt)
+		if label != "repeated" && !strings.HasPrefix(protoType, "map<") && protoType != protoValue {
+			return "repeated", protoType
+		}
+	case *ast.MapType:
+		label, protoType := pd.protoType(name, node.Value)
+		if label != "repeated" && !strings.HasPrefix(protoType, "map<") && protoType != protoValue {
+			return "", "map<string, " + protoType + ">"
+		}
+	case *ast.SelectorExpr:
+		if types.ExprString(node) == "time.Time" {
+			return "", "string"
+		}
+	case *ast.StructType:
+		return "", pd.declare(name, node)
+	}
+	return "", protoValue
+}
+
+// generateProto makes the proto file of services of receiver types with
+// messages of params and results. dir is the directory of templates
+// overriding the default one.
+func generateProto(buf bytes.Buffer, data *protoData, dir string) (bytes.Buffer, error) {
+	funcMap := make(template.FuncMap)
+	funcMap["GetRecvTypes"] = GetRecvTypes
+	funcMap["GetMethodName"] = GetMethodName
+	funcMap["GetMethodParamTypeName"] = GetMethodParamTypeName
+
+	tmpl, err := loadTemplate(dir, "proto", tmplProto, funcMap)
+	if err != nil {
+		return buf, err
+	}
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+// generateGRPC makes servers of receiver types delegating to their methods
+// with the validation and auth of the handlers. dir is the directory of
+// templates overriding the default one.
+func generateGRPC(buf bytes.Buffer, data *protoData, dir string) (bytes.Buffer, error) {
+	funcMap := make(template.FuncMap)
+	funcMap["GetRecvTypes"] = GetRecvTypes
+	funcMap["GetMethodName"] = GetMethodName
+	funcMap["GetMethodParamTypeName"] = GetMethodParamTypeName
+	funcMap["GetMethodRecvName"] = GetMethodRecvName
+	funcMap["ReturnsMeta"] = ReturnsMeta
+
+	tmpl, err := loadTemplate(dir, "grpc", tmplGRPC, funcMap)
+	if err != nil {
+		return buf, err
+	}
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+var tmplProto = `syntax = "proto3";
+
+package {{.PackageName}};
+{{with .GoPackage}}
+option go_package = "{{.}}";
+{{end}}
+{{- if .UsesValue}}
+import "google/protobuf/struct.proto";
+{{end}}
+{{- range $recvName, $methods := GetRecvTypes .Methods}}
+service {{$recvName}} {
+{{- range $method := $methods}}
+  rpc {{GetMethodName $method}}({{GetMethodParamTypeName $method 1}}) returns ({{$.ResultMessage $method}});
+{{- end}}
+}
+{{end}}
+{{- range $msg := .Messages}}
+message {{$msg.Name}} {
+{{- range $field := $msg.Fields}}
+  {{with $field.Label}}{{.}} {{end}}{{$field.Type}} {{$field.Name}} = {{$field.Number}} [json_name = {{printf "%q" $field.JSONName}}];
+{{- end}}
+}
+{{end -}}
+`
+
+var tmplGRPC = `
+package {{.PackageName}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb {{printf "%q" .GoPackage}}
+)
+
+// time is used by timeouts of methods only
+var _ = time.Millisecond
+
+// grpcParam is the param of the message read by its validator from the
+// source
+type grpcParam struct {
+	Name   string
+	Source string
+}
+
+// grpcRequest is the request of the params of the message and the metadata
+// of the context, validators and auth of the handlers read it like the
+// HTTP one
+func grpcRequest(ctx context.Context, in proto.Message, params []grpcParam) (*http.Request, error) {
+	buf, err := protojson.Marshal(in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	body := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	r := &http.Request{
+		Method:   http.MethodPost,
+		URL:      &url.URL{},
+		Header:   make(http.Header),
+		Form:     make(url.Values),
+		PostForm: make(url.Values),
+	}
+	r = r.WithContext(context.WithValue(ctx, jsonBodyKey{}, body))
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	query := make(url.Values)
+	for _, p := range params {
+		value := jsonValue(r, p.Name)
+		if value == "" {
+			continue
+		}
+		switch p.Source {
+		case "header":
+			r.Header.Set(p.Name, value)
+		case "query":
+			query.Set(p.Name, value)
+			r.Form.Set(p.Name, value)
+		case "form":
+			r.PostForm.Set(p.Name, value)
+			r.Form.Set(p.Name, value)
+		case "json":
+			// it's read from the body
+		default:
+			r.Form.Set(p.Name, value)
+		}
+	}
+	r.URL.RawQuery = query.Encode()
+	return r, nil
+}
+
+// grpcCodes are the codes of HTTP statuses of ApiError
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:         codes.InvalidArgument,
+	http.StatusUnauthorized:       codes.Unauthenticated,
+	http.StatusForbidden:          codes.PermissionDenied,
+	http.StatusNotFound:           codes.NotFound,
+	http.StatusConflict:           codes.AlreadyExists,
+	http.StatusPreconditionFailed: codes.FailedPrecondition,
+	http.StatusTooManyRequests:    codes.ResourceExhausted,
+	http.StatusNotImplemented:     codes.Unimplemented,
+	http.StatusServiceUnavailable: codes.Unavailable,
+	http.StatusGatewayTimeout:     codes.DeadlineExceeded,
+}
+
+// grpcError is the status of the error, the code of ApiError is the one of
+// its HTTP status, it's the code otherwise
+func grpcError(err error, code codes.Code) error {
+	if apiError, ok := err.(ApiError); ok {
+		code = codes.Unknown
+		if c, ok := grpcCodes[apiError.HTTPStatus]; ok {
+			code = c
+		}
+	}
+	if err == context.DeadlineExceeded {
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcResult converts the result to the message with its JSON
+func grpcResult(result interface{}, out proto.Message) error {
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if string(buf) == "null" {
+		return nil
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(buf, out); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+{{range $structName, $struct := .Structs}}
+var grpcParams{{$structName}} = []grpcParam{
+	{{- range $p := $.GetParams $structName}}
+	{ {{- printf "%q" $p.Name}}, {{printf "%q" $p.Cfg.Source -}} },
+	{{- end}}
+}
+{{end}}
+
+{{range $recvTypeName, $methods := GetRecvTypes .Methods}}
+// {{$recvTypeName}}GRPC serves methods of {{$recvTypeName}} with gRPC, params are
+// validated and requests are authorized like the ones of the handlers
+type {{$recvTypeName}}GRPC struct {
+	pb.Unimplemented{{$recvTypeName}}Server
+	Impl {{if not (index $.Interfaces $recvTypeName)}}*{{end}}{{$recvTypeName}}
+}
+{{range $method := $methods}}
+{{- $methodName := GetMethodName $method}}
+{{- $methodCfg := $.GetMethodConfig $method}}
+{{- $paramType := GetMethodParamTypeName $method 1}}
+{{- $resultMessage := $.ResultMessage $method}}
+func (s *{{$recvTypeName}}GRPC) {{$methodName}}(ctx context.Context, in *pb.{{$paramType}}) (*pb.{{$resultMessage}}, error) {
+	r, err := grpcRequest(ctx, in, grpcParams{{$paramType}})
+	if err != nil {
+		return nil, err
+	}
+	{{- if $methodCfg.HasContext "request_id"}}
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+	}
+	{{- end}}
+	{{- if $methodCfg.HasContext "principal"}}
+	principal, err := s.Impl.Authorize(r)
+	if err != nil {
+		return nil, grpcError(err, codes.PermissionDenied)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{{$recvTypeName}}{}, principal))
+	{{- else if eq $methodCfg.AuthScheme "method"}}
+	if {{if index $.Principals $recvTypeName}}_, {{end}}err := s.Impl.Authorize(r); err != nil {
+		return nil, grpcError(err, codes.PermissionDenied)
+	}
+	{{- else if $methodCfg.Auth}}
+	if !{{if eq $methodCfg.AuthScheme "bearer"}}checkBearer(r, {{printf "%q" $methodCfg.AuthToken}}){{else}}checkAuth(r, {{printf "%q" $methodCfg.AuthHeader}}, {{printf "%q" $methodCfg.AuthToken}}){{end}} {
+		return nil, status.Error(codes.PermissionDenied, "unauthorized")
+	}
+	{{- end}}
+	p := {{$paramType}}{}
+	if err := validate{{$paramType}}(&p, r, ""); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx = r.Context()
+	{{- if $methodCfg.TimeoutMS}}
+	ctx, cancel := context.WithTimeout(ctx, {{$methodCfg.TimeoutMS}}*time.Millisecond)
+	defer cancel()
+	{{- end}}
+	result, {{if ReturnsMeta $method}}_, {{end}}err := s.Impl.{{$methodName}}(ctx, p)
+	if err != nil {
+		return nil, grpcError(err, codes.Internal)
+	}
+	out := &pb.{{$resultMessage}}{}
+	if err := grpcResult(result, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+{{end}}
+{{end}}
+`
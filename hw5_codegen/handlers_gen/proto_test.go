@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProto(t *testing.T) {
+	cases := map[string][]string{
+		"nested.go": {
+			"service Api {\n  rpc Orders(OrderParams) returns (OrderParamsResult);\n}",
+			"message OrderParams {\n  optional int64 limit = 1 [json_name = \"limit\"];",
+			"  Location location = 4 [json_name = \"location\"];",
+			"message AddressResult {\n  string City = 1 [json_name = \"City\"];\n  optional int64 Zip = 2 [json_name = \"Zip\"];\n}",
+		},
+		"sources.go": {
+			"optional string X_Request_Id = 1 [json_name = \"X-Request-Id\"];",
+			"repeated string tags = 3 [json_name = \"tags\"];",
+		},
+		"times.go": {
+			"optional int64 deadline = ",
+			"optional uint64 seq = ",
+		},
+	}
+	for name, parts := range cases {
+		data, err := parseSrc([]string{filepath.Join("testdata", name)}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pd, err := newProtoData(data, "example.com/api/pb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := generateProto(bytes.Buffer{}, pd, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		proto := buf.String()
+		if !strings.HasPrefix(proto, "syntax = \"proto3\";\n\npackage api;\n\noption go_package = \"example.com/api/pb\";\n") {
+			t.Errorf("%s: unexpected header of proto:\n%s", name, proto)
+		}
+		for _, part := range parts {
+			if !strings.Contains(proto, part) {
+				t.Errorf("%s: expected %s in proto:\n%s", name, part, proto)
+			}
+		}
+	}
+
+	data, err := parseSrc([]string{filepath.Join("testdata", "upload.go")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newProtoData(data, ""); err == nil || !strings.Contains(err.Error(), "files aren't supported by gRPC") {
+		t.Errorf("expected error of files, got %v", err)
+	}
+}
+
+func TestProtoType(t *testing.T) {
+	cases := map[string]string{
+		"int":                          "int64",
+		"*bool":                        "optional bool",
+		"[]byte":                       "bytes",
+		"[]*string":                    "repeated string",
+		"map[string]float64":           "map<string, double>",
+		"map[string][]bool":            protoValue,
+		"[][]int":                      protoValue,
+		"time.Time":                    "string",
+		"interface{}":                  protoValue,
+		"struct{ A int `json:\"a\"` }": "Anonymous",
+	}
+	pd := &protoData{tmplData: &tmplData{}, declared: make(map[string]bool)}
+	for src, expected := range cases {
+		expr, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		label, protoType := pd.protoType("Anonymous", expr)
+		if label != "" {
+			protoType = label + " " + protoType
+		}
+		if protoType != expected {
+			t.Errorf("%s: expected %s, got %s", src, expected, protoType)
+		}
+	}
+	if len(pd.Messages) != 1 || pd.Messages[0].Fields[0].JSONName != "a" {
+		t.Errorf("expected the message of the anonymous struct, got %+v", pd.Messages)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	data, err := parseSrc([]string{filepath.Join("testdata", "context.go")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pd, err := newProtoData(data, "example.com/api/pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := generateGRPC(bytes.Buffer{}, pd, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err = formatCode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := buf.String()
+	for _, part := range []string{
+		`pb "example.com/api/pb"`,
+		"type UsersGRPC struct {\n\tpb.UnimplementedUsersServer\n\tImpl *Users\n}",
+		"func (s *UsersGRPC) Me(ctx context.Context, in *pb.MeParams) (*pb.MeResult, error) {",
+		"r = r.WithContext(context.WithValue(r.Context(), principalKeyUsers{}, principal))",
+		"if _, err := s.Impl.Authorize(r); err != nil {",
+		"var grpcParamsMeParams = []grpcParam{\n\t{\"lang\", \"\"},\n}",
+	} {
+		if !strings.Contains(code, part) {
+			t.Errorf("expected %s in gRPC code", part)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type AuthApi struct{}
+
+func (a *AuthApi) Authorize(r *http.Request) error {
+	switch r.Header.Get("Authorization") {
+	case "admin":
+		return nil
+	case "":
+		return ApiError{http.StatusUnauthorized, errors.New("no credentials")}
+	}
+	return errors.New("forbidden")
+}
+
+type IDParams struct {
+	ID int `apivalidator:"required"`
+}
+
+// apigen:api {"url": "/key", "auth": true, "auth_header": "X-Api-Key", "auth_token": "secret"}
+func (a *AuthApi) Key(ctx context.Context, in IDParams) (*IDParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/bearer", "auth": true, "auth_scheme": "bearer", "auth_token": "secret"}
+func (a *AuthApi) Bearer(ctx context.Context, in IDParams) (*IDParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/method", "auth": true, "auth_scheme": "method"}
+func (a *AuthApi) Method(ctx context.Context, in IDParams) (*IDParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,17 @@
+package api
+
+import "context"
+
+type Api struct{}
+
+type BoundParams struct {
+	Age   int      `apivalidator:"min=10,max=5"`
+	Score float64  `apivalidator:"min=1.5,max=0.5"`
+	Tags  []string `apivalidator:"min=3,max=1"`
+	Count int      `apivalidator:"min=5,max=5"`
+}
+
+// apigen:api {"url": "/bounds", "method": "POST"}
+func (a *Api) Bounds(ctx context.Context, in BoundParams) (*BoundParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct {
+	calls int32
+}
+
+type SearchParams struct {
+	Query string `apivalidator:"required"`
+	Limit *int   `apivalidator:"min=1"`
+}
+
+type SearchResult struct {
+	Query string
+	Calls int32
+}
+
+// apigen:api {"url": "/search", "method": "GET", "cache_ttl_ms": 100}
+func (a *Api) Search(ctx context.Context, in SearchParams) (*SearchResult, error) {
+	time.Sleep(10 * time.Millisecond)
+	return &SearchResult{Query: in.Query, Calls: atomic.AddInt32(&a.calls, 1)}, nil
+}
+
+// apigen:api {"url": "/slow", "method": "GET", "cache_ttl_ms": 100, "timeout_ms": 50}
+func (a *Api) Slow(ctx context.Context, in SearchParams) (*SearchResult, error) {
+	if in.Query == "fail" {
+		return nil, ApiError{HTTPStatus: 400, Err: context.Canceled}
+	}
+	return &SearchResult{Query: in.Query, Calls: atomic.AddInt32(&a.calls, 1)}, nil
+}
@@ -0,0 +1,28 @@
+package api
+
+import "context"
+
+type Api struct{}
+
+type Paging struct {
+	Limit int `apivalidator:"paramname=limit,default=10"`
+}
+
+type ConflictParams struct {
+	ID     int    `apivalidator:"required"`
+	UserID int    `apivalidator:"paramname=id"`
+	Token  string `apivalidator:"paramname=x-token,source=header"`
+	Auth   string `apivalidator:"paramname=X-Token,source=header"`
+	Limit  int    `apivalidator:"default=5"`
+	Paging
+}
+
+// apigen:api {"url": "/a", "method": "POST"}
+func (a *Api) First(ctx context.Context, in ConflictParams) (*ConflictParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/b", "method": "POST"}
+func (a *Api) Second(ctx context.Context, in ConflictParams) (*ConflictParams, error) {
+	return &in, nil
+}
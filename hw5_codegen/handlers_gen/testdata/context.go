@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type User struct {
+	Login string
+}
+
+type Users struct{}
+
+func (u *Users) Authorize(r *http.Request) (*User, error) {
+	login := r.Header.Get("Authorization")
+	if login == "" {
+		return nil, ApiError{http.StatusUnauthorized, errors.New("no credentials")}
+	}
+	return &User{Login: login}, nil
+}
+
+type MeParams struct {
+	Lang string `apivalidator:"default=en"`
+}
+
+type MeResult struct {
+	Login     string
+	Lang      string
+	RequestID string
+}
+
+// apigen:api {"url": "/me", "auth": true, "auth_scheme": "method", "context": ["request_id", "principal"], "timeout_ms": 100}
+func (u *Users) Me(ctx context.Context, in MeParams) (*MeResult, error) {
+	user, ok := UsersPrincipalFromContext(ctx)
+	if !ok {
+		return nil, errors.New("no principal")
+	}
+	id, _ := RequestIDFromContext(ctx)
+	return &MeResult{Login: user.Login, Lang: in.Lang, RequestID: id}, nil
+}
+
+// apigen:api {"url": "/ping", "context": ["request_id"]}
+func (u *Users) Ping(ctx context.Context, in MeParams) (*MeResult, error) {
+	id, _ := RequestIDFromContext(ctx)
+	return &MeResult{Lang: in.Lang, RequestID: id}, nil
+}
+
+// apigen:api {"url": "/admin", "auth": true, "auth_scheme": "method"}
+func (u *Users) Admin(ctx context.Context, in MeParams) (*MeResult, error) {
+	if _, ok := UsersPrincipalFromContext(ctx); ok {
+		return nil, errors.New("unexpected principal")
+	}
+	return &MeResult{Lang: in.Lang}, nil
+}
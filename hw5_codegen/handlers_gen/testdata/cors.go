@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type EchoParams struct {
+	Text string `apivalidator:"required"`
+}
+
+// apigen:api {"url": "/echo", "method": "POST", "auth": true, "cors_origins": ["https://example.com"]}
+func (a *Api) Echo(ctx context.Context, in EchoParams) (*EchoParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/any", "cors_origins": ["*"], "cors_methods": ["GET", "POST", "PUT"]}
+func (a *Api) Any(ctx context.Context, in EchoParams) (*EchoParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/plain"}
+func (a *Api) Plain(ctx context.Context, in EchoParams) (*EchoParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"mime/multipart"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+// CheckInn checks the control digit of INN of organizations
+func CheckInn(inn string) error {
+	sum := 0
+	for i, weight := range []int{2, 4, 10, 3, 5, 9, 4, 6, 8} {
+		sum += int(inn[i]-'0') * weight
+	}
+	if int(inn[9]-'0') != sum%11%10 {
+		return fmt.Errorf("bad control digit")
+	}
+	return nil
+}
+
+func checkTags(tags []string) error {
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		if seen[tag] {
+			return fmt.Errorf("tag %s is repeated", tag)
+		}
+		seen[tag] = true
+	}
+	return nil
+}
+
+func checkEmployees(n int) error {
+	if n%10 != 0 {
+		return fmt.Errorf("must be rounded to tens")
+	}
+	return nil
+}
+
+func checkLogo(file *multipart.FileHeader) error {
+	if file.Filename == "" {
+		return fmt.Errorf("has no name")
+	}
+	return nil
+}
+
+type CompanyParams struct {
+	Inn       string                `apivalidator:"required,len=10,charset=digit,custom=CheckInn"`
+	Tags      []string              `apivalidator:"custom=checkTags"`
+	Employees *int                  `apivalidator:"min=0,custom=checkEmployees"`
+	Logo      *multipart.FileHeader `apivalidator:"custom=checkLogo"`
+}
+
+// apigen:api {"url": "/company", "method": "POST"}
+func (a *Api) Company(ctx context.Context, in CompanyParams) (*CompanyParams, error) {
+	return &in, nil
+}
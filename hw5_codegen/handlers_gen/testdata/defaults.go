@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+const DefaultPageSize = 20
+
+type ListParams struct {
+	Region   string `apivalidator:"default=env:API_REGION"`
+	PageSize int    `apivalidator:"min=1,default=const:DefaultPageSize"`
+	Sort     string `apivalidator:"default=name"`
+}
+
+// apigen:api {"url": "/list"}
+func (a *Api) List(ctx context.Context, in ListParams) (*ListParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,45 @@
+package api
+
+import "context"
+
+type Api struct{}
+
+type BadParams struct {
+	Name  string     `apivalidator:"required,mandatory"`
+	Alias string     `apivalidator:"paramname"`
+	Score complex128 `apivalidator:"min=1"`
+	Plain string
+	A, B  int `apivalidator:"required"`
+}
+
+// apigen:api {url: "/config"}
+func (a *Api) BadConfig(ctx context.Context, in BadParams) (*BadParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/count"}
+func (a *Api) BadParamsCount(in BadParams) (*BadParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/missing"}
+func (a *Api) Missing(ctx context.Context, in Missing) (*BadParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/params"}
+func (a *Api) Params(ctx context.Context, in BadParams) (*BadParams, error) {
+	return &in, nil
+}
+
+type Level int
+
+type BadNested struct {
+	Level
+	Params *BadParams
+}
+
+// apigen:api {"url": "/nested"}
+func (a *Api) Nested(ctx context.Context, in BadNested) (*BadNested, error) {
+	return &in, nil
+}
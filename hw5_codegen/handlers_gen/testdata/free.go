@@ -0,0 +1,19 @@
+package api
+
+import "context"
+
+type Api struct{}
+
+type FreeParams struct {
+	Name string `apivalidator:"required"`
+}
+
+// apigen:api {"url": "/free", "method": "POST"}
+func Free(ctx context.Context, in FreeParams) (*FreeParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/method", "method": "POST"}
+func (a *Api) Method(ctx context.Context, in FreeParams) (*FreeParams, error) {
+	return &in, nil
+}
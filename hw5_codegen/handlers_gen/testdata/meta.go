@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type ItemParams struct {
+	Name string `apivalidator:"required"`
+}
+
+type Item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// apigen:api {"url": "/items", "method": "POST"}
+func (a *Api) Create(ctx context.Context, in ItemParams) (*Item, ResponseMeta, error) {
+	if in.Name == "taken" {
+		meta := ResponseMeta{Header: http.Header{"Retry-After": {"60"}}}
+		return nil, meta, ApiError{http.StatusConflict, fmt.Errorf("name is taken")}
+	}
+	meta := ResponseMeta{Status: http.StatusCreated, Header: http.Header{"Location": {"/items/1"}}}
+	return &Item{1, in.Name}, meta, nil
+}
+
+// apigen:api {"url": "/item"}
+func (a *Api) Get(ctx context.Context, in ItemParams) (*Item, error) {
+	return &Item{1, in.Name}, nil
+}
@@ -0,0 +1,18 @@
+package api
+
+import "context"
+
+type Api struct{}
+
+type MixedParams struct {
+	Score  complex128 `apivalidator:"min=1"`
+	ID     int        `apivalidator:"required"`
+	UserID int        `apivalidator:"paramname=id"`
+	Query  string     `apivalidator:"source=form"`
+	Body   string     `apivalidator:"source=json"`
+}
+
+// apigen:api {"url": "/mixed", "method": "POST"}
+func (a *Api) Mixed(ctx context.Context, in MixedParams) (*MixedParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,142 @@
+// APIResponse is the body of responses of the handlers
+export interface APIResponse<T> {
+  error: string;
+  response?: T;
+}
+
+// ApiError is thrown for errors of the handlers, status is the one of the
+// response
+export class ApiError extends Error {
+  status: number;
+  body?: APIResponse<unknown>;
+
+  constructor(status: number, message: string, body?: APIResponse<unknown>) {
+    super(message);
+    this.name = "ApiError";
+    this.status = status;
+    this.body = body;
+  }
+}
+
+// ApiRequest is the params of the handler by their sources, JSON body is
+// sent instead of the form if it's set. The form is multipart with files.
+class ApiRequest {
+  query = new URLSearchParams();
+  form = new URLSearchParams();
+  header = new Headers();
+  json?: Record<string, unknown>;
+  files?: Record<string, Blob>;
+
+  // setJSON sets the value of the body, dots of the name are the keys of
+  // nested objects
+  setJSON(name: string, value: unknown): void {
+    let object: Record<string, unknown> = (this.json ??= {});
+    const keys = name.split(".");
+    for (const key of keys.slice(0, -1)) {
+      object = (object[key] ??= {}) as Record<string, unknown>;
+    }
+    object[keys[keys.length - 1]] = value;
+  }
+
+  setFile(name: string, file: Blob): void {
+    (this.files ??= {})[name] = file;
+  }
+}
+
+// apiCall sends params of the handler and returns its response, errors of
+// the handler are ApiError with the status of the response
+async function apiCall<T>(baseURL: string, method: string, path: string, req: ApiRequest, auth?: [string, string], signal?: AbortSignal): Promise<T> {
+  let target = baseURL.replace(/\/$/, "") + path;
+  const query = req.query.toString();
+  if (query !== "") {
+    target += "?" + query;
+  }
+  let body: BodyInit | undefined;
+  if (req.json !== undefined) {
+    body = JSON.stringify(req.json);
+    req.header.set("Content-Type", "application/json");
+  } else if (req.files !== undefined) {
+    // fetch sets the multipart content type with the boundary
+    const form = new FormData();
+    req.form.forEach((value, name) => form.append(name, value));
+    for (const [name, file] of Object.entries(req.files)) {
+      form.append(name, file);
+    }
+    body = form;
+  } else if (method === "POST") {
+    body = req.form;
+  }
+  if (auth !== undefined) {
+    req.header.set(auth[0], auth[1]);
+  }
+  const resp = await fetch(target, { method, headers: req.header, body, signal });
+  let ar: APIResponse<T>;
+  try {
+    ar = await resp.json();
+  } catch (err) {
+    if (!resp.ok) {
+      throw new ApiError(resp.status, "unexpected status " + resp.status);
+    }
+    throw new Error("cant unpack response json: " + err);
+  }
+  // methods may set other successful statuses, like 201
+  if (ar.error || !resp.ok) {
+    throw new ApiError(resp.status, ar.error, ar);
+  }
+  return ar.response as T;
+}
+
+// params of the handlers, optional ones aren't sent if they are undefined
+export interface OrderParams {
+  id: number;
+  items?: string[];
+  coupon_code?: string;
+}
+
+export interface UserParams {
+  login: string;
+}
+
+// results of the handlers
+export interface UserParamsResult {
+  Login: string;
+}
+
+export interface OrderParamsResult {
+  ID: number;
+  Items: string[];
+  Coupon: string | null;
+}
+
+// ApiClient calls handlers of Api served at url, auth is sent to
+// handlers requiring authorization, it's the token of bearer auth and the
+// Authorization header of Authorize method
+export class ApiClient {
+  url: string;
+  auth: string;
+
+  constructor(url: string, auth = "") {
+    this.url = url;
+    this.auth = auth;
+  }
+
+  // user calls /user
+  user(params: UserParams, signal?: AbortSignal): Promise<UserParamsResult> {
+    const req = new ApiRequest();
+    req.query.set("login", params.login);
+    return apiCall<UserParamsResult>(this.url, "GET", "/user", req, ["X-Auth", this.auth], signal);
+  }
+
+  // order calls /order
+  order(params: OrderParams, signal?: AbortSignal): Promise<OrderParamsResult> {
+    const req = new ApiRequest();
+    req.form.set("id", String(params.id));
+    if (params.items !== undefined) {
+      req.form.set("items", params.items.join(","));
+    }
+    if (params.coupon_code !== undefined) {
+      req.form.set("coupon_code", params.coupon_code);
+    }
+    return apiCall<OrderParamsResult>(this.url, "POST", "/order", req, undefined, signal);
+  }
+}
@@ -0,0 +1,21 @@
+package multi
+
+import (
+	"context"
+)
+
+type Api struct{}
+
+func NewApi() *Api {
+	return &Api{}
+}
+
+// apigen:api {"url": "/user", "auth": true}
+func (a *Api) User(ctx context.Context, in UserParams) (*UserParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/order", "method": "POST"}
+func (a *Api) Order(ctx context.Context, in OrderParams) (*OrderParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,20 @@
+package multi
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type UserParams struct {
+	Login string `apivalidator:"required,min=3"`
+}
+
+type OrderParams struct {
+	ID     int      `apivalidator:"required,min=1"`
+	Items  []string `apivalidator:"min=1"`
+	Coupon *string  `apivalidator:"paramname=coupon_code"`
+}
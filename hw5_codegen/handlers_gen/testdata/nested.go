@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type Page struct {
+	Limit  int `apivalidator:"min=1,max=100,default=10"`
+	Offset int `apivalidator:"min=0"`
+}
+
+type Address struct {
+	City string `apivalidator:"required"`
+	Zip  *int   `apivalidator:"paramname=zip_code"`
+}
+
+type Location struct {
+	Home Address
+	Work Address `apivalidator:"paramname=office"`
+}
+
+type OrderParams struct {
+	Page
+	Query    string `apivalidator:"required"`
+	Location Location
+}
+
+// apigen:api {"url": "/orders"}
+func (a *Api) Orders(ctx context.Context, in OrderParams) (*OrderParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct {
+	// Panics are the paths, errors and stacks of recovered panics
+	Panics []string
+}
+
+func (a *Api) OnPanic(r *http.Request, err interface{}, stack []byte) {
+	a.Panics = append(a.Panics, fmt.Sprintf("%s: %v\n%s", r.URL.Path, err, stack))
+}
+
+type CrashParams struct {
+	Reason string `apivalidator:"required"`
+}
+
+// apigen:api {"url": "/crash"}
+func (a *Api) Crash(ctx context.Context, in CrashParams) (*CrashParams, error) {
+	panic(in.Reason)
+}
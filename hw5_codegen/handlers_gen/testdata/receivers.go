@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type EchoParams struct {
+	Text string `apivalidator:"required"`
+}
+
+// Echo has value receivers
+type Echo struct {
+	Prefix string
+}
+
+// apigen:api {"url": "/echo"}
+func (e Echo) Say(ctx context.Context, in EchoParams) (*EchoParams, error) {
+	return &EchoParams{Text: e.Prefix + in.Text}, nil
+}
+
+// apigen:api {"url": "/echo/unnamed"}
+func (Echo) Unnamed(ctx context.Context, in EchoParams) (*EchoParams, error) {
+	return &in, nil
+}
+
+// Counter isn't a struct, its receiver is named like the request
+type Counter int
+
+// apigen:api {"url": "/count"}
+func (r *Counter) Count(ctx context.Context, in EchoParams) (*EchoParams, error) {
+	*r++
+	return &in, nil
+}
+
+// Store is implemented by the app
+type Store interface {
+	Authorize(r *http.Request) (string, error)
+	// apigen:api {"url": "/store/get", "method": "GET", "auth": true, "auth_scheme": "method", "context": ["principal"]}
+	Get(ctx context.Context, in EchoParams) (*EchoParams, error)
+	// Put isn't served
+	Put(ctx context.Context, in EchoParams) error
+}
+
+type memStore struct{}
+
+func NewStore() Store {
+	return memStore{}
+}
+
+func (s memStore) Authorize(r *http.Request) (string, error) {
+	return r.Header.Get("X-User"), nil
+}
+
+func (s memStore) Get(ctx context.Context, in EchoParams) (*EchoParams, error) {
+	user, _ := StorePrincipalFromContext(ctx)
+	return &EchoParams{Text: user + ":" + in.Text}, nil
+}
+
+func (s memStore) Put(ctx context.Context, in EchoParams) error {
+	return nil
+}
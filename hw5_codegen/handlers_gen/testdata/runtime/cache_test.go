@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var client = &http.Client{Timeout: time.Second}
+
+type CacheCase struct {
+	Path  string
+	Calls int32
+}
+
+type CacheResponse struct {
+	Error    string        `json:"error"`
+	Response *SearchResult `json:"response"`
+}
+
+// search returns the result of the path, it fails the test on errors
+func search(t *testing.T, ts *httptest.Server, path string) *SearchResult {
+	resp, err := client.Get(ts.URL + path)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	defer resp.Body.Close()
+	result := CacheResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("%s: unexpected status %d, %q %v", path, resp.StatusCode, result.Error, err)
+		return nil
+	}
+	return result.Response
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	ts := httptest.NewServer(&Api{})
+	defer ts.Close()
+
+	// the requests wait for the first one instead of calling the method
+	const requests = 20
+	results := make([]*SearchResult, requests)
+	wg := &sync.WaitGroup{}
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = search(t, ts, "/search?query=go")
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if result != nil && result.Calls != 1 {
+			t.Errorf("[%d] expected 1 call, got %d", i, result.Calls)
+		}
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	ts := httptest.NewServer(&Api{})
+	defer ts.Close()
+
+	// the caches are shared by receivers, so the queries differ from the
+	// ones of other tests
+	cases := []CacheCase{
+		CacheCase{Path: "/search?query=keys&limit=1", Calls: 1},
+		// the key is made of the params, not of the query string
+		CacheCase{Path: "/search?limit=1&query=keys", Calls: 1},
+		CacheCase{Path: "/search?limit=01&query=keys", Calls: 1},
+		CacheCase{Path: "/search?query=keys&limit=1&unknown=x", Calls: 1},
+		CacheCase{Path: "/search?query=keys", Calls: 2},
+		CacheCase{Path: "/search?query=keys&limit=2", Calls: 3},
+		CacheCase{Path: "/search?query=other&limit=1", Calls: 4},
+		CacheCase{Path: "/search?limit=1&query=keys", Calls: 1},
+	}
+	for idx, item := range cases {
+		result := search(t, ts, item.Path)
+		if result != nil && result.Calls != item.Calls {
+			t.Errorf("[%d] %s: expected %d calls, got %d", idx, item.Path, item.Calls, result.Calls)
+		}
+	}
+
+	// expired responses aren't served
+	time.Sleep(150 * time.Millisecond)
+	if result := search(t, ts, "/search?query=keys&limit=1"); result != nil && result.Calls != 5 {
+		t.Errorf("expected the method called after the TTL, got %d calls", result.Calls)
+	}
+}
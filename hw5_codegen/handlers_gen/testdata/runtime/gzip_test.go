@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// the client doesn't ask for gzip by itself, so the responses are passed as is
+var client = &http.Client{
+	Timeout:   time.Second,
+	Transport: &http.Transport{DisableCompression: true},
+}
+
+type GzipCase struct {
+	Path     string
+	Encoding string
+	Gzip     bool
+	Status   int
+}
+
+// get returns the response of the path with the Accept-Encoding header, the
+// body is decoded if it's compressed
+func get(t *testing.T, ts *httptest.Server, path, encoding string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding != "" {
+		req.Header.Set("Accept-Encoding", encoding)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, err = ioutil.ReadAll(gz); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, body
+}
+
+func TestGzip(t *testing.T) {
+	ts := httptest.NewServer(&Api{})
+	defer ts.Close()
+
+	cases := []GzipCase{
+		GzipCase{Path: "/search?query=go", Encoding: "gzip", Gzip: true, Status: http.StatusOK},
+		GzipCase{Path: "/search?query=go", Encoding: "deflate, gzip;q=0.5", Gzip: true, Status: http.StatusOK},
+		GzipCase{Path: "/search", Encoding: "gzip", Gzip: true, Status: http.StatusBadRequest},
+		GzipCase{Path: "/slow?query=fail", Encoding: "gzip", Gzip: true, Status: http.StatusBadRequest},
+		// the rest isn't compressed
+		GzipCase{Path: "/search?query=go", Encoding: "gzip;q=0", Status: http.StatusOK},
+		GzipCase{Path: "/search?query=go", Encoding: "br", Status: http.StatusOK},
+		GzipCase{Path: "/search?query=go", Status: http.StatusOK},
+	}
+	for idx, item := range cases {
+		caseName := item.Path + " " + item.Encoding
+		// responses of the search are cached, so the plain one is the same
+		_, plain := get(t, ts, item.Path, "identity")
+		resp, body := get(t, ts, item.Path, item.Encoding)
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%d] %s: expected status %d, got %d", idx, caseName, item.Status, resp.StatusCode)
+		}
+		if encoding := resp.Header.Get("Content-Encoding"); (encoding == "gzip") != item.Gzip {
+			t.Errorf("[%d] %s: unexpected Content-Encoding %q", idx, caseName, encoding)
+		}
+		if vary := resp.Header.Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("[%d] %s: expected Vary Accept-Encoding, got %q", idx, caseName, vary)
+		}
+		if !bytes.Equal(body, plain) {
+			t.Errorf("[%d] %s: expected body %s, got %s", idx, caseName, plain, body)
+		}
+	}
+}
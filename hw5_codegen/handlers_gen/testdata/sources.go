@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type Item struct {
+	Name  string   `apivalidator:"required,source=json"`
+	Count int      `apivalidator:"min=1,default=1,source=json"`
+	Tags  []string `apivalidator:"source=json"`
+}
+
+type OrderParams struct {
+	RequestID string  `apivalidator:"required,paramname=X-Request-Id,source=header"`
+	Dry       bool    `apivalidator:"source=query"`
+	Note      *string `apivalidator:"source=json"`
+	Item      Item
+}
+
+// apigen:api {"url": "/order", "method": "POST"}
+func (a *Api) Order(ctx context.Context, in OrderParams) (*OrderParams, error) {
+	return &in, nil
+}
+
+type LoginParams struct {
+	Login    string `apivalidator:"required,source=form"`
+	Password string `apivalidator:"required,source=form"`
+	Next     string `apivalidator:"source=query"`
+	Lang     string `apivalidator:"default=en"`
+}
+
+// apigen:api {"url": "/login", "method": "POST"}
+func (a *Api) Login(ctx context.Context, in LoginParams) (*LoginParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type SleepParams struct {
+	Ms int `apivalidator:"min=0"`
+}
+
+// apigen:api {"url": "/sleep", "timeout_ms": 50}
+func (a *Api) Sleep(ctx context.Context, in SleepParams) (*SleepParams, error) {
+	select {
+	case <-time.After(time.Duration(in.Ms) * time.Millisecond):
+		return &in, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// apigen:api {"url": "/wait"}
+func (a *Api) Wait(ctx context.Context, in SleepParams) (*SleepParams, error) {
+	return a.Sleep(ctx, in)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"time"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type EventParams struct {
+	ID       int64      `apivalidator:"required,min=-5,max=9007199254740992"`
+	Seq      uint       `apivalidator:"max=1000"`
+	Size     *uint64    `apivalidator:"min=1"`
+	Start    time.Time  `apivalidator:"required,min=2020-01-01T00:00:00Z,max=2030-01-01T00:00:00Z"`
+	Deadline *time.Time `apivalidator:"layout=unix,min=1577836800"`
+	Day      time.Time  `apivalidator:"layout=DateOnly,default=2024-02-29"`
+	Local    *time.Time `apivalidator:"layout=02.01.2006"`
+}
+
+// apigen:api {"url": "/event"}
+func (a *Api) Event(ctx context.Context, in EventParams) (*EventParams, error) {
+	return &in, nil
+}
+
+type ScheduleParams struct {
+	At    time.Time `apivalidator:"required,layout=unix,source=json"`
+	Count uint64    `apivalidator:"default=1,source=json"`
+}
+
+// apigen:api {"url": "/schedule", "method": "POST"}
+func (a *Api) Schedule(ctx context.Context, in ScheduleParams) (*ScheduleParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type SearchParams struct {
+	Query  string   `apivalidator:"required"`
+	Score  float64  `apivalidator:"min=0.5,max=10,default=1"`
+	Exact  bool     `apivalidator:"default=false"`
+	Tags   []string `apivalidator:"enum=go|rust|c,min=1,max=2"`
+	Limit  *int     `apivalidator:"min=1,max=100"`
+	Fuzzy  *bool    `apivalidator:"paramname=fuzzy"`
+	Weight *float64 `apivalidator:"max=1"`
+	Lang   *string  `apivalidator:"enum=en|ru"`
+}
+
+// apigen:api {"url": "/search"}
+func (a *Api) Search(ctx context.Context, in SearchParams) (*SearchParams, error) {
+	return &in, nil
+}
+
+type ContactParams struct {
+	Email   string   `apivalidator:"required,email"`
+	Site    *string  `apivalidator:"url"`
+	ID      string   `apivalidator:"uuid"`
+	Phone   string   `apivalidator:"regexp=^\\+?[0-9]{3,15}$"`
+	Aliases []string `apivalidator:"max=3,regexp=^[a-z]+$"`
+	Code    string   `apivalidator:"len=6,charset=digit"`
+	File    string   `apivalidator:"prefix=src/,suffix=.go,maxlen=64,charset=ascii"`
+}
+
+// apigen:api {"url": "/contact", "method": "POST"}
+func (a *Api) Contact(ctx context.Context, in ContactParams) (*ContactParams, error) {
+	return &in, nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"context"
+	"mime/multipart"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type UploadParams struct {
+	Avatar  *multipart.FileHeader `apivalidator:"required,maxsize=1KB,content_type=image/*"`
+	Resume  *multipart.FileHeader `apivalidator:"content_type=application/pdf|text/plain"`
+	Comment string                `apivalidator:"maxlen=100"`
+}
+
+type UploadResult struct {
+	Avatar  string
+	Size    int64
+	Resume  string
+	Comment string
+}
+
+// apigen:api {"url": "/upload", "method": "POST"}
+func (a *Api) Upload(ctx context.Context, in UploadParams) (*UploadResult, error) {
+	res := &UploadResult{Avatar: in.Avatar.Filename, Size: in.Avatar.Size, Comment: in.Comment}
+	if in.Resume != nil {
+		res.Resume = in.Resume.Filename
+	}
+	return res, nil
+}
@@ -0,0 +1,24 @@
+package api
+
+import "context"
+
+type Api struct{}
+
+type ValueParams struct {
+	Role   string   `apivalidator:"enum=user|admin,default=guest"`
+	Level  int      `apivalidator:"enum=1|2|x"`
+	Count  int      `apivalidator:"min=1,max=10,default=20"`
+	Name   string   `apivalidator:"required,default=anon"`
+	Status string   `apivalidator:"enum=new|old,default=new"`
+	Tags   []string `apivalidator:"enum=a|b,min=2,default=a"`
+}
+
+// apigen:api {"url": "/a", "method": "POST"}
+func (a *Api) First(ctx context.Context, in ValueParams) (*ValueParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/a", "method": "POST"}
+func (a *Api) Second(ctx context.Context, in ValueParams) (*ValueParams, error) {
+	return &in, nil
+}
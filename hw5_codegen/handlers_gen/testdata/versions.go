@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Users struct{}
+
+type ProfileParams struct {
+	Login string `apivalidator:"required"`
+}
+
+type ProfileV2Params struct {
+	Login  string   `apivalidator:"required,min=3"`
+	Fields []string `apivalidator:"enum=name|email"`
+}
+
+// apigen:api {"url": "/user/profile"}
+func (u *Users) Profile(ctx context.Context, in ProfileParams) (*ProfileParams, error) {
+	return &in, nil
+}
+
+// apigen:api {"url": "/user/profile", "version": "v2"}
+func (u *Users) ProfileV2(ctx context.Context, in ProfileV2Params) (*ProfileV2Params, error) {
+	return &in, nil
+}
+
+// Admins is the API of the next version with the same method names
+type Admins struct{}
+
+// apigen:api {"url": "/user/profile", "version": "v3", "auth": true}
+func (a *Admins) Profile(ctx context.Context, in ProfileV2Params) (*ProfileV2Params, error) {
+	return &in, nil
+}
@@ -0,0 +1,703 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// testParam is the param of the request of generated tests by its
+// source, Value is the content type of files of Size bytes
+type testParam struct {
+	Source string
+	Name   string
+	Value  string
+	Size   int64
+}
+
+// testRequest is the request of generated tests of the handler, Param
+// fails its Rule, they are empty for valid params
+type testRequest struct {
+	Params []testParam
+	Param  string
+	Rule   string
+}
+
+// testValue is the value of the param failing the rule, files of zero
+// Size aren't sent
+type testValue struct {
+	Rule  string
+	Value string
+	Size  int64
+}
+
+// testRules are the rules of params in the order of the checks of
+// handlers, "format" is the format of the param
+var testRules = []string{"required", "type", "len", "min", "max", "maxlen", "prefix", "suffix", "charset",
+	"enum", "regexp", "format", "maxsize", "content_type"}
+
+var testUUIDRegexp = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// NewRecv is the expression of the new receiver of handlers in tests,
+// it's made by NewT of the package if there is one. It's empty for
+// interfaces without NewT returning their implementation.
+func (t *tmplData) NewRecv(recvName string) string {
+	switch {
+	case t.Interfaces[recvName] && t.Constructors["New"+recvName]:
+		return "&" + recvName + "Handler{New" + recvName + "()}"
+	case t.Interfaces[recvName]:
+		return ""
+	case t.Constructors["New"+recvName]:
+		return "New" + recvName + "()"
+	}
+	if spec, ok := t.Types[recvName]; ok {
+		if _, ok := spec.Type.(*ast.StructType); !ok {
+			return "new(" + recvName + ")"
+		}
+	}
+	return "&" + recvName + "{}"
+}
+
+// GetTestRequests returns requests of generated tests of the handler, the
+// one of valid params is first, then ones with a param failing each of
+// its rules. There are none if valid values of some params aren't known,
+// like of params with some regexps.
+func (t *tmplData) GetTestRequests(cfg *methodConfig, paramType string) []testRequest {
+	params := t.GetParams(paramType)
+	valid := make([]testValue, len(params))
+	for i, p := range params {
+		value, ok := validTestValue(p.Cfg)
+		if !ok {
+			return nil
+		}
+		valid[i] = value
+	}
+	// withValue returns valid params with the value of i-th param
+	withValue := func(i int, value testValue) []testParam {
+		var request []testParam
+		for j, p := range params {
+			v := valid[j]
+			if j == i {
+				v = value
+			}
+			if v.Value == "" {
+				// empty values are the same as missing ones, so are files
+				// without content type
+				continue
+			}
+			request = append(request, testParam{t.requestSource(cfg, paramType, p), p.Name, v.Value, v.Size})
+		}
+		return request
+	}
+	requests := []testRequest{{Params: withValue(-1, testValue{})}}
+	for i, p := range params {
+		for _, value := range invalidTestValues(p.Cfg) {
+			requests = append(requests, testRequest{withValue(i, value), p.Name, value.Rule})
+		}
+	}
+	return requests
+}
+
+// validTestValue returns the value passing all rules of the param, it's
+// the first one of candidates
+func validTestValue(cfg *fieldConfig) (testValue, bool) {
+	if cfg.Type == "file" {
+		if !cfg.Required {
+			return testValue{}, true
+		}
+		return testValue{Value: fileContentType(cfg), Size: 1}, cfg.Custom == ""
+	}
+	for _, value := range testCandidates(cfg) {
+		if failedRule(cfg, value) == "" {
+			return testValue{Value: value}, true
+		}
+	}
+	return testValue{}, false
+}
+
+// invalidTestValues returns values failing each rule of the param, rules
+// no candidate fails first are skipped
+func invalidTestValues(cfg *fieldConfig) []testValue {
+	found := make(map[string]testValue)
+	if cfg.Type == "file" {
+		if cfg.Required {
+			found["required"] = testValue{Rule: "required"}
+		}
+		if cfg.MaxSize > 0 {
+			found["maxsize"] = testValue{"maxsize", fileContentType(cfg), cfg.MaxSize + 1}
+		}
+		for _, contentType := range []string{"application/x-invalid", "text/x-invalid"} {
+			if len(cfg.ContentTypes) > 0 && !allowedContentType(cfg, contentType) {
+				found["content_type"] = testValue{"content_type", contentType, 1}
+				break
+			}
+		}
+	} else {
+		for _, value := range testCandidates(cfg) {
+			rule := failedRule(cfg, value)
+			if _, ok := found[rule]; !ok && rule != "" && rule != "default" && rule != "custom" {
+				found[rule] = testValue{Rule: rule, Value: value}
+			}
+		}
+	}
+	var values []testValue
+	for _, rule := range testRules {
+		if rule == "format" {
+			rule = cfg.Format
+		}
+		if value, ok := found[rule]; ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// testCandidates are values of the param tried by tests, values around
+// bounds of the rules come first, the empty one is the last
+func testCandidates(cfg *fieldConfig) []string {
+	var values []string
+	if cfg.Default != "" {
+		values = append(values, cfg.Default)
+	}
+	values = append(values, cfg.Enum...)
+	switch cfg.Type {
+	case "int", "int64", "uint", "uint64":
+		for _, n := range []float64{0, 1, -1, cfg.Min, cfg.Min - 1, cfg.Max, cfg.Max + 1} {
+			values = append(values, strconv.FormatInt(int64(n), 10))
+		}
+		values = append(values, "x")
+	case "time.Time":
+		// bounds are seconds, so times around them are in the layout
+		for _, sec := range []float64{0, cfg.Min, cfg.Min - 1, cfg.Max, cfg.Max + 1} {
+			values = append(values, formatTestTime(cfg, int64(sec)))
+		}
+		values = append(values, "x")
+	case "float64":
+		for _, n := range []float64{0, 1, -1, cfg.Min, cfg.Min - 1, cfg.Max, cfg.Max + 1} {
+			values = append(values, strconv.FormatFloat(n, 'g', -1, 64))
+		}
+		values = append(values, "x")
+	case "bool":
+		values = append(values, "true", "x")
+	case "string":
+		values = append(values, stringCandidates(cfg)...)
+	case "[]string":
+		counts := []int{1, int(cfg.Min), int(cfg.Min) - 1, int(cfg.Max), int(cfg.Max) + 1}
+		for _, item := range stringCandidates(cfg) {
+			if item == "" || strings.Contains(item, ",") || strings.TrimSpace(item) != item {
+				continue
+			}
+			for _, n := range counts {
+				if n > 0 {
+					values = append(values, strings.TrimSuffix(strings.Repeat(item+",", n), ","))
+				}
+			}
+		}
+	}
+	return append(values, "")
+}
+
+// formatTestTime formats unix seconds in the layout of the time param
+func formatTestTime(cfg *fieldConfig, sec int64) string {
+	if cfg.Layout == "unix" {
+		return strconv.FormatInt(sec, 10)
+	}
+	return time.Unix(sec, 0).UTC().Format(cfg.Layout)
+}
+
+// stringCandidates are strings of the enum, the regexp and formats, and
+// ones of each charset of the lengths around bounds with and without the
+// prefix and the suffix
+func stringCandidates(cfg *fieldConfig) []string {
+	values := append([]string{}, cfg.Enum...)
+	if cfg.Regexp != "" {
+		if value, ok := regexpExample(cfg.Regexp); ok && value != "" {
+			values = append(values, value)
+		}
+	}
+	lengths := []int{1, 3}
+	if cfg.Type == "string" && cfg.HasMin {
+		lengths = append(lengths, int(cfg.Min), int(cfg.Min)-1)
+	}
+	if cfg.HasMaxLen {
+		lengths = append(lengths, cfg.MaxLen, cfg.MaxLen+1)
+	}
+	affixes := len(cfg.Prefix) + len(cfg.Suffix)
+	lengths = append(lengths, affixes+1)
+	for _, fill := range []string{"a", "1", "!", "é"} {
+		for _, n := range lengths {
+			if n <= 0 {
+				continue
+			}
+			values = append(values, strings.Repeat(fill, n))
+			if affixes > 0 && n >= affixes {
+				values = append(values, cfg.Prefix+strings.Repeat(fill, n-affixes)+cfg.Suffix)
+			}
+			if cfg.Prefix != "" && n > len(cfg.Prefix) {
+				values = append(values, cfg.Prefix+strings.Repeat(fill, n-len(cfg.Prefix)))
+			}
+			if cfg.Suffix != "" && n > len(cfg.Suffix) {
+				values = append(values, strings.Repeat(fill, n-len(cfg.Suffix))+cfg.Suffix)
+			}
+		}
+	}
+	return append(values, "user@example.com", "https://example.com", "123e4567-e89b-12d3-a456-426614174000")
+}
+
+// regexpExample returns the string matching the pattern made of first
+// choices of its parts, it's not found for some patterns
+func regexpExample(pattern string) (string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	var b strings.Builder
+	var walk func(re *syntax.Regexp)
+	walk = func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpLiteral:
+			b.WriteString(string(re.Rune))
+		case syntax.OpCharClass:
+			b.WriteRune(classRune(re.Rune))
+		case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+			b.WriteByte('a')
+		case syntax.OpCapture, syntax.OpPlus, syntax.OpAlternate:
+			walk(re.Sub[0])
+		case syntax.OpRepeat:
+			for i := 0; i < re.Min; i++ {
+				walk(re.Sub[0])
+			}
+		case syntax.OpConcat:
+			for _, sub := range re.Sub {
+				walk(sub)
+			}
+		}
+	}
+	walk(re)
+	return b.String(), regexp.MustCompile(pattern).MatchString(b.String())
+}
+
+// classRune is the rune of the ranges of the char class, letters and
+// digits are preferred
+func classRune(ranges []rune) rune {
+	if len(ranges) == 0 {
+		return 'a'
+	}
+	for _, r := range []rune{'a', '0', 'A'} {
+		for i := 0; i+1 < len(ranges); i += 2 {
+			if ranges[i] <= r && r <= ranges[i+1] {
+				return r
+			}
+		}
+	}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i+1] > ' ' {
+			if ranges[i] > ' ' {
+				return ranges[i]
+			}
+			return ' ' + 1
+		}
+	}
+	return ranges[0]
+}
+
+// failedRule returns the first rule of the param the value fails, like
+// handlers check them, it's empty for valid values. Empty values replaced
+// by defaults of environment or constants fail "default", values checked
+// by custom validators fail "custom", they are known at runtime only.
+func failedRule(cfg *fieldConfig, value string) string {
+	if value == "" {
+		if cfg.DefaultEnv != "" || cfg.DefaultConst != "" {
+			return "default"
+		}
+		value = cfg.Default
+	}
+	if cfg.Required && value == "" {
+		return "required"
+	}
+	if cfg.Optional && value == "" {
+		return ""
+	}
+	items := []string{value}
+	switch cfg.Type {
+	case "int", "int64":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "type"
+		}
+		if rule := boundRule(cfg, float64(n)); rule != "" {
+			return rule
+		}
+	case "uint", "uint64":
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return "type"
+		}
+		if rule := boundRule(cfg, float64(n)); rule != "" {
+			return rule
+		}
+	case "time.Time":
+		t, err := parseTime(cfg.Layout, value)
+		if err != nil {
+			return "type"
+		}
+		if rule := boundRule(cfg, float64(t.Unix())); rule != "" {
+			return rule
+		}
+	case "float64":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "type"
+		}
+		if rule := boundRule(cfg, n); rule != "" {
+			return rule
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "type"
+		}
+	case "string":
+		if rule := stringRule(cfg, value); rule != "" {
+			return rule
+		}
+	case "[]string":
+		items = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				items = append(items, item)
+			}
+		}
+		if rule := boundRule(cfg, float64(len(items))); rule != "" {
+			return rule
+		}
+	}
+	for _, item := range items {
+		if len(cfg.Enum) > 0 && !inEnum(cfg.Enum, item) {
+			return "enum"
+		}
+	}
+	for _, item := range items {
+		if cfg.Regexp != "" && item != "" && !regexp.MustCompile(cfg.Regexp).MatchString(item) {
+			return "regexp"
+		}
+	}
+	for _, item := range items {
+		if cfg.Format != "" && item != "" && !validFormat(cfg.Format, item) {
+			return cfg.Format
+		}
+	}
+	if cfg.Custom != "" {
+		return "custom"
+	}
+	return ""
+}
+
+// boundRule checks the number or the count of items
+func boundRule(cfg *fieldConfig, n float64) string {
+	switch {
+	case cfg.HasMin && n < cfg.Min:
+		return "min"
+	case cfg.HasMax && n > cfg.Max:
+		return "max"
+	}
+	return ""
+}
+
+// stringRule checks the length, the prefix, the suffix and the charset
+func stringRule(cfg *fieldConfig, value string) string {
+	switch n := len(value); {
+	case cfg.HasMin && cfg.HasMaxLen && int(cfg.Min) == cfg.MaxLen && n != cfg.MaxLen:
+		return "len"
+	case cfg.HasMin && n < int(cfg.Min):
+		return "min"
+	case cfg.HasMaxLen && n > cfg.MaxLen:
+		return "maxlen"
+	case value != "" && !strings.HasPrefix(value, cfg.Prefix):
+		return "prefix"
+	case value != "" && !strings.HasSuffix(value, cfg.Suffix):
+		return "suffix"
+	}
+	for _, c := range value {
+		if cfg.Charset != "" && !inCharset(cfg.Charset, c) {
+			return "charset"
+		}
+	}
+	return ""
+}
+
+func inCharset(charset string, c rune) bool {
+	letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	digit := c >= '0' && c <= '9'
+	switch charset {
+	case "alpha":
+		return letter
+	case "alnum":
+		return letter || digit
+	case "digit":
+		return digit
+	case "hex":
+		return digit || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	}
+	return c < 0x80
+}
+
+func inEnum(enum []string, value string) bool {
+	for _, v := range enum {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validFormat(format, value string) bool {
+	switch format {
+	case "email":
+		addr, err := mail.ParseAddress(value)
+		return err == nil && addr.Address == value
+	case "url":
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	}
+	return testUUIDRegexp.MatchString(value)
+}
+
+// fileContentType is the content type of valid files, wildcards are
+// replaced with x-test
+func fileContentType(cfg *fieldConfig) string {
+	if len(cfg.ContentTypes) == 0 {
+		return "application/octet-stream"
+	}
+	return strings.Replace(cfg.ContentTypes[0], "*", "x-test", -1)
+}
+
+func allowedContentType(cfg *fieldConfig, contentType string) bool {
+	for _, t := range cfg.ContentTypes {
+		if t == contentType || strings.HasSuffix(t, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateTests makes table-driven tests of the handlers, they are
+// written to the package of the handlers. dir is the directory of
+// templates overriding the default one.
+func generateTests(buf bytes.Buffer, data *tmplData, dir string) (bytes.Buffer, error) {
+	funcMap := make(template.FuncMap)
+	funcMap["GetRecvTypes"] = GetRecvTypes
+	funcMap["GetMethodName"] = GetMethodName
+	funcMap["GetMethodParamTypeName"] = GetMethodParamTypeName
+
+	tmpl, err := loadTemplate(dir, "tests", tmplTests, funcMap)
+	if err != nil {
+		return buf, err
+	}
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+var tmplTests = `
+package {{.PackageName}}
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// handlerTestParam is the param of the request by its source, value is
+// the content type of files of size bytes
+type handlerTestParam struct {
+	source string
+	name   string
+	value  string
+	size   int64
+}
+
+// handlerTestCase is the request of the test of the handler, the status
+// of the response is any one of the method if status is 0, the error of
+// the response is of param if it's not empty
+type handlerTestCase struct {
+	name   string
+	method string
+	auth   bool
+	params []handlerTestParam
+	status int
+	param  string
+}
+
+// newHandlerTestRequest makes the request of the case, authorized ones
+// have authValue of authHeader
+func newHandlerTestRequest(path, authHeader, authValue string, c handlerTestCase) *http.Request {
+	query, form, header := url.Values{}, url.Values{}, http.Header{}
+	var jsonBody map[string]interface{}
+	var files []handlerTestParam
+	for _, p := range c.params {
+		switch p.source {
+		case "query":
+			query.Set(p.name, p.value)
+		case "form":
+			form.Set(p.name, p.value)
+		case "header":
+			header.Set(p.name, p.value)
+		case "file":
+			files = append(files, p)
+		case "json":
+			if jsonBody == nil {
+				jsonBody = map[string]interface{}{}
+			}
+			// dots of the name are the keys of nested objects
+			object := jsonBody
+			keys := strings.Split(p.name, ".")
+			for _, key := range keys[:len(keys)-1] {
+				nested, ok := object[key].(map[string]interface{})
+				if !ok {
+					nested = map[string]interface{}{}
+					object[key] = nested
+				}
+				object = nested
+			}
+			object[keys[len(keys)-1]] = p.value
+		}
+	}
+	var body io.Reader
+	contentType := ""
+	switch {
+	case jsonBody != nil:
+		buf, err := json.Marshal(jsonBody)
+		if err != nil {
+			panic(err)
+		}
+		body, contentType = bytes.NewReader(buf), "application/json"
+	case len(files) > 0:
+		buf := &bytes.Buffer{}
+		writer := multipart.NewWriter(buf)
+		for name := range form {
+			writer.WriteField(name, form.Get(name))
+		}
+		for _, f := range files {
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Disposition", fmt.Sprintf("form-data; name=%q; filename=%q", f.name, f.name))
+			h.Set("Content-Type", f.value)
+			part, err := writer.CreatePart(h)
+			if err != nil {
+				panic(err)
+			}
+			part.Write(bytes.Repeat([]byte("x"), int(f.size)))
+		}
+		writer.Close()
+		body, contentType = buf, writer.FormDataContentType()
+	case len(form) > 0:
+		body, contentType = strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"
+	}
+	r := httptest.NewRequest(c.method, path+"?"+query.Encode(), body)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	if c.auth && authHeader != "" {
+		r.Header.Set(authHeader, authValue)
+	}
+	return r
+}
+
+// checkHandlerTestResponse checks the status of the response of the case
+// and the param of its error
+func checkHandlerTestResponse(t *testing.T, c handlerTestCase, w *httptest.ResponseRecorder) {
+	t.Helper()
+	if c.status == 0 {
+		switch w.Code {
+		case http.StatusBadRequest, http.StatusForbidden, http.StatusNotAcceptable:
+			t.Errorf("%s: expected the response of the method, got %d %s", c.name, w.Code, w.Body)
+		}
+		return
+	}
+	if w.Code != c.status {
+		t.Errorf("%s: expected status %d, got %d %s", c.name, c.status, w.Code, w.Body)
+		return
+	}
+	resp := struct {
+		Error string ` + "`json:\"error\"`" + `
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !strings.Contains(resp.Error, c.param) {
+		t.Errorf("%s: expected the error of %s, got %s", c.name, c.param, w.Body)
+	}
+}
+{{range $recvName, $methods := GetRecvTypes .Methods}}
+{{range $method := $methods}}
+{{- $methodName := GetMethodName $method}}
+{{- $methodCfg := $.GetMethodConfig $method}}
+{{- $paramType := GetMethodParamTypeName $method 1}}
+{{- $httpMethod := $.ClientMethod $methodCfg $paramType}}
+func Test{{$recvName}}{{$methodName}}Handler(t *testing.T) {
+	{{- if eq $methodCfg.AuthScheme "method"}}
+	t.Skip("requests are authorized by Authorize method of {{$recvName}}")
+	{{- else if not ($.NewRecv $recvName)}}
+	t.Skip("{{$recvName}} is an interface without New{{$recvName}} returning its implementation")
+	{{- else}}
+	cases := []handlerTestCase{
+		{{- if $methodCfg.Auth}}
+		{name: "unauthorized", method: {{$httpMethod}}, status: http.StatusForbidden},
+		{{- end}}
+		{{- if and $methodCfg.HTTPMethod (not $.Router)}}
+		{name: "bad method", method: {{if eq $methodCfg.HTTPMethod "GET"}}http.MethodPost{{else}}http.MethodGet{{end}}, auth: true, status: http.StatusNotAcceptable},
+		{{- end}}
+		{{- range $request := $.GetTestRequests $methodCfg $paramType}}
+		{
+			name: {{if $request.Param}}"{{$request.Param}} {{$request.Rule}}"{{else}}"valid params"{{end}},
+			method: {{$httpMethod}},
+			auth: true,
+			params: []handlerTestParam{
+				{{- range $p := $request.Params}}
+				{ {{- printf "%q" $p.Source}}, {{printf "%q" $p.Name}}, {{printf "%q" $p.Value}}, {{$p.Size -}} },
+				{{- end}}
+			},
+			{{- if $request.Param}}
+			status: http.StatusBadRequest,
+			param: {{printf "%q" $request.Param}},
+			{{- end}}
+		},
+		{{- else}}
+		// valid params aren't known, like values of some regexps or custom
+		// validators
+		{{- end}}
+	}
+	for _, c := range cases {
+		h := {{$.NewRecv $recvName}}
+		w := httptest.NewRecorder()
+		{{- if eq $methodCfg.AuthScheme "bearer"}}
+		r := newHandlerTestRequest({{printf "%q" $methodCfg.URL}}, "Authorization", {{printf "%q" (print "Bearer " $methodCfg.AuthToken)}}, c)
+		{{- else if $methodCfg.Auth}}
+		r := newHandlerTestRequest({{printf "%q" $methodCfg.URL}}, {{printf "%q" $methodCfg.AuthHeader}}, {{printf "%q" $methodCfg.AuthToken}}, c)
+		{{- else}}
+		r := newHandlerTestRequest({{printf "%q" $methodCfg.URL}}, "", "", c)
+		{{- end}}
+		h.handler{{$methodName}}(w, r)
+		checkHandlerTestResponse(t, c, w)
+	}
+	{{- end}}
+}
+{{end}}
+{{end}}
+`
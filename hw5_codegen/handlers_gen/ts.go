@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// tsIdent matches property names written without quotes
+var tsIdent = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsParamTypes are TypeScript types of params by supportedTypes
+var tsParamTypes = map[string]string{
+	"int":       "number",
+	"int64":     "number",
+	"uint":      "number",
+	"uint64":    "number",
+	"float64":   "number",
+	"bool":      "boolean",
+	"string":    "string",
+	"[]string":  "string[]",
+	"file":      "Blob",
+	"time.Time": "string",
+}
+
+// tsBasicTypes are TypeScript types of Go basic types in JSON
+var tsBasicTypes = map[string]string{
+	"string":  "string",
+	"bool":    "boolean",
+	"int":     "number",
+	"int8":    "number",
+	"int16":   "number",
+	"int32":   "number",
+	"int64":   "number",
+	"uint":    "number",
+	"uint8":   "number",
+	"uint16":  "number",
+	"uint32":  "number",
+	"uint64":  "number",
+	"float32": "number",
+	"float64": "number",
+	"byte":    "number",
+	"rune":    "number",
+}
+
+// tsDecl is the TypeScript declaration of the type, interfaces have
+// Fields and extend Extends, Type is the aliased type otherwise
+type tsDecl struct {
+	Name    string
+	Extends []string
+	Fields  []tsField
+	Type    string
+}
+
+type tsField struct {
+	Name     string
+	Type     string
+	Optional bool
+}
+
+// tsData is the data of the TypeScript template, Params are interfaces of
+// param structs by their params and Results are declarations of the types
+// methods return in JSON
+type tsData struct {
+	*tmplData
+	Params   []*tsDecl
+	Results  []*tsDecl
+	declared map[string]bool
+}
+
+// newTSData declares param structs and the types of method results with
+// the types of the package they use
+func newTSData(data *tmplData) *tsData {
+	td := &tsData{tmplData: data, declared: make(map[string]bool)}
+	names := make([]string, 0, len(data.Structs))
+	for name := range data.Structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		td.Params = append(td.Params, td.paramDecl(name))
+	}
+	for _, method := range data.Methods {
+		td.ResultType(method)
+	}
+	return td
+}
+
+// paramDecl is the interface of the param struct, nested structs are
+// properties named after their aliases and embedded ones are extended
+func (td *tsData) paramDecl(structName string) *tsDecl {
+	decl := &tsDecl{Name: structName}
+	for _, field := range td.Structs[structName].Fields.List {
+		cfg := td.GetFieldConfig(structName, getFieldName(field))
+		switch {
+		case cfg.Embedded:
+			decl.Extends = append(decl.Extends, cfg.Struct)
+		case cfg.Struct != "":
+			decl.Fields = append(decl.Fields, tsField{cfg.Alias, cfg.Struct, false})
+		default:
+			decl.Fields = append(decl.Fields, tsField{cfg.Alias, TSParamType(cfg), TSOptional(cfg)})
+		}
+	}
+	return decl
+}
+
+// TSOptional reports whether the param may be undefined, numbers, bools
+// and times have to be sent unless they are pointers or have defaults
+func TSOptional(cfg *fieldConfig) bool {
+	switch {
+	case cfg.Required:
+		return false
+	case cfg.Optional || cfg.Default != "" || cfg.DefaultEnv != "" || cfg.DefaultConst != "":
+		return true
+	}
+	return !numberTypes[cfg.Type] && cfg.Type != "bool" && cfg.Type != "time.Time"
+}
+
+// TSParamType is the TypeScript type of the param, enums are unions of
+// their values. Times are strings in their layout, numbers of seconds of
+// unix one.
+func TSParamType(cfg *fieldConfig) string {
+	if cfg.Layout == "unix" {
+		return "number"
+	}
+	if len(cfg.Enum) == 0 {
+		return tsParamTypes[cfg.Type]
+	}
+	values := make([]string, 0, len(cfg.Enum))
+	for _, value := range cfg.Enum {
+		if !numberTypes[cfg.Type] {
+			value = strconv.Quote(value)
+		}
+		values = append(values, value)
+	}
+	if cfg.Type == "[]string" {
+		return "(" + strings.Join(values, " | ") + ")[]"
+	}
+	return strings.Join(values, " | ")
+}
+
+// ResultType is the TypeScript type of the method result, the pointer to
+// it is never null
+func (td *tsData) ResultType(method *ast.FuncDecl) string {
+	expr := method.Type.Results.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	return td.tsType(expr)
+}
+
+// tsType is the TypeScript type of the JSON value of the Go type, types of
+// the package are declared. Unknown types are unknown.
+func (td *tsData) tsType(expr ast.Expr) string {
+	switch node := expr.(type) {
+	case *ast.Ident:
+		if spec := td.Types[node.Name]; spec != nil {
+			return td.declare(spec)
+		}
+		if tsType, ok := tsBasicTypes[node.Name]; ok {
+			return tsType
+		}
+	case *ast.StarExpr:
+		return td.tsType(node.X) + " | null"
+	case *ast.ArrayType:
+		if ident, ok := node.Elt.(*ast.Ident); ok && ident.Name == "byte" {
+			// []byte is base64 string
+			return "string"
+		}
+		elem := td.tsType(node.Elt)
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case *ast.MapType:
+		return "Record<string, " + td.tsType(node.Value) + ">"
+	case *ast.SelectorExpr:
+		if types.ExprString(node) == "time.Time" {
+			return "string"
+		}
+	case *ast.StructType:
+		extends, fields := td.jsonFields(node)
+		var props []string
+		for _, field := range fields {
+			props = append(props, TSProperty(field))
+		}
+		return strings.Join(append(extends, "{ "+strings.Join(props, "; ")+" }"), " & ")
+	}
+	return "unknown"
+}
+
+// resultName is the name of the declaration of the type, param structs
+// have Result suffix since their JSON differs from their params
+func (td *tsData) resultName(name string) string {
+	if td.Structs[name] != nil {
+		return name + "Result"
+	}
+	return name
+}
+
+// declare adds the declaration of the type to Results once and returns its
+// name
+func (td *tsData) declare(spec *ast.TypeSpec) string {
+	name := td.resultName(spec.Name.Name)
+	if td.declared[name] {
+		return name
+	}
+	td.declared[name] = true
+	decl := &tsDecl{Name: name}
+	td.Results = append(td.Results, decl)
+	if st, ok := spec.Type.(*ast.StructType); ok {
+		decl.Extends, decl.Fields = td.jsonFields(st)
+	} else {
+		decl.Type = td.tsType(spec.Type)
+	}
+	return name
+}
+
+// jsonFields are the properties of the struct by encoding/json rules,
+// embedded structs of the package without names are extended
+func (td *tsData) jsonFields(st *ast.StructType) (extends []string, fields []tsField) {
+	for _, field := range st.Fields.List {
+		tag := ""
+		if field.Tag != nil {
+			if value, err := strconv.Unquote(field.Tag.Value); err == nil {
+				tag = reflect.StructTag(value).Get("json")
+			}
+		}
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		tsType := td.tsType(field.Type)
+		optional := false
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "omitempty":
+				optional = true
+			case "string":
+				tsType = "string"
+			}
+		}
+		names := field.Names
+		if len(names) == 0 {
+			typeName := getTypeNameFromExpr(field.Type)
+			typeName = typeName[strings.LastIndex(typeName, ".")+1:]
+			if spec := td.Types[typeName]; opts[0] == "" && spec != nil {
+				if _, ok := spec.Type.(*ast.StructType); ok {
+					extends = append(extends, td.declare(spec))
+					continue
+				}
+			}
+			names = []*ast.Ident{ast.NewIdent(typeName)}
+		}
+		for _, ident := range names {
+			if !ident.IsExported() {
+				continue
+			}
+			name := opts[0]
+			if name == "" {
+				name = ident.Name
+			}
+			fields = append(fields, tsField{name, tsType, optional})
+		}
+	}
+	return extends, fields
+}
+
+// TSProperty is the property of the interface
+func TSProperty(field tsField) string {
+	name := field.Name
+	if !tsIdent.MatchString(name) {
+		name = strconv.Quote(name)
+	}
+	if field.Optional {
+		name += "?"
+	}
+	return name + ": " + field.Type
+}
+
+// TSAccess is the expression of the param of the object, dots of the name
+// are the keys of nested objects
+func TSAccess(object, name string) string {
+	for _, key := range strings.Split(name, ".") {
+		if tsIdent.MatchString(key) {
+			object += "." + key
+		} else {
+			object += "[" + strconv.Quote(key) + "]"
+		}
+	}
+	return object
+}
+
+// TSMethodName is the name of the client method, the one of the handler
+// starting with the lower case letter
+func TSMethodName(method *ast.FuncDecl) string {
+	name := GetMethodName(method)
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// TSMethod is the string of the HTTP method the client sends
+func (td *tsData) TSMethod(cfg *methodConfig, paramType string) string {
+	return strconv.Quote(td.requestMethod(cfg, paramType))
+}
+
+// TSSetParam returns the statement adding the param to the request by its
+// source, values of the query, form and headers are encoded like the ones
+// of the Go client
+func (td *tsData) TSSetParam(cfg *methodConfig, paramType string, p param, expr string) string {
+	switch source := td.requestSource(cfg, paramType, p); source {
+	case "file":
+		return "req.setFile(" + strconv.Quote(p.Name) + ", " + expr + ")"
+	case "json":
+		return "req.setJSON(" + strconv.Quote(p.Name) + ", " + expr + ")"
+	default:
+		switch p.Cfg.Type {
+		case "int", "int64", "uint", "uint64", "float64", "bool", "time.Time":
+			expr = "String(" + expr + ")"
+		case "[]string":
+			expr += `.join(",")`
+		}
+		return "req." + source + ".set(" + strconv.Quote(p.Name) + ", " + expr + ")"
+	}
+}
+
+// generateTS makes TypeScript interfaces of params and results and fetch
+// clients per receiver type of the handlers. dir is the directory of
+// templates overriding the default one.
+func generateTS(buf bytes.Buffer, data *tmplData, dir string) (bytes.Buffer, error) {
+	funcMap := make(template.FuncMap)
+	funcMap["GetRecvTypes"] = GetRecvTypes
+	funcMap["GetMethodParamTypeName"] = GetMethodParamTypeName
+	funcMap["TSMethodName"] = TSMethodName
+	funcMap["TSProperty"] = TSProperty
+	funcMap["TSAccess"] = TSAccess
+	funcMap["TSOptional"] = TSOptional
+	funcMap["join"] = strings.Join
+
+	tmpl, err := loadTemplate(dir, "ts", tmplTS, funcMap)
+	if err != nil {
+		return buf, err
+	}
+	err = tmpl.Execute(&buf, newTSData(data))
+	if err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+var tmplTS = `
+{{- define "tsDecl"}}
+{{- if .Type}}export type {{.Name}} = {{.Type}};
+{{- else}}export interface {{.Name}}{{if .Extends}} extends {{join .Extends ", "}}{{end}} {
+{{- range .Fields}}
+  {{TSProperty .}};
+{{- end}}
+}
+{{- end}}
+{{- end}}
+
+{{- define "tsAuth"}}
+{{- if eq .AuthScheme "header"}}[{{printf "%q" .AuthHeader}}, this.auth]
+{{- else if eq .AuthScheme "bearer"}}["Authorization", "Bearer " + this.auth]
+{{- else if eq .AuthScheme "method"}}["Authorization", this.auth]
+{{- else}}undefined
+{{- end}}
+{{- end -}}
+
+// APIResponse is the body of responses of the handlers
+export interface APIResponse<T> {
+  error: string;
+  response?: T;
+{{- if .StructuredErrors}}
+  // code is the status of errors in snake case, like bad_request
+  code?: string;
+  // errors are the errors of params
+  errors?: FieldError[];
+{{- end}}
+}
+{{if .StructuredErrors}}
+// FieldError is the error of the param, rule is the apivalidator token it
+// fails, or the type of the param
+export interface FieldError {
+  field: string;
+  rule: string;
+  message: string;
+}
+{{end}}
+// ApiError is thrown for errors of the handlers, status is the one of the
+// response
+export class ApiError extends Error {
+  status: number;
+  body?: APIResponse<unknown>;
+
+  constructor(status: number, message: string, body?: APIResponse<unknown>) {
+    super(message);
+    this.name = "ApiError";
+    this.status = status;
+    this.body = body;
+  }
+}
+
+// ApiRequest is the params of the handler by their sources, JSON body is
+// sent instead of the form if it's set. The form is multipart with files.
+class ApiRequest {
+  query = new URLSearchParams();
+  form = new URLSearchParams();
+  header = new Headers();
+  json?: Record<string, unknown>;
+  files?: Record<string, Blob>;
+
+  // setJSON sets the value of the body, dots of the name are the keys of
+  // nested objects
+  setJSON(name: string, value: unknown): void {
+    let object: Record<string, unknown> = (this.json ??= {});
+    const keys = name.split(".");
+    for (const key of keys.slice(0, -1)) {
+      object = (object[key] ??= {}) as Record<string, unknown>;
+    }
+    object[keys[keys.length - 1]] = value;
+  }
+
+  setFile(name: string, file: Blob): void {
+    (this.files ??= {})[name] = file;
+  }
+}
+
+// apiCall sends params of the handler and returns its response, errors of
+// the handler are ApiError with the status of the response
+async function apiCall<T>(baseURL: string, method: string, path: string, req: ApiRequest, auth?: [string, string], signal?: AbortSignal): Promise<T> {
+  let target = baseURL.replace(/\/$/, "") + path;
+  const query = req.query.toString();
+  if (query !== "") {
+    target += "?" + query;
+  }
+  let body: BodyInit | undefined;
+  if (req.json !== undefined) {
+    body = JSON.stringify(req.json);
+    req.header.set("Content-Type", "application/json");
+  } else if (req.files !== undefined) {
+    // fetch sets the multipart content type with the boundary
+    const form = new FormData();
+    req.form.forEach((value, name) => form.append(name, value));
+    for (const [name, file] of Object.entries(req.files)) {
+      form.append(name, file);
+    }
+    body = form;
+  } else if (method === "POST") {
+    body = req.form;
+  }
+  if (auth !== undefined) {
+    req.header.set(auth[0], auth[1]);
+  }
+  const resp = await fetch(target, { method, headers: req.header, body, signal });
+  let ar: APIResponse<T>;
+  try {
+    ar = await resp.json();
+  } catch (err) {
+    if (!resp.ok) {
+      throw new ApiError(resp.status, "unexpected status " + resp.status);
+    }
+    throw new Error("cant unpack response json: " + err);
+  }
+  // methods may set other successful statuses, like 201
+  if (ar.error || !resp.ok) {
+    throw new ApiError(resp.status, ar.error, ar);
+  }
+  return ar.response as T;
+}
+
+// params of the handlers, optional ones aren't sent if they are undefined
+{{- range .Params}}
+{{template "tsDecl" .}}
+{{end}}
+{{- if .Results}}
+// results of the handlers
+{{- range .Results}}
+{{template "tsDecl" .}}
+{{end}}
+{{- end}}
+{{- range $recvTypeName, $methods := GetRecvTypes .Methods}}
+// {{$recvTypeName}}Client calls handlers of {{$recvTypeName}} served at url, auth is sent to
+// handlers requiring authorization, it's the token of bearer auth and the
+// Authorization header of Authorize method
+export class {{$recvTypeName}}Client {
+  url: string;
+  auth: string;
+
+  constructor(url: string, auth = "") {
+    this.url = url;
+    this.auth = auth;
+  }
+{{range $method := $methods}}
+{{- $methodName := TSMethodName $method}}
+{{- $methodCfg := $.GetMethodConfig $method}}
+{{- $paramType := GetMethodParamTypeName $method 1}}
+{{- $resultType := $.ResultType $method}}
+  // {{$methodName}} calls {{$methodCfg.URL}}
+  {{$methodName}}(params: {{$paramType}}, signal?: AbortSignal): Promise<{{$resultType}}> {
+    const req = new ApiRequest();
+    {{- range $param := $.GetParams $paramType}}
+    {{- $value := TSAccess "params" $param.Name}}
+    {{- if TSOptional $param.Cfg}}
+    if ({{$value}} !== undefined) {
+      {{$.TSSetParam $methodCfg $paramType $param $value}};
+    }
+    {{- else}}
+    {{$.TSSetParam $methodCfg $paramType $param $value}};
+    {{- end}}
+    {{- end}}
+    return apiCall<{{$resultType}}>(this.url, {{$.TSMethod $methodCfg $paramType}}, "{{$methodCfg.URL}}", req, {{template "tsAuth" $methodCfg}}, signal);
+  }
+{{end -}}
+}
+{{end}}`
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeScript(t *testing.T) {
+	data, err := parseSrc([]string{filepath.Join("testdata", "multi")}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := generateTS(bytes.Buffer{}, data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadFile(filepath.Join("testdata", "multi.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ts.Bytes(), expected) {
+		t.Errorf("unexpected TypeScript:\n%s", ts.Bytes())
+	}
+}
+
+func TestTypeScriptParams(t *testing.T) {
+	cases := map[string][]string{
+		"types.go": {
+			"export interface SearchParams {\n  query: string;\n  score?: number;\n",
+			`tags?: ("go" | "rust" | "c")[];`,
+			`lang?: "en" | "ru";`,
+			"export interface SearchParamsResult {\n  Query: string;",
+			"Limit: number | null;",
+			`req.query.set("tags", params.tags.join(","));`,
+		},
+		"nested.go": {
+			"export interface OrderParams extends Page {\n  query: string;\n  location: Location;\n}",
+			"export interface OrderParamsResult extends PageResult {",
+			`req.query.set("location.home.city", params.location.home.city);`,
+		},
+		"sources.go": {
+			`"X-Request-Id": string;`,
+			`req.header.set("X-Request-Id", params["X-Request-Id"]);`,
+			`req.setJSON("item.name", params.item.name);`,
+			`apiCall<OrderParamsResult>(this.url, "POST", "/order", req, undefined, signal)`,
+		},
+		"upload.go": {
+			"avatar: Blob;",
+			`req.setFile("avatar", params.avatar);`,
+		},
+		"times.go": {
+			"start: string;",
+			"deadline?: number;",
+			"seq: number;",
+			`req.query.set("id", String(params.id));`,
+		},
+		"auth.go": {
+			`["X-Api-Key", this.auth]`,
+			`["Authorization", "Bearer " + this.auth]`,
+		},
+	}
+	for file, parts := range cases {
+		data, err := parseSrc([]string{filepath.Join("testdata", file)}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, err := generateTS(bytes.Buffer{}, data, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, part := range parts {
+			if !strings.Contains(ts.String(), part) {
+				t.Errorf("%s: expected %s in TypeScript:\n%s", file, part, ts.Bytes())
+			}
+		}
+	}
+}
+
+func TestTSType(t *testing.T) {
+	cases := map[string]string{
+		"int64":                        "number",
+		"[]byte":                       "string",
+		"[]*string":                    "(string | null)[]",
+		"map[string][]bool":            "Record<string, boolean[]>",
+		"time.Time":                    "string",
+		"interface{}":                  "unknown",
+		"struct{ A int `json:\"a\"` }": "{ a: number }",
+	}
+	td := &tsData{tmplData: &tmplData{}, declared: make(map[string]bool)}
+	for src, expected := range cases {
+		expr, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tsType := td.tsType(expr); tsType != expected {
+			t.Errorf("%s: expected %s, got %s", src, expected, tsType)
+		}
+	}
+}
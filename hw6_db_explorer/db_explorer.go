@@ -1,17 +1,28 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -19,6 +30,20 @@ const (
 	defaultOffset int = 0
 )
 
+const (
+	// defaultTxTimeout is the time of the transaction without requests
+	// without Options.TxTimeout
+	defaultTxTimeout = 30 * time.Second
+	// defaultMaxTx is the limit of open transactions without
+	// Options.MaxTransactions
+	defaultMaxTx = 100
+	// txHeader is the header of the token of the transaction of requests
+	txHeader = "X-Transaction"
+	// refreshOnMiss is the minimal time between refreshes of the schema of
+	// requests of unknown tables and of /_schema/refresh
+	refreshOnMiss = time.Second
+)
+
 const (
 	kindString kind = iota
 	kindNullString
@@ -26,13 +51,29 @@ const (
 	kindNullInt64
 	kindFloat64
 	kindNullFloat64
+	kindBool
+	kindNullBool
+	// dates and times are RFC3339 in JSON
+	kindTime
+	kindNullTime
+	// decimals are strings in JSON to keep their precision
+	kindDecimal
+	kindNullDecimal
+	// blobs are base64 strings in JSON
+	kindBytes
+	kindNullBytes
 )
 
 type kind int
 type errInvalidType string
-type wrapper func(h http.HandlerFunc) http.HandlerFunc
+type errInvalidFilter string
+type errSchemaChanged string
+type errUnsupportedTable string
+type handlerFunc func(w http.ResponseWriter, r *http.Request) error
+type wrapper func(h handlerFunc) handlerFunc
 type segmentsMap string
 type rowKey string
+type txKey string
 
 type route struct {
 	re       *regexp.Regexp
@@ -44,20 +85,119 @@ type httpRouter struct {
 	routes []*route
 }
 
+// Options of the explorer, the zero value exposes all tables for reads and
+// writes
+type Options struct {
+	// ReadOnly rejects inserts, updates and deletes, transactions are read
+	// only too
+	ReadOnly bool
+	// AllowTables are the only exposed tables if it's not empty, the ones
+	// of DenyTables are hidden
+	AllowTables []string
+	DenyTables  []string
+	// RefreshInterval is the age of the schema refreshed in the background
+	// by requests, 0 disables it
+	RefreshInterval time.Duration
+	// MaxTransactions is the limit of open transactions, new ones are
+	// rejected with 503 at it. 0 is defaultMaxTx. Each open transaction
+	// holds a connection of the pool, so the limit is kept below
+	// db.SetMaxOpenConns of the explorer for requests without transactions.
+	MaxTransactions int
+	// TxTimeout is the time of the transaction without requests, it's
+	// rolled back after it. 0 is defaultTxTimeout.
+	TxTimeout time.Duration
+}
+
 type env struct {
-	db   *sql.DB
-	meta *dbMeta
+	db      *sql.DB
+	dialect dialect
+	schema  *schema
+}
+
+// querier runs queries of the database or of the transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
+// txs are the open transactions of the REST API by their tokens
+type txs struct {
+	mu       sync.Mutex
+	db       *sql.DB
+	timeout  time.Duration
+	readOnly bool
+	maxOpen  int
+	open     map[string]*txEntry
+	// starting are the transactions being begun, they count for maxOpen
+	starting int
+}
+
+// txEntry is the transaction of the token, its requests are serialized by
+// mu
+type txEntry struct {
+	mu       sync.Mutex
+	tx       *sql.Tx
+	timer    *time.Timer
+	deadline time.Time
+	done     bool
+}
+
+// dialect is the SQL of the database, queries are written with ?
+// placeholders of MySQL and rebound to the ones of the database
+type dialect interface {
+	// tablesQuery selects names of the tables
+	tablesQuery() string
+	// columnsQuery selects name, type, key (PRI of the primary key) and
+	// nullability (YES or NO) of columns of the table of its parameter
+	columnsQuery() string
+	// refsQuery selects the column, the referenced table and its column of
+	// single column foreign keys of the table of its parameter
+	refsQuery() string
+	rebind(q string) string
+	// quote quotes the identifier, quotes of the name are escaped
+	quote(name string) string
+	// insert runs the insert query and returns the primary key of the new
+	// record, pkName is quoted
+	insert(db querier, q, pkName string, args []interface{}) (int64, error)
+}
+
+type mysqlDialect struct{}
+
+type postgresDialect struct{}
+
 type dbMeta struct {
-	keys []string
-	data map[string]tableSpec
+	keys   []string
+	data   map[string]tableSpec
+	loaded time.Time
+}
+
+// schema is the dbMeta of the database, refreshes replace it as a whole
+type schema struct {
+	db      *sql.DB
+	dialect dialect
+	opts    Options
+	// meta is *dbMeta, mu serializes refreshes
+	meta       atomic.Value
+	mu         sync.Mutex
+	refreshing int32
 }
 
 type tableSpec struct {
 	name string
 	pk   *colSpec
 	cols []*colSpec
+	refs []*refSpec
+}
+
+// refSpec is the foreign key of the column, name is the relation of expand,
+// it's the name of the column without _id. It's empty if it's the name of
+// another column, such keys are only the ones of child records.
+type refSpec struct {
+	name   string
+	col    *colSpec
+	table  string
+	refCol string
 }
 
 type colSpec struct {
@@ -66,6 +206,35 @@ type colSpec struct {
 	nullable bool
 }
 
+// row is the record of the table, vals are pointers to values of its
+// columns
+type row struct {
+	cols []*colSpec
+	vals []interface{}
+	// rels are the records of expanded relations, missing ones are nil
+	rels []rel
+}
+
+type rel struct {
+	name string
+	row  *row
+}
+
+// idents quotes identifiers of the table for queries, only the names of
+// the table and its columns of the cached dbMeta are quoted
+type idents struct {
+	d    dialect
+	spec tableSpec
+}
+
+// filter is the condition of the column parsed from the query parameter
+// like age__gte=30, op is the SQL operator
+type filter struct {
+	col    *colSpec
+	op     string
+	values []interface{}
+}
+
 type nullString struct {
 	sql.NullString
 }
@@ -78,240 +247,805 @@ type nullFloat64 struct {
 	sql.NullFloat64
 }
 
+type nullBool struct {
+	sql.NullBool
+}
+
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+type nullDecimal struct {
+	sql.NullString
+}
+
+type nullBytes struct {
+	Bytes []byte
+	Valid bool
+}
+
 func (e errInvalidType) Error() string {
 	return string(e)
 }
 
-func (m *dbMeta) get(tableName string) tableSpec {
+func (e errInvalidFilter) Error() string {
+	return string(e)
+}
+
+func (e errSchemaChanged) Error() string {
+	return "columns of table " + string(e) + " changed"
+}
+
+func (e errUnsupportedTable) Error() string {
+	return string(e)
+}
+
+// errHTTP is the error of the response with its status, the cause isn't
+// sent to clients
+type errHTTP struct {
+	status int
+	msg    string
+	cause  error
+}
+
+func (e errHTTP) Error() string {
+	if e.cause == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.cause.Error()
+}
+
+// responseWriter remembers whether the response is written, errors
+// after it are logged only
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (m *dbMeta) get(tableName string) (tableSpec, error) {
 	val, ok := m.data[tableName]
 	if !ok {
-		panic("missing key: " + tableName)
+		return val, errHTTP{status: http.StatusNotFound, msg: "unknown table"}
 	}
-	return val
+	return val, nil
 }
 
-func (m *dbMeta) set(tableName string, spec tableSpec) {
+func (m *dbMeta) set(tableName string, spec tableSpec) error {
 	_, ok := m.data[tableName]
 	if ok {
-		panic("key already exists: " + tableName)
+		return errors.New("key already exists: " + tableName)
 	}
 	m.keys = append(m.keys, tableName)
 	m.data[tableName] = spec
+	return nil
 }
 
-func makeSelectFromHandler(env *env) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func makeSelectFromHandler(env *env) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		tableName := getSegmentValue(r.Context(), "table")
-		limitRaw := r.URL.Query().Get("limit")
-		offsetRaw := r.URL.Query().Get("offset")
-		limit, offset := parseLimitOffset(limitRaw, offsetRaw)
-		q := fmt.Sprintf("SELECT * FROM %s LIMIT %d, %d", tableName, offset, limit)
-		rows, err := env.db.Query(q)
+		ids, err := env.schema.idents(tableName)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		defer func() {
-			err := rows.Close()
-			if err != nil {
-				panic(err.Error())
-			}
-		}()
+		return selectRecords(w, r, env, ids, nil)
+	}
+}
 
-		tableSpec := env.meta.get(tableName)
-		rowType := makeRowTypeFromSpec(tableSpec)
-		var result []interface{}
-		for rows.Next() {
-			row, vals := newRowWithVals(rowType)
-			err = rows.Scan(vals...)
-			if err != nil {
-				panic(err.Error())
-			}
-			result = append(result, row)
+// selectRecords writes the page of records of the filters of the query
+// and the extra ones
+func selectRecords(w http.ResponseWriter, r *http.Request, env *env, ids idents, extra []filter) error {
+	limitRaw := r.URL.Query().Get("limit")
+	offsetRaw := r.URL.Query().Get("offset")
+	limit, offset := parseLimitOffset(limitRaw, offsetRaw)
+	tableSpec := ids.spec
+	filters, err := parseFilters(tableSpec, r.URL.Query())
+	if err != nil {
+		return err
+	}
+	refs, err := parseExpand(tableSpec, r.URL.Query())
+	if err != nil {
+		return err
+	}
+	where, args, err := prepareWhere(ids, append(extra, filters...))
+	if err != nil {
+		return err
+	}
+	// total is the number of records of the filters on all pages
+	var total int64
+	q := env.dialect.rebind(fmt.Sprintf("SELECT COUNT(*) FROM %s%s", ids.table(), where))
+	err = env.conn(r).QueryRow(q, args...).Scan(&total)
+	if err != nil {
+		return err
+	}
+	q = env.dialect.rebind(fmt.Sprintf("SELECT * FROM %s%s LIMIT %d OFFSET %d", ids.table(), where, limit, offset))
+	records, err := selectRows(env.conn(r), q, args, tableSpec)
+	if err != nil {
+		return err
+	}
+	err = expandRows(env.conn(r), env, records, refs)
+	if err != nil {
+		return err
+	}
+
+	response := map[string]interface{}{
+		"response": map[string]interface{}{
+			"records": records,
+			"total":   total,
+			"limit":   limit,
+			"offset":  offset,
+		},
+	}
+	return writeResponse(w, response)
+}
+
+func makeSelectFromWhereHandler(env *env) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		tableName := getSegmentValue(r.Context(), "table")
+		id, err := getID(r)
+		if err != nil {
+			return err
 		}
-		err = rows.Err()
+		ids, err := env.schema.idents(tableName)
 		if err != nil {
-			panic(err.Error())
+			return err
+		}
+		tableSpec := ids.spec
+		refs, err := parseExpand(tableSpec, r.URL.Query())
+		if err != nil {
+			return err
+		}
+		pkName, err := ids.pk()
+		if err != nil {
+			return err
+		}
+		q := env.dialect.rebind(fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", ids.table(), pkName))
+		records, err := selectRows(env.conn(r), q, []interface{}{id}, tableSpec)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return errHTTP{status: http.StatusNotFound, msg: "record not found"}
+		}
+		result := records[0]
+		err = expandRows(env.conn(r), env, records[:1], refs)
+		if err != nil {
+			return err
 		}
-
 		response := map[string]interface{}{
 			"response": map[string]interface{}{
-				"records": result,
+				"record": result,
 			},
 		}
+		return writeResponse(w, response)
+	}
+}
 
-		err = writeResponse(w, response)
+// getID returns the id of the record of the URL
+func getID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(getSegmentValue(r.Context(), "id"))
+	if err != nil {
+		return 0, errHTTP{status: http.StatusBadRequest, msg: "invalid id", cause: err}
+	}
+	return id, nil
+}
+
+// getRowParams returns the values of the columns of the request validated
+// by the JSON validator
+func getRowParams(r *http.Request) (map[string]interface{}, error) {
+	parsedParams, ok := r.Context().Value(rowKey("")).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("query parameters expected")
+	}
+	return parsedParams, nil
+}
+
+func (t tableSpec) getCol(name string) *colSpec {
+	for _, col := range t.cols {
+		if col.name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+// getRef returns the foreign key of the relation, it's nil if there is no
+// such one
+func (t tableSpec) getRef(name string) *refSpec {
+	for _, ref := range t.refs {
+		if ref.name == name {
+			return ref
+		}
+	}
+	return nil
+}
+
+func (t tableSpec) getColNames() []string {
+	var names []string
+	for _, col := range t.cols {
+		names = append(names, col.name)
+	}
+	return names
+}
+
+// newSchema introspects the database, unknown tables of the allow list and
+// unsupported tables are errors of the startup, refreshes skip them
+func newSchema(db *sql.DB, d dialect, opts Options) (*schema, error) {
+	s := &schema{db: db, dialect: d, opts: opts}
+	m, err := getDBMeta(db, d, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	s.meta.Store(m)
+	return s, nil
+}
+
+// load returns the current metadata, the old one is refreshed in the
+// background
+func (s *schema) load() *dbMeta {
+	m := s.meta.Load().(*dbMeta)
+	interval := s.opts.RefreshInterval
+	if interval <= 0 || time.Since(m.loaded) < interval || !atomic.CompareAndSwapInt32(&s.refreshing, 0, 1) {
+		return m
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.refreshing, 0)
+		_, err := s.refresh()
 		if err != nil {
-			panic(err.Error())
+			log.Printf("refresh of schema: %v", err)
 		}
+	}()
+	return m
+}
+
+// refresh introspects the database again and swaps the metadata
+func (s *schema) refresh() (*dbMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reload()
+}
+
+// reload swaps the metadata, mu must be locked. The last one is kept on
+// errors.
+func (s *schema) reload() (*dbMeta, error) {
+	m, err := getDBMeta(s.db, s.dialect, s.opts, false)
+	if err != nil {
+		return nil, err
 	}
+	s.meta.Store(m)
+	return m, nil
 }
 
-func makeSelectFromWhereHandler(env *env) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		tableName := getSegmentValue(r.Context(), "table")
-		idRaw := getSegmentValue(r.Context(), "id")
-		id, err := strconv.Atoi(idRaw)
+// refreshSince refreshes the metadata unless it's been done since old was
+// loaded
+func (s *schema) refreshSince(old *dbMeta) (*dbMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m := s.meta.Load().(*dbMeta); m != old {
+		return m, nil
+	}
+	return s.reload()
+}
+
+// refreshStale refreshes the metadata unless it's been loaded less than
+// refreshOnMiss ago, so requests can't reload it too often. refreshed is
+// false for the fresh one.
+func (s *schema) refreshStale() (m *dbMeta, refreshed bool, err error) {
+	m = s.load()
+	if time.Since(m.loaded) < refreshOnMiss {
+		return m, false, nil
+	}
+	m, err = s.refreshSince(m)
+	return m, err == nil, err
+}
+
+// get returns the table of the metadata, unknown tables are looked up in
+// the refreshed one unless it's fresh
+func (s *schema) get(tableName string) (tableSpec, error) {
+	spec, err := s.load().get(tableName)
+	if err == nil {
+		return spec, nil
+	}
+	m, _, err := s.refreshStale()
+	if err != nil {
+		return spec, err
+	}
+	return m.get(tableName)
+}
+
+// idents returns the quoter of identifiers of the table, it's 404 for
+// tables which aren't in the metadata
+func (s *schema) idents(tableName string) (idents, error) {
+	spec, err := s.get(tableName)
+	if err != nil {
+		return idents{}, err
+	}
+	return idents{s.dialect, spec}, nil
+}
+
+func (i idents) table() string {
+	return i.d.quote(i.spec.name)
+}
+
+// col returns the quoted name of the column, names of other columns never
+// reach queries
+func (i idents) col(name string) (string, error) {
+	if i.spec.getCol(name) == nil {
+		return "", fmt.Errorf("unknown column %s of table %s", name, i.spec.name)
+	}
+	return i.d.quote(name), nil
+}
+
+func (i idents) pk() (string, error) {
+	if i.spec.pk == nil {
+		return "", errors.New("missing PK of table " + i.spec.name)
+	}
+	return i.col(i.spec.pk.name)
+}
+
+// getFilterOp returns the SQL operator of the filter suffix, the column
+// is compared with = without it
+func getFilterOp(suffix string) (string, bool) {
+	switch suffix {
+	case "", "eq":
+		return "=", true
+	case "ne":
+		return "<>", true
+	case "gt":
+		return ">", true
+	case "gte":
+		return ">=", true
+	case "lt":
+		return "<", true
+	case "lte":
+		return "<=", true
+	case "like":
+		return "LIKE", true
+	case "in":
+		return "IN", true
+	case "isnull":
+		return "IS NULL", true
+	}
+	return "", false
+}
+
+// parseFilterValue converts the value of the filter to the type of the
+// column
+func parseFilterValue(col *colSpec, value string) (interface{}, error) {
+	switch col.typ {
+	case kindInt64, kindNullInt64:
+		return strconv.ParseInt(value, 10, 64)
+	case kindFloat64, kindNullFloat64:
+		return strconv.ParseFloat(value, 64)
+	case kindBool, kindNullBool:
+		return strconv.ParseBool(value)
+	case kindTime, kindNullTime:
+		return parseTime(value)
+	case kindDecimal, kindNullDecimal:
+		if !isDecimal(value) {
+			return nil, errors.New("invalid decimal " + value)
+		}
+	case kindBytes, kindNullBytes:
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return value, nil
+}
+
+// parseFilters returns filters of the query parameters named like
+// column__op, limit and offset aren't filters. The conditions of all of
+// them must match.
+func parseFilters(t tableSpec, query url.Values) ([]filter, error) {
+	var params []string
+	for param := range query {
+		if param == "limit" || param == "offset" || param == "expand" {
+			continue
+		}
+		params = append(params, param)
+	}
+	// filters of the same query are in the same order
+	sort.Strings(params)
+	var filters []filter
+	for _, param := range params {
+		colName, suffix := param, ""
+		if i := strings.LastIndex(param, "__"); i != -1 {
+			colName, suffix = param[:i], param[i+2:]
+		}
+		col := t.getCol(colName)
+		if whole := t.getCol(param); whole != nil {
+			// the column may have __ in its name
+			col, colName, suffix = whole, param, ""
+		}
+		if col == nil {
+			return nil, errInvalidFilter("unknown field " + colName)
+		}
+		op, ok := getFilterOp(suffix)
+		if !ok {
+			return nil, errInvalidFilter("unknown filter " + param)
+		}
+		isString := col.typ == kindString || col.typ == kindNullString
+		if op == "LIKE" && !isString {
+			return nil, errInvalidFilter("filter " + param + " requires string field")
+		}
+		for _, raw := range query[param] {
+			f := filter{col: col, op: op}
+			switch op {
+			case "IS NULL":
+				isNull, err := strconv.ParseBool(raw)
+				if err != nil {
+					return nil, errInvalidFilter("filter " + param + " have invalid value")
+				}
+				if !isNull {
+					f.op = "IS NOT NULL"
+				}
+				filters = append(filters, f)
+				continue
+			case "IN":
+				for _, item := range strings.Split(raw, ",") {
+					value, err := parseFilterValue(col, item)
+					if err != nil {
+						return nil, errInvalidFilter("filter " + param + " have invalid value")
+					}
+					f.values = append(f.values, value)
+				}
+			default:
+				value, err := parseFilterValue(col, raw)
+				if err != nil {
+					return nil, errInvalidFilter("filter " + param + " have invalid value")
+				}
+				f.values = append(f.values, value)
+			}
+			filters = append(filters, f)
+		}
+	}
+	return filters, nil
+}
+
+// parseExpand returns the relations of the expand parameters, names of
+// relations are comma separated
+func parseExpand(t tableSpec, query url.Values) ([]*refSpec, error) {
+	var refs []*refSpec
+	seen := make(map[string]bool)
+	for _, param := range query["expand"] {
+		for _, name := range strings.Split(param, ",") {
+			if name == "" || seen[name] {
+				continue
+			}
+			ref := t.getRef(name)
+			if ref == nil {
+				return nil, errHTTP{status: http.StatusBadRequest, msg: "unknown relation " + name}
+			}
+			seen[name] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// expandRows adds the referenced records of the relations to the rows,
+// records of each relation are selected by one query
+func expandRows(db querier, env *env, rows []*row, refs []*refSpec) error {
+	for _, ref := range refs {
+		ids, err := env.schema.idents(ref.table)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		tableSpec := env.meta.get(tableName)
-		q := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", tableSpec.name, tableSpec.pk.name)
-		row := env.db.QueryRow(q, id)
-		rowType := makeRowTypeFromSpec(tableSpec)
-		result, vals := newRowWithVals(rowType)
-		err = row.Scan(vals...)
+		refCol, err := ids.col(ref.refCol)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			_, err := w.Write([]byte(`{"error": "record not found"}`))
+			return err
+		}
+		// values are keys of records by their JSON, null ones reference
+		// nothing
+		var args []interface{}
+		seen := make(map[string]bool)
+		for _, row := range rows {
+			val := row.get(ref.col.name)
+			key, err := json.Marshal(val)
 			if err != nil {
-				panic(err.Error())
+				return err
+			}
+			if !isValid(val) || seen[string(key)] {
+				continue
 			}
-			return
+			seen[string(key)] = true
+			args = append(args, val)
 		}
-		response := map[string]interface{}{
-			"response": map[string]interface{}{
-				"record": result,
-			},
+		var records map[string]*row
+		if len(args) > 0 {
+			placeHolders := "?" + strings.Repeat(",?", len(args)-1)
+			q := fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s)", ids.table(), refCol, placeHolders)
+			records, err = selectByKey(db, env.dialect.rebind(q), args, ids.spec, ref.refCol)
+			if err != nil {
+				return err
+			}
 		}
-		err = writeResponse(w, response)
+		for _, row := range rows {
+			key, err := json.Marshal(row.get(ref.col.name))
+			if err != nil {
+				return err
+			}
+			row.rels = append(row.rels, rel{ref.name, records[string(key)]})
+		}
+	}
+	return nil
+}
+
+// selectByKey returns the records of the query by JSON of their column
+func selectByKey(db querier, q string, args []interface{}, t tableSpec, colName string) (map[string]*row, error) {
+	records, err := selectRows(db, q, args, t)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*row)
+	for _, row := range records {
+		key, err := json.Marshal(row.get(colName))
 		if err != nil {
-			panic(err.Error())
+			return nil, err
 		}
+		result[string(key)] = row
 	}
+	return result, nil
 }
 
-func (t tableSpec) getColNames() []string {
-	var names []string
-	for _, col := range t.cols {
-		names = append(names, col.name)
+// selectRows returns the records of the query of all columns of the
+// table, it's errSchemaChanged if the columns differ from the ones of the
+// metadata
+func selectRows(db querier, q string, args []interface{}, t tableSpec) ([]*row, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
 	}
-	return names
+	defer rows.Close()
+	colNames, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if len(colNames) != len(t.cols) {
+		return nil, errSchemaChanged(t.name)
+	}
+	for i, col := range t.cols {
+		if colNames[i] != col.name {
+			return nil, errSchemaChanged(t.name)
+		}
+	}
+	var result []*row
+	for rows.Next() {
+		row := newRow(t)
+		err = rows.Scan(row.vals...)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
 }
 
-func prepareInsertQuery(t tableSpec, values map[string]interface{}) (string, []interface{}) {
+// prepareWhere returns the WHERE clause of the filters with its
+// placeholders and their values, it's empty without filters
+func prepareWhere(ids idents, filters []filter) (string, []interface{}, error) {
+	if len(filters) == 0 {
+		return "", nil, nil
+	}
+	var conds []string
+	var values []interface{}
+	for _, f := range filters {
+		colName, err := ids.col(f.col.name)
+		if err != nil {
+			return "", nil, err
+		}
+		switch f.op {
+		case "IS NULL", "IS NOT NULL":
+			conds = append(conds, colName+" "+f.op)
+		case "IN":
+			placeHolders := "?" + strings.Repeat(",?", len(f.values)-1)
+			conds = append(conds, colName+" IN ("+placeHolders+")")
+		default:
+			conds = append(conds, colName+" "+f.op+" ?")
+		}
+		values = append(values, f.values...)
+	}
+	return " WHERE " + strings.Join(conds, " AND "), values, nil
+}
+
+func prepareInsertQuery(ids idents, values map[string]interface{}) (string, []interface{}, error) {
 	q := "INSERT INTO %s (%s) VALUES (%s)"
 	var colNames []string
 	var colVals []interface{}
 	for colName, value := range values {
-		colNames = append(colNames, colName)
+		name, err := ids.col(colName)
+		if err != nil {
+			return "", nil, err
+		}
+		colNames = append(colNames, name)
 		colVals = append(colVals, value)
 	}
 	names := strings.Join(colNames, ", ")
 	placeHolders := "?" + strings.Repeat(",?", len(colVals)-1)
-	return fmt.Sprintf(q, t.name, names, placeHolders), colVals
+	return fmt.Sprintf(q, ids.table(), names, placeHolders), colVals, nil
 }
 
-func prepareUpdateQuery(t tableSpec, values map[string]interface{}, pkVal int) (string, []interface{}) {
+func prepareUpdateQuery(ids idents, values map[string]interface{}, pkVal int) (string, []interface{}, error) {
 	q := "UPDATE %s SET %s WHERE %s = ?"
 	var colNames []string
 	var colVals []interface{}
 	for colName, value := range values {
-		colNames = append(colNames, colName+" = ?")
+		name, err := ids.col(colName)
+		if err != nil {
+			return "", nil, err
+		}
+		colNames = append(colNames, name+" = ?")
 		colVals = append(colVals, value)
 	}
 	colVals = append(colVals, pkVal)
 	colPlaceholders := strings.Join(colNames, ", ")
-	return fmt.Sprintf(q, t.name, colPlaceholders, t.pk.name), colVals
+	pkName, err := ids.pk()
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf(q, ids.table(), colPlaceholders, pkName), colVals, nil
 }
 
-func makeInsertHandler(env *env) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// makeSelectChildrenHandler lists records of the child table referencing
+// the record, the filters and relations of the query are supported
+func makeSelectChildrenHandler(env *env) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		tableName := getSegmentValue(r.Context(), "table")
-		tableSpec := env.meta.get(tableName)
-		pm := r.Context().Value(rowKey(""))
-		if pm == nil {
-			panic("query parameters expected")
+		childName := getSegmentValue(r.Context(), "child")
+		id, err := getID(r)
+		if err != nil {
+			return err
 		}
-		parsedParams, ok := pm.(map[string]interface{})
-		if !ok {
-			panic("type missmatch")
+		ids, err := env.schema.idents(tableName)
+		if err != nil {
+			return err
 		}
-		query, values := prepareInsertQuery(tableSpec, parsedParams)
-		result, err := env.db.Exec(query, values...)
+		childIds, err := env.schema.idents(childName)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		id, err := result.LastInsertId()
+		var ref *refSpec
+		for _, childRef := range childIds.spec.refs {
+			if childRef.table != tableName {
+				continue
+			}
+			if ref != nil {
+				return errHTTP{status: http.StatusBadRequest, msg: "relation " + childName + " is ambiguous"}
+			}
+			ref = childRef
+		}
+		if ref == nil {
+			return errHTTP{status: http.StatusNotFound, msg: "unknown relation " + childName}
+		}
+		// the referenced column of the record is mostly its PK
+		refCol, err := ids.col(ref.refCol)
 		if err != nil {
-			panic(err.Error())
+			return err
+		}
+		pkName, err := ids.pk()
+		if err != nil {
+			return err
+		}
+		key := reflect.New(getTypeOf(ids.spec.getCol(ref.refCol))).Interface()
+		q := env.dialect.rebind(fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?", refCol, ids.table(), pkName))
+		err = env.conn(r).QueryRow(q, id).Scan(key)
+		if err == sql.ErrNoRows {
+			return errHTTP{status: http.StatusNotFound, msg: "record not found"}
+		}
+		if err != nil {
+			return err
+		}
+		return selectRecords(w, r, env, childIds, []filter{{ref.col, "=", []interface{}{key}}})
+	}
+}
+
+func makeInsertHandler(env *env) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		tableName := getSegmentValue(r.Context(), "table")
+		ids, err := env.schema.idents(tableName)
+		if err != nil {
+			return err
+		}
+		tableSpec := ids.spec
+		parsedParams, err := getRowParams(r)
+		if err != nil {
+			return err
+		}
+		query, values, err := prepareInsertQuery(ids, parsedParams)
+		if err != nil {
+			return err
+		}
+		pkName, err := ids.pk()
+		if err != nil {
+			return err
+		}
+		id, err := env.dialect.insert(env.conn(r), query, pkName, values)
+		if err != nil {
+			return err
 		}
 		response := map[string]interface{}{
 			"response": map[string]interface{}{
 				tableSpec.pk.name: id,
 			},
 		}
-		err = writeResponse(w, response)
-		if err != nil {
-			panic(err.Error())
-		}
+		return writeResponse(w, response)
 	}
 }
 
-func makeUpdateHandler(env *env) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func makeUpdateHandler(env *env) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		tableName := getSegmentValue(r.Context(), "table")
-		idRaw := getSegmentValue(r.Context(), "id")
-		id, err := strconv.Atoi(idRaw)
+		id, err := getID(r)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		tableSpec := env.meta.get(tableName)
-		pm := r.Context().Value(rowKey(""))
-		if pm == nil {
-			panic("query parameters expected")
+		ids, err := env.schema.idents(tableName)
+		if err != nil {
+			return err
 		}
-		parsedParams, ok := pm.(map[string]interface{})
-		if !ok {
-			panic("type missmatch")
+		parsedParams, err := getRowParams(r)
+		if err != nil {
+			return err
+		}
+		query, values, err := prepareUpdateQuery(ids, parsedParams, id)
+		if err != nil {
+			return err
 		}
-		query, values := prepareUpdateQuery(tableSpec, parsedParams, id)
-		result, err := env.db.Exec(query, values...)
+		result, err := env.conn(r).Exec(env.dialect.rebind(query), values...)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
 		affected, err := result.RowsAffected()
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
 		response := map[string]interface{}{
 			"response": map[string]interface{}{
 				"updated": affected,
 			},
 		}
-		err = writeResponse(w, response)
-		if err != nil {
-			panic(err.Error())
-		}
+		return writeResponse(w, response)
 	}
 }
 
-func makeDeleteHandler(env *env) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func makeDeleteHandler(env *env) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		tableName := getSegmentValue(r.Context(), "table")
-		idRaw := getSegmentValue(r.Context(), "id")
-		id, err := strconv.Atoi(idRaw)
+		id, err := getID(r)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		tableSpec := env.meta.get(tableName)
-		query := fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, tableName, tableSpec.pk.name)
-		result, err := env.db.Exec(query, id)
+		ids, err := env.schema.idents(tableName)
 		if err != nil {
-			panic(err.Error())
+			return err
+		}
+		pkName, err := ids.pk()
+		if err != nil {
+			return err
+		}
+		query := fmt.Sprintf(`DELETE FROM %s WHERE %s = ?`, ids.table(), pkName)
+		result, err := env.conn(r).Exec(env.dialect.rebind(query), id)
+		if err != nil {
+			return err
 		}
 		affected, err := result.RowsAffected()
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
 		response := map[string]interface{}{
 			"response": map[string]interface{}{
 				"deleted": affected,
 			},
 		}
-		err = writeResponse(w, response)
-		if err != nil {
-			panic(err.Error())
-		}
+		return writeResponse(w, response)
 	}
 }
 
@@ -329,37 +1063,107 @@ func getTypeOf(c *colSpec) reflect.Type {
 		fallthrough
 	case kindNullFloat64:
 		return reflect.TypeOf(nullFloat64{})
+	case kindBool:
+		fallthrough
+	case kindNullBool:
+		return reflect.TypeOf(nullBool{})
+	case kindTime:
+		fallthrough
+	case kindNullTime:
+		return reflect.TypeOf(nullTime{})
+	case kindDecimal:
+		fallthrough
+	case kindNullDecimal:
+		return reflect.TypeOf(nullDecimal{})
+	case kindBytes:
+		fallthrough
+	case kindNullBytes:
+		return reflect.TypeOf(nullBytes{})
 	default:
 		panic("unknown type")
 	}
 }
 
-func makeShowTablesHandler(meta *dbMeta) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+func makeShowTablesHandler(s *schema) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
 		response := make(map[string]interface{})
-		response["response"] = map[string]interface{}{"tables": meta.keys}
-		buf, err := json.Marshal(response)
+		response["response"] = map[string]interface{}{"tables": s.load().keys}
+		return writeResponse(w, response)
+	}
+}
+
+// newRow returns the record of the table with the values of its columns
+// to scan
+func newRow(ts tableSpec) *row {
+	r := &row{cols: ts.cols, vals: make([]interface{}, len(ts.cols))}
+	for i, col := range ts.cols {
+		r.vals[i] = reflect.New(getTypeOf(col)).Interface()
+	}
+	return r
+}
+
+// get returns the value of the column, it's nil for unknown columns
+func (r *row) get(colName string) interface{} {
+	for i, col := range r.cols {
+		if col.name == colName {
+			return r.vals[i]
+		}
+	}
+	return nil
+}
+
+// isValid reports whether the value of the column isn't null
+func isValid(val interface{}) bool {
+	return val != nil && reflect.ValueOf(val).Elem().FieldByName("Valid").Bool()
+}
+
+// MarshalJSON writes columns in their order, names of columns are JSON
+// strings as they are
+func (r *row) MarshalJSON() ([]byte, error) {
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	write := func(key string, val interface{}) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
-		_, err = w.Write(buf)
+		data, err := json.Marshal(val)
 		if err != nil {
-			panic(err.Error())
+			return err
 		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(data)
+		return nil
 	}
-}
-
-func makeRowTypeFromSpec(ts tableSpec) reflect.Type {
-	var fields []reflect.StructField
-	for _, col := range ts.cols {
-		field := reflect.StructField{
-			Name: strings.Title(col.name),
-			Type: getTypeOf(col),
-			Tag:  reflect.StructTag(`json:"` + col.name + `"`),
+	// relations named as their columns replace their values
+	rels := make(map[string]*row)
+	for _, rel := range r.rels {
+		rels[rel.name] = rel.row
+	}
+	for i, col := range r.cols {
+		val := r.vals[i]
+		if rel, ok := rels[col.name]; ok {
+			val = rel
+			delete(rels, col.name)
+		}
+		if err := write(col.name, val); err != nil {
+			return nil, err
+		}
+	}
+	for _, rel := range r.rels {
+		if _, ok := rels[rel.name]; !ok {
+			continue
+		}
+		if err := write(rel.name, rel.row); err != nil {
+			return nil, err
 		}
-		fields = append(fields, field)
 	}
-	return reflect.StructOf(fields)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
 
 func validateJSON(t tableSpec, jsonRaw map[string]json.RawMessage, update bool) (map[string]interface{}, error) {
@@ -372,6 +1176,10 @@ func validateJSON(t tableSpec, jsonRaw map[string]json.RawMessage, update bool)
 		if !ok {
 			// default values for non-nullable fields (insert)
 			if !col.nullable && col != t.pk && !update {
+				// the zero time isn't a value of DATE and DATETIME
+				if col.typ == kindTime {
+					return nil, errInvalidType("field " + col.name + " is required")
+				}
 				reflect.ValueOf(valPtr).Elem().FieldByName("Valid").SetBool(true)
 				result[col.name] = valPtr
 			}
@@ -396,19 +1204,6 @@ func validateJSON(t tableSpec, jsonRaw map[string]json.RawMessage, update bool)
 	return result, nil
 }
 
-func getFieldPtr(row reflect.Value, idx int) interface{} {
-	return row.Elem().Field(idx).Addr().Interface()
-}
-
-func newRowWithVals(typ reflect.Type) (row interface{}, vals []interface{}) {
-	rowPtr := reflect.New(typ)
-	vals = make([]interface{}, typ.NumField())
-	for i := 0; i < typ.NumField(); i++ {
-		vals[i] = getFieldPtr(rowPtr, i)
-	}
-	return rowPtr.Interface(), vals
-}
-
 func writeResponse(w http.ResponseWriter, response map[string]interface{}) error {
 	buf, err := json.Marshal(response)
 	if err != nil {
@@ -459,87 +1254,320 @@ func getSegmentsMap(c context.Context) map[string]string {
 	if !ok {
 		panic("missing url segments map")
 	}
-	return value
+	return value
+}
+
+func getSegmentValue(c context.Context, segmentName string) string {
+	m := getSegmentsMap(c)
+	v, ok := m[segmentName]
+	if !ok {
+		panic("missing segment value: " + segmentName)
+	}
+	return v
+}
+
+func makeTableValidator(meta *schema, segmentName string) (wrapper, error) {
+	validator := func(h handlerFunc) handlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			tableSegment := getSegmentValue(r.Context(), segmentName)
+			_, err := meta.get(tableSegment)
+			if err != nil {
+				return err
+			}
+			// call next handler in the chain
+			return h(w, r)
+		}
+	}
+	return validator, nil
+}
+
+func getJSONRaw(body []byte) (map[string]json.RawMessage, error) {
+	data := make(map[string]json.RawMessage)
+	err := json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty request body")
+	}
+	return data, nil
+}
+
+func makeJSONValidator(meta *schema, segmentName string) wrapper {
+	wrapper := func(h handlerFunc) handlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			tableName := getSegmentValue(r.Context(), segmentName)
+			tableSpec, err := meta.get(tableName)
+			if err != nil {
+				return err
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return errHTTP{status: http.StatusBadRequest, msg: "can't read request body", cause: err}
+			}
+			jsonRaw, err := getJSONRaw(body)
+			if err != nil {
+				return errHTTP{status: http.StatusBadRequest, msg: "invalid request body", cause: err}
+			}
+			queryParams, err := validateJSON(tableSpec, jsonRaw, r.Method == http.MethodPost)
+			if err != nil {
+				return err
+			}
+
+			// call next handler in the chain
+			return h(w, r.WithContext(context.WithValue(r.Context(), rowKey(""), queryParams)))
+		}
+	}
+	return wrapper
+}
+
+// conn returns the transaction of the request or the database without it
+func (env *env) conn(r *http.Request) querier {
+	tx, ok := r.Context().Value(txKey("")).(*sql.Tx)
+	if ok {
+		return tx
+	}
+	return env.db
+}
+
+func newTxs(db *sql.DB, timeout time.Duration, readOnly bool, maxOpen int) *txs {
+	return &txs{db: db, timeout: timeout, readOnly: readOnly, maxOpen: maxOpen, open: make(map[string]*txEntry)}
+}
+
+func newTxToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// begin starts the transaction and returns its token, it's rolled back
+// after the timeout without requests. It's 503 if maxOpen transactions are
+// open.
+func (t *txs) begin() (string, error) {
+	token, err := newTxToken()
+	if err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	if len(t.open)+t.starting >= t.maxOpen {
+		t.mu.Unlock()
+		return "", errHTTP{status: http.StatusServiceUnavailable, msg: "too many transactions"}
+	}
+	t.starting++
+	t.mu.Unlock()
+	tx, err := t.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: t.readOnly})
+	t.mu.Lock()
+	t.starting--
+	if err != nil {
+		t.mu.Unlock()
+		return "", err
+	}
+	e := &txEntry{tx: tx, deadline: time.Now().Add(t.timeout)}
+	t.open[token] = e
+	e.timer = time.AfterFunc(t.timeout, func() { t.expire(token, e) })
+	t.mu.Unlock()
+	return token, nil
+}
+
+// acquire locks the transaction of the token for the request, it's
+// released by release
+func (t *txs) acquire(token string) (*txEntry, error) {
+	t.mu.Lock()
+	e, ok := t.open[token]
+	t.mu.Unlock()
+	if !ok {
+		return nil, errHTTP{status: http.StatusNotFound, msg: "unknown transaction"}
+	}
+	e.mu.Lock()
+	if e.done {
+		e.mu.Unlock()
+		return nil, errHTTP{status: http.StatusNotFound, msg: "unknown transaction"}
+	}
+	return e, nil
+}
+
+// release unlocks the transaction, the timeout starts again
+func (t *txs) release(e *txEntry) {
+	e.deadline = time.Now().Add(t.timeout)
+	e.timer.Reset(t.timeout)
+	e.mu.Unlock()
+}
+
+// end commits or rolls back the transaction of the token
+func (t *txs) end(token string, commit bool) error {
+	e, err := t.acquire(token)
+	if err != nil {
+		return err
+	}
+	defer e.mu.Unlock()
+	t.close(token, e)
+	if commit {
+		return e.tx.Commit()
+	}
+	return e.tx.Rollback()
+}
+
+// expire rolls back the transaction if there were no requests during the
+// timeout, its timer is reset by requests
+func (t *txs) expire(token string, e *txEntry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done || time.Now().Before(e.deadline) {
+		return
+	}
+	t.close(token, e)
+	err := e.tx.Rollback()
+	if err != nil {
+		log.Printf("rollback of expired transaction: %v", err)
+	}
+}
+
+// close forgets the transaction, e must be locked
+func (t *txs) close(token string, e *txEntry) {
+	e.done = true
+	e.timer.Stop()
+	t.mu.Lock()
+	delete(t.open, token)
+	t.mu.Unlock()
+}
+
+// makeTxBinder runs requests with the token of the header in its
+// transaction, the ones without it run in the database
+func makeTxBinder(t *txs) wrapper {
+	return func(h handlerFunc) handlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			token := r.Header.Get(txHeader)
+			if token == "" {
+				return h(w, r)
+			}
+			e, err := t.acquire(token)
+			if err != nil {
+				return err
+			}
+			defer t.release(e)
+			return h(w, r.WithContext(context.WithValue(r.Context(), txKey(""), e.tx)))
+		}
+	}
+}
+
+func makeBeginHandler(t *txs) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		token, err := t.begin()
+		if err != nil {
+			return err
+		}
+		response := map[string]interface{}{
+			"response": map[string]interface{}{
+				"tx":      token,
+				"timeout": int((t.timeout + time.Second - 1) / time.Second),
+			},
+		}
+		return writeResponse(w, response)
+	}
 }
 
-func getSegmentValue(c context.Context, segmentName string) string {
-	m := getSegmentsMap(c)
-	v, ok := m[segmentName]
-	if !ok {
-		panic("missing segment value: " + segmentName)
+func makeEndHandler(t *txs, commit bool) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		token := getSegmentValue(r.Context(), "tx")
+		err := t.end(token, commit)
+		if err != nil {
+			return err
+		}
+		key := "rolledBack"
+		if commit {
+			key = "committed"
+		}
+		response := map[string]interface{}{
+			"response": map[string]interface{}{
+				key: true,
+			},
+		}
+		return writeResponse(w, response)
 	}
-	return v
 }
 
-func makeTableValidator(meta *dbMeta, segmentName string) (wrapper, error) {
-	validator := func(h http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			tableSegment := getSegmentValue(r.Context(), segmentName)
-			_, ok := meta.data[tableSegment]
-			// call next handler in the chain
-			if ok {
-				h(w, r)
-				return
+// makeSchemaRetry runs reads again with the refreshed schema if columns
+// of their tables changed, requests with bodies can't be retried
+func makeSchemaRetry(s *schema) wrapper {
+	return func(h handlerFunc) handlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			m := s.load()
+			err := h(w, r)
+			if _, ok := err.(errSchemaChanged); !ok {
+				return err
 			}
-			w.WriteHeader(http.StatusNotFound)
-			_, err := w.Write([]byte(`{"error": "unknown table"}`))
+			_, err = s.refreshSince(m)
 			if err != nil {
-				panic(err.Error())
+				return err
 			}
+			return h(w, r)
 		}
 	}
-	return validator, nil
 }
 
-func getJSONRaw(body []byte) (map[string]json.RawMessage, error) {
-	data := make(map[string]json.RawMessage)
-	err := json.Unmarshal(body, &data)
-	if err != nil {
-		return nil, err
+// makeRefreshHandler reloads the schema unless it's fresh, refreshed is
+// false for the fresh one
+func makeRefreshHandler(s *schema) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		m, refreshed, err := s.refreshStale()
+		if err != nil {
+			return err
+		}
+		response := map[string]interface{}{
+			"response": map[string]interface{}{
+				"tables":    m.keys,
+				"refreshed": refreshed,
+			},
+		}
+		return writeResponse(w, response)
 	}
-	if len(data) == 0 {
-		return nil, errors.New("empty request body")
+}
+
+// rejectWrites is the handler of writes of the read-only mode
+func rejectWrites(h handlerFunc) handlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		return errHTTP{status: http.StatusMethodNotAllowed, msg: "read-only mode"}
 	}
-	return data, nil
 }
 
-func makeJSONValidator(meta *dbMeta, segmentName string) wrapper {
-	wrapper := func(h http.HandlerFunc) http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			tableName := getSegmentValue(r.Context(), segmentName)
-			tableSpec := meta.get(tableName)
-			body, err := ioutil.ReadAll(r.Body)
-			if err != nil {
-				panic(err.Error())
-			}
-			jsonRaw, err := getJSONRaw(body)
-			if err != nil {
-				panic(err.Error())
-			}
-			if len(jsonRaw) == 0 {
-				panic("there is no params in the request")
-			}
-			queryParams, err := validateJSON(tableSpec, jsonRaw, r.Method == http.MethodPost)
-			if err != nil {
-				switch err.(type) {
-				case errInvalidType:
-					w.WriteHeader(http.StatusBadRequest)
-					_, err := w.Write([]byte(`{"error": "` + err.Error() + `"}`))
-					if err != nil {
-						panic(err.Error())
-					}
-					return
-				default:
-					panic(err.Error())
-				}
+// withErrors writes errors of the handler as JSON with their statuses,
+// internal errors and panics are logged and clients get 500 without
+// their causes
+func withErrors(h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			if e := recover(); e != nil {
+				writeError(rw, r, fmt.Errorf("panic: %v", e))
 			}
-
-			// call next handler in the chain
-			h(w, r.WithContext(context.WithValue(r.Context(), rowKey(""), queryParams)))
-			return
+		}()
+		if err := h(rw, r); err != nil {
+			writeError(rw, r, err)
 		}
 	}
-	return wrapper
+}
+
+func writeError(w *responseWriter, r *http.Request, err error) {
+	status, msg := http.StatusInternalServerError, "internal error"
+	switch e := err.(type) {
+	case errInvalidType, errInvalidFilter:
+		status, msg = http.StatusBadRequest, err.Error()
+	case errHTTP:
+		status, msg = e.status, e.msg
+	}
+	if status >= http.StatusInternalServerError || w.written {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	if w.written {
+		return
+	}
+	w.WriteHeader(status)
+	if err := writeResponse(w, map[string]interface{}{"error": msg}); err != nil {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
 }
 
 func (r *route) methods(methods ...string) {
@@ -636,35 +1664,414 @@ func (n *nullFloat64) UnmarshalJSON(b []byte) error {
 	return err
 }
 
+func (n *nullBool) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.Bool)
+}
+
+func (n *nullBool) UnmarshalJSON(b []byte) error {
+	v := new(bool)
+	err := json.Unmarshal(b, &v)
+	n.Valid = (err == nil && v != nil)
+	if v != nil {
+		n.Bool = *v
+	}
+	return err
+}
+
+// parseTime parses RFC3339 times, the ones of MySQL without time zones
+// are UTC
+func parseTime(value string) (time.Time, error) {
+	layouts := []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid time " + value)
+}
+
+// Scan reads times of drivers parsing them and the text of MySQL
+// without parseTime
+func (n *nullTime) Scan(value interface{}) error {
+	var err error
+	switch v := value.(type) {
+	case nil:
+		n.Time, n.Valid = time.Time{}, false
+		return nil
+	case time.Time:
+		n.Time = v
+	case []byte:
+		n.Time, err = parseTime(string(v))
+	case string:
+		n.Time, err = parseTime(v)
+	default:
+		return fmt.Errorf("unsupported time %T", value)
+	}
+	n.Valid = err == nil
+	return err
+}
+
+func (n nullTime) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	return n.Time, nil
+}
+
+func (n *nullTime) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.Time.Format(time.RFC3339))
+}
+
+func (n *nullTime) UnmarshalJSON(b []byte) error {
+	v := new(string)
+	err := json.Unmarshal(b, &v)
+	n.Valid = (err == nil && v != nil)
+	if v == nil || err != nil {
+		return err
+	}
+	n.Time, err = parseTime(*v)
+	n.Valid = err == nil
+	return err
+}
+
+// isDecimal reports whether the value is a number without exponent, like
+// the ones of DECIMAL columns
+func isDecimal(value string) bool {
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+	digits, dot := 0, false
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c == '.' && !dot:
+			dot = true
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+func (n nullDecimal) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	if n.String == "" {
+		// the default of the new record
+		return "0", nil
+	}
+	return n.String, nil
+}
+
+func (n *nullDecimal) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.String)
+}
+
+// UnmarshalJSON accepts decimals as strings and numbers, their text is
+// kept as is
+func (n *nullDecimal) UnmarshalJSON(b []byte) error {
+	v := new(string)
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		var number json.Number
+		if json.Unmarshal(b, &number) != nil {
+			n.Valid = false
+			return err
+		}
+		v = new(string)
+		*v = number.String()
+	}
+	n.Valid = v != nil
+	if v == nil {
+		return nil
+	}
+	if !isDecimal(*v) {
+		n.Valid = false
+		return errors.New("invalid decimal " + *v)
+	}
+	n.String = *v
+	return nil
+}
+
+func (n *nullBytes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		n.Bytes, n.Valid = nil, false
+	case []byte:
+		// the buffer of the driver is reused
+		n.Bytes, n.Valid = append([]byte{}, v...), true
+	case string:
+		n.Bytes, n.Valid = []byte(v), true
+	default:
+		return fmt.Errorf("unsupported bytes %T", value)
+	}
+	return nil
+}
+
+func (n nullBytes) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+	if n.Bytes == nil {
+		return []byte{}, nil
+	}
+	return n.Bytes, nil
+}
+
+func (n *nullBytes) MarshalJSON() ([]byte, error) {
+	if !n.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(n.Bytes)
+}
+
+func (n *nullBytes) UnmarshalJSON(b []byte) error {
+	v := new([]byte)
+	err := json.Unmarshal(b, &v)
+	n.Valid = (err == nil && v != nil)
+	if v != nil {
+		n.Bytes = *v
+	}
+	return err
+}
+
 func newTableSpec(name string, pk *colSpec, cols []*colSpec) tableSpec {
 	return tableSpec{
 		name,
 		pk,
 		cols,
+		nil,
+	}
+}
+
+func (mysqlDialect) tablesQuery() string {
+	return "SHOW TABLES"
+}
+
+func (mysqlDialect) columnsQuery() string {
+	// the type of the column has the width of tinyint(1) of booleans
+	return `SELECT COLUMN_NAME, COLUMN_TYPE, COLUMN_KEY, IS_NULLABLE 
+FROM information_schema.columns WHERE TABLE_SCHEMA = database() AND TABLE_NAME = ?
+ORDER BY ORDINAL_POSITION`
+}
+
+func (mysqlDialect) refsQuery() string {
+	// composite foreign keys are skipped
+	return `SELECT MIN(COLUMN_NAME), MIN(REFERENCED_TABLE_NAME), MIN(REFERENCED_COLUMN_NAME)
+FROM information_schema.key_column_usage
+WHERE TABLE_SCHEMA = database() AND TABLE_NAME = ? AND REFERENCED_TABLE_SCHEMA = database()
+GROUP BY CONSTRAINT_NAME HAVING COUNT(*) = 1 ORDER BY CONSTRAINT_NAME`
+}
+
+func (mysqlDialect) rebind(q string) string {
+	return q
+}
+
+func (mysqlDialect) quote(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (mysqlDialect) insert(db querier, q, pkName string, args []interface{}) (int64, error) {
+	result, err := db.Exec(q, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (postgresDialect) tablesQuery() string {
+	return `SELECT table_name FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name`
+}
+
+func (postgresDialect) columnsQuery() string {
+	return `SELECT a.attname, format_type(a.atttypid, NULL),
+	CASE WHEN EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = a.attrelid AND i.indisprimary AND a.attnum = ANY(i.indkey)) THEN 'PRI' ELSE '' END,
+	CASE WHEN a.attnotnull THEN 'NO' ELSE 'YES' END
+FROM pg_attribute a
+WHERE a.attrelid = (quote_ident(current_schema()) || '.' || quote_ident($1))::regclass AND a.attnum > 0 AND NOT a.attisdropped
+ORDER BY a.attnum`
+}
+
+func (postgresDialect) refsQuery() string {
+	// composite foreign keys are skipped
+	return `SELECT a.attname, t.relname, ta.attname
+FROM pg_constraint c
+JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+JOIN pg_class t ON t.oid = c.confrelid
+JOIN pg_attribute ta ON ta.attrelid = c.confrelid AND ta.attnum = c.confkey[1]
+WHERE c.contype = 'f' AND array_length(c.conkey, 1) = 1
+	AND c.conrelid = (quote_ident(current_schema()) || '.' || quote_ident($1))::regclass
+ORDER BY a.attnum, c.conname`
+}
+
+// rebind numbers placeholders of the query as $1, $2..., question marks
+// of quoted strings and identifiers are kept
+func (postgresDialect) rebind(q string) string {
+	var b strings.Builder
+	var quote rune
+	n := 0
+	for _, c := range q {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (postgresDialect) quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (d postgresDialect) insert(db querier, q, pkName string, args []interface{}) (int64, error) {
+	var id int64
+	err := db.QueryRow(d.rebind(q)+" RETURNING "+pkName, args...).Scan(&id)
+	return id, err
+}
+
+// getDialect returns the dialect of the database by its version, the
+// one of PostgreSQL starts with its name. Drivers are registered by the
+// program, main.go has the one of MySQL and the tests of PostgreSQL use
+// github.com/lib/pq.
+func getDialect(db *sql.DB) (dialect, error) {
+	var version string
+	err := db.QueryRow("SELECT version()").Scan(&version)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(version, "PostgreSQL") {
+		return postgresDialect{}, nil
 	}
+	return mysqlDialect{}, nil
 }
 
-func getAllTableSpecs(db *sql.DB) ([]tableSpec, error) {
+// getAllTableSpecs returns the exposed tables, unsupported ones are errors
+// if it's strict, otherwise they're logged and skipped
+func getAllTableSpecs(db *sql.DB, d dialect, opts Options, strict bool) ([]tableSpec, error) {
 	var tables []tableSpec
-	tableNames, err := getTableNames(db)
+	tableNames, err := getTableNames(db, d)
+	if err != nil {
+		return nil, err
+	}
+	tableNames, err = opts.exposed(tableNames, strict)
 	if err != nil {
 		return nil, err
 	}
 	for _, name := range tableNames {
-		table, err := getTableSpec(db, name)
+		table, err := getTableSpec(db, d, name)
+		if _, ok := err.(errUnsupportedTable); ok && !strict {
+			log.Printf("refresh of schema: table %s is skipped: %v", name, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		table.refs, err = getTableRefs(db, d, table)
 		if err != nil {
 			return nil, err
 		}
 		tables = append(tables, table)
 	}
+	// relations are only the ones of known tables and columns
+	byName := make(map[string]tableSpec)
+	for _, table := range tables {
+		byName[table.name] = table
+	}
+	for i, table := range tables {
+		var refs []*refSpec
+		for _, ref := range table.refs {
+			if target, ok := byName[ref.table]; ok && target.getCol(ref.refCol) != nil {
+				refs = append(refs, ref)
+			}
+		}
+		tables[i].refs = refs
+	}
 	return tables, nil
 }
 
-func getTableSpec(db *sql.DB, tableName string) (tableSpec, error) {
+// exposed returns the tables of the allow and deny lists, hidden tables
+// aren't introspected. Unknown tables of the allow list are errors if it's
+// strict, otherwise they're logged.
+func (o Options) exposed(tableNames []string, strict bool) ([]string, error) {
+	known := make(map[string]bool)
+	for _, name := range tableNames {
+		known[name] = true
+	}
+	allowed := make(map[string]bool)
+	for _, name := range o.AllowTables {
+		if !known[name] && strict {
+			return nil, errors.New("unknown table of allow list: " + name)
+		}
+		if !known[name] {
+			log.Printf("refresh of schema: unknown table of allow list: %s", name)
+		}
+		allowed[name] = true
+	}
+	denied := make(map[string]bool)
+	for _, name := range o.DenyTables {
+		denied[name] = true
+	}
+	var result []string
+	for _, name := range tableNames {
+		if len(allowed) > 0 && !allowed[name] || denied[name] {
+			continue
+		}
+		result = append(result, name)
+	}
+	return result, nil
+}
+
+// getTableRefs returns the foreign keys of the table, the ones of taken
+// names of relations are skipped
+func getTableRefs(db *sql.DB, d dialect, t tableSpec) ([]*refSpec, error) {
+	rows, err := db.Query(d.refsQuery(), t.name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var colName, refTable, refCol string
+	for rows.Next() {
+		err = rows.Scan(&colName, &refTable, &refCol)
+		if err != nil {
+			return nil, err
+		}
+		col := t.getCol(colName)
+		name := strings.TrimSuffix(colName, "_id")
+		if col == nil || name == "" || t.getRef(name) != nil {
+			continue
+		}
+		// the relation would replace the value of the other column
+		if name != colName && t.getCol(name) != nil {
+			name = ""
+		}
+		t.refs = append(t.refs, &refSpec{name, col, refTable, refCol})
+	}
+	return t.refs, rows.Err()
+}
+
+func getTableSpec(db *sql.DB, d dialect, tableName string) (tableSpec, error) {
 	table := newTableSpec(tableName, nil, nil)
-	q := `SELECT COLUMN_NAME, DATA_TYPE, COLUMN_KEY, IS_NULLABLE 
-FROM information_schema.columns WHERE TABLE_SCHEMA = database() AND TABLE_NAME = ?`
-	rows, err := db.Query(q, tableName)
+	rows, err := db.Query(d.columnsQuery(), tableName)
 	if err != nil {
 		return table, err
 	}
@@ -675,11 +2082,14 @@ FROM information_schema.columns WHERE TABLE_SCHEMA = database() AND TABLE_NAME =
 		if err != nil {
 			return table, err
 		}
-		col := newColSpec(colName, typeName, nullable)
+		col, err := newColSpec(colName, typeName, nullable)
+		if err != nil {
+			return table, errUnsupportedTable(fmt.Sprintf("%s.%s: %v", tableName, colName, err))
+		}
 		table.cols = append(table.cols, col)
 		if key == "PRI" {
 			if table.pk != nil {
-				panic("only one PK expected")
+				return table, errUnsupportedTable(tableName + ": only one PK expected")
 			}
 			table.pk = col
 		}
@@ -691,10 +2101,10 @@ FROM information_schema.columns WHERE TABLE_SCHEMA = database() AND TABLE_NAME =
 	return table, nil
 }
 
-func getTableNames(db *sql.DB) ([]string, error) {
+func getTableNames(db *sql.DB, d dialect) ([]string, error) {
 	var tableName string
 	var result []string
-	rows, err := db.Query("SHOW TABLES")
+	rows, err := db.Query(d.tablesQuery())
 	if err != nil {
 		return nil, err
 	}
@@ -713,7 +2123,7 @@ func getTableNames(db *sql.DB) ([]string, error) {
 	return result, nil
 }
 
-func newColSpec(colName, typeName, null string) *colSpec {
+func newColSpec(colName, typeName, null string) (*colSpec, error) {
 	var typeKind kind
 	var nullable = null == "YES"
 	switch {
@@ -722,30 +2132,50 @@ func newColSpec(colName, typeName, null string) *colSpec {
 	case strings.HasPrefix(typeName, "char"):
 		fallthrough
 	case strings.HasPrefix(typeName, "varchar"):
-		if nullable {
-			typeKind = kindNullString
-			break
-		}
 		typeKind = kindString
+	case strings.HasPrefix(typeName, "tinyint(1)"):
+		fallthrough
+	case strings.HasPrefix(typeName, "bool"):
+		typeKind = kindBool
 	case strings.HasPrefix(typeName, "int"):
 		fallthrough
+	case strings.HasPrefix(typeName, "tinyint"):
+		fallthrough
+	case strings.HasPrefix(typeName, "smallint"):
+		fallthrough
+	case strings.HasPrefix(typeName, "mediumint"):
+		fallthrough
 	case strings.HasPrefix(typeName, "bigint"):
-		if nullable {
-			typeKind = kindNullInt64
-			break
-		}
 		typeKind = kindInt64
 	case strings.HasPrefix(typeName, "float"):
-		if nullable {
-			typeKind = kindNullFloat64
-			break
-		}
+		fallthrough
+	case strings.HasPrefix(typeName, "double"):
+		fallthrough
+	case strings.HasPrefix(typeName, "real"):
 		typeKind = kindFloat64
+	case strings.HasPrefix(typeName, "decimal"):
+		fallthrough
+	case strings.HasPrefix(typeName, "numeric"):
+		typeKind = kindDecimal
+	case strings.HasPrefix(typeName, "date"):
+		fallthrough
+	case strings.HasPrefix(typeName, "timestamp"):
+		typeKind = kindTime
+	case strings.Contains(typeName, "blob"):
+		fallthrough
+	case strings.Contains(typeName, "binary"):
+		fallthrough
+	case typeName == "bytea":
+		typeKind = kindBytes
 	default:
-		panic("unknown type: " + typeName)
+		return nil, errors.New("unknown type: " + typeName)
+	}
+	if nullable {
+		// null kinds follow their kinds
+		typeKind++
 	}
 
-	return &colSpec{colName, typeKind, nullable}
+	return &colSpec{colName, typeKind, nullable}, nil
 }
 
 func newDBMeta() *dbMeta {
@@ -754,26 +2184,30 @@ func newDBMeta() *dbMeta {
 	return &meta
 }
 
-func getDBMeta(db *sql.DB) (*dbMeta, error) {
+func getDBMeta(db *sql.DB, d dialect, opts Options, strict bool) (*dbMeta, error) {
 	meta := newDBMeta()
-	specs, err := getAllTableSpecs(db)
+	specs, err := getAllTableSpecs(db, d, opts, strict)
 	if err != nil {
 		return meta, err
 	}
 	for _, spec := range specs {
-		meta.set(spec.name, spec)
+		err = meta.set(spec.name, spec)
+		if err != nil {
+			return meta, err
+		}
 	}
+	meta.loaded = time.Now()
 	return meta, nil
 }
 
 func parseLimitOffset(limitRaw, offsetRaw string) (limit, offset int) {
 	var err error
 	limit, err = strconv.Atoi(limitRaw)
-	if limitRaw == "" || err != nil {
+	if limitRaw == "" || err != nil || limit < 0 {
 		limit = defaultLimit
 	}
 	offset, err = strconv.Atoi(offsetRaw)
-	if offsetRaw == "" || err != nil {
+	if offsetRaw == "" || err != nil || offset < 0 {
 		offset = defaultOffset
 	}
 	return
@@ -781,33 +2215,75 @@ func parseLimitOffset(limitRaw, offsetRaw string) (limit, offset int) {
 
 // NewDbExplorer ...
 func NewDbExplorer(db *sql.DB) (http.Handler, error) {
-	dbMeta, err := getDBMeta(db)
+	return NewDbExplorerOptions(db, Options{})
+}
+
+// NewDbExplorerOptions is NewDbExplorer with options of writes and exposed
+// tables
+func NewDbExplorerOptions(db *sql.DB, opts Options) (http.Handler, error) {
+	dialect, err := getDialect(db)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
+	}
+	schema, err := newSchema(db, dialect, opts)
+	if err != nil {
+		return nil, err
 	}
-	env := env{db: db, meta: dbMeta}
+	env := env{db: db, dialect: dialect, schema: schema}
+	maxTx := opts.MaxTransactions
+	if maxTx <= 0 {
+		maxTx = defaultMaxTx
+	}
+	// a connection of the pool is left for requests without transactions
+	if maxConns := db.Stats().MaxOpenConnections; maxConns > 0 && maxTx >= maxConns {
+		maxTx = maxConns - 1
+	}
+	txTimeout := opts.TxTimeout
+	if txTimeout <= 0 {
+		txTimeout = defaultTxTimeout
+	}
+	txs := newTxs(db, txTimeout, opts.ReadOnly, maxTx)
 
 	router := httpRouter{}
-	checkTable, err := makeTableValidator(dbMeta, "table")
+	checkTable, err := makeTableValidator(schema, "table")
 	if err != nil {
-		panic(err.Error())
+		return nil, err
+	}
+	parseJSON := makeJSONValidator(schema, "table")
+	bindTx := makeTxBinder(txs)
+	retry := makeSchemaRetry(schema)
+	checkWrite := func(h handlerFunc) handlerFunc { return h }
+	if opts.ReadOnly {
+		checkWrite = rejectWrites
 	}
-	parseJSON := makeJSONValidator(dbMeta, "table")
 
-	showTables := makeShowTablesHandler(dbMeta)
+	showTables := makeShowTablesHandler(schema)
+	refresh := makeRefreshHandler(schema)
 	selectFrom := makeSelectFromHandler(&env)
 	selectFromWhere := makeSelectFromWhereHandler(&env)
+	selectChildren := makeSelectChildrenHandler(&env)
 	insertInto := makeInsertHandler(&env)
 	updateWhere := makeUpdateHandler(&env)
 	deleteFrom := makeDeleteHandler(&env)
-
-	router.HandleFunc("/", showTables).methods("GET")
-	router.HandleFunc("/{table}", checkTable(selectFrom)).methods("GET")
-	router.HandleFunc("/{table}/{id:[0-9]+}", checkTable(selectFromWhere)).methods("GET")
-
-	router.HandleFunc("/{table}", checkTable(parseJSON(insertInto))).methods("PUT")
-	router.HandleFunc("/{table}/{id:[0-9]+}", checkTable(parseJSON(updateWhere))).methods("POST")
-
-	router.HandleFunc("/{table}/{id:[0-9]+}", checkTable(deleteFrom)).methods("DELETE")
+	begin := makeBeginHandler(txs)
+	commit := makeEndHandler(txs, true)
+	rollback := makeEndHandler(txs, false)
+
+	router.HandleFunc("/", withErrors(showTables)).methods("GET")
+	router.HandleFunc("/{table}", withErrors(retry(bindTx(checkTable(selectFrom))))).methods("GET")
+	router.HandleFunc("/{table}/{id:[0-9]+}", withErrors(retry(bindTx(checkTable(selectFromWhere))))).methods("GET")
+	router.HandleFunc("/{table}/{id:[0-9]+}/{child}", withErrors(retry(bindTx(checkTable(selectChildren))))).methods("GET")
+
+	router.HandleFunc("/{table}", withErrors(checkWrite(bindTx(checkTable(parseJSON(insertInto)))))).methods("PUT")
+	router.HandleFunc("/{table}/{id:[0-9]+}", withErrors(checkWrite(bindTx(checkTable(parseJSON(updateWhere)))))).methods("POST")
+
+	router.HandleFunc("/{table}/{id:[0-9]+}", withErrors(checkWrite(bindTx(checkTable(deleteFrom))))).methods("DELETE")
+
+	// the last matched route wins, routes of transactions follow the ones
+	// of tables matching their paths too
+	router.HandleFunc("/_tx", withErrors(begin)).methods("PUT")
+	router.HandleFunc("/_tx/{tx:[0-9a-f]+}/commit", withErrors(commit)).methods("POST")
+	router.HandleFunc("/_tx/{tx:[0-9a-f]+}/rollback", withErrors(rollback)).methods("POST")
+	router.HandleFunc("/_schema/refresh", withErrors(refresh)).methods("POST")
 	return &router, nil
 }